@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ardanlabs/conf"
+	"github.com/rerikdev/WASAText/service/database"
+	"gopkg.in/yaml.v2"
+)
+
+// MigrationConfiguration describes the migration configuration. The database and media settings are the same of
+// the web API, so the same config file can be used.
+type MigrationConfiguration struct {
+	Config struct {
+		Path string `conf:"default:data/config.yaml"` // Config file
+	}
+	Debug bool
+	DB    struct {
+		Driver   string `conf:"default:sqlite3"`            // Database driver: sqlite3 or postgres
+		Filename string `conf:"default:data/wasatext_2.db"` // SQLite DB path
+		DSN      string `conf:"mask"`                       // PostgreSQL connection string
+	}
+	Media struct {
+		Dir string `conf:"default:data/media"` // Directory of the uploaded media
+	}
+	BatchSize int  `conf:"default:100"` // Rows rewritten in each transaction
+	Vacuum    bool // Rebuild the database file at the end, to give the reclaimed space back to the file system
+}
+
+// loadConfiguration reads CLI flags, env vars, then YAML config
+func loadConfiguration() (MigrationConfiguration, error) {
+	var cfg MigrationConfiguration
+
+	// Parse CLI and env
+	if err := conf.Parse(os.Args[1:], "CFG", &cfg); err != nil {
+		if errors.Is(err, conf.ErrHelpWanted) {
+			usage, err := conf.Usage("CFG", &cfg)
+			if err != nil {
+				return cfg, fmt.Errorf("generating config usage: %w", err)
+			}
+			fmt.Println(usage)
+			return cfg, conf.ErrHelpWanted
+		}
+		return cfg, fmt.Errorf("parsing config: %w", err)
+	}
+
+	// Load YAML config file if exists
+	fp, err := os.Open(cfg.Config.Path)
+	if err != nil && !os.IsNotExist(err) {
+		return cfg, fmt.Errorf("cannot read config file: %w", err)
+	} else if err == nil {
+		defer fp.Close()
+		yamlFile, err := io.ReadAll(fp)
+		if err != nil {
+			return cfg, fmt.Errorf("cannot read YAML: %w", err)
+		}
+		if err := yaml.Unmarshal(yamlFile, &cfg); err != nil {
+			return cfg, fmt.Errorf("cannot unmarshal YAML: %w", err)
+		}
+	}
+
+	if cfg.DB.Driver == database.DriverPostgres && cfg.DB.DSN == "" {
+		return cfg, errors.New("the postgres driver requires a connection string (--db-dsn)")
+	}
+	if cfg.DB.Driver == database.DriverMemory {
+		return cfg, errors.New("the in-memory database is not persistent, there is nothing to migrate")
+	}
+	if cfg.BatchSize <= 0 {
+		return cfg, errors.New("batch size must be positive")
+	}
+	return cfg, nil
+}
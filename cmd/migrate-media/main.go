@@ -0,0 +1,166 @@
+/*
+Migrate-media moves the images saved as base64 data URLs in the database, before media existed, to the blob store.
+It scans messages.content, users.profile_picture and conversations.photo; every image is processed like an uploaded
+one (metadata removed, thumbnails generated) and its row is rewritten to reference the new media, in batches inside
+transactions. At the end it reports the space reclaimed.
+
+The migration is resumable: it can be stopped at any time (SIGINT or SIGTERM stop it after the current batch) and
+run again, and it only touches rows that still contain a data URL. It can run while the web API is running. Images
+that can't be decoded are reported and left inline.
+
+Usage:
+
+	migrate-media [flags]
+
+Flags and configurations are handled automatically by the code in `load-configuration.go`; the database and media
+settings are the same of webapi.
+
+Return values (exit codes):
+
+	0
+		The migration completed, or was interrupted by a signal
+
+	> 0
+		The migration stopped due to an error
+*/
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/ardanlabs/conf"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/rerikdev/WASAText/service/blobstore"
+	"github.com/rerikdev/WASAText/service/database"
+	"github.com/rerikdev/WASAText/service/mediastore"
+	"github.com/sirupsen/logrus"
+)
+
+func main() {
+	if err := run(); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "error: ", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	cfg, err := loadConfiguration()
+	if err != nil {
+		if errors.Is(err, conf.ErrHelpWanted) {
+			return nil
+		}
+		return err
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(os.Stderr)
+	if cfg.Debug {
+		logger.SetLevel(logrus.DebugLevel)
+	} else {
+		logger.SetLevel(logrus.InfoLevel)
+	}
+
+	var dbconn *sql.DB
+	var sizeBefore int64
+	if cfg.DB.Driver == database.DriverPostgres {
+		dbconn, err = sql.Open(database.DriverPostgres, cfg.DB.DSN)
+	} else {
+		sizeBefore, err = databaseSize(cfg.DB.Filename)
+		if err != nil {
+			return fmt.Errorf("reading the database: %w", err)
+		}
+		dbconn, err = sql.Open(database.DriverSQLite, fmt.Sprintf("%s?_journal_mode=WAL&_busy_timeout=5000", cfg.DB.Filename))
+	}
+	if err != nil {
+		return fmt.Errorf("opening the database: %w", err)
+	}
+	defer func() {
+		_ = dbconn.Close()
+	}()
+
+	// Also brings the schema up to date, adding the media tables
+	db, err := database.New(dbconn, cfg.DB.Driver)
+	if err != nil {
+		return fmt.Errorf("creating AppDatabase: %w", err)
+	}
+	blobs, err := blobstore.NewLocal(cfg.Media.Dir)
+	if err != nil {
+		return fmt.Errorf("creating the blob store: %w", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	report, err := mediastore.MigrateInlineImages(ctx, db, blobs, cfg.BatchSize, logger)
+	if report != nil {
+		printReport(report)
+	}
+	if err != nil {
+		return fmt.Errorf("migrating images: %w", err)
+	}
+	if report.Interrupted {
+		fmt.Println("Interrupted: run the migration again to resume it")
+		return nil
+	}
+
+	if !cfg.Vacuum {
+		fmt.Println("The database file keeps its size until it is vacuumed: run again with --vacuum to shrink it")
+		return nil
+	}
+	logger.Info("vacuuming the database")
+	if err := db.Vacuum(); err != nil {
+		return fmt.Errorf("vacuuming the database: %w", err)
+	}
+	if cfg.DB.Driver == database.DriverPostgres {
+		return nil
+	}
+	sizeAfter, err := databaseSize(cfg.DB.Filename)
+	if err != nil {
+		return fmt.Errorf("reading the database: %w", err)
+	}
+	fmt.Printf("Database file: %s -> %s\n", formatBytes(sizeBefore), formatBytes(sizeAfter))
+	return nil
+}
+
+// databaseSize returns the size of a SQLite database, including its WAL file
+func databaseSize(filename string) (int64, error) {
+	var size int64
+	for _, name := range []string{filename, filename + "-wal"} {
+		info, err := os.Stat(name)
+		if errors.Is(err, os.ErrNotExist) && name != filename {
+			continue
+		} else if err != nil {
+			return 0, err
+		}
+		size += info.Size()
+	}
+	return size, nil
+}
+
+func printReport(report *mediastore.MigrationReport) {
+	for _, source := range report.Sources {
+		fmt.Printf("%-15s %d images migrated, %d left inline\n", source.Source, source.Migrated, source.Failed)
+	}
+	fmt.Printf("Inline images removed from the database: %s\n", formatBytes(report.InlineBytes))
+	fmt.Printf("Images written to the blob store: %s\n", formatBytes(report.StoredBytes))
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
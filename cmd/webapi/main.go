@@ -1,7 +1,7 @@
 /*
 Webapi is the executable for the main web server.
 It builds a web server around APIs from `service/api`.
-Webapi connects to external resources needed (database) and starts two web servers: the API web server, and the debug.
+Webapi connects to external resources needed (database, SQLite or PostgreSQL, or an in-memory one for demos) and starts two web servers: the API web server, and the debug.
 Everything is served via the API web server, except debug variables (/debug/vars) and profiler infos (pprof).
 
 Usage:
@@ -19,7 +19,8 @@ Return values (exit codes):
 		The program ended due to an error
 
 Note that this program will update the schema of the database to the latest version available (embedded in the
-executable during the build).
+executable during the build), and refuses to start if the database was updated by a newer version. With
+--migrate-only it exits after updating the schema; with --dry-run it only lists the migrations that would be applied.
 */
 package main
 
@@ -35,8 +36,10 @@ import (
 	"syscall"
 
 	"github.com/ardanlabs/conf"
+	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/rerikdev/WASAText/service/api"
+	"github.com/rerikdev/WASAText/service/blobstore"
 	"github.com/rerikdev/WASAText/service/database"
 	"github.com/rerikdev/WASAText/service/globaltime"
 	"github.com/sirupsen/logrus"
@@ -84,21 +87,69 @@ func run() error {
 	// Start Database
 	logger.Println("initializing database support")
 
-	// Open SQLite DB with WAL mode and busy timeout (5 seconds)
-	dbconn, err := sql.Open("sqlite3", fmt.Sprintf("%s?_journal_mode=WAL&_busy_timeout=5000", cfg.DB.Filename))
-	if err != nil {
-		logger.WithError(err).Error("error opening SQLite DB")
-		return fmt.Errorf("opening SQLite with WAL: %w", err)
-	}
-	defer func() {
-		logger.Debug("database stopping")
-		_ = dbconn.Close()
-	}()
+	var db database.AppDatabase
+	var blobs blobstore.Store
+	if cfg.DB.Driver == database.DriverMemory {
+		// Demo mode: the data and the uploaded media live only as long as the process
+		logger.Warn("using the in-memory database: all data will be lost when the server stops")
+		if cfg.DryRun || cfg.MigrateOnly {
+			logger.Info("the in-memory database has no schema to migrate")
+			return nil
+		}
+		db = database.NewMemory()
+		blobs = blobstore.NewMemory()
+	} else {
+		dbconn, err := openDatabase(cfg)
+		if err != nil {
+			logger.WithError(err).Error("error opening the database")
+			return fmt.Errorf("opening the database: %w", err)
+		}
+		defer func() {
+			logger.Debug("database stopping")
+			_ = dbconn.Close()
+		}()
 
-	db, err := database.New(dbconn)
-	if err != nil {
-		logger.WithError(err).Error("error creating AppDatabase")
-		return fmt.Errorf("creating AppDatabase: %w", err)
+		// Schema migrations
+		status, err := database.CheckSchema(dbconn, cfg.DB.Driver)
+		if err != nil {
+			logger.WithError(err).Error("error checking the database schema")
+			return fmt.Errorf("checking the database schema: %w", err)
+		}
+		if cfg.DryRun {
+			logger.Infof("database schema version %d, latest %d", status.Version, status.Latest)
+			if status.Legacy {
+				logger.Info("the database was created before versioned migrations, it will be adapted by the first one")
+			}
+			for _, m := range status.Pending {
+				logger.Infof("pending migration %d: %s", m.Version, m.Name)
+			}
+			return nil
+		}
+		applied, err := database.Migrate(dbconn, cfg.DB.Driver)
+		for _, m := range applied {
+			logger.Infof("applied migration %d: %s", m.Version, m.Name)
+		}
+		if err != nil {
+			logger.WithError(err).Error("error migrating the database")
+			return fmt.Errorf("migrating the database: %w", err)
+		}
+		if cfg.MigrateOnly {
+			logger.Infof("database schema is at version %d", status.Latest)
+			return nil
+		}
+
+		db, err = database.New(dbconn, cfg.DB.Driver)
+		if err != nil {
+			logger.WithError(err).Error("error creating AppDatabase")
+			return fmt.Errorf("creating AppDatabase: %w", err)
+		}
+
+		// Blob store for the uploaded media
+		blobs, err = blobstore.NewLocal(cfg.Media.Dir)
+		if err != nil {
+			logger.WithError(err).Error("error creating the blob store")
+			return fmt.Errorf("creating the blob store: %w", err)
+		}
 	}
 
 	// Start (main) API server
@@ -115,8 +166,12 @@ func run() error {
 
 	// Create the API router
 	apirouter, err := api.New(api.Config{
-		Logger:   logger,
-		Database: db,
+		Logger:                  logger,
+		Database:                db,
+		Blobs:                   blobs,
+		SessionTTL:              cfg.Auth.SessionTTL,
+		MessageEditWindow:       cfg.Messages.EditWindow,
+		DeletedMessageRetention: cfg.Messages.DeletedRetention,
 	})
 	if err != nil {
 		logger.WithError(err).Error("error creating the API server instance")
@@ -186,3 +241,13 @@ func run() error {
 
 	return nil
 }
+
+// openDatabase opens the database selected by the configuration. SQLite is opened in WAL mode with a busy timeout (5
+// seconds). With either database only one instance of webapi must run, since real-time events are not shared between
+// processes.
+func openDatabase(cfg WebAPIConfiguration) (*sql.DB, error) {
+	if cfg.DB.Driver == database.DriverPostgres {
+		return sql.Open(database.DriverPostgres, cfg.DB.DSN)
+	}
+	return sql.Open(database.DriverSQLite, fmt.Sprintf("%s?_journal_mode=WAL&_busy_timeout=5000", cfg.DB.Filename))
+}
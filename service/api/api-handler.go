@@ -10,29 +10,68 @@ func (rt *_router) Handler() http.Handler {
 	rt.router.GET("/", rt.getHelloWorld)
 	rt.router.GET("/context", rt.wrap(rt.getContextReply))
 	rt.router.POST("/session", rt.doLogin)
+	rt.router.DELETE("/session", rt.authWrap(rt.doLogout))
+	rt.router.DELETE("/sessions", rt.authWrap(rt.doLogoutAll))
 
 	// Profile routes
-	rt.router.GET("/users/:userId", rt.getUser)
-	rt.router.PATCH("/users/:userId", rt.setMyUserName)
-	rt.router.PATCH("/users/:userId/photo", rt.setMyPhoto)
-	rt.router.GET("/search/users", rt.searchUsers)
-
-	rt.router.POST("/conversations", rt.createConversation)
-	rt.router.POST("/conversations/:id/messages", rt.sendMessage)
-	rt.router.GET("/conversations/:id/messages", rt.getConversation)
-	rt.router.GET("/conversations", rt.getMyConversations)
-	rt.router.PATCH("/conversations/:id/messages/read", rt.markMessagesRead)
-	rt.router.DELETE("/conversations/:id/messages/:messageId", rt.deleteMessage)
-	rt.router.POST("/conversations/:id/messages/:messageId/forward", rt.forwardMessage)
-	//rt.router.POST("/conversations/:id/messages/:messageId/reactions", rt.commentMessage)
-	//rt.router.DELETE("/conversations/:id/messages/:messageId/reactions", rt.uncommentMessage)
-	//rt.router.GET("/conversations/:id/messages/:messageId/reactions", rt.getMessageReactions)
-	rt.router.POST("/groups", rt.addToGroup)
-	rt.router.GET("/groups", rt.listGroups)
-	rt.router.DELETE("/groups/:id/members", rt.leaveGroup)
-	rt.router.PATCH("/groups/:id/name", rt.setGroupName)
-	rt.router.PATCH("/groups/:id/photo", rt.setGroupPhoto)
-	rt.router.PATCH("/groups/:id/members", rt.addGroupMembers)
+	rt.router.GET("/users/:userId", rt.authWrap(rt.getUser))
+	rt.router.PATCH("/users/:userId", rt.authWrap(rt.selfOnly(rt.setMyUserName)))
+	rt.router.PATCH("/users/:userId/photo", rt.authWrap(rt.selfOnly(rt.setMyPhoto)))
+	rt.router.PUT("/users/:userId/password", rt.authWrap(rt.selfOnly(rt.changePassword)))
+	rt.router.GET("/search/users", rt.authWrap(rt.searchUsers))
+	rt.router.GET("/search/messages", rt.authWrap(rt.searchMessages))
+
+	rt.router.POST("/conversations", rt.authWrap(rt.createConversation))
+	rt.router.POST("/conversations/:id/messages", rt.authWrap(rt.conversationMember(rt.sendMessage)))
+	rt.router.GET("/conversations/:id/messages", rt.authWrap(rt.conversationMember(rt.getConversation)))
+	rt.router.GET("/conversations", rt.authWrap(rt.getMyConversations))
+	rt.router.GET("/inbox", rt.authWrap(rt.getInbox))
+	rt.router.PATCH("/conversations/:id/preferences", rt.authWrap(rt.conversationMember(rt.setConversationPreferences)))
+	// Handles both PATCH /conversations/:id/messages/read and the message edit, see patchMessage
+	rt.router.PATCH("/conversations/:id/messages/:messageId", rt.authWrap(rt.patchMessage))
+	rt.router.DELETE("/conversations/:id/messages/:messageId", rt.authWrap(rt.conversationMessage(rt.deleteMessage)))
+	rt.router.GET("/conversations/:id/messages/:messageId/edits", rt.authWrap(rt.conversationMessage(rt.getMessageEdits)))
+	rt.router.GET("/conversations/:id/messages/:messageId/receipts", rt.authWrap(rt.conversationMessage(rt.getMessageReceipts)))
+	rt.router.GET("/conversations/:id/messages/:messageId/thread", rt.authWrap(rt.conversationMessage(rt.getThread)))
+	rt.router.PATCH("/conversations/:id/messages/:messageId/thread/read", rt.authWrap(rt.conversationMessage(rt.markThreadRead)))
+	rt.router.POST("/conversations/:id/messages/:messageId/forward", rt.authWrap(rt.conversationMessage(rt.forwardMessage)))
+	rt.router.PUT("/conversations/:id/messages/:messageId/pin", rt.authWrap(rt.conversationMessage(rt.pinMessage)))
+	rt.router.DELETE("/conversations/:id/messages/:messageId/pin", rt.authWrap(rt.conversationMessage(rt.unpinMessage)))
+	rt.router.GET("/conversations/:id/pins", rt.authWrap(rt.conversationMember(rt.getPinnedMessages)))
+	//rt.router.POST("/conversations/:id/messages/:messageId/reactions", rt.authWrap(rt.conversationMessage(rt.commentMessage)))
+	//rt.router.DELETE("/conversations/:id/messages/:messageId/reactions", rt.authWrap(rt.conversationMessage(rt.uncommentMessage)))
+	//rt.router.GET("/conversations/:id/messages/:messageId/reactions", rt.authWrap(rt.conversationMessage(rt.getMessageReactions)))
+	rt.router.POST("/groups", rt.authWrap(rt.addToGroup))
+	rt.router.GET("/groups", rt.authWrap(rt.listGroups))
+	rt.router.GET("/groups/:id", rt.authWrap(rt.groupMember(rt.getGroupInfo)))
+	rt.router.DELETE("/groups/:id", rt.authWrap(rt.groupMember(rt.deleteGroup)))
+	rt.router.DELETE("/groups/:id/members", rt.authWrap(rt.groupMember(rt.leaveGroup)))
+	rt.router.PATCH("/groups/:id/name", rt.authWrap(rt.groupMember(rt.setGroupName)))
+	rt.router.PATCH("/groups/:id/photo", rt.authWrap(rt.groupMember(rt.setGroupPhoto)))
+	rt.router.PATCH("/groups/:id/description", rt.authWrap(rt.groupMember(rt.setGroupDescription)))
+	rt.router.PATCH("/groups/:id/members", rt.authWrap(rt.groupMember(rt.addGroupMembers)))
+	rt.router.PATCH("/groups/:id/members/:userId", rt.authWrap(rt.groupMember(rt.setGroupMemberRole)))
+	rt.router.DELETE("/groups/:id/members/:userId", rt.authWrap(rt.groupMember(rt.removeGroupMember)))
+	rt.router.PATCH("/groups/:id/settings", rt.authWrap(rt.groupMember(rt.setGroupSettings)))
+	rt.router.GET("/groups/:id/bans", rt.authWrap(rt.groupMember(rt.listGroupBans)))
+	rt.router.PUT("/groups/:id/bans/:userId", rt.authWrap(rt.groupMember(rt.banFromGroup)))
+	rt.router.DELETE("/groups/:id/bans/:userId", rt.authWrap(rt.groupMember(rt.unbanFromGroup)))
+	rt.router.POST("/groups/:id/invites", rt.authWrap(rt.groupMember(rt.createGroupInvite)))
+	rt.router.GET("/groups/:id/invites", rt.authWrap(rt.groupMember(rt.listGroupInvites)))
+	rt.router.DELETE("/groups/:id/invites/:token", rt.authWrap(rt.groupMember(rt.revokeGroupInvite)))
+	rt.router.GET("/groups/:id/join-requests", rt.authWrap(rt.groupMember(rt.listGroupJoinRequests)))
+	rt.router.POST("/groups/:id/join-requests/:userId/approve", rt.authWrap(rt.groupMember(rt.approveGroupJoinRequest)))
+	rt.router.DELETE("/groups/:id/join-requests/:userId", rt.authWrap(rt.groupMember(rt.rejectGroupJoinRequest)))
+	rt.router.GET("/invites/:token", rt.authWrap(rt.previewGroupInvite))
+	rt.router.POST("/invites/:token/join", rt.authWrap(rt.joinGroupByInvite))
+
+	// Media
+	rt.router.POST("/media", rt.authWrap(rt.uploadMedia))
+	rt.router.GET("/media/:id", rt.streamAuthWrap(rt.getMedia))
+
+	// Real-time events
+	rt.router.GET("/ws", rt.streamAuthWrap(rt.websocketHandler))
+	rt.router.GET("/events", rt.streamAuthWrap(rt.sseHandler))
 
 	// Special routes
 	rt.router.GET("/liveness", rt.liveness)
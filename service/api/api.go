@@ -38,8 +38,12 @@ package api
 
 import (
 	"errors"
+	"sync"
+	"time"
 
+	"github.com/rerikdev/WASAText/service/blobstore"
 	"github.com/rerikdev/WASAText/service/database"
+	"github.com/rerikdev/WASAText/service/events"
 
 	// "git.sapienzaapps.it/fantasticcoffee/fantastic-coffee-decaffeinated/service/database"
 	"net/http"
@@ -55,6 +59,19 @@ type Config struct {
 
 	// Database is the instance of database.AppDatabase where data are saved
 	Database database.AppDatabase
+
+	// Blobs is the store where the content of uploaded media is saved
+	Blobs blobstore.Store
+
+	// SessionTTL is the lifetime of session tokens issued by doLogin (default: 30 days)
+	SessionTTL time.Duration
+
+	// MessageEditWindow is how long after sending a message its sender can edit it (default: 15 minutes)
+	MessageEditWindow time.Duration
+
+	// DeletedMessageRetention is how long deleted messages are kept as placeholders before being purged (default: 30
+	// days)
+	DeletedMessageRetention time.Duration
 }
 
 // Router is the package API interface representing an API handler builder
@@ -75,18 +92,39 @@ func New(cfg Config) (Router, error) {
 	if cfg.Database == nil {
 		return nil, errors.New("database is required")
 	}
+	if cfg.Blobs == nil {
+		return nil, errors.New("blob store is required")
+	}
 
 	// Create a new router where we will register HTTP endpoints. The server will pass requests to this router to be
 	// handled.
+	if cfg.SessionTTL <= 0 {
+		cfg.SessionTTL = defaultSessionTTL
+	}
+	if cfg.MessageEditWindow <= 0 {
+		cfg.MessageEditWindow = defaultMessageEditWindow
+	}
+	if cfg.DeletedMessageRetention <= 0 {
+		cfg.DeletedMessageRetention = defaultDeletedMessageRetention
+	}
+
 	router := httprouter.New()
 	router.RedirectTrailingSlash = false
 	router.RedirectFixedPath = false
 
-	return &_router{
-		router:     router,
-		baseLogger: cfg.Logger,
-		db:         cfg.Database,
-	}, nil
+	rt := &_router{
+		router:           router,
+		baseLogger:       cfg.Logger,
+		db:               cfg.Database,
+		blobs:            cfg.Blobs,
+		sessionTTL:       cfg.SessionTTL,
+		editWindow:       cfg.MessageEditWindow,
+		deletedRetention: cfg.DeletedMessageRetention,
+		hub:              events.NewHub(cfg.Database),
+		stop:             make(chan struct{}),
+	}
+	go rt.housekeeping()
+	return rt, nil
 }
 
 type _router struct {
@@ -97,4 +135,24 @@ type _router struct {
 	baseLogger logrus.FieldLogger
 
 	db database.AppDatabase
+
+	blobs blobstore.Store
+
+	// blobsMu is held for reading while a media is saved and for writing while the unused blobs are deleted, so that
+	// a blob reused by a new media is not deleted before the media is in the database
+	blobsMu sync.RWMutex
+
+	sessionTTL time.Duration
+
+	// editWindow is how long after sending a message its sender can edit it
+	editWindow time.Duration
+
+	// deletedRetention is how long deleted messages are kept as placeholders before being purged
+	deletedRetention time.Duration
+
+	// hub dispatches real-time events to the clients connected to /ws and /events
+	hub *events.Hub
+
+	// stop is closed by Close to terminate the background goroutines
+	stop chan struct{}
 }
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rerikdev/WASAText/service/blobstore"
+	"github.com/rerikdev/WASAText/service/database"
+	"github.com/sirupsen/logrus"
+)
+
+// newTestHandler returns the API handler backed by an empty in-memory database and blob store, so tests do not touch
+// the disk.
+func newTestHandler(t *testing.T) http.Handler {
+	t.Helper()
+	return newTestHandlerWithDB(t, database.NewMemory())
+}
+
+// newTestHandlerWithDB is like newTestHandler, backed by db.
+func newTestHandlerWithDB(t *testing.T, db database.AppDatabase) http.Handler {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	rt, err := New(Config{Logger: logger, Database: db, Blobs: blobstore.NewMemory()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rt.Handler()
+}
+
+// doRequest sends a JSON request as the user owning token (if any) and returns the recorded response.
+func doRequest(t *testing.T, h http.Handler, method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	var payload io.Reader
+	if body != nil {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		payload = bytes.NewReader(buf)
+	}
+	req := httptest.NewRequest(method, path, payload)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// register creates a user and returns its ID and session token.
+func register(t *testing.T, h http.Handler, username string) (int, string) {
+	t.Helper()
+	rec := doRequest(t, h, http.MethodPost, "/session", "", map[string]string{
+		"name": username, "displayName": username, "profilePicture": "https://example.com/" + username + ".jpg",
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("registering %s: status %d: %s", username, rec.Code, rec.Body.String())
+	}
+	var res struct {
+		User  struct{ ID int }
+		Token string
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	return res.User.ID, res.Token
+}
@@ -2,18 +2,67 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/rerikdev/WASAText/service/api/reqcontext"
+	"github.com/rerikdev/WASAText/service/database"
 )
 
-func checkAuthorization(w http.ResponseWriter, r *http.Request) bool {
-	auth := r.Header.Get("Authorization")
-	if auth == "" {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusUnauthorized)
-		if err := json.NewEncoder(w).Encode(map[string]string{"message": "Non autorizzato"}); err != nil {
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+// authWrap works like wrap, but before calling the handler it resolves the bearer token in the Authorization header to
+// the authenticated user (see reqcontext.RequestContext.UserID). Requests without a valid session receive a 401.
+func (rt *_router) authWrap(fn httpRouterHandler) func(http.ResponseWriter, *http.Request, httprouter.Params) {
+	return rt.authenticate(fn, false)
+}
+
+// streamAuthWrap is like authWrap, but the token can also be passed in the "token" query parameter: browsers cannot
+// set custom headers when opening a WebSocket or an EventSource.
+func (rt *_router) streamAuthWrap(fn httpRouterHandler) func(http.ResponseWriter, *http.Request, httprouter.Params) {
+	return rt.authenticate(fn, true)
+}
+
+func (rt *_router) authenticate(fn httpRouterHandler, allowQueryToken bool) func(http.ResponseWriter, *http.Request, httprouter.Params) {
+	return rt.wrap(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+		token := bearerToken(r)
+		if token == "" && allowQueryToken {
+			token = r.URL.Query().Get("token")
+		}
+		if token == "" {
+			sendUnauthorized(w)
+			return
+		}
+		userId, err := rt.db.GetSessionUserId(token)
+		if errors.Is(err, database.ErrSessionNotFound) {
+			sendUnauthorized(w)
+			return
+		} else if err != nil {
+			ctx.Logger.WithError(err).Error("can't resolve the session token")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
 		}
-		return false
+
+		ctx.UserID = userId
+		ctx.Token = token
+		ctx.Logger = ctx.Logger.WithField("userid", userId)
+		fn(w, r, ps, ctx)
+	})
+}
+
+// bearerToken estrae il token dall'header "Authorization: Bearer <token>"
+func bearerToken(r *http.Request) string {
+	auth := strings.TrimSpace(r.Header.Get("Authorization"))
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+func sendUnauthorized(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	if err := json.NewEncoder(w).Encode(map[string]string{"message": "Non autorizzato"}); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 	}
-	return true
 }
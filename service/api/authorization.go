@@ -0,0 +1,114 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/rerikdev/WASAText/service/api/reqcontext"
+	"github.com/rerikdev/WASAText/service/database"
+)
+
+// This file contains the resource-level authorization policies. Every route that touches a user, a conversation or a
+// group is registered in api-handler.go behind one of the wrappers below (after authWrap), so that handlers can assume
+// the caller is allowed to act on the resource in the path. Unknown resources are answered with 404, resources that
+// exist but are not accessible by the caller with 403.
+
+// selfOnly allows the request only if the :userId path parameter is the authenticated user.
+func (rt *_router) selfOnly(fn httpRouterHandler) httpRouterHandler {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+		if ps.ByName("userId") != strconv.Itoa(ctx.UserID) {
+			sendPolicyError(w, http.StatusForbidden, "Operazione non consentita")
+			return
+		}
+		fn(w, r, ps, ctx)
+	}
+}
+
+// conversationMember allows the request only if the caller is a member of the conversation (1:1 or group) in the :id
+// path parameter.
+func (rt *_router) conversationMember(fn httpRouterHandler) httpRouterHandler {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+		conversationId, err := strconv.Atoi(ps.ByName("id"))
+		if err != nil {
+			sendPolicyError(w, http.StatusBadRequest, "Conversazione non valida")
+			return
+		}
+		if !rt.checkConversationAccess(w, ctx, conversationId, false) {
+			return
+		}
+		fn(w, r, ps, ctx)
+	}
+}
+
+// groupMember allows the request only if the :id path parameter is a group and the caller is one of its members.
+func (rt *_router) groupMember(fn httpRouterHandler) httpRouterHandler {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+		groupId, err := strconv.Atoi(ps.ByName("id"))
+		if err != nil {
+			sendPolicyError(w, http.StatusBadRequest, "ID gruppo non valido")
+			return
+		}
+		if !rt.checkConversationAccess(w, ctx, groupId, true) {
+			return
+		}
+		fn(w, r, ps, ctx)
+	}
+}
+
+// conversationMessage works like conversationMember, and additionally requires the :messageId path parameter to be a
+// message of that conversation.
+func (rt *_router) conversationMessage(fn httpRouterHandler) httpRouterHandler {
+	return rt.conversationMember(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+		conversationId, _ := strconv.Atoi(ps.ByName("id"))
+		messageId, err := strconv.Atoi(ps.ByName("messageId"))
+		if err != nil {
+			sendPolicyError(w, http.StatusBadRequest, "Messaggio non valido")
+			return
+		}
+		exists, err := rt.db.MessageExists(conversationId, messageId)
+		if err != nil {
+			ctx.Logger.WithError(err).Error("can't check the message")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if !exists {
+			sendPolicyError(w, http.StatusNotFound, "Messaggio non trovato")
+			return
+		}
+		fn(w, r, ps, ctx)
+	})
+}
+
+// checkConversationAccess verifies that the authenticated user is a member of the conversation (and, if groupOnly is
+// true, that the conversation is a group). If not, it writes the error response and returns false.
+func (rt *_router) checkConversationAccess(w http.ResponseWriter, ctx reqcontext.RequestContext, conversationId int, groupOnly bool) bool {
+	isGroup, isMember, err := rt.db.GetMembership(conversationId, ctx.UserID)
+	if errors.Is(err, database.ErrConversationNotFound) || (err == nil && groupOnly && !isGroup) {
+		if groupOnly {
+			sendPolicyError(w, http.StatusNotFound, "Gruppo non trovato")
+		} else {
+			sendPolicyError(w, http.StatusNotFound, "Conversazione non trovata")
+		}
+		return false
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("can't check conversation membership")
+		w.WriteHeader(http.StatusInternalServerError)
+		return false
+	}
+	if !isMember {
+		sendPolicyError(w, http.StatusForbidden, "Non sei membro di questa conversazione")
+		return false
+	}
+	return true
+}
+
+func sendPolicyError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(map[string]string{"message": message}); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
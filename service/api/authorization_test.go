@@ -0,0 +1,146 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/rerikdev/WASAText/service/database"
+)
+
+func TestNonMembersCannotAccessConversations(t *testing.T) {
+	h := newTestHandler(t)
+	_, aliceToken := register(t, h, "alice")
+	bobID, _ := register(t, h, "bob")
+	_, malloryToken := register(t, h, "mallory")
+
+	rec := doRequest(t, h, http.MethodPost, "/conversations", aliceToken, map[string]int{"userId": bobID})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("creating conversation: status %d", rec.Code)
+	}
+	var conv struct{ ConversationID int }
+	_ = json.Unmarshal(rec.Body.Bytes(), &conv)
+
+	rec = doRequest(t, h, http.MethodPost, "/groups", aliceToken, map[string]interface{}{
+		"name": "friends", "members": []string{"alice", "bob"},
+	})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("creating group: status %d", rec.Code)
+	}
+	var group struct{ ID int }
+	_ = json.Unmarshal(rec.Body.Bytes(), &group)
+
+	rec = doRequest(t, h, http.MethodPost, fmt.Sprintf("/conversations/%d/messages", conv.ConversationID), aliceToken, map[string]string{"content": "secret"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("sending message: status %d", rec.Code)
+	}
+	var msg struct{ ID int }
+	_ = json.Unmarshal(rec.Body.Bytes(), &msg)
+
+	convPath := fmt.Sprintf("/conversations/%d", conv.ConversationID)
+	msgPath := fmt.Sprintf("%s/messages/%d", convPath, msg.ID)
+	groupPath := fmt.Sprintf("/groups/%d", group.ID)
+	cases := []struct {
+		method, path string
+		body         interface{}
+	}{
+		{http.MethodGet, convPath + "/messages", nil},
+		{http.MethodPost, convPath + "/messages", map[string]string{"content": "hi"}},
+		{http.MethodPatch, convPath + "/messages/read", nil},
+		{http.MethodDelete, msgPath, nil},
+		{http.MethodPost, msgPath + "/forward", map[string]int{"targetConversationId": conv.ConversationID}},
+		{http.MethodGet, msgPath + "/thread", nil},
+		{http.MethodPatch, msgPath + "/thread/read", nil},
+		{http.MethodPut, msgPath + "/pin", nil},
+		{http.MethodDelete, msgPath + "/pin", nil},
+		{http.MethodGet, fmt.Sprintf("/conversations/%d/pins", group.ID), nil},
+		{http.MethodGet, fmt.Sprintf("/conversations/%d/messages", group.ID), nil},
+		{http.MethodPatch, groupPath + "/name", map[string]string{"name": "pwned"}},
+		{http.MethodPatch, groupPath + "/photo", map[string]string{"photo": "https://example.com/x.jpg"}},
+		{http.MethodPatch, groupPath + "/members", map[string][]string{"members": {"mallory"}}},
+		{http.MethodDelete, groupPath + "/members", nil},
+		{http.MethodPatch, fmt.Sprintf("%s/members/%d", groupPath, bobID), map[string]string{"role": "admin"}},
+		{http.MethodDelete, fmt.Sprintf("%s/members/%d", groupPath, bobID), nil},
+		{http.MethodPatch, groupPath + "/settings", map[string]bool{"onlyAdminsSend": true}},
+		{http.MethodPost, groupPath + "/invites", map[string]int{}},
+		{http.MethodGet, groupPath + "/invites", nil},
+		{http.MethodGet, groupPath + "/join-requests", nil},
+		{http.MethodGet, groupPath + "/bans", nil},
+		{http.MethodPut, fmt.Sprintf("%s/bans/%d", groupPath, bobID), nil},
+		{http.MethodDelete, fmt.Sprintf("%s/bans/%d", groupPath, bobID), nil},
+		{http.MethodDelete, groupPath, nil},
+		{http.MethodGet, groupPath, nil},
+		{http.MethodPatch, groupPath + "/description", map[string]string{"description": "pwned"}},
+		{http.MethodPatch, fmt.Sprintf("/users/%d", bobID), map[string]string{"newName": "pwned"}},
+		{http.MethodPatch, fmt.Sprintf("/users/%d/photo", bobID), map[string]string{"photoUrl": "https://example.com/x.jpg"}},
+		{http.MethodPut, fmt.Sprintf("/users/%d/password", bobID), map[string]string{"newPassword": "pwned-password"}},
+	}
+	for _, c := range cases {
+		rec := doRequest(t, h, c.method, c.path, malloryToken, c.body)
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("%s %s as non-member: got status %d, want %d", c.method, c.path, rec.Code, http.StatusForbidden)
+		}
+	}
+
+	// Nothing has been changed by the rejected requests
+	rec = doRequest(t, h, http.MethodGet, convPath+"/messages", aliceToken, nil)
+	var page struct{ Messages []struct{ Content string } }
+	_ = json.Unmarshal(rec.Body.Bytes(), &page)
+	if rec.Code != http.StatusOK || len(page.Messages) != 1 || page.Messages[0].Content != "secret" {
+		t.Errorf("conversation was modified by a non-member: status %d, %d messages", rec.Code, len(page.Messages))
+	}
+}
+
+func TestUnknownResourcesAreNotFound(t *testing.T) {
+	h := newTestHandler(t)
+	_, token := register(t, h, "alice")
+
+	cases := []struct{ method, path string }{
+		{http.MethodGet, "/conversations/999/messages"},
+		{http.MethodPatch, "/groups/999/name"},
+	}
+	for _, c := range cases {
+		rec := doRequest(t, h, c.method, c.path, token, map[string]string{"name": "x"})
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("%s %s: got status %d, want %d", c.method, c.path, rec.Code, http.StatusNotFound)
+		}
+	}
+
+	rec := doRequest(t, h, http.MethodGet, "/conversations", "not-a-token", nil)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("invalid token: got status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
+
+// brokenMessageLookup is a database that can't check whether a message exists
+type brokenMessageLookup struct {
+	database.AppDatabase
+}
+
+func (brokenMessageLookup) MessageExists(conversationId, messageId int) (bool, error) {
+	return false, errors.New("connection lost")
+}
+
+func TestMessageLookupErrors(t *testing.T) {
+	db := database.NewMemory()
+	h := newTestHandlerWithDB(t, db)
+	_, aliceToken := register(t, h, "alice")
+	bobID, _ := register(t, h, "bob")
+	var conv struct{ ConversationID int }
+	decode(t, doRequest(t, h, http.MethodPost, "/conversations", aliceToken, map[string]int{"userId": bobID}), http.StatusCreated, &conv)
+	var msg struct{ ID int }
+	decode(t, doRequest(t, h, http.MethodPost, fmt.Sprintf("/conversations/%d/messages", conv.ConversationID), aliceToken, map[string]string{"content": "hi"}), http.StatusOK, &msg)
+
+	// Only a missing message is not found: a failed lookup is a server error
+	missing := fmt.Sprintf("/conversations/%d/messages/%d/receipts", conv.ConversationID, msg.ID+100)
+	if rec := doRequest(t, h, http.MethodGet, missing, aliceToken, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("missing message: got status %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	broken := newTestHandlerWithDB(t, brokenMessageLookup{db})
+	existing := fmt.Sprintf("/conversations/%d/messages/%d/receipts", conv.ConversationID, msg.ID)
+	if rec := doRequest(t, broken, http.MethodGet, existing, aliceToken, nil); rec.Code != http.StatusInternalServerError {
+		t.Errorf("failed lookup: got status %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+}
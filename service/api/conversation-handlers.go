@@ -6,14 +6,11 @@ import (
 	"strconv"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/rerikdev/WASAText/service/api/reqcontext"
 )
 
 // POST /conversations
-func (rt *_router) createConversation(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	if !checkAuthorization(w, r) {
-		return
-	}
-
+func (rt *_router) createConversation(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
 	var req struct {
 		UserId int `json:"userId"`
 	}
@@ -26,17 +23,15 @@ func (rt *_router) createConversation(w http.ResponseWriter, r *http.Request, _
 		return
 	}
 
-	userId := r.Header.Get("Authorization")
-	userIdInt, err := strconv.Atoi(userId)
-	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		if encErr := json.NewEncoder(w).Encode(map[string]string{"message": "Utente non autorizzato"}); encErr != nil {
+	if _, err := rt.db.GetUserById(strconv.Itoa(req.UserId)); err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		if encErr := json.NewEncoder(w).Encode(map[string]string{"message": "Utente non trovato"}); encErr != nil {
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		}
 		return
 	}
 
-	convID, err := rt.db.CreateConversation(userIdInt, req.UserId)
+	convID, err := rt.db.CreateConversation(ctx.UserID, req.UserId)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		if encErr := json.NewEncoder(w).Encode(map[string]string{"message": "Errore creazione conversazione"}); encErr != nil {
@@ -53,22 +48,8 @@ func (rt *_router) createConversation(w http.ResponseWriter, r *http.Request, _
 }
 
 // GET /conversations
-func (rt *_router) getMyConversations(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	if !checkAuthorization(w, r) {
-		return
-	}
-
-	userId := r.Header.Get("Authorization")
-	userIdInt, err := strconv.Atoi(userId)
-	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		if encErr := json.NewEncoder(w).Encode(map[string]string{"message": "Utente non autorizzato"}); encErr != nil {
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		}
-		return
-	}
-
-	conversations, err := rt.db.GetUserConversations(userIdInt)
+func (rt *_router) getMyConversations(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	conversations, err := rt.db.GetUserConversations(ctx.UserID)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		if encErr := json.NewEncoder(w).Encode(map[string]string{"message": "Errore recupero conversazioni"}); encErr != nil {
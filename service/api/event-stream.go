@@ -0,0 +1,41 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/rerikdev/WASAText/service/api/reqcontext"
+	"github.com/rerikdev/WASAText/service/events"
+	"github.com/rerikdev/WASAText/service/globaltime"
+	"github.com/rerikdev/WASAText/service/structures"
+)
+
+// lastEventId returns the ID of the last event received by a reconnecting client, from the Last-Event-ID header or the
+// lastEventId query parameter (zero if none).
+func lastEventId(r *http.Request) int64 {
+	value := r.Header.Get("Last-Event-ID")
+	if value == "" {
+		value = r.URL.Query().Get("lastEventId")
+	}
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || id < 0 {
+		return 0
+	}
+	return id
+}
+
+// subscribe registers the authenticated user to the event hub, resuming from the last event ID sent by the client. It
+// returns the events to send before the live ones: a Resync event if the missed events are no longer available, or the
+// missed events themselves. On error it replies with 500 and returns false.
+func (rt *_router) subscribe(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) (*events.Subscription, []*structures.Event, bool) {
+	sub, missed, complete, err := rt.hub.Subscribe(ctx.UserID, lastEventId(r))
+	if err != nil {
+		ctx.Logger.WithError(err).Error("can't load the missed events")
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	if !complete {
+		return sub, []*structures.Event{{Type: events.Resync, Timestamp: globaltime.Now()}}, true
+	}
+	return sub, missed, true
+}
@@ -0,0 +1,230 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// streamEvent is an event received from /events or /ws
+type streamEvent struct {
+	ID   int64
+	Type string
+	Data struct{ Content, Kind string }
+}
+
+// sseStream is an open connection to /events
+type sseStream struct {
+	resp   *http.Response
+	events chan streamEvent
+}
+
+// openSSE connects to /events with the given query and headers, failing unless the stream starts
+func openSSE(t *testing.T, srv *httptest.Server, query string, header http.Header) *sseStream {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/events"+query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		resp.Body.Close()
+		t.Fatalf("opening the stream: status %d, content type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	s := &sseStream{resp: resp, events: make(chan streamEvent, 100)}
+	t.Cleanup(s.close)
+	go func() {
+		defer close(s.events)
+		var ev streamEvent
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "id: "):
+				ev.ID, _ = strconv.ParseInt(line[len("id: "):], 10, 64)
+			case strings.HasPrefix(line, "data: "):
+				var payload streamEvent
+				if json.Unmarshal([]byte(line[len("data: "):]), &payload) == nil {
+					ev.Type, ev.Data = payload.Type, payload.Data
+					if payload.ID != ev.ID {
+						ev.Type = fmt.Sprintf("id mismatch: %d in the data, %d in the stream", payload.ID, ev.ID)
+					}
+				}
+			case line == "" && ev.Type != "":
+				s.events <- ev
+				ev = streamEvent{}
+			}
+		}
+	}()
+	return s
+}
+
+func (s *sseStream) close() {
+	s.resp.Body.Close()
+}
+
+// next returns the next event of the given type, skipping the others
+func (s *sseStream) next(t *testing.T, eventType string) streamEvent {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ev, ok := <-s.events:
+			if !ok {
+				t.Fatalf("stream closed while waiting for %s", eventType)
+			}
+			if ev.Type == eventType {
+				return ev
+			}
+		case <-timeout:
+			t.Fatalf("no %s event received", eventType)
+		}
+	}
+}
+
+// nextWS returns the next event of the given type received on a WebSocket, skipping the others
+func nextWS(t *testing.T, conn *websocket.Conn, eventType string) streamEvent {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var ev streamEvent
+		if err := conn.ReadJSON(&ev); err != nil {
+			t.Fatalf("waiting for %s: %v", eventType, err)
+		}
+		if ev.Type == eventType {
+			return ev
+		}
+	}
+}
+
+// newStreamTest starts a server with alice and bob in a conversation and returns a function sending messages from
+// alice, together with bob's token
+func newStreamTest(t *testing.T) (*httptest.Server, func(content string), string) {
+	t.Helper()
+	h := newTestHandler(t)
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+
+	_, aliceToken := register(t, h, "alice")
+	bobID, bobToken := register(t, h, "bob")
+	var conv struct{ ConversationID int }
+	decode(t, doRequest(t, h, http.MethodPost, "/conversations", aliceToken, map[string]int{"userId": bobID}), http.StatusCreated, &conv)
+
+	send := func(content string) {
+		t.Helper()
+		path := fmt.Sprintf("/conversations/%d/messages", conv.ConversationID)
+		decode(t, doRequest(t, h, http.MethodPost, path, aliceToken, map[string]string{"content": content}), http.StatusOK, nil)
+	}
+	return srv, send, bobToken
+}
+
+func TestSSEResumesWithLastEventID(t *testing.T) {
+	srv, send, bobToken := newStreamTest(t)
+	auth := http.Header{"Authorization": {"Bearer " + bobToken}}
+
+	first := openSSE(t, srv, "", auth)
+	send("one")
+	one := first.next(t, "message.created")
+	if one.Data.Content != "one" || one.ID <= 0 {
+		t.Fatalf("first event = %+v", one)
+	}
+	first.close()
+
+	// Messages sent while the client is disconnected are replayed on reconnection, then the stream goes on live
+	send("two")
+	send("three")
+	resumed := openSSE(t, srv, "", http.Header{"Authorization": auth["Authorization"], "Last-Event-ID": {strconv.FormatInt(one.ID, 10)}})
+	received := []streamEvent{one, resumed.next(t, "message.created"), resumed.next(t, "message.created")}
+	send("four")
+	received = append(received, resumed.next(t, "message.created"))
+
+	// The same replay works for clients that pass the token and the last event ID in the query
+	query := openSSE(t, srv, fmt.Sprintf("?token=%s&lastEventId=%d", bobToken, one.ID), nil)
+	for _, want := range received[1:] {
+		if got := query.next(t, "message.created"); got.ID != want.ID || got.Data.Content != want.Data.Content {
+			t.Errorf("replay with the query parameters: got %+v, want %+v", got, want)
+		}
+	}
+
+	var contents []string
+	for i, ev := range received {
+		contents = append(contents, ev.Data.Content)
+		if i > 0 && ev.ID <= received[i-1].ID {
+			t.Errorf("event IDs are not increasing: %+v", received)
+		}
+	}
+	if got := strings.Join(contents, " "); got != "one two three four" {
+		t.Errorf("received messages %q, want every message exactly once", got)
+	}
+}
+
+func TestWebSocketResumesWithLastEventID(t *testing.T) {
+	srv, send, bobToken := newStreamTest(t)
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+
+	send("one")
+	send("two")
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL+"?token="+bobToken, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	send("three")
+	three := nextWS(t, conn, "message.created")
+	conn.Close()
+	if three.Data.Content != "three" {
+		t.Fatalf("live event = %+v, want the message sent after connecting", three)
+	}
+
+	send("four")
+	conn, _, err = websocket.DefaultDialer.Dial(fmt.Sprintf("%s?token=%s&lastEventId=%d", wsURL, bobToken, three.ID), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if four := nextWS(t, conn, "message.created"); four.Data.Content != "four" || four.ID <= three.ID {
+		t.Errorf("replayed event = %+v, want the message sent while disconnected", four)
+	}
+}
+
+func TestEventStreamsRequireAuthentication(t *testing.T) {
+	h := newTestHandler(t)
+	_, token := register(t, h, "alice")
+
+	tests := []struct {
+		name   string
+		query  string
+		bearer string
+	}{
+		{"no token", "", ""},
+		{"unknown bearer token", "", "not-a-session"},
+		{"unknown query token", "?token=not-a-session", ""},
+		{"token of a closed session", "?token=" + token, ""},
+	}
+	if rec := doRequest(t, h, http.MethodDelete, "/session", token, nil); rec.Code/100 != 2 {
+		t.Fatalf("logout: status %d", rec.Code)
+	}
+	for _, path := range []string{"/events", "/ws"} {
+		for _, tt := range tests {
+			t.Run(strings.TrimPrefix(path, "/")+" "+tt.name, func(t *testing.T) {
+				if rec := doRequest(t, h, http.MethodGet, path+tt.query, tt.bearer, nil); rec.Code != http.StatusUnauthorized {
+					t.Errorf("got status %d, want %d", rec.Code, http.StatusUnauthorized)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/rerikdev/WASAText/service/api/reqcontext"
+	"github.com/rerikdev/WASAText/service/events"
+)
+
+// PUT /groups/:id/bans/:userId (operationId: banFromGroup)
+// Bandisce l'utente dal gruppo, rimuovendolo se è membro
+func (rt *_router) banFromGroup(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	groupID, _ := strconv.Atoi(ps.ByName("id"))
+	userID, err := strconv.Atoi(ps.ByName("userId"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		if encErr := json.NewEncoder(w).Encode(map[string]string{"message": "ID utente non valido"}); encErr != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+	// I destinatari dell'evento includono anche chi viene bandito, se è membro
+	members, err := rt.db.GetConversationMemberIds(groupID)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("can't load group members")
+	}
+	inserted, err := rt.db.BanFromGroup(groupID, ctx.UserID, userID)
+	if err != nil {
+		sendGroupError(w, ctx, err, "Errore ban")
+		return
+	}
+	rt.publish(ctx, events.GroupUpdated, groupID, members, map[string]interface{}{"change": "member_banned", "userId": userID})
+	rt.publishSystemMessages(ctx, groupID, inserted)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DELETE /groups/:id/bans/:userId (operationId: unbanFromGroup)
+func (rt *_router) unbanFromGroup(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	groupID, _ := strconv.Atoi(ps.ByName("id"))
+	userID, err := strconv.Atoi(ps.ByName("userId"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		if encErr := json.NewEncoder(w).Encode(map[string]string{"message": "ID utente non valido"}); encErr != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+	if err := rt.db.UnbanFromGroup(groupID, ctx.UserID, userID); err != nil {
+		sendGroupError(w, ctx, err, "Errore revoca ban")
+		return
+	}
+	rt.publishToConversation(ctx, events.GroupUpdated, groupID, map[string]interface{}{"change": "member_unbanned", "userId": userID})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GET /groups/:id/bans (operationId: listGroupBans)
+func (rt *_router) listGroupBans(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	groupID, _ := strconv.Atoi(ps.ByName("id"))
+	bans, err := rt.db.ListGroupBans(groupID, ctx.UserID)
+	if err != nil {
+		sendGroupError(w, ctx, err, "Errore recupero utenti banditi")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if encErr := json.NewEncoder(w).Encode(bans); encErr != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
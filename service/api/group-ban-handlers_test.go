@@ -0,0 +1,65 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestGroupBans(t *testing.T) {
+	h := newTestHandler(t)
+	_, aliceToken := register(t, h, "alice")
+	_, bobToken := register(t, h, "bob")
+	carolID, carolToken := register(t, h, "carol")
+
+	var group struct{ ID int }
+	decode(t, doRequest(t, h, http.MethodPost, "/groups", aliceToken, map[string]interface{}{
+		"name": "friends", "members": []string{"bob", "carol"},
+	}), http.StatusCreated, &group)
+	groupPath := fmt.Sprintf("/groups/%d", group.ID)
+
+	if rec := doRequest(t, h, http.MethodPut, fmt.Sprintf("%s/bans/%d", groupPath, carolID), bobToken, nil); rec.Code != http.StatusForbidden {
+		t.Errorf("ban by a member: got status %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if rec := doRequest(t, h, http.MethodPut, fmt.Sprintf("%s/bans/%d", groupPath, carolID+100), aliceToken, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("ban of a missing user: got status %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	decode(t, doRequest(t, h, http.MethodPut, fmt.Sprintf("%s/bans/%d", groupPath, carolID), aliceToken, nil), http.StatusNoContent, nil)
+	if rec := doRequest(t, h, http.MethodGet, fmt.Sprintf("/conversations/%d/messages", group.ID), carolToken, nil); rec.Code != http.StatusForbidden {
+		t.Errorf("banned member reading the group: got status %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	var bans []struct {
+		User     struct{ ID int }
+		BannedBy int
+	}
+	decode(t, doRequest(t, h, http.MethodGet, groupPath+"/bans", aliceToken, nil), http.StatusOK, &bans)
+	if len(bans) != 1 || bans[0].User.ID != carolID || bans[0].BannedBy == carolID {
+		t.Errorf("bans = %+v", bans)
+	}
+
+	// The banned user can't come back with an invite link
+	var invite struct{ Token string }
+	decode(t, doRequest(t, h, http.MethodPost, groupPath+"/invites", aliceToken, map[string]int{}), http.StatusCreated, &invite)
+	if rec := doRequest(t, h, http.MethodPost, "/invites/"+invite.Token+"/join", carolToken, nil); rec.Code != http.StatusForbidden {
+		t.Errorf("banned user joining: got status %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	decode(t, doRequest(t, h, http.MethodDelete, fmt.Sprintf("%s/bans/%d", groupPath, carolID), aliceToken, nil), http.StatusNoContent, nil)
+	if rec := doRequest(t, h, http.MethodDelete, fmt.Sprintf("%s/bans/%d", groupPath, carolID), aliceToken, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("lifting a lifted ban: got status %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	decode(t, doRequest(t, h, http.MethodPost, "/invites/"+invite.Token+"/join", carolToken, nil), http.StatusOK, nil)
+
+	// Only the owner deletes the group
+	if rec := doRequest(t, h, http.MethodDelete, groupPath, bobToken, nil); rec.Code != http.StatusForbidden {
+		t.Errorf("group deleted by a member: got status %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	decode(t, doRequest(t, h, http.MethodDelete, groupPath, aliceToken, nil), http.StatusNoContent, nil)
+	if rec := doRequest(t, h, http.MethodGet, fmt.Sprintf("/conversations/%d/messages", group.ID), bobToken, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("reading a deleted group: got status %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	var groups []struct{ ID int }
+	decode(t, doRequest(t, h, http.MethodGet, "/groups", bobToken, nil), http.StatusOK, &groups)
+	if len(groups) != 0 {
+		t.Errorf("groups after the deletion = %+v", groups)
+	}
+}
@@ -2,37 +2,72 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
+	"unicode/utf8"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/rerikdev/WASAText/service/api/reqcontext"
+	"github.com/rerikdev/WASAText/service/database"
+	"github.com/rerikdev/WASAText/service/events"
 )
 
-// POST /groups (operationId: addToGroup)
-func (rt *_router) addToGroup(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	if !checkAuthorization(w, r) {
-		return
+// maxGroupDescriptionLength è la lunghezza massima, in caratteri, della descrizione di un gruppo
+const maxGroupDescriptionLength = 1024
+
+// groupErrorStatus restituisce lo stato HTTP per un errore delle funzioni dei gruppi del database
+func groupErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, database.ErrPermissionDenied), errors.Is(err, database.ErrUserBanned):
+		return http.StatusForbidden
+	case errors.Is(err, database.ErrMemberNotFound), errors.Is(err, database.ErrInviteNotFound),
+		errors.Is(err, database.ErrJoinRequestNotFound), errors.Is(err, database.ErrBanNotFound),
+		errors.Is(err, database.ErrUserNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, database.ErrInviteExpired):
+		return http.StatusGone
+	case errors.Is(err, database.ErrAlreadyMember), errors.Is(err, database.ErrGroupFull):
+		return http.StatusConflict
+	case errors.Is(err, database.ErrInvalidGroupRequest):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
 	}
+}
+
+// POST /groups (operationId: addToGroup)
+func (rt *_router) addToGroup(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
 	var req struct {
-		Name    string   `json:"name"`
-		Members []string `json:"members"`
-		Photo   string   `json:"photo"`
+		Name        string   `json:"name"`
+		Members     []string `json:"members"`
+		Photo       string   `json:"photo"`
+		MediaID     *string  `json:"mediaId"` // Alternativa a photo: immagine caricata con POST /media
+		Description string   `json:"description"`
+		MaxMembers  int      `json:"maxMembers"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" || len(req.Members) == 0 {
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" || len(req.Members) == 0 ||
+		utf8.RuneCountInString(req.Description) > maxGroupDescriptionLength || req.MaxMembers < 0 {
 		w.WriteHeader(http.StatusBadRequest)
 		if encErr := json.NewEncoder(w).Encode(map[string]string{"message": "Dati gruppo non validi"}); encErr != nil {
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		}
 		return
 	}
-	group, err := rt.db.AddToGroup(req.Name, req.Photo, req.Members)
+	photo, ok := rt.photoURL(w, ctx, req.Photo, req.MediaID)
+	if !ok {
+		return
+	}
+	req.Photo = photo
+	group, err := rt.db.AddToGroup(ctx.UserID, req.Name, req.Photo, req.Members, database.GroupOptions{
+		Description: req.Description,
+		MaxMembers:  req.MaxMembers,
+	})
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		if encErr := json.NewEncoder(w).Encode(map[string]string{"message": err.Error()}); encErr != nil {
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		}
+		sendGroupError(w, ctx, err, "Errore creazione gruppo")
 		return
 	}
+	rt.publishToConversation(ctx, events.GroupUpdated, group.ID, map[string]interface{}{"change": "created", "group": group})
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	if encErr := json.NewEncoder(w).Encode(group); encErr != nil {
@@ -41,18 +76,8 @@ func (rt *_router) addToGroup(w http.ResponseWriter, r *http.Request, _ httprout
 }
 
 // GET /groups (operationId: listGroups)
-func (rt *_router) listGroups(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	if !checkAuthorization(w, r) {
-		return
-	}
-	userID, err := strconv.Atoi(r.Header.Get("Authorization"))
-	if err != nil || userID == 0 {
-		w.WriteHeader(http.StatusUnauthorized)
-		if encErr := json.NewEncoder(w).Encode(map[string]string{"message": "Utente non autorizzato"}); encErr != nil {
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		}
-		return
-	}
+func (rt *_router) listGroups(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	userID := ctx.UserID
 	groups, err := rt.db.ListGroups(userID)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -68,10 +93,7 @@ func (rt *_router) listGroups(w http.ResponseWriter, r *http.Request, _ httprout
 }
 
 // DELETE /groups/:id/members (operationId: leaveGroup)
-func (rt *_router) leaveGroup(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	if !checkAuthorization(w, r) {
-		return
-	}
+func (rt *_router) leaveGroup(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 	groupID, err := strconv.Atoi(ps.ByName("id"))
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
@@ -80,29 +102,44 @@ func (rt *_router) leaveGroup(w http.ResponseWriter, r *http.Request, ps httprou
 		}
 		return
 	}
-	userID, err := strconv.Atoi(r.Header.Get("Authorization"))
-	if err != nil || userID == 0 {
-		w.WriteHeader(http.StatusUnauthorized)
-		if encErr := json.NewEncoder(w).Encode(map[string]string{"message": "Utente non autorizzato"}); encErr != nil {
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		}
-		return
+	userID := ctx.UserID
+	// I destinatari dell'evento includono anche chi esce dal gruppo
+	members, err := rt.db.GetConversationMemberIds(groupID)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("can't load group members")
 	}
-	if err := rt.db.LeaveGroup(groupID, userID); err != nil {
+	inserted, err := rt.db.LeaveGroup(groupID, userID)
+	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		if encErr := json.NewEncoder(w).Encode(map[string]string{"message": err.Error()}); encErr != nil {
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		}
 		return
 	}
+	rt.publish(ctx, events.GroupUpdated, groupID, members, map[string]interface{}{"change": "member_left", "userId": userID})
+	rt.publishSystemMessages(ctx, groupID, inserted)
 	w.WriteHeader(http.StatusNoContent)
 }
 
-// PATCH /groups/:id/name (operationId: setGroupName)
-func (rt *_router) setGroupName(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	if !checkAuthorization(w, r) {
+// DELETE /groups/:id (operationId: deleteGroup)
+// Solo il proprietario può eliminare il gruppo, con tutti i suoi messaggi
+func (rt *_router) deleteGroup(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	groupID, _ := strconv.Atoi(ps.ByName("id"))
+	// Dopo l'eliminazione il gruppo non ha più membri: i destinatari dell'evento vanno letti prima
+	members, err := rt.db.GetConversationMemberIds(groupID)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("can't load group members")
+	}
+	if err := rt.db.DeleteGroup(groupID, ctx.UserID); err != nil {
+		sendGroupError(w, ctx, err, "Errore eliminazione gruppo")
 		return
 	}
+	rt.publish(ctx, events.GroupUpdated, groupID, members, map[string]interface{}{"change": "deleted"})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PATCH /groups/:id/name (operationId: setGroupName)
+func (rt *_router) setGroupName(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 	groupID, err := strconv.Atoi(ps.ByName("id"))
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
@@ -121,21 +158,18 @@ func (rt *_router) setGroupName(w http.ResponseWriter, r *http.Request, ps httpr
 		}
 		return
 	}
-	if err := rt.db.SetGroupName(groupID, req.Name); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		if encErr := json.NewEncoder(w).Encode(map[string]string{"message": err.Error()}); encErr != nil {
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		}
+	inserted, err := rt.db.SetGroupName(groupID, ctx.UserID, req.Name)
+	if err != nil {
+		sendGroupError(w, ctx, err, "Errore modifica nome")
 		return
 	}
+	rt.publishToConversation(ctx, events.GroupUpdated, groupID, map[string]interface{}{"change": "name", "name": req.Name})
+	rt.publishSystemMessages(ctx, groupID, inserted)
 	w.WriteHeader(http.StatusNoContent)
 }
 
 // PATCH /groups/:id/photo (operationId: setGroupPhoto)
-func (rt *_router) setGroupPhoto(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	if !checkAuthorization(w, r) {
-		return
-	}
+func (rt *_router) setGroupPhoto(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 	groupID, err := strconv.Atoi(ps.ByName("id"))
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
@@ -145,30 +179,33 @@ func (rt *_router) setGroupPhoto(w http.ResponseWriter, r *http.Request, ps http
 		return
 	}
 	var req struct {
-		Photo string `json:"photo"`
+		Photo   string  `json:"photo"`
+		MediaID *string `json:"mediaId"` // Alternativa a photo: immagine caricata con POST /media
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Photo == "" {
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.Photo == "" && req.MediaID == nil) {
 		w.WriteHeader(http.StatusBadRequest)
 		if encErr := json.NewEncoder(w).Encode(map[string]string{"message": "Foto non valida"}); encErr != nil {
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		}
 		return
 	}
-	if err := rt.db.SetGroupPhoto(groupID, req.Photo); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		if encErr := json.NewEncoder(w).Encode(map[string]string{"message": err.Error()}); encErr != nil {
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		}
+	photo, ok := rt.photoURL(w, ctx, req.Photo, req.MediaID)
+	if !ok {
 		return
 	}
+	req.Photo = photo
+	inserted, err := rt.db.SetGroupPhoto(groupID, ctx.UserID, req.Photo)
+	if err != nil {
+		sendGroupError(w, ctx, err, "Errore modifica foto")
+		return
+	}
+	rt.publishToConversation(ctx, events.GroupUpdated, groupID, map[string]interface{}{"change": "photo", "photo": req.Photo})
+	rt.publishSystemMessages(ctx, groupID, inserted)
 	w.WriteHeader(http.StatusNoContent)
 }
 
 // PATCH /groups/:id/members
-func (rt *_router) addGroupMembers(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	if !checkAuthorization(w, r) {
-		return
-	}
+func (rt *_router) addGroupMembers(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 	groupID, err := strconv.Atoi(ps.ByName("id"))
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
@@ -187,12 +224,51 @@ func (rt *_router) addGroupMembers(w http.ResponseWriter, r *http.Request, ps ht
 		}
 		return
 	}
-	if err := rt.db.AddMembersToGroup(groupID, req.Members); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		if encErr := json.NewEncoder(w).Encode(map[string]string{"message": "Errore aggiunta membri"}); encErr != nil {
+	inserted, err := rt.db.AddMembersToGroup(groupID, ctx.UserID, req.Members)
+	if err != nil {
+		sendGroupError(w, ctx, err, "Errore aggiunta membri")
+		return
+	}
+	rt.publishToConversation(ctx, events.GroupUpdated, groupID, map[string]interface{}{"change": "members_added", "members": req.Members})
+	rt.publishSystemMessages(ctx, groupID, inserted)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PATCH /groups/:id/description (operationId: setGroupDescription)
+// Una descrizione vuota rimuove quella attuale
+func (rt *_router) setGroupDescription(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	groupID, _ := strconv.Atoi(ps.ByName("id"))
+	var req struct {
+		Description *string `json:"description"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Description == nil ||
+		utf8.RuneCountInString(*req.Description) > maxGroupDescriptionLength {
+		w.WriteHeader(http.StatusBadRequest)
+		if encErr := json.NewEncoder(w).Encode(map[string]string{"message": "Descrizione non valida"}); encErr != nil {
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		}
 		return
 	}
+	inserted, err := rt.db.SetGroupDescription(groupID, ctx.UserID, *req.Description)
+	if err != nil {
+		sendGroupError(w, ctx, err, "Errore modifica descrizione")
+		return
+	}
+	rt.publishToConversation(ctx, events.GroupUpdated, groupID, map[string]interface{}{"change": "description", "description": *req.Description})
+	rt.publishSystemMessages(ctx, groupID, inserted)
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// GET /groups/:id (operationId: getGroupInfo)
+func (rt *_router) getGroupInfo(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	groupID, _ := strconv.Atoi(ps.ByName("id"))
+	info, err := rt.db.GetGroupInfo(groupID)
+	if err != nil {
+		sendGroupError(w, ctx, err, "Errore recupero gruppo")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if encErr := json.NewEncoder(w).Encode(info); encErr != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
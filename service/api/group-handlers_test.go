@@ -0,0 +1,90 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGroupInfo(t *testing.T) {
+	h := newTestHandler(t)
+	aliceID, aliceToken := register(t, h, "alice")
+	_, bobToken := register(t, h, "bob")
+	register(t, h, "carol")
+
+	if rec := doRequest(t, h, http.MethodPost, "/groups", aliceToken, map[string]interface{}{
+		"name": "crowded", "members": []string{"bob", "carol"}, "maxMembers": 2,
+	}); rec.Code != http.StatusConflict {
+		t.Errorf("creating a group over its limit: got status %d, want %d", rec.Code, http.StatusConflict)
+	}
+	var group struct{ ID int }
+	decode(t, doRequest(t, h, http.MethodPost, "/groups", aliceToken, map[string]interface{}{
+		"name": "news", "members": []string{"bob"}, "description": "Team news", "maxMembers": 2,
+	}), http.StatusCreated, &group)
+	groupPath := fmt.Sprintf("/groups/%d", group.ID)
+
+	// Announcement mode: only admins can post
+	decode(t, doRequest(t, h, http.MethodPatch, groupPath+"/settings", aliceToken, map[string]bool{"onlyAdminsSend": true}), http.StatusOK, nil)
+	if rec := doRequest(t, h, http.MethodPost, fmt.Sprintf("/conversations/%d/messages", group.ID), bobToken, map[string]string{"content": "hi"}); rec.Code != http.StatusForbidden {
+		t.Errorf("member posting in announcement mode: got status %d, want %d", rec.Code, http.StatusForbidden)
+	}
+
+	if rec := doRequest(t, h, http.MethodPatch, groupPath+"/members", aliceToken, map[string][]string{"members": {"carol"}}); rec.Code != http.StatusConflict {
+		t.Errorf("adding a member to a full group: got status %d, want %d", rec.Code, http.StatusConflict)
+	}
+	if rec := doRequest(t, h, http.MethodPatch, groupPath+"/description", aliceToken, map[string]string{"description": strings.Repeat("x", 1025)}); rec.Code != http.StatusBadRequest {
+		t.Errorf("description too long: got status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	decode(t, doRequest(t, h, http.MethodPatch, groupPath+"/description", bobToken, map[string]string{"description": "Announcements"}), http.StatusNoContent, nil)
+
+	var info struct {
+		Name, Description string
+		Settings          struct {
+			OnlyAdminsSend bool
+			MaxMembers     int
+		}
+		Members []struct {
+			ID   int
+			Role string
+		}
+		CreatedAt string
+		CreatedBy struct{ ID int }
+	}
+	decode(t, doRequest(t, h, http.MethodGet, groupPath, bobToken, nil), http.StatusOK, &info)
+	if info.Name != "news" || info.Description != "Announcements" || !info.Settings.OnlyAdminsSend || info.Settings.MaxMembers != 2 ||
+		len(info.Members) != 2 || info.Members[0].Role != "owner" || info.CreatedAt == "" || info.CreatedBy.ID != aliceID {
+		t.Errorf("group info = %+v", info)
+	}
+}
+
+func TestSystemMessagesArePublished(t *testing.T) {
+	h := newTestHandler(t)
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	_, aliceToken := register(t, h, "alice")
+	_, bobToken := register(t, h, "bob")
+
+	var group struct{ ID int }
+	decode(t, doRequest(t, h, http.MethodPost, "/groups", aliceToken, map[string]interface{}{
+		"name": "team", "members": []string{"bob"},
+	}), http.StatusCreated, &group)
+	stream := openSSE(t, srv, "", http.Header{"Authorization": {"Bearer " + bobToken}})
+
+	// The system messages reach the members like the messages they write
+	decode(t, doRequest(t, h, http.MethodPatch, fmt.Sprintf("/groups/%d/name", group.ID), aliceToken, map[string]string{"name": "dream team"}), http.StatusNoContent, nil)
+	if ev := stream.next(t, "message.created"); ev.Data.Kind != "group_renamed" || ev.Data.Content != "dream team" {
+		t.Errorf("message for the new name = %+v", ev)
+	}
+
+	var sent struct{ ID int }
+	decode(t, doRequest(t, h, http.MethodPost, fmt.Sprintf("/conversations/%d/messages", group.ID), aliceToken, map[string]string{"content": "rules"}), http.StatusOK, &sent)
+	if ev := stream.next(t, "message.created"); ev.Data.Kind != "user" {
+		t.Errorf("message written by alice = %+v", ev)
+	}
+	decode(t, doRequest(t, h, http.MethodPut, fmt.Sprintf("/conversations/%d/messages/%d/pin", group.ID, sent.ID), aliceToken, nil), http.StatusNoContent, nil)
+	if ev := stream.next(t, "message.created"); ev.Data.Kind != "message_pinned" {
+		t.Errorf("message for the pin = %+v", ev)
+	}
+}
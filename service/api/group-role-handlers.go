@@ -0,0 +1,107 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/rerikdev/WASAText/service/api/reqcontext"
+	"github.com/rerikdev/WASAText/service/database"
+	"github.com/rerikdev/WASAText/service/events"
+)
+
+// PATCH /groups/:id/members/:userId (operationId: setGroupMemberRole)
+// Nomina amministratore un membro ({"role": "admin"}) o lo riporta a membro semplice ({"role": "member"})
+func (rt *_router) setGroupMemberRole(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	groupID, _ := strconv.Atoi(ps.ByName("id"))
+	userID, err := strconv.Atoi(ps.ByName("userId"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		if encErr := json.NewEncoder(w).Encode(map[string]string{"message": "ID utente non valido"}); encErr != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.Role != database.RoleAdmin && req.Role != database.RoleMember) {
+		w.WriteHeader(http.StatusBadRequest)
+		if encErr := json.NewEncoder(w).Encode(map[string]string{"message": "Ruolo non valido"}); encErr != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+	if err := rt.db.SetGroupMemberRole(groupID, ctx.UserID, userID, req.Role); err != nil {
+		sendGroupError(w, ctx, err, "Errore modifica ruolo")
+		return
+	}
+	rt.publishToConversation(ctx, events.GroupUpdated, groupID, map[string]interface{}{"change": "role", "userId": userID, "role": req.Role})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DELETE /groups/:id/members/:userId (operationId: removeGroupMember)
+func (rt *_router) removeGroupMember(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	groupID, _ := strconv.Atoi(ps.ByName("id"))
+	userID, err := strconv.Atoi(ps.ByName("userId"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		if encErr := json.NewEncoder(w).Encode(map[string]string{"message": "ID utente non valido"}); encErr != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+	// I destinatari dell'evento includono anche chi viene rimosso
+	members, err := rt.db.GetConversationMemberIds(groupID)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("can't load group members")
+	}
+	inserted, err := rt.db.RemoveGroupMember(groupID, ctx.UserID, userID)
+	if err != nil {
+		sendGroupError(w, ctx, err, "Errore rimozione membro")
+		return
+	}
+	rt.publish(ctx, events.GroupUpdated, groupID, members, map[string]interface{}{"change": "member_removed", "userId": userID})
+	rt.publishSystemMessages(ctx, groupID, inserted)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PATCH /groups/:id/settings (operationId: setGroupSettings)
+// Cambia le impostazioni indicate e restituisce quelle risultanti
+func (rt *_router) setGroupSettings(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	groupID, _ := strconv.Atoi(ps.ByName("id"))
+	// I campi assenti restano invariati
+	var req struct {
+		OnlyAdminsEditInfo   *bool `json:"onlyAdminsEditInfo"`
+		OnlyAdminsAddMembers *bool `json:"onlyAdminsAddMembers"`
+		OnlyAdminsSend       *bool `json:"onlyAdminsSend"`
+		OnlyAdminsPin        *bool `json:"onlyAdminsPin"`
+		MaxMembers           *int  `json:"maxMembers"` // 0 rimuove il limite
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil ||
+		(req.OnlyAdminsEditInfo == nil && req.OnlyAdminsAddMembers == nil && req.OnlyAdminsSend == nil &&
+			req.OnlyAdminsPin == nil && req.MaxMembers == nil) {
+		w.WriteHeader(http.StatusBadRequest)
+		if encErr := json.NewEncoder(w).Encode(map[string]string{"message": "Impostazioni non valide"}); encErr != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+	settings, err := rt.db.SetGroupSettings(groupID, ctx.UserID, database.GroupSettingsUpdate{
+		OnlyAdminsEditInfo:   req.OnlyAdminsEditInfo,
+		OnlyAdminsAddMembers: req.OnlyAdminsAddMembers,
+		OnlyAdminsSend:       req.OnlyAdminsSend,
+		OnlyAdminsPin:        req.OnlyAdminsPin,
+		MaxMembers:           req.MaxMembers,
+	})
+	if err != nil {
+		sendGroupError(w, ctx, err, "Errore modifica impostazioni")
+		return
+	}
+	rt.publishToConversation(ctx, events.GroupUpdated, groupID, map[string]interface{}{"change": "settings", "settings": settings})
+	w.Header().Set("Content-Type", "application/json")
+	if encErr := json.NewEncoder(w).Encode(settings); encErr != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestGroupRoles(t *testing.T) {
+	h := newTestHandler(t)
+	aliceID, aliceToken := register(t, h, "alice")
+	bobID, bobToken := register(t, h, "bob")
+	carolID, carolToken := register(t, h, "carol")
+
+	var group struct{ ID int }
+	decode(t, doRequest(t, h, http.MethodPost, "/groups", aliceToken, map[string]interface{}{
+		"name": "friends", "members": []string{"bob", "carol"},
+	}), http.StatusCreated, &group)
+	groupPath := fmt.Sprintf("/groups/%d", group.ID)
+
+	type member struct {
+		ID   int
+		Role string
+	}
+	var groups []struct {
+		Members  []member
+		Settings struct{ OnlyAdminsSend bool }
+	}
+	decode(t, doRequest(t, h, http.MethodGet, "/groups", bobToken, nil), http.StatusOK, &groups)
+	if len(groups) != 1 || len(groups[0].Members) != 3 || groups[0].Members[0].Role != "owner" || groups[0].Members[1].Role != "member" {
+		t.Fatalf("groups = %+v", groups)
+	}
+
+	if rec := doRequest(t, h, http.MethodPatch, fmt.Sprintf("%s/members/%d", groupPath, bobID), aliceToken,
+		map[string]string{"role": "owner"}); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid role: got status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	// The other requests the database rejects are client errors too, not internal ones
+	invalid := []struct {
+		name, method, path string
+		body               interface{}
+	}{
+		{"removing oneself", http.MethodDelete, fmt.Sprintf("%s/members/%d", groupPath, aliceID), nil},
+		{"banning oneself", http.MethodPut, fmt.Sprintf("%s/bans/%d", groupPath, aliceID), nil},
+		{"limit below the members", http.MethodPatch, groupPath + "/settings", map[string]int{"maxMembers": 2}},
+		{"group with an unknown user", http.MethodPost, "/groups", map[string]interface{}{"name": "x", "members": []string{"nobody"}}},
+	}
+	for _, tc := range invalid {
+		if rec := doRequest(t, h, tc.method, tc.path, aliceToken, tc.body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d, want %d: %s", tc.name, rec.Code, http.StatusBadRequest, rec.Body.String())
+		}
+	}
+	if rec := doRequest(t, h, http.MethodPatch, fmt.Sprintf("%s/members/%d", groupPath, bobID), carolToken,
+		map[string]string{"role": "admin"}); rec.Code != http.StatusForbidden {
+		t.Errorf("promotion by a member: got status %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	decode(t, doRequest(t, h, http.MethodPatch, fmt.Sprintf("%s/members/%d", groupPath, bobID), aliceToken,
+		map[string]string{"role": "admin"}), http.StatusNoContent, nil)
+
+	// Only the admins can write once the group is restricted
+	var settings struct{ OnlyAdminsSend, OnlyAdminsEditInfo bool }
+	decode(t, doRequest(t, h, http.MethodPatch, groupPath+"/settings", bobToken, map[string]bool{"onlyAdminsSend": true}),
+		http.StatusOK, &settings)
+	if !settings.OnlyAdminsSend || settings.OnlyAdminsEditInfo {
+		t.Errorf("settings = %+v", settings)
+	}
+	messagesPath := fmt.Sprintf("/conversations/%d/messages", group.ID)
+	if rec := doRequest(t, h, http.MethodPost, messagesPath, carolToken, map[string]string{"content": "hi"}); rec.Code != http.StatusForbidden {
+		t.Errorf("member sending to a restricted group: got status %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	decode(t, doRequest(t, h, http.MethodPost, messagesPath, bobToken, map[string]string{"content": "hi"}), http.StatusOK, nil)
+
+	if rec := doRequest(t, h, http.MethodDelete, fmt.Sprintf("%s/members/%d", groupPath, carolID+100), bobToken, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("removing a non-member: got status %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	decode(t, doRequest(t, h, http.MethodDelete, fmt.Sprintf("%s/members/%d", groupPath, carolID), bobToken, nil), http.StatusNoContent, nil)
+	if rec := doRequest(t, h, http.MethodGet, messagesPath, carolToken, nil); rec.Code != http.StatusForbidden {
+		t.Errorf("removed member reading the group: got status %d, want %d", rec.Code, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"time"
+
+	"github.com/rerikdev/WASAText/service/globaltime"
+)
+
+const (
+	// eventRetention is how long events are kept in the log for clients resuming after a disconnection
+	eventRetention = 7 * 24 * time.Hour
+
+	// defaultDeletedMessageRetention is how long deleted messages are kept as placeholders, if not configured
+	defaultDeletedMessageRetention = 30 * 24 * time.Hour
+
+	// housekeepingInterval is the interval between two runs of the background cleanups
+	housekeepingInterval = time.Hour
+
+	// blobGracePeriod is how long a new blob is kept even if no media uses it: the media of a blob stored by another
+	// process, like cmd/migrate-media, may not be in the database yet
+	blobGracePeriod = time.Hour
+
+	// blobBatch is the number of blobs checked at a time, while the uploads wait
+	blobBatch = 500
+)
+
+// housekeeping periodically removes the old events from the log, purges the deleted messages older than the
+// retention period and deletes the blobs no longer used by any media, until Close is called
+func (rt *_router) housekeeping() {
+	ticker := time.NewTicker(housekeepingInterval)
+	defer ticker.Stop()
+	for {
+		now := globaltime.Now()
+		if err := rt.db.DeleteEventsBefore(now.Add(-eventRetention)); err != nil {
+			rt.baseLogger.WithError(err).Error("can't prune the event log")
+		}
+		if purged, err := rt.db.PurgeDeletedMessages(now.Add(-rt.deletedRetention)); err != nil {
+			rt.baseLogger.WithError(err).Error("can't purge deleted messages")
+		} else if purged > 0 {
+			rt.baseLogger.WithField("count", purged).Info("deleted messages purged")
+		}
+		// The blob store records the real time of the files, not globaltime
+		if deleted, err := rt.collectBlobs(time.Now().Add(-blobGracePeriod)); err != nil {
+			rt.baseLogger.WithError(err).Error("can't delete unused blobs")
+		} else if deleted > 0 {
+			rt.baseLogger.WithField("count", deleted).Info("unused blobs deleted")
+		}
+
+		select {
+		case <-ticker.C:
+		case <-rt.stop:
+			return
+		}
+	}
+}
+
+// collectBlobs deletes the blobs stored before storedBefore that no media or thumbnail uses anymore, like the media of
+// a deleted group, and returns how many it deleted. A blob can be shared by several media, so it is deleted only when
+// the last of them is gone.
+func (rt *_router) collectBlobs(storedBefore time.Time) (int, error) {
+	var keys []string
+	err := rt.blobs.Walk(func(key string, stored time.Time) error {
+		if stored.Before(storedBefore) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for len(keys) > 0 {
+		n := blobBatch
+		if n > len(keys) {
+			n = len(keys)
+		}
+		count, err := rt.deleteUnusedBlobs(keys[:n])
+		deleted += count
+		if err != nil {
+			return deleted, err
+		}
+		keys = keys[n:]
+	}
+	return deleted, nil
+}
+
+// deleteUnusedBlobs deletes the blobs among keys that no media uses. No media can be saved meanwhile, so a blob
+// reused by a new upload is either found in use or stored again after being deleted.
+func (rt *_router) deleteUnusedBlobs(keys []string) (int, error) {
+	rt.blobsMu.Lock()
+	defer rt.blobsMu.Unlock()
+
+	unused, err := rt.db.UnusedBlobKeys(keys)
+	if err != nil {
+		return 0, err
+	}
+	for i, key := range unused {
+		if err := rt.blobs.Delete(key); err != nil {
+			return i, err
+		}
+	}
+	return len(unused), nil
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/rerikdev/WASAText/service/api/reqcontext"
+	"github.com/rerikdev/WASAText/service/events"
+)
+
+// GET /inbox (operationId: getInbox)
+func (rt *_router) getInbox(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	entries, err := rt.db.GetInbox(ctx.UserID)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("can't load the inbox")
+		w.WriteHeader(http.StatusInternalServerError)
+		if encErr := json.NewEncoder(w).Encode(map[string]string{"message": "Errore recupero conversazioni"}); encErr != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if encErr := json.NewEncoder(w).Encode(entries); encErr != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// PATCH /conversations/:id/preferences (operationId: setConversationPreferences)
+func (rt *_router) setConversationPreferences(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	conversationId, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		if encErr := json.NewEncoder(w).Encode(map[string]string{"message": "Conversazione non valida"}); encErr != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+	// I campi assenti restano invariati
+	var req struct {
+		Muted  *bool `json:"muted"`
+		Pinned *bool `json:"pinned"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.Muted == nil && req.Pinned == nil) {
+		w.WriteHeader(http.StatusBadRequest)
+		if encErr := json.NewEncoder(w).Encode(map[string]string{"message": "Preferenze non valide"}); encErr != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if req.Muted != nil {
+		err = rt.db.SetConversationMuted(conversationId, ctx.UserID, *req.Muted)
+	}
+	if err == nil && req.Pinned != nil {
+		err = rt.db.SetConversationPinned(conversationId, ctx.UserID, *req.Pinned)
+	}
+	if err != nil {
+		ctx.Logger.WithError(err).Error("can't update the conversation preferences")
+		w.WriteHeader(http.StatusInternalServerError)
+		if encErr := json.NewEncoder(w).Encode(map[string]string{"message": "Errore aggiornamento preferenze"}); encErr != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+	rt.publish(ctx, events.PreferencesUpdated, conversationId, []int{ctx.UserID}, req)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestInbox(t *testing.T) {
+	h := newTestHandler(t)
+	_, aliceToken := register(t, h, "alice")
+	bobID, bobToken := register(t, h, "bob")
+	register(t, h, "carol")
+
+	var conv struct{ ConversationID int }
+	decode(t, doRequest(t, h, http.MethodPost, "/conversations", aliceToken, map[string]int{"userId": bobID}), http.StatusCreated, &conv)
+	var group struct{ ID int }
+	decode(t, doRequest(t, h, http.MethodPost, "/groups", aliceToken, map[string]interface{}{
+		"name": "friends", "members": []string{"alice", "bob", "carol"},
+	}), http.StatusCreated, &group)
+	decode(t, doRequest(t, h, http.MethodPost, fmt.Sprintf("/conversations/%d/messages", conv.ConversationID), bobToken,
+		map[string]string{"content": "hi"}), http.StatusOK, nil)
+
+	type entry struct {
+		ID          int
+		Type        string
+		Title       string
+		UnreadCount int
+		Muted       bool
+		Pinned      bool
+		LastMessage *struct {
+			Text   string
+			Sender struct{ ID int }
+		}
+	}
+	var inbox []entry
+	decode(t, doRequest(t, h, http.MethodGet, "/inbox", aliceToken, nil), http.StatusOK, &inbox)
+	if len(inbox) != 2 {
+		t.Fatalf("got %d inbox entries, want 2", len(inbox))
+	}
+	if e := inbox[0]; e.ID != conv.ConversationID || e.Type != "direct" || e.Title != "bob" || e.UnreadCount != 1 ||
+		e.LastMessage == nil || e.LastMessage.Text != "hi" || e.LastMessage.Sender.ID != bobID {
+		t.Errorf("first entry = %+v", e)
+	}
+	if e := inbox[1]; e.ID != group.ID || e.Type != "group" || e.Title != "friends" || e.LastMessage != nil {
+		t.Errorf("second entry = %+v", e)
+	}
+
+	prefsPath := fmt.Sprintf("/conversations/%d/preferences", group.ID)
+	if rec := doRequest(t, h, http.MethodPatch, prefsPath, aliceToken, map[string]bool{}); rec.Code != http.StatusBadRequest {
+		t.Errorf("empty preferences: got status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	decode(t, doRequest(t, h, http.MethodPatch, prefsPath, aliceToken, map[string]bool{"muted": true, "pinned": true}), http.StatusNoContent, nil)
+	decode(t, doRequest(t, h, http.MethodGet, "/inbox", aliceToken, nil), http.StatusOK, &inbox)
+	if e := inbox[0]; e.ID != group.ID || !e.Muted || !e.Pinned {
+		t.Errorf("pinned group entry = %+v", e)
+	}
+
+	// Non-members can't change the preferences of a conversation
+	_, daveToken := register(t, h, "dave")
+	if rec := doRequest(t, h, http.MethodPatch, fmt.Sprintf("/conversations/%d/preferences", conv.ConversationID), daveToken,
+		map[string]bool{"muted": true}); rec.Code != http.StatusForbidden {
+		t.Errorf("preferences of another conversation: got status %d, want %d", rec.Code, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,195 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/rerikdev/WASAText/service/api/reqcontext"
+	"github.com/rerikdev/WASAText/service/database"
+	"github.com/rerikdev/WASAText/service/events"
+	"github.com/rerikdev/WASAText/service/globaltime"
+	"github.com/rerikdev/WASAText/service/structures"
+)
+
+// newInviteToken genera il token casuale di un link di invito (128 bit)
+func newInviteToken() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// sendGroupError scrive la risposta per un errore delle funzioni dei gruppi del database. Gli errori che non dipendono
+// dalla richiesta diventano 500 e vengono registrati; al client arriva il messaggio fallback.
+func sendGroupError(w http.ResponseWriter, ctx reqcontext.RequestContext, err error, fallback string) {
+	status := groupErrorStatus(err)
+	message := err.Error()
+	if status == http.StatusInternalServerError {
+		ctx.Logger.WithError(err).Error(fallback)
+		message = fallback
+	}
+	w.WriteHeader(status)
+	if encErr := json.NewEncoder(w).Encode(map[string]string{"message": message}); encErr != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// POST /groups/:id/invites (operationId: createGroupInvite)
+func (rt *_router) createGroupInvite(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	groupID, _ := strconv.Atoi(ps.ByName("id"))
+	// expiresIn è la durata del link in secondi; senza expiresIn e maxUses il link non scade
+	var req struct {
+		ExpiresIn        int  `json:"expiresIn"`
+		MaxUses          int  `json:"maxUses"`
+		RequiresApproval bool `json:"requiresApproval"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ExpiresIn < 0 || req.MaxUses < 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		if encErr := json.NewEncoder(w).Encode(map[string]string{"message": "Opzioni dell'invito non valide"}); encErr != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+	token, err := newInviteToken()
+	if err != nil {
+		ctx.Logger.WithError(err).Error("can't generate invite token")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	options := database.GroupInviteOptions{Token: token, MaxUses: req.MaxUses, RequiresApproval: req.RequiresApproval}
+	if req.ExpiresIn > 0 {
+		expiresAt := globaltime.Now().Add(time.Duration(req.ExpiresIn) * time.Second)
+		options.ExpiresAt = &expiresAt
+	}
+
+	invite, err := rt.db.CreateGroupInvite(groupID, ctx.UserID, options)
+	if err != nil {
+		sendGroupError(w, ctx, err, "Errore creazione invito")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if encErr := json.NewEncoder(w).Encode(invite); encErr != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// GET /groups/:id/invites (operationId: listGroupInvites)
+func (rt *_router) listGroupInvites(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	groupID, _ := strconv.Atoi(ps.ByName("id"))
+	invites, err := rt.db.ListGroupInvites(groupID, ctx.UserID)
+	if err != nil {
+		sendGroupError(w, ctx, err, "Errore recupero inviti")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if encErr := json.NewEncoder(w).Encode(invites); encErr != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// DELETE /groups/:id/invites/:token (operationId: revokeGroupInvite)
+func (rt *_router) revokeGroupInvite(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	groupID, _ := strconv.Atoi(ps.ByName("id"))
+	if err := rt.db.RevokeGroupInvite(groupID, ctx.UserID, ps.ByName("token")); err != nil {
+		sendGroupError(w, ctx, err, "Errore revoca invito")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GET /invites/:token (operationId: previewGroupInvite)
+// Chiunque abbia il link vede l'anteprima del gruppo, anche se non ne è membro
+func (rt *_router) previewGroupInvite(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	preview, err := rt.db.PreviewGroupInvite(ps.ByName("token"), ctx.UserID)
+	if err != nil {
+		sendGroupError(w, ctx, err, "Errore recupero invito")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if encErr := json.NewEncoder(w).Encode(preview); encErr != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// POST /invites/:token/join (operationId: joinGroupByInvite)
+// Risponde con lo stato "joined" se l'utente è entrato nel gruppo, o "pending" se deve essere approvato
+func (rt *_router) joinGroupByInvite(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	groupID, pending, inserted, err := rt.db.JoinGroupByInvite(ps.ByName("token"), ctx.UserID)
+	if err != nil {
+		sendGroupError(w, ctx, err, "Errore ingresso nel gruppo")
+		return
+	}
+	status := "joined"
+	if pending {
+		status = "pending"
+		rt.publishToConversation(ctx, events.GroupUpdated, groupID, map[string]interface{}{"change": "join_requested", "userId": ctx.UserID})
+	} else {
+		rt.publishToConversation(ctx, events.GroupUpdated, groupID, map[string]interface{}{"change": "member_joined", "userId": ctx.UserID})
+		rt.publishSystemMessages(ctx, groupID, inserted)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if encErr := json.NewEncoder(w).Encode(map[string]interface{}{"groupId": groupID, "status": status}); encErr != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// GET /groups/:id/join-requests (operationId: listGroupJoinRequests)
+func (rt *_router) listGroupJoinRequests(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	groupID, _ := strconv.Atoi(ps.ByName("id"))
+	requests, err := rt.db.ListGroupJoinRequests(groupID, ctx.UserID)
+	if err != nil {
+		sendGroupError(w, ctx, err, "Errore recupero richieste")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if encErr := json.NewEncoder(w).Encode(requests); encErr != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// POST /groups/:id/join-requests/:userId/approve (operationId: approveGroupJoinRequest)
+func (rt *_router) approveGroupJoinRequest(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	rt.resolveGroupJoinRequest(w, ps, ctx, true)
+}
+
+// DELETE /groups/:id/join-requests/:userId (operationId: rejectGroupJoinRequest)
+func (rt *_router) rejectGroupJoinRequest(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	rt.resolveGroupJoinRequest(w, ps, ctx, false)
+}
+
+func (rt *_router) resolveGroupJoinRequest(w http.ResponseWriter, ps httprouter.Params, ctx reqcontext.RequestContext, approve bool) {
+	groupID, _ := strconv.Atoi(ps.ByName("id"))
+	userID, err := strconv.Atoi(ps.ByName("userId"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		if encErr := json.NewEncoder(w).Encode(map[string]string{"message": "ID utente non valido"}); encErr != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+	var inserted []*structures.Message
+	if approve {
+		inserted, err = rt.db.ApproveGroupJoinRequest(groupID, ctx.UserID, userID)
+	} else {
+		err = rt.db.RejectGroupJoinRequest(groupID, ctx.UserID, userID)
+	}
+	if err != nil {
+		sendGroupError(w, ctx, err, "Errore gestione richiesta")
+		return
+	}
+	if approve {
+		rt.publishToConversation(ctx, events.GroupUpdated, groupID, map[string]interface{}{"change": "member_joined", "userId": userID})
+		rt.publishSystemMessages(ctx, groupID, inserted)
+	} else {
+		// Chi ha chiesto di entrare non è membro: l'evento del rifiuto arriva solo a lui
+		rt.publish(ctx, events.GroupUpdated, groupID, []int{userID}, map[string]interface{}{"change": "join_rejected", "userId": userID})
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestGroupInvites(t *testing.T) {
+	h := newTestHandler(t)
+	_, aliceToken := register(t, h, "alice")
+	_, bobToken := register(t, h, "bob")
+	carolID, carolToken := register(t, h, "carol")
+
+	var group struct{ ID int }
+	decode(t, doRequest(t, h, http.MethodPost, "/groups", aliceToken, map[string]interface{}{
+		"name": "friends", "members": []string{"bob"},
+	}), http.StatusCreated, &group)
+	groupPath := fmt.Sprintf("/groups/%d", group.ID)
+
+	if rec := doRequest(t, h, http.MethodPost, groupPath+"/invites", bobToken, map[string]int{}); rec.Code != http.StatusForbidden {
+		t.Errorf("invite created by a member: got status %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if rec := doRequest(t, h, http.MethodPost, groupPath+"/invites", aliceToken, map[string]int{"maxUses": -1}); rec.Code != http.StatusBadRequest {
+		t.Errorf("negative maxUses: got status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	var invite struct {
+		Token   string
+		MaxUses *int
+	}
+	decode(t, doRequest(t, h, http.MethodPost, groupPath+"/invites", aliceToken, map[string]interface{}{
+		"expiresIn": 3600, "maxUses": 5, "requiresApproval": true,
+	}), http.StatusCreated, &invite)
+	if invite.Token == "" || invite.MaxUses == nil || *invite.MaxUses != 5 {
+		t.Fatalf("invite = %+v", invite)
+	}
+
+	// Carol isn't a member, but can preview the group and ask to join it
+	var preview struct {
+		GroupID          int
+		Name             string
+		MemberCount      int
+		RequiresApproval bool
+	}
+	decode(t, doRequest(t, h, http.MethodGet, "/invites/"+invite.Token, carolToken, nil), http.StatusOK, &preview)
+	if preview.GroupID != group.ID || preview.Name != "friends" || preview.MemberCount != 2 || !preview.RequiresApproval {
+		t.Errorf("preview = %+v", preview)
+	}
+	var joined struct {
+		GroupID int
+		Status  string
+	}
+	decode(t, doRequest(t, h, http.MethodPost, "/invites/"+invite.Token+"/join", carolToken, nil), http.StatusOK, &joined)
+	if joined.GroupID != group.ID || joined.Status != "pending" {
+		t.Errorf("join = %+v", joined)
+	}
+	if rec := doRequest(t, h, http.MethodPost, "/invites/"+invite.Token+"/join", bobToken, nil); rec.Code != http.StatusConflict {
+		t.Errorf("member joining again: got status %d, want %d", rec.Code, http.StatusConflict)
+	}
+
+	var requests []struct{ User struct{ ID int } }
+	decode(t, doRequest(t, h, http.MethodGet, groupPath+"/join-requests", aliceToken, nil), http.StatusOK, &requests)
+	if len(requests) != 1 || requests[0].User.ID != carolID {
+		t.Fatalf("join requests = %+v", requests)
+	}
+	approvePath := fmt.Sprintf("%s/join-requests/%d/approve", groupPath, carolID)
+	if rec := doRequest(t, h, http.MethodPost, approvePath, bobToken, nil); rec.Code != http.StatusForbidden {
+		t.Errorf("approval by a member: got status %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	decode(t, doRequest(t, h, http.MethodPost, approvePath, aliceToken, nil), http.StatusNoContent, nil)
+	decode(t, doRequest(t, h, http.MethodGet, fmt.Sprintf("/conversations/%d/messages", group.ID), carolToken, nil), http.StatusOK, nil)
+
+	decode(t, doRequest(t, h, http.MethodDelete, groupPath+"/invites/"+invite.Token, aliceToken, nil), http.StatusNoContent, nil)
+	if rec := doRequest(t, h, http.MethodGet, "/invites/"+invite.Token, carolToken, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("preview of a revoked invite: got status %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
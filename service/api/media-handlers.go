@@ -0,0 +1,163 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/rerikdev/WASAText/service/api/reqcontext"
+	"github.com/rerikdev/WASAText/service/blobstore"
+	"github.com/rerikdev/WASAText/service/database"
+	"github.com/rerikdev/WASAText/service/imaging"
+	"github.com/rerikdev/WASAText/service/mediastore"
+)
+
+// POST /media
+// Uploads an image (multipart field "file") and returns its media ID, to be referenced by messages and photos.
+func (rt *_router) uploadMedia(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	r.Body = http.MaxBytesReader(w, r.Body, mediastore.MaxSize+1<<20)
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		sendPolicyError(w, http.StatusBadRequest, "File mancante o troppo grande")
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, mediastore.MaxSize+1))
+	if err != nil {
+		sendPolicyError(w, http.StatusBadRequest, "File non valido")
+		return
+	}
+	if len(data) > mediastore.MaxSize {
+		sendPolicyError(w, http.StatusRequestEntityTooLarge, "File troppo grande")
+		return
+	}
+
+	rt.blobsMu.RLock()
+	media, err := mediastore.Save(rt.db, rt.blobs, data, ctx.UserID)
+	rt.blobsMu.RUnlock()
+	if sendImageError(w, err) {
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("can't save media")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", mediastore.Path(media.ID))
+	w.WriteHeader(http.StatusCreated)
+	if encErr := json.NewEncoder(w).Encode(media); encErr != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// photoURL returns the value to store as a profile or group photo. Images sent as data URLs or uploaded with
+// POST /media (mediaId) are stored as media and referenced by their path, so clients can ask for a thumbnail; other
+// URLs are kept as they are. If the photo is rejected the response is written and ok is false.
+func (rt *_router) photoURL(w http.ResponseWriter, ctx reqcontext.RequestContext, url string, mediaId *string) (photo string, ok bool) {
+	if mediaId != nil {
+		media, err := rt.db.GetMedia(*mediaId)
+		if err != nil || media.UploaderID != ctx.UserID {
+			sendPolicyError(w, http.StatusBadRequest, "Media non trovato")
+			return "", false
+		}
+		return mediastore.Path(media.ID), true
+	}
+	if !mediastore.IsDataURL(url) {
+		return url, true
+	}
+	rt.blobsMu.RLock()
+	media, err := mediastore.SaveDataURL(rt.db, rt.blobs, url, ctx.UserID)
+	rt.blobsMu.RUnlock()
+	if sendImageError(w, err) {
+		return "", false
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("can't save photo")
+		w.WriteHeader(http.StatusInternalServerError)
+		return "", false
+	}
+	return mediastore.Path(media.ID), true
+}
+
+// sendImageError writes the response for an image rejected by the mediastore package, and reports whether err was
+// one of these errors
+func sendImageError(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, imaging.ErrUnsupportedFormat):
+		sendPolicyError(w, http.StatusUnsupportedMediaType, "Formato non supportato")
+	case errors.Is(err, imaging.ErrInvalidImage):
+		sendPolicyError(w, http.StatusBadRequest, "Immagine non valida")
+	case errors.Is(err, imaging.ErrTooManyPixels), errors.Is(err, mediastore.ErrTooLarge):
+		sendPolicyError(w, http.StatusRequestEntityTooLarge, "Immagine troppo grande")
+	default:
+		return false
+	}
+	return true
+}
+
+// GET /media/:id?size=
+// Serves the content of a media, with support for range and conditional requests. With size, the smallest thumbnail
+// at least that large is served instead, or the original if there is none. Since browsers do not send the
+// Authorization header for <img> tags, the token can also be passed in the "token" query parameter. Only the uploader,
+// and the members of a conversation where the media is shared, can download it; to anyone else the media does not
+// exist. The content of a media never changes, so it can be cached forever, but only by the browser.
+func (rt *_router) getMedia(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	allowed, err := rt.db.CanAccessMedia(ps.ByName("id"), ctx.UserID)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("can't check media access")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if !allowed {
+		sendPolicyError(w, http.StatusNotFound, "Media non trovato")
+		return
+	}
+
+	media, err := rt.db.GetMedia(ps.ByName("id"))
+	if errors.Is(err, database.ErrMediaNotFound) {
+		sendPolicyError(w, http.StatusNotFound, "Media non trovato")
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("can't load media")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	blobKey, contentType := media.BlobKey, media.ContentType
+	if raw := r.URL.Query().Get("size"); raw != "" {
+		size, err := strconv.Atoi(raw)
+		if err != nil || size <= 0 {
+			sendPolicyError(w, http.StatusBadRequest, "Dimensione non valida")
+			return
+		}
+		for _, thumb := range media.Thumbnails {
+			if thumb.Size >= size {
+				blobKey, contentType = thumb.BlobKey, thumb.ContentType
+				break
+			}
+		}
+	}
+
+	content, err := rt.blobs.Open(blobKey)
+	if errors.Is(err, blobstore.ErrNotFound) {
+		ctx.Logger.WithField("blob", blobKey).Error("media blob is missing")
+		sendPolicyError(w, http.StatusNotFound, "Media non trovato")
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("can't open media blob")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer content.Close()
+
+	createdAt, _ := time.Parse(time.RFC3339, media.CreatedAt)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+	w.Header().Set("ETag", `"`+blobKey+`"`)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, "", createdAt, content)
+}
@@ -0,0 +1,153 @@
+package api
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rerikdev/WASAText/service/blobstore"
+	"github.com/rerikdev/WASAText/service/database"
+	"github.com/sirupsen/logrus"
+)
+
+// pngDataURL returns a small PNG image as a data URL, as the web UI sends it.
+func pngDataURL(t *testing.T) string {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	img.Set(0, 0, color.RGBA{R: 0xff, A: 0xff})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func TestMediaAccess(t *testing.T) {
+	h := newTestHandler(t)
+	_, aliceToken := register(t, h, "alice")
+	bobID, bobToken := register(t, h, "bob")
+	_, eveToken := register(t, h, "eve")
+
+	var conv struct{ ConversationID int }
+	decode(t, doRequest(t, h, http.MethodPost, "/conversations", aliceToken, map[string]int{"userId": bobID}), http.StatusCreated, &conv)
+	convPath := fmt.Sprintf("/conversations/%d", conv.ConversationID)
+
+	var msg struct {
+		ID      int
+		MediaID *string
+	}
+	decode(t, doRequest(t, h, http.MethodPost, convPath+"/messages", aliceToken, map[string]string{
+		"content": pngDataURL(t), "mediaType": "image",
+	}), http.StatusOK, &msg)
+	if msg.MediaID == nil {
+		t.Fatal("image message has no media")
+	}
+	mediaPath := "/media/" + *msg.MediaID
+
+	// Members can download the image, also with the token in the query as <img> tags do
+	if rec := doRequest(t, h, http.MethodGet, mediaPath, bobToken, nil); rec.Code != http.StatusOK {
+		t.Errorf("download by a member: got status %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rec := doRequest(t, h, http.MethodGet, mediaPath+"?size=64&token="+bobToken, "", nil); rec.Code != http.StatusOK {
+		t.Errorf("download with the token in the query: got status %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rec := doRequest(t, h, http.MethodGet, mediaPath, "", nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("download without a session: got status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	if rec := doRequest(t, h, http.MethodGet, mediaPath, eveToken, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("download by a non-member: got status %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	// Once the message is deleted for everyone the image is no longer shared with the other members
+	if rec := doRequest(t, h, http.MethodDelete, fmt.Sprintf("%s/messages/%d", convPath, msg.ID), aliceToken, nil); rec.Code/100 != 2 {
+		t.Fatalf("delete: got status %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := doRequest(t, h, http.MethodGet, mediaPath, bobToken, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("download of a deleted image: got status %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	var page struct {
+		Messages []struct {
+			ID      int
+			MediaID *string
+		}
+	}
+	decode(t, doRequest(t, h, http.MethodGet, convPath+"/messages", bobToken, nil), http.StatusOK, &page)
+	for _, m := range page.Messages {
+		if m.ID == msg.ID && m.MediaID != nil {
+			t.Errorf("deleted message still references media %s", *m.MediaID)
+		}
+	}
+}
+
+func TestUnusedBlobsAreCollected(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	blobs := blobstore.NewMemory()
+	r, err := New(Config{Logger: logger, Database: database.NewMemory(), Blobs: blobs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = r.Close() })
+	rt := r.(*_router)
+	h := rt.Handler()
+	_, aliceToken := register(t, h, "alice")
+	register(t, h, "bob")
+
+	var group struct{ ID int }
+	decode(t, doRequest(t, h, http.MethodPost, "/groups", aliceToken, map[string]interface{}{
+		"name": "team", "members": []string{"bob"},
+	}), http.StatusCreated, &group)
+	var msg struct{ MediaID *string }
+	decode(t, doRequest(t, h, http.MethodPost, fmt.Sprintf("/conversations/%d/messages", group.ID), aliceToken, map[string]string{
+		"content": pngDataURL(t), "mediaType": "image",
+	}), http.StatusOK, &msg)
+	if msg.MediaID == nil {
+		t.Fatal("image message has no media")
+	}
+	media, err := rt.db.GetMedia(*msg.MediaID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []string{media.BlobKey}
+	for _, thumb := range media.Thumbnails {
+		keys = append(keys, thumb.BlobKey)
+	}
+	stray, _, err := blobs.Put(strings.NewReader("left by an interrupted upload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the blob that no media uses is deleted
+	if deleted, err := rt.collectBlobs(time.Now().Add(time.Minute)); err != nil || deleted != 1 {
+		t.Errorf("collectBlobs = %d, %v, want only the stray blob", deleted, err)
+	}
+	if _, err := blobs.Open(stray); !errors.Is(err, blobstore.ErrNotFound) {
+		t.Errorf("stray blob: got %v", err)
+	}
+
+	// The blobs of a deleted group are kept during the grace period, then deleted
+	decode(t, doRequest(t, h, http.MethodDelete, fmt.Sprintf("/groups/%d", group.ID), aliceToken, nil), http.StatusNoContent, nil)
+	if deleted, err := rt.collectBlobs(time.Now().Add(-blobGracePeriod)); err != nil || deleted != 0 {
+		t.Errorf("collectBlobs of the new blobs = %d, %v, want none", deleted, err)
+	}
+	if deleted, err := rt.collectBlobs(time.Now().Add(time.Minute)); err != nil || deleted != len(keys) {
+		t.Errorf("collectBlobs after the group was deleted = %d, %v, want %d", deleted, err, len(keys))
+	}
+	for _, key := range keys {
+		if _, err := blobs.Open(key); !errors.Is(err, blobstore.ErrNotFound) {
+			t.Errorf("blob %s of the deleted group: got %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/rerikdev/WASAText/service/api/reqcontext"
+	"github.com/rerikdev/WASAText/service/database"
+	"github.com/rerikdev/WASAText/service/events"
+)
+
+// defaultMessageEditWindow is how long after sending a message its sender can edit it, if not configured
+const defaultMessageEditWindow = 15 * time.Minute
+
+// PATCH /conversations/:id/messages/:messageId
+// httprouter does not allow a wildcard next to the static PATCH /conversations/:id/messages/read route, so both are
+// registered here and dispatched by the value of the last segment.
+func (rt *_router) patchMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	if ps.ByName("messageId") == "read" {
+		rt.conversationMember(rt.markMessagesRead)(w, r, ps, ctx)
+		return
+	}
+	rt.conversationMessage(rt.editMessage)(w, r, ps, ctx)
+}
+
+// editMessage replaces the content of a text message. Only the sender can edit it, within the edit window.
+func (rt *_router) editMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	conversationId, _ := strconv.Atoi(ps.ByName("id"))
+	messageId, _ := strconv.Atoi(ps.ByName("messageId"))
+
+	var req struct {
+		Content string `json:"content"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Content) == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		if encErr := json.NewEncoder(w).Encode(map[string]string{"error": "Contenuto mancante"}); encErr != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	msg, err := rt.db.EditMessage(conversationId, messageId, ctx.UserID, req.Content, rt.editWindow)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, database.ErrMessageNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, database.ErrNotMessageSender), errors.Is(err, database.ErrEditWindowExpired):
+			status = http.StatusForbidden
+		case errors.Is(err, database.ErrMessageNotEditable):
+			status = http.StatusBadRequest
+		default:
+			ctx.Logger.WithError(err).Error("can't edit message")
+		}
+		message := "Errore modifica messaggio"
+		if status != http.StatusInternalServerError {
+			message = err.Error()
+		}
+		w.WriteHeader(status)
+		if encErr := json.NewEncoder(w).Encode(map[string]string{"error": message}); encErr != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	rt.publishToConversation(ctx, events.MessageEdited, conversationId, msg)
+	w.Header().Set("Content-Type", "application/json")
+	if encErr := json.NewEncoder(w).Encode(msg); encErr != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// GET /conversations/:id/messages/:messageId/edits
+// Returns the previous versions of the message, oldest first.
+func (rt *_router) getMessageEdits(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	conversationId, _ := strconv.Atoi(ps.ByName("id"))
+	messageId, _ := strconv.Atoi(ps.ByName("messageId"))
+
+	edits, err := rt.db.GetMessageEdits(conversationId, messageId)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("can't load message edits")
+		w.WriteHeader(http.StatusInternalServerError)
+		if encErr := json.NewEncoder(w).Encode(map[string]string{"error": "Errore recupero modifiche"}); encErr != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if encErr := json.NewEncoder(w).Encode(edits); encErr != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
@@ -2,17 +2,27 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/rerikdev/WASAText/service/api/reqcontext"
+	"github.com/rerikdev/WASAText/service/database"
+	"github.com/rerikdev/WASAText/service/events"
+	"github.com/rerikdev/WASAText/service/mediastore"
+)
+
+const (
+	// defaultPageSize è il numero di messaggi restituiti se il client non specifica limit
+	defaultPageSize = 50
+
+	// maxPageSize è il numero massimo di messaggi restituiti in una pagina
+	maxPageSize = 100
 )
 
 // POST /conversations/:id/messages
-func (rt *_router) sendMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	if !checkAuthorization(w, r) {
-		return
-	}
+func (rt *_router) sendMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 	conversationId, err := strconv.Atoi(ps.ByName("id"))
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
@@ -21,21 +31,16 @@ func (rt *_router) sendMessage(w http.ResponseWriter, r *http.Request, ps httpro
 		}
 		return
 	}
-	userId, err := strconv.Atoi(r.Header.Get("Authorization"))
-	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		if encErr := json.NewEncoder(w).Encode(map[string]string{"message": "Utente non autorizzato"}); encErr != nil {
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		}
-		return
-	}
+	userId := ctx.UserID
 	var req struct {
 		Content          string `json:"content"`
 		MediaType        string `json:"mediaType"`
 		IsForwarded      bool   `json:"isForwarded"`
 		ReplyToMessageID *int   `json:"replyToMessageId"` // NEW: Optional reply reference
+		// Media caricato con POST /media; content diventa la didascalia e può essere vuoto
+		MediaID *string `json:"mediaId"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Content == "" {
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.Content == "" && req.MediaID == nil) {
 		w.WriteHeader(http.StatusBadRequest)
 		if encErr := json.NewEncoder(w).Encode(map[string]string{"message": "Contenuto mancante"}); encErr != nil {
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -46,6 +51,34 @@ func (rt *_router) sendMessage(w http.ResponseWriter, r *http.Request, ps httpro
 		req.MediaType = "text"
 	}
 
+	// Le immagini inviate inline come data URL vengono salvate come media
+	if req.MediaID == nil && mediastore.IsDataURL(req.Content) {
+		rt.blobsMu.RLock()
+		media, err := mediastore.SaveDataURL(rt.db, rt.blobs, req.Content, userId)
+		rt.blobsMu.RUnlock()
+		if sendImageError(w, err) {
+			return
+		} else if err != nil {
+			ctx.Logger.WithError(err).Error("can't save message image")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		req.MediaID, req.Content = &media.ID, ""
+	}
+
+	// Il media deve esistere ed essere stato caricato dal mittente
+	if req.MediaID != nil {
+		media, err := rt.db.GetMedia(*req.MediaID)
+		if err != nil || media.UploaderID != userId {
+			w.WriteHeader(http.StatusBadRequest)
+			if encErr := json.NewEncoder(w).Encode(map[string]string{"message": "Media non trovato"}); encErr != nil {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			}
+			return
+		}
+		req.MediaType = "image"
+	}
+
 	// Validate reply message exists if provided
 	if req.ReplyToMessageID != nil {
 		_, err := rt.db.GetMessageById(conversationId, *req.ReplyToMessageID)
@@ -58,25 +91,31 @@ func (rt *_router) sendMessage(w http.ResponseWriter, r *http.Request, ps httpro
 		}
 	}
 
-	messages, err := rt.db.SendMessage(conversationId, userId, req.Content, req.MediaType, req.IsForwarded, req.ReplyToMessageID)
-	if err != nil || len(messages) == 0 {
+	message, err := rt.db.SendMessage(conversationId, userId, req.Content, req.MediaType, req.MediaID, req.IsForwarded, req.ReplyToMessageID)
+	if errors.Is(err, database.ErrPermissionDenied) {
+		w.WriteHeader(http.StatusForbidden)
+		if encErr := json.NewEncoder(w).Encode(map[string]string{"message": err.Error()}); encErr != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		if encErr := json.NewEncoder(w).Encode(map[string]string{"message": "Errore invio messaggio"}); encErr != nil {
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		}
 		return
 	}
+	rt.publishToConversation(ctx, events.MessageCreated, conversationId, message)
 	w.Header().Set("Content-Type", "application/json")
-	if encErr := json.NewEncoder(w).Encode(messages[len(messages)-1]); encErr != nil {
+	if encErr := json.NewEncoder(w).Encode(message); encErr != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 	}
 }
 
-// GET /conversations/:id/messages
-func (rt *_router) getConversation(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	if !checkAuthorization(w, r) {
-		return
-	}
+// GET /conversations/:id/messages?before=&after=&limit=&collapseThreads=
+// Con collapseThreads=true le risposte dei thread sono escluse e si leggono con GET .../thread
+func (rt *_router) getConversation(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 	conversationId, err := strconv.Atoi(ps.ByName("id"))
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
@@ -85,20 +124,23 @@ func (rt *_router) getConversation(w http.ResponseWriter, r *http.Request, ps ht
 		}
 		return
 	}
-	userIdStr := r.Header.Get("Authorization")
-	userId, err := strconv.Atoi(userIdStr)
-	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		if encErr := json.NewEncoder(w).Encode(map[string]string{"error": "Unauthorized"}); encErr != nil {
+	userId := ctx.UserID
+	query, msg := parseMessagePageQuery(r)
+	query.CollapseThreads = r.URL.Query().Get("collapseThreads") == "true"
+	if msg != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		if encErr := json.NewEncoder(w).Encode(map[string]string{"error": msg}); encErr != nil {
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		}
 		return
 	}
 
 	// Aggiorna a "received" i messaggi ricevuti da questo utente
-	_ = rt.db.SetMessagesReceived(conversationId, userId)
+	if updated, err := rt.db.SetMessagesReceived(conversationId, userId); err == nil && updated > 0 {
+		rt.publishToConversation(ctx, events.ReceiptUpdated, conversationId, map[string]interface{}{"userId": userId, "status": "received"})
+	}
 
-	messages, err := rt.db.GetMessages(conversationId)
+	page, err := rt.db.GetMessagesPage(conversationId, userId, query)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		if encErr := json.NewEncoder(w).Encode(map[string]string{"error": "Errore recupero messaggi"}); encErr != nil {
@@ -107,20 +149,46 @@ func (rt *_router) getConversation(w http.ResponseWriter, r *http.Request, ps ht
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	if encErr := json.NewEncoder(w).Encode(messages); encErr != nil {
+	if encErr := json.NewEncoder(w).Encode(page); encErr != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 	}
 }
 
-// PUT /conversations/:id/messages/read
-func (rt *_router) markMessagesRead(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	if !checkAuthorization(w, r) {
-		w.WriteHeader(http.StatusUnauthorized)
-		if encErr := json.NewEncoder(w).Encode(map[string]string{"error": "Unauthorized"}); encErr != nil {
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+// parseMessagePageQuery legge i parametri di paginazione before, after e limit. Se non sono validi restituisce il
+// messaggio di errore da mostrare al client.
+func parseMessagePageQuery(r *http.Request) (database.MessagePageQuery, string) {
+	query := database.MessagePageQuery{Limit: defaultPageSize}
+	params := r.URL.Query()
+	if value := params.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			return query, "Parametro limit non valido"
 		}
-		return
+		query.Limit = limit
 	}
+	before, after := params.Get("before"), params.Get("after")
+	if before != "" && after != "" {
+		return query, "Specificare solo uno tra before e after"
+	}
+	if before != "" {
+		cursor, err := database.ParseMessageCursor(before)
+		if err != nil {
+			return query, "Cursore non valido"
+		}
+		query.Before = &cursor
+	}
+	if after != "" {
+		cursor, err := database.ParseMessageCursor(after)
+		if err != nil {
+			return query, "Cursore non valido"
+		}
+		query.After = &cursor
+	}
+	return query, ""
+}
+
+// PUT /conversations/:id/messages/read
+func (rt *_router) markMessagesRead(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 	conversationId, err := strconv.Atoi(ps.ByName("id"))
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
@@ -129,16 +197,10 @@ func (rt *_router) markMessagesRead(w http.ResponseWriter, r *http.Request, ps h
 		}
 		return
 	}
-	userIdStr := r.Header.Get("Authorization")
-	userId, err := strconv.Atoi(userIdStr)
-	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		if encErr := json.NewEncoder(w).Encode(map[string]string{"error": "Unauthorized"}); encErr != nil {
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		}
-		return
-	}
-	err = rt.db.SetMessagesRead(conversationId, userId)
+	userId := ctx.UserID
+	// Con collapseThreads le risposte dei thread restano non lette, come nella cronologia mostrata dal client
+	collapseThreads := r.URL.Query().Get("collapseThreads") == "true"
+	updated, err := rt.db.SetMessagesRead(conversationId, userId, collapseThreads)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		if encErr := json.NewEncoder(w).Encode(map[string]string{"error": "Errore aggiornamento messaggi"}); encErr != nil {
@@ -146,18 +208,14 @@ func (rt *_router) markMessagesRead(w http.ResponseWriter, r *http.Request, ps h
 		}
 		return
 	}
+	if updated > 0 {
+		rt.publishToConversation(ctx, events.ReceiptUpdated, conversationId, map[string]interface{}{"userId": userId, "status": "read"})
+	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
-// DELETE /conversations/:id/messages/:messageId
-func (rt *_router) deleteMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	if !checkAuthorization(w, r) {
-		w.WriteHeader(http.StatusUnauthorized)
-		if encErr := json.NewEncoder(w).Encode(map[string]string{"error": "Unauthorized"}); encErr != nil {
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		}
-		return
-	}
+// DELETE /conversations/:id/messages/:messageId?for=me|everyone
+func (rt *_router) deleteMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 	conversationId, err := strconv.Atoi(ps.ByName("id"))
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
@@ -174,16 +232,25 @@ func (rt *_router) deleteMessage(w http.ResponseWriter, r *http.Request, ps http
 		}
 		return
 	}
-	userIdStr := r.Header.Get("Authorization")
-	userId, err := strconv.Atoi(userIdStr)
-	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		if encErr := json.NewEncoder(w).Encode(map[string]string{"error": "Unauthorized"}); encErr != nil {
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	userId := ctx.UserID
+
+	// "Elimina per me": il messaggio viene nascosto solo all'utente, qualunque sia il mittente
+	if r.URL.Query().Get("for") == "me" {
+		if err := rt.db.HideMessage(conversationId, messageId, userId); err != nil {
+			ctx.Logger.WithError(err).Error("can't hide message")
+			w.WriteHeader(http.StatusInternalServerError)
+			if encErr := json.NewEncoder(w).Encode(map[string]string{"error": "Errore eliminazione"}); encErr != nil {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			}
+			return
 		}
+		// L'evento arriva solo agli altri dispositivi dell'utente
+		rt.publish(ctx, events.MessageDeleted, conversationId, []int{userId}, map[string]interface{}{"messageId": messageId, "for": "me"})
+		w.WriteHeader(http.StatusNoContent)
 		return
 	}
-	// Solo il mittente può eliminare il proprio messaggio
+
+	// Solo il mittente può eliminare il proprio messaggio per tutti
 	err = rt.db.DeleteMessage(conversationId, messageId, userId)
 	if err != nil {
 		w.WriteHeader(http.StatusForbidden)
@@ -192,18 +259,12 @@ func (rt *_router) deleteMessage(w http.ResponseWriter, r *http.Request, ps http
 		}
 		return
 	}
+	rt.publishToConversation(ctx, events.MessageDeleted, conversationId, map[string]interface{}{"messageId": messageId, "for": "everyone"})
 	w.WriteHeader(http.StatusNoContent)
 }
 
 // POST /conversations/:id/messages/:messageId/forward
-func (rt *_router) forwardMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	if !checkAuthorization(w, r) {
-		w.WriteHeader(http.StatusUnauthorized)
-		if encErr := json.NewEncoder(w).Encode(map[string]string{"error": "Unauthorized"}); encErr != nil {
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		}
-		return
-	}
+func (rt *_router) forwardMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 	sourceConvId, err := strconv.Atoi(ps.ByName("id"))
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
@@ -220,15 +281,7 @@ func (rt *_router) forwardMessage(w http.ResponseWriter, r *http.Request, ps htt
 		}
 		return
 	}
-	userIdStr := r.Header.Get("Authorization")
-	userId, err := strconv.Atoi(userIdStr)
-	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		if encErr := json.NewEncoder(w).Encode(map[string]string{"error": "Unauthorized"}); encErr != nil {
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		}
-		return
-	}
+	userId := ctx.UserID
 	var req struct {
 		TargetConversationId int `json:"targetConversationId"`
 	}
@@ -240,9 +293,14 @@ func (rt *_router) forwardMessage(w http.ResponseWriter, r *http.Request, ps htt
 		return
 	}
 
-	// Recupera il messaggio originale
+	// Anche la conversazione di destinazione deve essere accessibile all'utente
+	if !rt.checkConversationAccess(w, ctx, req.TargetConversationId, false) {
+		return
+	}
+
+	// Recupera il messaggio originale: i messaggi di sistema non si possono inoltrare
 	original, err := rt.db.GetMessageById(sourceConvId, messageId)
-	if err != nil {
+	if err != nil || original.DeletedAt != nil || original.Kind != database.MessageKindUser {
 		w.WriteHeader(http.StatusNotFound)
 		if encErr := json.NewEncoder(w).Encode(map[string]string{"error": "Messaggio da inoltrare non trovato"}); encErr != nil {
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -251,16 +309,45 @@ func (rt *_router) forwardMessage(w http.ResponseWriter, r *http.Request, ps htt
 	}
 
 	// Inoltra il messaggio usando SendMessage (isForwarded: true, replyToMessageID: nil)
-	messages, err := rt.db.SendMessage(req.TargetConversationId, userId, original.Content, original.MediaType, true, nil)
-	if err != nil || len(messages) == 0 {
+	message, err := rt.db.SendMessage(req.TargetConversationId, userId, original.Content, original.MediaType, original.MediaID, true, nil)
+	if errors.Is(err, database.ErrPermissionDenied) {
+		w.WriteHeader(http.StatusForbidden)
+		if encErr := json.NewEncoder(w).Encode(map[string]string{"error": err.Error()}); encErr != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		if encErr := json.NewEncoder(w).Encode(map[string]string{"error": "Errore inoltro messaggio"}); encErr != nil {
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		}
 		return
 	}
+	rt.publishToConversation(ctx, events.MessageCreated, req.TargetConversationId, message)
+	w.Header().Set("Content-Type", "application/json")
+	if encErr := json.NewEncoder(w).Encode(message); encErr != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// GET /conversations/:id/messages/:messageId/receipts
+// Restituisce per ogni destinatario quando ha ricevuto e letto il messaggio, prima chi lo ha letto
+func (rt *_router) getMessageReceipts(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	conversationId, _ := strconv.Atoi(ps.ByName("id"))
+	messageId, _ := strconv.Atoi(ps.ByName("messageId"))
+
+	receipts, err := rt.db.GetMessageReceipts(conversationId, messageId)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("can't load message receipts")
+		w.WriteHeader(http.StatusInternalServerError)
+		if encErr := json.NewEncoder(w).Encode(map[string]string{"error": "Errore recupero ricevute"}); encErr != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
-	if encErr := json.NewEncoder(w).Encode(messages[len(messages)-1]); encErr != nil {
+	if encErr := json.NewEncoder(w).Encode(receipts); encErr != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 	}
 }
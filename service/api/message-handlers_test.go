@@ -0,0 +1,149 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// decode unmarshals the body of a response, failing the test if the status is not want.
+func decode(t *testing.T, rec *httptest.ResponseRecorder, want int, v interface{}) {
+	t.Helper()
+	if rec.Code != want {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, want, rec.Body.String())
+	}
+	if v != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMessageLifecycle(t *testing.T) {
+	h := newTestHandler(t)
+	_, aliceToken := register(t, h, "alice")
+	bobID, bobToken := register(t, h, "bob")
+
+	var conv struct{ ConversationID int }
+	decode(t, doRequest(t, h, http.MethodPost, "/conversations", aliceToken, map[string]int{"userId": bobID}), http.StatusCreated, &conv)
+	convPath := fmt.Sprintf("/conversations/%d", conv.ConversationID)
+
+	var msg struct {
+		ID     int
+		Status string
+	}
+	decode(t, doRequest(t, h, http.MethodPost, convPath+"/messages", aliceToken, map[string]string{"content": "helo"}), http.StatusOK, &msg)
+	if msg.Status != "sent" {
+		t.Errorf("new message status = %q, want sent", msg.Status)
+	}
+	msgPath := fmt.Sprintf("%s/messages/%d", convPath, msg.ID)
+
+	// Only the sender can edit, and the edit keeps the previous version
+	if rec := doRequest(t, h, http.MethodPatch, msgPath, bobToken, map[string]string{"content": "hijacked"}); rec.Code != http.StatusForbidden {
+		t.Errorf("edit by another member: got status %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	var edited struct {
+		Content  string
+		EditedAt *string
+	}
+	decode(t, doRequest(t, h, http.MethodPatch, msgPath, aliceToken, map[string]string{"content": "hello"}), http.StatusOK, &edited)
+	if edited.Content != "hello" || edited.EditedAt == nil {
+		t.Errorf("edited message = %+v", edited)
+	}
+	var edits []struct{ Content string }
+	decode(t, doRequest(t, h, http.MethodGet, msgPath+"/edits", bobToken, nil), http.StatusOK, &edits)
+	if len(edits) != 1 || edits[0].Content != "helo" {
+		t.Errorf("edits = %+v, want the original content", edits)
+	}
+
+	// Reading the conversation marks the message as received, then as read
+	decode(t, doRequest(t, h, http.MethodGet, convPath+"/messages", bobToken, nil), http.StatusOK, nil)
+	if rec := doRequest(t, h, http.MethodPatch, convPath+"/messages/read", bobToken, nil); rec.Code/100 != 2 {
+		t.Fatalf("marking as read: status %d", rec.Code)
+	}
+	var receipts []struct {
+		User   struct{ ID int }
+		ReadAt *string
+	}
+	decode(t, doRequest(t, h, http.MethodGet, msgPath+"/receipts", aliceToken, nil), http.StatusOK, &receipts)
+	if len(receipts) != 1 || receipts[0].User.ID != bobID || receipts[0].ReadAt == nil {
+		t.Errorf("receipts = %+v, want one read by bob", receipts)
+	}
+
+	// Deleting leaves a placeholder without content
+	if rec := doRequest(t, h, http.MethodDelete, msgPath, aliceToken, nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("deleting: status %d", rec.Code)
+	}
+	var page struct {
+		Messages []struct {
+			ID        int
+			Content   string
+			Status    string
+			DeletedAt *string
+		}
+	}
+	decode(t, doRequest(t, h, http.MethodGet, convPath+"/messages", bobToken, nil), http.StatusOK, &page)
+	if len(page.Messages) != 1 {
+		t.Fatalf("got %d messages, want 1", len(page.Messages))
+	}
+	if m := page.Messages[0]; m.Content != "" || m.DeletedAt == nil || m.Status != "read" {
+		t.Errorf("deleted message = %+v", m)
+	}
+}
+
+func TestGroupErrorsAreReported(t *testing.T) {
+	h := newTestHandler(t)
+	_, aliceToken := register(t, h, "alice")
+
+	rec := doRequest(t, h, http.MethodPost, "/groups", aliceToken, map[string]interface{}{
+		"name": "friends", "members": []string{"alice", "nobody"},
+	})
+	var res struct{ Message string }
+	decode(t, rec, http.StatusBadRequest, &res)
+	if !strings.Contains(res.Message, "nobody") {
+		t.Errorf("error message %q does not name the missing user", res.Message)
+	}
+
+	var groups []struct{ ID int }
+	decode(t, doRequest(t, h, http.MethodGet, "/groups", aliceToken, nil), http.StatusOK, &groups)
+	if len(groups) != 0 {
+		t.Errorf("a failed creation left %d groups", len(groups))
+	}
+}
+
+func TestSystemMessages(t *testing.T) {
+	h := newTestHandler(t)
+	aliceID, aliceToken := register(t, h, "alice")
+	register(t, h, "bob")
+
+	var group struct{ ID int }
+	decode(t, doRequest(t, h, http.MethodPost, "/groups", aliceToken, map[string]interface{}{
+		"name": "friends", "members": []string{"bob"},
+	}), http.StatusCreated, &group)
+	decode(t, doRequest(t, h, http.MethodPatch, fmt.Sprintf("/groups/%d/name", group.ID), aliceToken,
+		map[string]string{"name": "best friends"}), http.StatusNoContent, nil)
+
+	messagesPath := fmt.Sprintf("/conversations/%d/messages", group.ID)
+	var page struct {
+		Messages []struct {
+			ID      int
+			Kind    string
+			Content string
+			Sender  struct{ ID int }
+		}
+	}
+	decode(t, doRequest(t, h, http.MethodGet, messagesPath, aliceToken, nil), http.StatusOK, &page)
+	if len(page.Messages) != 1 || page.Messages[0].Kind != "group_renamed" || page.Messages[0].Content != "best friends" ||
+		page.Messages[0].Sender.ID != aliceID {
+		t.Fatalf("messages = %+v", page.Messages)
+	}
+
+	// System messages can't be forwarded
+	if rec := doRequest(t, h, http.MethodPost, fmt.Sprintf("%s/%d/forward", messagesPath, page.Messages[0].ID), aliceToken,
+		map[string]int{"targetConversationId": group.ID}); rec.Code != http.StatusNotFound {
+		t.Errorf("forwarding a system message: got status %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
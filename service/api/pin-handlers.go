@@ -0,0 +1,90 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/rerikdev/WASAText/service/api/reqcontext"
+	"github.com/rerikdev/WASAText/service/database"
+	"github.com/rerikdev/WASAText/service/events"
+)
+
+// pinErrorStatus restituisce lo stato HTTP corrispondente a un errore di PinMessage o UnpinMessage
+func pinErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, database.ErrPermissionDenied):
+		return http.StatusForbidden
+	case errors.Is(err, database.ErrMessageNotFound), errors.Is(err, database.ErrMessageNotPinned):
+		return http.StatusNotFound
+	case errors.Is(err, database.ErrMessageAlreadyPinned), errors.Is(err, database.ErrTooManyPinnedMessages):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// sendPinError risponde con lo stato di pinErrorStatus; gli errori interni vengono registrati e sostituiti da fallback
+func sendPinError(w http.ResponseWriter, ctx reqcontext.RequestContext, err error, fallback string) {
+	status := pinErrorStatus(err)
+	message := err.Error()
+	if status == http.StatusInternalServerError {
+		ctx.Logger.WithError(err).Error(fallback)
+		message = fallback
+	}
+	w.WriteHeader(status)
+	if encErr := json.NewEncoder(w).Encode(map[string]string{"error": message}); encErr != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// PUT /conversations/:id/messages/:messageId/pin
+// Fissa il messaggio in cima alla conversazione
+func (rt *_router) pinMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	conversationId, _ := strconv.Atoi(ps.ByName("id"))
+	messageId, _ := strconv.Atoi(ps.ByName("messageId"))
+	inserted, err := rt.db.PinMessage(conversationId, messageId, ctx.UserID)
+	if err != nil {
+		sendPinError(w, ctx, err, "Errore nel fissare il messaggio")
+		return
+	}
+	rt.publishToConversation(ctx, events.MessagePinned, conversationId, map[string]interface{}{"messageId": messageId, "userId": ctx.UserID})
+	rt.publishSystemMessages(ctx, conversationId, inserted)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DELETE /conversations/:id/messages/:messageId/pin
+// Toglie il messaggio da quelli fissati
+func (rt *_router) unpinMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	conversationId, _ := strconv.Atoi(ps.ByName("id"))
+	messageId, _ := strconv.Atoi(ps.ByName("messageId"))
+	inserted, err := rt.db.UnpinMessage(conversationId, messageId, ctx.UserID)
+	if err != nil {
+		sendPinError(w, ctx, err, "Errore nel togliere il messaggio fissato")
+		return
+	}
+	rt.publishToConversation(ctx, events.MessageUnpinned, conversationId, map[string]interface{}{"messageId": messageId, "userId": ctx.UserID})
+	rt.publishSystemMessages(ctx, conversationId, inserted)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GET /conversations/:id/pins
+// Restituisce i messaggi fissati della conversazione, dal più recentemente fissato
+func (rt *_router) getPinnedMessages(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	conversationId, _ := strconv.Atoi(ps.ByName("id"))
+	pins, err := rt.db.GetPinnedMessages(conversationId, ctx.UserID)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("can't load pinned messages")
+		w.WriteHeader(http.StatusInternalServerError)
+		if encErr := json.NewEncoder(w).Encode(map[string]string{"error": "Errore recupero messaggi fissati"}); encErr != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if encErr := json.NewEncoder(w).Encode(pins); encErr != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
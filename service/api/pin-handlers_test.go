@@ -0,0 +1,62 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestPinnedMessages(t *testing.T) {
+	h := newTestHandler(t)
+	_, aliceToken := register(t, h, "alice")
+	_, bobToken := register(t, h, "bob")
+
+	var group struct{ ID int }
+	decode(t, doRequest(t, h, http.MethodPost, "/groups", aliceToken, map[string]interface{}{
+		"name": "friends", "members": []string{"bob"},
+	}), http.StatusCreated, &group)
+	messagesPath := fmt.Sprintf("/conversations/%d/messages", group.ID)
+	pinsPath := fmt.Sprintf("/conversations/%d/pins", group.ID)
+
+	var sent struct{ ID int }
+	decode(t, doRequest(t, h, http.MethodPost, messagesPath, aliceToken, map[string]string{"content": "rules"}), http.StatusOK, &sent)
+	pinPath := fmt.Sprintf("%s/%d/pin", messagesPath, sent.ID)
+
+	decode(t, doRequest(t, h, http.MethodPut, pinPath, bobToken, nil), http.StatusNoContent, nil)
+	if rec := doRequest(t, h, http.MethodPut, pinPath, bobToken, nil); rec.Code != http.StatusConflict {
+		t.Errorf("pinning twice: got status %d, want %d", rec.Code, http.StatusConflict)
+	}
+
+	var pins []struct {
+		Message  struct{ ID int }
+		PinnedBy *struct{ Username string }
+	}
+	decode(t, doRequest(t, h, http.MethodGet, pinsPath, aliceToken, nil), http.StatusOK, &pins)
+	if len(pins) != 1 || pins[0].Message.ID != sent.ID || pins[0].PinnedBy == nil || pins[0].PinnedBy.Username != "bob" {
+		t.Errorf("pins = %+v", pins)
+	}
+
+	var inbox []struct {
+		LastMessage *struct{ Kind, Text string }
+	}
+	decode(t, doRequest(t, h, http.MethodGet, "/inbox", aliceToken, nil), http.StatusOK, &inbox)
+	if len(inbox) != 1 || inbox[0].LastMessage == nil || inbox[0].LastMessage.Kind != "message_pinned" || inbox[0].LastMessage.Text != "rules" {
+		t.Errorf("inbox = %+v", inbox)
+	}
+
+	// With onlyAdminsPin members can't unpin
+	decode(t, doRequest(t, h, http.MethodPatch, fmt.Sprintf("/groups/%d/settings", group.ID), aliceToken, map[string]bool{
+		"onlyAdminsPin": true,
+	}), http.StatusOK, nil)
+	if rec := doRequest(t, h, http.MethodDelete, pinPath, bobToken, nil); rec.Code != http.StatusForbidden {
+		t.Errorf("unpinning as a member: got status %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	decode(t, doRequest(t, h, http.MethodDelete, pinPath, aliceToken, nil), http.StatusNoContent, nil)
+	if rec := doRequest(t, h, http.MethodDelete, pinPath, aliceToken, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("unpinning a message that is not pinned: got status %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	decode(t, doRequest(t, h, http.MethodGet, pinsPath, aliceToken, nil), http.StatusOK, &pins)
+	if len(pins) != 0 {
+		t.Errorf("pins after unpinning = %+v", pins)
+	}
+}
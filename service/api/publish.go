@@ -0,0 +1,33 @@
+package api
+
+import (
+	"github.com/rerikdev/WASAText/service/api/reqcontext"
+	"github.com/rerikdev/WASAText/service/events"
+	"github.com/rerikdev/WASAText/service/structures"
+)
+
+// publishToConversation sends a real-time event to every current member of the conversation. Errors are only logged:
+// the operation that generated the event has already been committed.
+func (rt *_router) publishToConversation(ctx reqcontext.RequestContext, eventType string, conversationId int, data interface{}) {
+	members, err := rt.db.GetConversationMemberIds(conversationId)
+	if err != nil {
+		ctx.Logger.WithError(err).WithField("event", eventType).Error("can't load the recipients of the event")
+		return
+	}
+	rt.publish(ctx, eventType, conversationId, members, data)
+}
+
+// publish saves a real-time event in the event log and sends it to the given users
+func (rt *_router) publish(ctx reqcontext.RequestContext, eventType string, conversationId int, recipients []int, data interface{}) {
+	if _, err := rt.hub.Publish(eventType, conversationId, recipients, data); err != nil {
+		ctx.Logger.WithError(err).WithField("event", eventType).Error("can't publish the event")
+	}
+}
+
+// publishSystemMessages sends the system messages inserted by a change (member added, group renamed, message pinned...)
+// as new messages of the conversation, so that they appear in the clients' timelines like the other messages
+func (rt *_router) publishSystemMessages(ctx reqcontext.RequestContext, conversationId int, messages []*structures.Message) {
+	for _, msg := range messages {
+		rt.publishToConversation(ctx, events.MessageCreated, conversationId, msg)
+	}
+}
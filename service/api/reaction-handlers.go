@@ -7,17 +7,12 @@ import (
 	"strconv"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/rerikdev/WASAText/service/api/reqcontext"
+	"github.com/rerikdev/WASAText/service/events"
 )
 
 // POST /conversations/:id/messages/:messageId/reactions
-func (rt *_router) commentMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	if !checkAuthorization(w, r) {
-		w.WriteHeader(http.StatusUnauthorized)
-		if encErr := json.NewEncoder(w).Encode(map[string]string{"error": "Unauthorized"}); encErr != nil {
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		}
-		return
-	}
+func (rt *_router) commentMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 	messageId, err := strconv.Atoi(ps.ByName("messageId"))
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
@@ -26,14 +21,7 @@ func (rt *_router) commentMessage(w http.ResponseWriter, r *http.Request, ps htt
 		}
 		return
 	}
-	userId, err := strconv.Atoi(r.Header.Get("Authorization"))
-	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		if encErr := json.NewEncoder(w).Encode(map[string]string{"error": "Utente non autorizzato"}); encErr != nil {
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		}
-		return
-	}
+	userId := ctx.UserID
 	var req struct {
 		Emoji string `json:"emoji"`
 	}
@@ -52,6 +40,7 @@ func (rt *_router) commentMessage(w http.ResponseWriter, r *http.Request, ps htt
 		}
 		return
 	}
+	rt.publishReactions(ctx, ps, messageId)
 	w.WriteHeader(http.StatusOK)
 	if encErr := json.NewEncoder(w).Encode(map[string]string{"status": "ok"}); encErr != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -59,14 +48,7 @@ func (rt *_router) commentMessage(w http.ResponseWriter, r *http.Request, ps htt
 }
 
 // DELETE /conversations/:id/messages/:messageId/reactions
-func (rt *_router) uncommentMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	if !checkAuthorization(w, r) {
-		w.WriteHeader(http.StatusUnauthorized)
-		if encErr := json.NewEncoder(w).Encode(map[string]string{"error": "Unauthorized"}); encErr != nil {
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		}
-		return
-	}
+func (rt *_router) uncommentMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 	messageId, err := strconv.Atoi(ps.ByName("messageId"))
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
@@ -75,14 +57,7 @@ func (rt *_router) uncommentMessage(w http.ResponseWriter, r *http.Request, ps h
 		}
 		return
 	}
-	userId, err := strconv.Atoi(r.Header.Get("Authorization"))
-	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		if encErr := json.NewEncoder(w).Encode(map[string]string{"error": "Utente non autorizzato"}); encErr != nil {
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		}
-		return
-	}
+	userId := ctx.UserID
 	if err := rt.db.RemoveReaction(messageId, userId); err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		if encErr := json.NewEncoder(w).Encode(map[string]string{"error": "Errore rimozione reazione"}); encErr != nil {
@@ -90,19 +65,12 @@ func (rt *_router) uncommentMessage(w http.ResponseWriter, r *http.Request, ps h
 		}
 		return
 	}
+	rt.publishReactions(ctx, ps, messageId)
 	w.WriteHeader(http.StatusNoContent)
 }
 
 // GET /conversations/:id/messages/:messageId/reactions
-func (rt *_router) getMessageReactions(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	if !checkAuthorization(w, r) {
-		w.WriteHeader(http.StatusUnauthorized)
-		if encErr := json.NewEncoder(w).Encode(map[string]string{"error": "Unauthorized"}); encErr != nil {
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		}
-		return
-	}
-
+func (rt *_router) getMessageReactions(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 	messageId, err := strconv.Atoi(ps.ByName("messageId"))
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
@@ -128,3 +96,14 @@ func (rt *_router) getMessageReactions(w http.ResponseWriter, r *http.Request, p
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 	}
 }
+
+// publishReactions notifica ai membri della conversazione le reazioni aggiornate di un messaggio
+func (rt *_router) publishReactions(ctx reqcontext.RequestContext, ps httprouter.Params, messageId int) {
+	conversationId, _ := strconv.Atoi(ps.ByName("id"))
+	reactions, err := rt.db.GetReactions(messageId)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("can't load reactions")
+		return
+	}
+	rt.publishToConversation(ctx, events.ReactionChanged, conversationId, map[string]interface{}{"messageId": messageId, "reactions": reactions})
+}
@@ -18,4 +18,10 @@ type RequestContext struct {
 
 	// Logger is a custom field logger for the request
 	Logger logrus.FieldLogger
+
+	// UserID is the ID of the authenticated user (zero if the route does not require authentication)
+	UserID int
+
+	// Token is the session token used to authenticate the request
+	Token string
 }
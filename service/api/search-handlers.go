@@ -0,0 +1,114 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/rerikdev/WASAText/service/api/reqcontext"
+	"github.com/rerikdev/WASAText/service/database"
+)
+
+// GET /search/messages?q=&conversationId=&senderId=&from=&to=&mediaType=&before=&limit=
+// Cerca tra i messaggi delle conversazioni dell'utente, dal più recente. q può mancare solo se è presente almeno un
+// filtro (ad esempio per elencare le immagini di una conversazione).
+func (rt *_router) searchMessages(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	query, msg := parseMessageSearchQuery(r)
+	if msg != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		if encErr := json.NewEncoder(w).Encode(map[string]string{"message": msg}); encErr != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	page, err := rt.db.SearchMessages(ctx.UserID, query)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("can't search messages")
+		w.WriteHeader(http.StatusInternalServerError)
+		if encErr := json.NewEncoder(w).Encode(map[string]string{"message": "Errore ricerca messaggi"}); encErr != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if encErr := json.NewEncoder(w).Encode(page); encErr != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// parseMessageSearchQuery legge i parametri della ricerca. Se non sono validi restituisce il messaggio di errore da
+// mostrare al client.
+func parseMessageSearchQuery(r *http.Request) (database.MessageSearchQuery, string) {
+	params := r.URL.Query()
+	query := database.MessageSearchQuery{
+		Text:      strings.TrimSpace(params.Get("q")),
+		MediaType: params.Get("mediaType"),
+		Limit:     defaultPageSize,
+	}
+
+	var err error
+	if value := params.Get("conversationId"); value != "" {
+		if query.ConversationID, err = strconv.Atoi(value); err != nil || query.ConversationID <= 0 {
+			return query, "Parametro conversationId non valido"
+		}
+	}
+	if value := params.Get("senderId"); value != "" {
+		if query.SenderID, err = strconv.Atoi(value); err != nil || query.SenderID <= 0 {
+			return query, "Parametro senderId non valido"
+		}
+	}
+	if value := params.Get("from"); value != "" {
+		if query.From, err = parseSearchDate(value, false); err != nil {
+			return query, "Parametro from non valido"
+		}
+	}
+	if value := params.Get("to"); value != "" {
+		if query.To, err = parseSearchDate(value, true); err != nil {
+			return query, "Parametro to non valido"
+		}
+	}
+	if query.MediaType != "" && query.MediaType != "text" && query.MediaType != "image" {
+		return query, "Parametro mediaType non valido"
+	}
+	if value := params.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			return query, "Parametro limit non valido"
+		}
+		query.Limit = limit
+	}
+	if value := params.Get("before"); value != "" {
+		cursor, err := database.ParseMessageCursor(value)
+		if err != nil {
+			return query, "Cursore non valido"
+		}
+		query.Before = &cursor
+	}
+
+	if query.Text == "" && query.ConversationID == 0 && query.SenderID == 0 && query.From.IsZero() &&
+		query.To.IsZero() && query.MediaType == "" {
+		return query, "Specificare il testo da cercare o almeno un filtro"
+	}
+	return query, ""
+}
+
+// parseSearchDate accetta una data in formato RFC 3339 oppure un giorno (AAAA-MM-GG). Se endOfDay è true, un giorno
+// indica la fine della giornata, così che il filtro to la includa.
+func parseSearchDate(value string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		// Le date sono salvate nell'ora locale del server
+		return t.Local(), nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if endOfDay {
+		t = t.Add(24 * time.Hour)
+	}
+	return t, nil
+}
@@ -1,15 +1,36 @@
 package api
 
 import (
+	"crypto/rand"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/rerikdev/WASAText/service/api/reqcontext"
+	"github.com/rerikdev/WASAText/service/database"
+	"github.com/rerikdev/WASAText/service/globaltime"
 	"github.com/rerikdev/WASAText/service/structures"
 )
 
-// Assicurati che _router abbia il campo db di tipo AppDatabase
-// type _router struct { db database.AppDatabase }
+// defaultSessionTTL è la durata di una sessione se non configurata diversamente
+const defaultSessionTTL = 30 * 24 * time.Hour
+
+// validPassword controlla la lunghezza della password (bcrypt considera al massimo 72 byte)
+func validPassword(password string) bool {
+	return len(password) >= 8 && len(password) <= 64
+}
+
+// newSessionToken genera un token di sessione opaco e casuale (256 bit)
+func newSessionToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
 
 func (rt *_router) doLogin(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	if r.Method != http.MethodPost {
@@ -23,8 +44,29 @@ func (rt *_router) doLogin(w http.ResponseWriter, r *http.Request, _ httprouter.
 		return
 	}
 
-	user, action, err := rt.db.DoLogin(req.Name, req.DisplayName, req.ProfilePicture)
+	if req.Password != "" && !validPassword(req.Password) {
+		http.Error(w, `{"message":"Invalid request: password must be 8-64 characters"}`, http.StatusBadRequest)
+		return
+	}
+
+	user, action, err := rt.db.DoLogin(req.Name, req.DisplayName, req.ProfilePicture, req.Password)
 	if err != nil {
+		if errors.Is(err, database.ErrInvalidCredentials) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			if encErr := json.NewEncoder(w).Encode(map[string]string{"message": "Credenziali non valide"}); encErr != nil {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			}
+			return
+		}
+		if errors.Is(err, database.ErrTooManyAttempts) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusTooManyRequests)
+			if encErr := json.NewEncoder(w).Encode(map[string]string{"message": "Troppi tentativi, riprova più tardi"}); encErr != nil {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			}
+			return
+		}
 		if err.Error() == "registrazione già effettuata" {
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusBadRequest)
@@ -45,6 +87,17 @@ func (rt *_router) doLogin(w http.ResponseWriter, r *http.Request, _ httprouter.
 		return
 	}
 
+	token, err := newSessionToken()
+	if err != nil {
+		http.Error(w, `{"message":"Internal server error"}`, http.StatusInternalServerError)
+		return
+	}
+	expiresAt := globaltime.Now().Add(rt.sessionTTL)
+	if err := rt.db.CreateSession(user.ID, token, expiresAt); err != nil {
+		http.Error(w, `{"message":"Internal server error"}`, http.StatusInternalServerError)
+		return
+	}
+
 	var msg string
 	if action == "login" {
 		msg = "Login effettuato"
@@ -55,12 +108,42 @@ func (rt *_router) doLogin(w http.ResponseWriter, r *http.Request, _ httprouter.
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	if encErr := json.NewEncoder(w).Encode(struct {
-		Message string           `json:"message"`
-		User    *structures.User `json:"user"`
+		Message   string           `json:"message"`
+		User      *structures.User `json:"user"`
+		Token     string           `json:"token"`
+		ExpiresAt time.Time        `json:"expiresAt"`
 	}{
-		Message: msg,
-		User:    user,
+		Message:   msg,
+		User:      user,
+		Token:     token,
+		ExpiresAt: expiresAt,
 	}); encErr != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 	}
 }
+
+// DELETE /session (logout dal dispositivo corrente)
+func (rt *_router) doLogout(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	if err := rt.db.RevokeSession(ctx.Token); err != nil {
+		ctx.Logger.WithError(err).Error("can't revoke session")
+		w.WriteHeader(http.StatusInternalServerError)
+		if encErr := json.NewEncoder(w).Encode(map[string]string{"message": "Errore durante il logout"}); encErr != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DELETE /sessions (logout da tutti i dispositivi)
+func (rt *_router) doLogoutAll(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	if err := rt.db.RevokeUserSessions(ctx.UserID); err != nil {
+		ctx.Logger.WithError(err).Error("can't revoke user sessions")
+		w.WriteHeader(http.StatusInternalServerError)
+		if encErr := json.NewEncoder(w).Encode(map[string]string{"message": "Errore durante il logout"}); encErr != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -2,5 +2,8 @@ package api
 
 // Close should close everything opened in the lifecycle of the `_router`; for example, background goroutines.
 func (rt *_router) Close() error {
+	// Disconnect WebSocket and SSE clients, so that their handlers return
+	rt.hub.Close()
+	close(rt.stop)
 	return nil
 }
@@ -0,0 +1,133 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/rerikdev/WASAText/service/api/reqcontext"
+	"github.com/rerikdev/WASAText/service/structures"
+)
+
+const (
+	// sseHeartbeatPeriod is the interval between the comments sent to keep idle connections (and proxies) alive
+	sseHeartbeatPeriod = 30 * time.Second
+
+	// sseRetry is the reconnection delay suggested to the browser, in milliseconds
+	sseRetry = 3000
+)
+
+// GET /events
+// Streams the events addressed to the user as Server-Sent Events. Every event carries its ID, so that the browser
+// resumes automatically after a reconnection sending the Last-Event-ID header (or ?lastEventId= for other clients).
+//
+// The connection is hijacked from the HTTP server: the server write timeout would otherwise close the stream after a
+// few seconds. The response is then written by hand, and the connection is closed when the stream ends.
+func (rt *_router) sseHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		ctx.Logger.Error("the response writer does not support hijacking, can't stream events")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	sub, backlog, ok := rt.subscribe(w, r, ctx)
+	if !ok {
+		return
+	}
+	defer sub.Close()
+
+	conn, buf, err := hijacker.Hijack()
+	if err != nil {
+		ctx.Logger.WithError(err).Error("can't hijack the connection")
+		return
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Time{})
+
+	// The client never sends anything else on this connection: a read returns only when it is closed
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = io.Copy(io.Discard, buf.Reader)
+	}()
+
+	// Headers set by the outer middlewares (e.g. CORS) are kept
+	header := w.Header().Clone()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "close")
+	header.Set("X-Accel-Buffering", "no")
+	if !sseWrite(conn, buf.Writer, func(bw *bufio.Writer) error {
+		if _, err := fmt.Fprintf(bw, "HTTP/1.1 200 OK\r\n"); err != nil {
+			return err
+		}
+		if err := header.Write(bw); err != nil {
+			return err
+		}
+		_, err := fmt.Fprintf(bw, "\r\nretry: %d\n\n", sseRetry)
+		return err
+	}) {
+		return
+	}
+
+	send := func(ev *structures.Event) bool {
+		return sseWrite(conn, buf.Writer, func(bw *bufio.Writer) error {
+			data, err := json.Marshal(ev)
+			if err != nil {
+				return err
+			}
+			if ev.ID > 0 {
+				if _, err := fmt.Fprintf(bw, "id: %d\n", ev.ID); err != nil {
+					return err
+				}
+			}
+			_, err = fmt.Fprintf(bw, "event: %s\ndata: %s\n\n", ev.Type, data)
+			return err
+		})
+	}
+
+	for _, ev := range backlog {
+		if !send(ev) {
+			return
+		}
+	}
+
+	ticker := time.NewTicker(sseHeartbeatPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case ev, ok := <-sub.Events():
+			if !ok {
+				// Subscription dropped (slow client or shutdown): the browser will reconnect and resume
+				return
+			}
+			if !send(ev) {
+				return
+			}
+		case <-ticker.C:
+			if !sseWrite(conn, buf.Writer, func(bw *bufio.Writer) error {
+				_, err := io.WriteString(bw, ": ping\n\n")
+				return err
+			}) {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// sseWrite writes a chunk of the stream with write and flushes it to the client within wsWriteWait
+func sseWrite(conn net.Conn, bw *bufio.Writer, write func(*bufio.Writer) error) bool {
+	_ = conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	if err := write(bw); err != nil {
+		return false
+	}
+	return bw.Flush() == nil
+}
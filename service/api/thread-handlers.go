@@ -0,0 +1,70 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/rerikdev/WASAText/service/api/reqcontext"
+	"github.com/rerikdev/WASAText/service/database"
+	"github.com/rerikdev/WASAText/service/events"
+)
+
+// GET /conversations/:id/messages/:messageId/thread?before=&after=&limit=
+// Restituisce il messaggio iniziale del thread di cui fa parte il messaggio e una pagina delle sue risposte, con gli
+// stessi parametri di paginazione della cronologia
+func (rt *_router) getThread(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	conversationId, _ := strconv.Atoi(ps.ByName("id"))
+	messageId, _ := strconv.Atoi(ps.ByName("messageId"))
+	query, msg := parseMessagePageQuery(r)
+	if msg != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		if encErr := json.NewEncoder(w).Encode(map[string]string{"error": msg}); encErr != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	thread, err := rt.db.GetThread(conversationId, messageId, ctx.UserID, query)
+	if errors.Is(err, database.ErrMessageNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		if encErr := json.NewEncoder(w).Encode(map[string]string{"error": "Messaggio non trovato"}); encErr != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("can't load thread")
+		w.WriteHeader(http.StatusInternalServerError)
+		if encErr := json.NewEncoder(w).Encode(map[string]string{"error": "Errore recupero thread"}); encErr != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if encErr := json.NewEncoder(w).Encode(thread); encErr != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// PATCH /conversations/:id/messages/:messageId/thread/read
+// Segna come letti il messaggio iniziale e le risposte del thread, lasciando invariato il resto della conversazione
+func (rt *_router) markThreadRead(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	conversationId, _ := strconv.Atoi(ps.ByName("id"))
+	messageId, _ := strconv.Atoi(ps.ByName("messageId"))
+
+	rootId, updated, err := rt.db.SetThreadRead(conversationId, messageId, ctx.UserID)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("can't mark thread as read")
+		w.WriteHeader(http.StatusInternalServerError)
+		if encErr := json.NewEncoder(w).Encode(map[string]string{"error": "Errore aggiornamento messaggi"}); encErr != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+	if updated > 0 {
+		rt.publishToConversation(ctx, events.ReceiptUpdated, conversationId, map[string]interface{}{"userId": ctx.UserID, "status": "read", "threadRootId": rootId})
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
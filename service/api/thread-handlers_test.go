@@ -0,0 +1,62 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestThreads(t *testing.T) {
+	h := newTestHandler(t)
+	_, aliceToken := register(t, h, "alice")
+	_, bobToken := register(t, h, "bob")
+
+	var group struct{ ID int }
+	decode(t, doRequest(t, h, http.MethodPost, "/groups", aliceToken, map[string]interface{}{
+		"name": "friends", "members": []string{"bob"},
+	}), http.StatusCreated, &group)
+	messagesPath := fmt.Sprintf("/conversations/%d/messages", group.ID)
+
+	type message struct {
+		ID           int
+		ThreadRootID *int
+		Thread       *struct{ ReplyCount, UnreadCount int }
+	}
+	var sent, reply message
+	decode(t, doRequest(t, h, http.MethodPost, messagesPath, aliceToken, map[string]string{"content": "lunch?"}), http.StatusOK, &sent)
+	root := sent.ID
+	decode(t, doRequest(t, h, http.MethodPost, messagesPath, aliceToken, map[string]interface{}{"content": "at noon", "replyToMessageId": root}), http.StatusOK, &reply)
+	if reply.ThreadRootID == nil || *reply.ThreadRootID != root {
+		t.Fatalf("reply = %+v", reply)
+	}
+
+	var thread struct {
+		Root     message
+		Messages []message
+		HasMore  bool
+	}
+	threadPath := fmt.Sprintf("%s/%d/thread", messagesPath, reply.ID)
+	decode(t, doRequest(t, h, http.MethodGet, threadPath, bobToken, nil), http.StatusOK, &thread)
+	if thread.Root.ID != root || thread.Root.Thread == nil || thread.Root.Thread.ReplyCount != 1 || thread.Root.Thread.UnreadCount != 1 ||
+		len(thread.Messages) != 1 || thread.Messages[0].ID != reply.ID || thread.HasMore {
+		t.Errorf("thread = %+v", thread)
+	}
+	if rec := doRequest(t, h, http.MethodGet, threadPath+"?limit=0", bobToken, nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid limit: got status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	var page struct{ Messages []message }
+	decode(t, doRequest(t, h, http.MethodGet, messagesPath+"?collapseThreads=true", bobToken, nil), http.StatusOK, &page)
+	if len(page.Messages) != 1 || page.Messages[0].ID != root {
+		t.Errorf("collapsed timeline = %+v", page.Messages)
+	}
+
+	decode(t, doRequest(t, h, http.MethodPatch, threadPath+"/read", bobToken, nil), http.StatusNoContent, nil)
+	decode(t, doRequest(t, h, http.MethodGet, threadPath, bobToken, nil), http.StatusOK, &thread)
+	if thread.Root.Thread == nil || thread.Root.Thread.UnreadCount != 0 {
+		t.Errorf("thread after reading it = %+v", thread.Root.Thread)
+	}
+	if rec := doRequest(t, h, http.MethodGet, fmt.Sprintf("%s/%d/thread", messagesPath, reply.ID+100), bobToken, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("thread of a missing message: got status %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
@@ -2,15 +2,15 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/rerikdev/WASAText/service/api/reqcontext"
+	"github.com/rerikdev/WASAText/service/database"
 )
 
-func (rt *_router) getUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	if !checkAuthorization(w, r) {
-		return
-	}
+func (rt *_router) getUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 	userId := ps.ByName("userId")
 	user, err := rt.db.GetUserById(userId)
 	if err != nil {
@@ -27,21 +27,24 @@ func (rt *_router) getUser(w http.ResponseWriter, r *http.Request, ps httprouter
 }
 
 // PATCH /users/:username/photo per cambiare foto profilo
-func (rt *_router) setMyPhoto(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	if !checkAuthorization(w, r) {
-		return
-	}
+func (rt *_router) setMyPhoto(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 	userId := ps.ByName("userId")
 	var req struct {
-		PhotoUrl string `json:"photoUrl"`
+		PhotoUrl string  `json:"photoUrl"`
+		MediaID  *string `json:"mediaId"` // Alternativa a photoUrl: immagine caricata con POST /media
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.PhotoUrl == "" {
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.PhotoUrl == "" && req.MediaID == nil) {
 		w.WriteHeader(http.StatusBadRequest)
 		if encErr := json.NewEncoder(w).Encode(map[string]string{"message": "URL non valido"}); encErr != nil {
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		}
 		return
 	}
+	photo, ok := rt.photoURL(w, ctx, req.PhotoUrl, req.MediaID)
+	if !ok {
+		return
+	}
+	req.PhotoUrl = photo
 	if err := rt.db.SetMyPhotoById(userId, req.PhotoUrl); err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		if encErr := json.NewEncoder(w).Encode(map[string]string{"message": "Errore aggiornamento immagine"}); encErr != nil {
@@ -57,10 +60,7 @@ func (rt *_router) setMyPhoto(w http.ResponseWriter, r *http.Request, ps httprou
 }
 
 // PATCH /users/:username per cambiare username
-func (rt *_router) setMyUserName(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	if !checkAuthorization(w, r) {
-		return
-	}
+func (rt *_router) setMyUserName(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
 	userId := ps.ByName("userId")
 	var req struct {
 		NewName string `json:"newName"`
@@ -87,10 +87,7 @@ func (rt *_router) setMyUserName(w http.ResponseWriter, r *http.Request, ps http
 }
 
 // GET /users/search?q=...
-func (rt *_router) searchUsers(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	if !checkAuthorization(w, r) {
-		return
-	}
+func (rt *_router) searchUsers(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
 	query := r.URL.Query().Get("q")
 	if len(query) < 1 {
 		w.WriteHeader(http.StatusBadRequest)
@@ -112,3 +109,45 @@ func (rt *_router) searchUsers(w http.ResponseWriter, r *http.Request, _ httprou
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 	}
 }
+
+// PUT /users/:userId/password per impostare o cambiare la password
+func (rt *_router) changePassword(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	var req struct {
+		CurrentPassword string `json:"currentPassword"`
+		NewPassword     string `json:"newPassword"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !validPassword(req.NewPassword) {
+		w.WriteHeader(http.StatusBadRequest)
+		if encErr := json.NewEncoder(w).Encode(map[string]string{"message": "La password deve essere di 8-64 caratteri"}); encErr != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+	err := rt.db.ChangePassword(ctx.UserID, req.CurrentPassword, req.NewPassword)
+	if errors.Is(err, database.ErrInvalidCredentials) {
+		w.WriteHeader(http.StatusUnauthorized)
+		if encErr := json.NewEncoder(w).Encode(map[string]string{"message": "Password attuale errata"}); encErr != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	} else if errors.Is(err, database.ErrTooManyAttempts) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		if encErr := json.NewEncoder(w).Encode(map[string]string{"message": "Troppi tentativi, riprova più tardi"}); encErr != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("can't change password")
+		w.WriteHeader(http.StatusInternalServerError)
+		if encErr := json.NewEncoder(w).Encode(map[string]string{"message": "Errore aggiornamento password"}); encErr != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Le altre sessioni vengono invalidate: chi conosceva la vecchia password non resta collegato
+	if err := rt.db.RevokeOtherSessions(ctx.UserID, ctx.Token); err != nil {
+		ctx.Logger.WithError(err).Error("can't revoke other sessions")
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
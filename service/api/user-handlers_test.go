@@ -0,0 +1,43 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestChangePassword(t *testing.T) {
+	h := newTestHandler(t)
+	aliceID, aliceToken := register(t, h, "alice")
+	bobID, _ := register(t, h, "bob")
+	alicePath := fmt.Sprintf("/users/%d/password", aliceID)
+
+	cases := []struct {
+		name string
+		path string
+		body map[string]string
+		want int
+	}{
+		{"set the first password", alicePath, map[string]string{"newPassword": "correct horse"}, http.StatusNoContent},
+		{"wrong current password", alicePath, map[string]string{"currentPassword": "wrong", "newPassword": "battery staple"}, http.StatusUnauthorized},
+		{"password too short", alicePath, map[string]string{"currentPassword": "correct horse", "newPassword": "short"}, http.StatusBadRequest},
+		{"another user's password", fmt.Sprintf("/users/%d/password", bobID), map[string]string{"newPassword": "battery staple"}, http.StatusForbidden},
+		{"change the password", alicePath, map[string]string{"currentPassword": "correct horse", "newPassword": "battery staple"}, http.StatusNoContent},
+	}
+	for _, c := range cases {
+		if rec := doRequest(t, h, http.MethodPut, c.path, aliceToken, c.body); rec.Code != c.want {
+			t.Errorf("%s: got status %d, want %d: %s", c.name, rec.Code, c.want, rec.Body.String())
+		}
+	}
+
+	// The new password is the one required to log in
+	login := func(password string) int {
+		return doRequest(t, h, http.MethodPost, "/session", "", map[string]string{"name": "alice", "password": password}).Code
+	}
+	if code := login("correct horse"); code != http.StatusUnauthorized {
+		t.Errorf("login with the old password: got status %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code := login("battery staple"); code != http.StatusOK {
+		t.Errorf("login with the new password: got status %d, want %d", code, http.StatusOK)
+	}
+}
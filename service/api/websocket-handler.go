@@ -0,0 +1,103 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/julienschmidt/httprouter"
+	"github.com/rerikdev/WASAText/service/api/reqcontext"
+	"github.com/rerikdev/WASAText/service/structures"
+)
+
+const (
+	// wsWriteWait is the time allowed to write a message to the client
+	wsWriteWait = 10 * time.Second
+
+	// wsPongWait is the time allowed to read the next pong from the client
+	wsPongWait = 60 * time.Second
+
+	// wsPingPeriod is the interval between heartbeats; it must be less than wsPongWait
+	wsPingPeriod = wsPongWait * 9 / 10
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	// The API is already served with a permissive CORS policy and the connection is authenticated by the token,
+	// cookies are never used.
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// GET /ws
+// Upgrades the connection to a WebSocket and pushes the events addressed to the user as JSON text messages. The
+// client can resume after a reconnection passing the ID of the last received event in ?lastEventId=.
+func (rt *_router) websocketHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params, ctx reqcontext.RequestContext) {
+	sub, backlog, ok := rt.subscribe(w, r, ctx)
+	if !ok {
+		return
+	}
+	defer sub.Close()
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade already replied to the client
+		ctx.Logger.WithError(err).Debug("websocket upgrade failed")
+		return
+	}
+	defer conn.Close()
+
+	// Read loop: the client does not send messages, but reading is needed to process pongs and close frames
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn.SetReadLimit(512)
+		_ = conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		})
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	send := func(ev *structures.Event) bool {
+		_ = conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+		if err := conn.WriteJSON(ev); err != nil {
+			ctx.Logger.WithError(err).Debug("websocket write failed")
+			return false
+		}
+		return true
+	}
+
+	for _, ev := range backlog {
+		if !send(ev) {
+			return
+		}
+	}
+
+	ticker := time.NewTicker(wsPingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case ev, ok := <-sub.Events():
+			if !ok {
+				// Subscription dropped (slow client or shutdown): the client will reconnect and resume
+				_ = conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseTryAgainLater, ""), time.Now().Add(wsWriteWait))
+				return
+			}
+			if !send(ev) {
+				return
+			}
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
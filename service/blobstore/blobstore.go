@@ -0,0 +1,50 @@
+/*
+Package blobstore contains the storage for binary media (images and their derived versions).
+
+Blobs are content-addressed: the key of a blob is the hex-encoded SHA-256 of its content, so storing the same bytes
+twice keeps a single copy. The Store interface allows different backends; Local stores the blobs in a directory of the
+filesystem, Memory keeps them in memory.
+
+A blob can be shared by several media, so the store does not know when it is no longer used: the API walks the store
+periodically and deletes the blobs that no media references anymore (see the housekeeping of the api package).
+*/
+package blobstore
+
+import (
+	"encoding/hex"
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrNotFound is returned when no blob exists with the requested key
+var ErrNotFound = errors.New("blob not found")
+
+// ErrInvalidKey is returned for keys that are not a hex-encoded SHA-256
+var ErrInvalidKey = errors.New("invalid blob key")
+
+// Store is a content-addressed blob storage
+type Store interface {
+	// Put stores the content read from r and returns its key and size. If a blob with the same content already
+	// exists, it is reused and its storage time is updated, so that it is not collected while the new media is
+	// being saved.
+	Put(r io.Reader) (key string, size int64, err error)
+
+	// Open returns the content of the blob
+	Open(key string) (io.ReadSeekCloser, error)
+
+	// Delete removes the blob. Deleting a missing blob is not an error.
+	Delete(key string) error
+
+	// Walk calls fn for every blob, with the last time it was stored, and stops at the first error returned by fn
+	Walk(fn func(key string, stored time.Time) error) error
+}
+
+// validKey reports whether key is a hex-encoded SHA-256
+func validKey(key string) bool {
+	if len(key) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(key)
+	return err == nil
+}
@@ -0,0 +1,123 @@
+package blobstore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Local is a Store keeping each blob in a file of a directory. Files are spread in subdirectories named after the first
+// two bytes of the key, to avoid huge directories.
+type Local struct {
+	dir string
+}
+
+// NewLocal returns a Local store saving the blobs in dir, creating it if needed
+func NewLocal(dir string) (*Local, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating blob directory: %w", err)
+	}
+	return &Local{dir: dir}, nil
+}
+
+func (s *Local) path(key string) string {
+	return filepath.Join(s.dir, key[0:2], key[2:4], key)
+}
+
+// Put writes the content to a temporary file while hashing it, then moves it to its final path
+func (s *Local) Put(r io.Reader) (string, int64, error) {
+	tmp, err := os.CreateTemp(s.dir, "upload-*")
+	if err != nil {
+		return "", 0, err
+	}
+	defer func() {
+		// After a successful rename the temporary file does not exist anymore
+		_ = os.Remove(tmp.Name())
+	}()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), r)
+	if err != nil {
+		_ = tmp.Close()
+		return "", 0, err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return "", 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", 0, err
+	}
+
+	key := hex.EncodeToString(hash.Sum(nil))
+	path := s.path(key)
+	if _, err := os.Stat(path); err == nil {
+		// Same content already stored: the modification time is the storage time seen by Walk
+		now := time.Now()
+		if err := os.Chtimes(path, now, now); err != nil {
+			return "", 0, err
+		}
+		return key, size, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", 0, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", 0, err
+	}
+	return key, size, nil
+}
+
+// Open opens the file of the blob
+func (s *Local) Open(key string) (io.ReadSeekCloser, error) {
+	if !validKey(key) {
+		return nil, ErrInvalidKey
+	}
+	f, err := os.Open(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Delete removes the file of the blob
+func (s *Local) Delete(key string) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+	err := os.Remove(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Walk lists the files of the blobs, skipping the temporary files of the uploads in progress
+func (s *Local) Walk(fn func(key string, stored time.Time) error) error {
+	return filepath.WalkDir(s.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !validKey(d.Name()) {
+			return nil
+		}
+		info, err := d.Info()
+		if errors.Is(err, os.ErrNotExist) {
+			// Deleted while walking
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return fn(d.Name(), info.ModTime())
+	})
+}
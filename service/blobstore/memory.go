@@ -0,0 +1,97 @@
+package blobstore
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"sync"
+	"time"
+)
+
+// Memory is a Store keeping the blobs in memory, for tests and demos. It is safe for concurrent use.
+type Memory struct {
+	mu    sync.RWMutex
+	blobs map[string]*memoryBlob
+}
+
+// memoryBlob is the content of a blob and the last time it was stored
+type memoryBlob struct {
+	content []byte
+	stored  time.Time
+}
+
+// NewMemory returns an empty Memory store
+func NewMemory() *Memory {
+	return &Memory{blobs: make(map[string]*memoryBlob)}
+}
+
+// Put reads the whole content and stores it under its hash
+func (s *Memory) Put(r io.Reader) (string, int64, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return "", 0, err
+	}
+	sum := sha256.Sum256(content)
+	key := hex.EncodeToString(sum[:])
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if blob, ok := s.blobs[key]; ok {
+		blob.stored = time.Now()
+	} else {
+		s.blobs[key] = &memoryBlob{content: content, stored: time.Now()}
+	}
+	return key, int64(len(content)), nil
+}
+
+// Open returns a reader over the content of the blob. Blobs are never modified, so the reader does not need a copy.
+func (s *Memory) Open(key string) (io.ReadSeekCloser, error) {
+	if !validKey(key) {
+		return nil, ErrInvalidKey
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	blob, ok := s.blobs[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return nopCloser{bytes.NewReader(blob.content)}, nil
+}
+
+// Delete removes the blob from memory
+func (s *Memory) Delete(key string) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.blobs, key)
+	return nil
+}
+
+// Walk calls fn on a snapshot of the blobs, so that fn can delete them
+func (s *Memory) Walk(fn func(key string, stored time.Time) error) error {
+	s.mu.RLock()
+	stored := make(map[string]time.Time, len(s.blobs))
+	for key, blob := range s.blobs {
+		stored[key] = blob.stored
+	}
+	s.mu.RUnlock()
+
+	for key, t := range stored {
+		if err := fn(key, t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nopCloser adds a Close method that does nothing to a bytes.Reader
+type nopCloser struct {
+	*bytes.Reader
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,146 @@
+package database
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/rerikdev/WASAText/service/structures"
+)
+
+// Size of the dataset used by the benchmarks: the user "me" has benchChats one-to-one conversations and benchGroups
+// groups of benchGroupSize members, each with benchMessages messages, and a group with a long history of benchHistory
+// messages. Every other message has a reaction.
+const (
+	benchChats     = 200
+	benchGroups    = 50
+	benchGroupSize = 6
+	benchMessages  = 10
+	benchHistory   = 300
+)
+
+// benchDataset is a seeded database with the IDs used by the benchmarks
+type benchDataset struct {
+	db      AppDatabase
+	me      *structures.User
+	history int
+}
+
+// seedBenchmark fills db with the benchmark dataset.
+func seedBenchmark(b *testing.B, db AppDatabase) *benchDataset {
+	b.Helper()
+	setTime(b, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	me := register(b, db, "me")
+
+	fill := func(conversationId int, senders []*structures.User, count int) {
+		for i := 0; i < count; i++ {
+			advance(time.Second)
+			msg := send(b, db, conversationId, senders[i%len(senders)].ID, "message "+strconv.Itoa(i), nil)
+			if i%2 == 0 {
+				if err := db.AddReaction(msg.ID, me.ID, "👍"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	}
+
+	others := make([]*structures.User, benchChats)
+	for i := range others {
+		others[i] = register(b, db, "user"+strconv.Itoa(i))
+		id, err := db.CreateConversation(me.ID, others[i].ID)
+		if err != nil {
+			b.Fatal(err)
+		}
+		fill(int(id), []*structures.User{me, others[i]}, benchMessages)
+	}
+	for g := 0; g < benchGroups; g++ {
+		members := []*structures.User{me}
+		for i := 1; i < benchGroupSize; i++ {
+			members = append(members, others[(g*benchGroupSize+i)%len(others)])
+		}
+		fill(newGroup(b, db, "group"+strconv.Itoa(g), members...), members, benchMessages)
+	}
+
+	history := []*structures.User{me, others[0], others[1]}
+	ds := &benchDataset{db: db, me: me, history: newGroup(b, db, "history", history...)}
+	fill(ds.history, history, benchHistory)
+	return ds
+}
+
+// runBenchmarks runs the listing and sending benchmarks on a database returned by open, seeded once per benchmark.
+func runBenchmarks(b *testing.B, open func(b *testing.B) AppDatabase) {
+	b.Run("GetUserConversations", func(b *testing.B) {
+		ds := seedBenchmark(b, open(b))
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := ds.db.GetUserConversations(ds.me.ID); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("ListGroups", func(b *testing.B) {
+		ds := seedBenchmark(b, open(b))
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := ds.db.ListGroups(ds.me.ID); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("GetInbox", func(b *testing.B) {
+		ds := seedBenchmark(b, open(b))
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := ds.db.GetInbox(ds.me.ID); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("GetMessages", func(b *testing.B) {
+		ds := seedBenchmark(b, open(b))
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := ds.db.GetMessages(ds.history); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("GetMessagesPage", func(b *testing.B) {
+		ds := seedBenchmark(b, open(b))
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := ds.db.GetMessagesPage(ds.history, ds.me.ID, MessagePageQuery{Limit: 50}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	// Sending to the long history must not cost more than sending to a new conversation
+	b.Run("SendMessage", func(b *testing.B) {
+		ds := seedBenchmark(b, open(b))
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := ds.db.SendMessage(ds.history, ds.me.ID, "hello", "text", nil, false, nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkSQLite(b *testing.B) {
+	runBenchmarks(b, func(b *testing.B) AppDatabase {
+		return newTestDatabase(b, openSQLite(b), DriverSQLite)
+	})
+}
+
+func BenchmarkPostgres(b *testing.B) {
+	postgresDSN(b)
+	runBenchmarks(b, func(b *testing.B) AppDatabase {
+		return newTestDatabase(b, openPostgres(b), DriverPostgres)
+	})
+}
+
+func BenchmarkMemory(b *testing.B) {
+	runBenchmarks(b, func(b *testing.B) AppDatabase {
+		return NewMemory()
+	})
+}
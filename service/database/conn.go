@@ -0,0 +1,140 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Driver supportati, con il nome con cui sono registrati in database/sql. DriverMemory non usa database/sql: indica il
+// database in memoria restituito da NewMemory.
+const (
+	DriverSQLite   = "sqlite3"
+	DriverPostgres = "postgres"
+	DriverMemory   = "memory"
+)
+
+// Le query del package sono scritte una volta sola, con i parametri ? e in SQL comune ai due database. dbConn e dbTx
+// le adattano al driver prima di eseguirle: PostgreSQL vuole i parametri numerati ($1, $2, ...). Le poche differenze
+// rimanenti sono gestite dove servono confrontando il driver.
+
+// dbConn è la connessione al database usata da appdbimpl
+type dbConn struct {
+	*sql.DB
+	driver string
+}
+
+// dbTx è una transazione aperta con dbConn.Begin
+type dbTx struct {
+	*sql.Tx
+	driver string
+}
+
+// newConn restituisce la connessione per il driver indicato, o un errore se il driver non è supportato
+func newConn(db *sql.DB, driver string) (*dbConn, error) {
+	if driver != DriverSQLite && driver != DriverPostgres {
+		return nil, fmt.Errorf("unsupported database driver: %q", driver)
+	}
+	return &dbConn{DB: db, driver: driver}, nil
+}
+
+func (c *dbConn) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return c.DB.Exec(rebind(c.driver, query), args...)
+}
+
+func (c *dbConn) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return c.DB.Query(rebind(c.driver, query), args...)
+}
+
+func (c *dbConn) QueryRow(query string, args ...interface{}) *sql.Row {
+	return c.DB.QueryRow(rebind(c.driver, query), args...)
+}
+
+func (c *dbConn) Begin() (*dbTx, error) {
+	tx, err := c.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &dbTx{Tx: tx, driver: c.driver}, nil
+}
+
+func (tx *dbTx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return tx.Tx.Exec(rebind(tx.driver, query), args...)
+}
+
+func (tx *dbTx) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return tx.Tx.Query(rebind(tx.driver, query), args...)
+}
+
+func (tx *dbTx) QueryRow(query string, args ...interface{}) *sql.Row {
+	return tx.Tx.QueryRow(rebind(tx.driver, query), args...)
+}
+
+// rebind riscrive i parametri ? nel formato del driver, ignorando quelli dentro stringhe, identificatori tra
+// virgolette e commenti
+func rebind(driver, query string) string {
+	if driver != DriverPostgres || !strings.Contains(query, "?") {
+		return query
+	}
+	var b strings.Builder
+	b.Grow(len(query) + 16)
+	n := 0
+	for i := 0; i < len(query); i++ {
+		switch ch := query[i]; {
+		case ch == '\'' || ch == '"':
+			end := strings.IndexByte(query[i+1:], ch)
+			if end < 0 {
+				b.WriteString(query[i:])
+				return b.String()
+			}
+			b.WriteString(query[i : i+end+2])
+			i += end + 1
+		case ch == '-' && strings.HasPrefix(query[i:], "--"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				b.WriteString(query[i:])
+				return b.String()
+			}
+			b.WriteString(query[i : i+end+1])
+			i += end
+		case ch == '?':
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+		default:
+			b.WriteByte(ch)
+		}
+	}
+	return b.String()
+}
+
+// likeOperator restituisce l'operatore per cercare testo senza distinguere maiuscole e minuscole: il LIKE di SQLite
+// lo fa già, quello di PostgreSQL no
+func (db *appdbimpl) likeOperator() string {
+	if db.c.driver == DriverPostgres {
+		return "ILIKE"
+	}
+	return "LIKE"
+}
+
+// maxInArgs è il numero massimo di valori in una lista IN (...), ben sotto il limite di parametri per query di SQLite
+const maxInArgs = 500
+
+// placeholders restituisce n parametri separati da virgole, per una lista IN (...)
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// chunkArgs divide i valori di una lista IN (...) in gruppi di al massimo maxInArgs
+func chunkArgs(args []interface{}) [][]interface{} {
+	var chunks [][]interface{}
+	for len(args) > maxInArgs {
+		chunks = append(chunks, args[:maxInArgs])
+		args = args[maxInArgs:]
+	}
+	if len(args) > 0 {
+		chunks = append(chunks, args)
+	}
+	return chunks
+}
@@ -4,11 +4,53 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
-	"sort"
 
 	"github.com/rerikdev/WASAText/service/structures"
 )
 
+// ErrConversationNotFound viene restituito quando la conversazione richiesta non esiste
+var ErrConversationNotFound = errors.New("conversazione non trovata")
+
+// GetMembership restituisce se la conversazione è un gruppo e se l'utente ne è membro
+func (db *appdbimpl) GetMembership(conversationId, userId int) (bool, bool, error) {
+	var isGroup, isMember bool
+	err := db.c.QueryRow(`
+        SELECT c.is_group,
+               EXISTS(SELECT 1 FROM conversation_members cm WHERE cm.conversation_id = c.id AND cm.user_id = ?)
+        FROM conversations c
+        WHERE c.id = ?
+    `, userId, conversationId).Scan(&isGroup, &isMember)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, false, ErrConversationNotFound
+	}
+	if err != nil {
+		return false, false, err
+	}
+	return isGroup, isMember, nil
+}
+
+// GetConversationMemberIds restituisce gli ID dei membri di una conversazione
+func (db *appdbimpl) GetConversationMemberIds(conversationId int) ([]int, error) {
+	rows, err := db.c.Query(`SELECT user_id FROM conversation_members WHERE conversation_id = ?`, conversationId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
 // Crea una nuova conversazione 1:1 tra due utenti e restituisce l'id della conversazione
 func (db *appdbimpl) CreateConversation(user1, user2 int) (int64, error) {
 	if user1 == user2 {
@@ -22,7 +64,7 @@ func (db *appdbimpl) CreateConversation(user1, user2 int) (int64, error) {
         FROM conversations c
         JOIN conversation_members cm1 ON c.id = cm1.conversation_id AND cm1.user_id = ?
         JOIN conversation_members cm2 ON c.id = cm2.conversation_id AND cm2.user_id = ?
-        WHERE c.is_group = 0
+        WHERE c.is_group = FALSE
     `, user1, user2).Scan(&existingID)
 
 	if err == nil && existingID != 0 {
@@ -34,17 +76,11 @@ func (db *appdbimpl) CreateConversation(user1, user2 int) (int64, error) {
 	}
 
 	// Crea la conversazione
-	res, err := db.c.Exec(
-		`INSERT INTO conversations (is_group) VALUES (0)`,
-	)
-	if err != nil {
-		return 0, err
-	}
-	convID64, err := res.LastInsertId()
+	var convID int
+	err = db.c.QueryRow(`INSERT INTO conversations (is_group) VALUES (FALSE) RETURNING id`).Scan(&convID)
 	if err != nil {
 		return 0, err
 	}
-	convID := int(convID64)
 
 	// Inserisci i due membri
 	_, err = db.c.Exec(`INSERT INTO conversation_members (conversation_id, user_id) VALUES (?, ?), (?, ?)`, convID, user1, convID, user2)
@@ -52,18 +88,26 @@ func (db *appdbimpl) CreateConversation(user1, user2 int) (int64, error) {
 		return 0, err
 	}
 
-	return convID64, nil
+	return int64(convID), nil
 }
 
-// Restituisce tutte le conversazioni 1:1 dell'utente con anteprima ultimo messaggio
+// lastMessageIdQuery è la sottoquery correlata che trova l'ultimo messaggio della conversazione c, nell'ordine della
+// cronologia. Con l'indice idx_messages_conversation_timestamp costa una ricerca per conversazione: una funzione
+// finestra (ROW_NUMBER) dovrebbe invece ordinare tutti i messaggi delle conversazioni dell'utente.
+const lastMessageIdQuery = `SELECT id FROM messages WHERE conversation_id = c.id ORDER BY timestamp DESC, id DESC LIMIT 1`
+
+// Restituisce tutte le conversazioni 1:1 dell'utente con anteprima ultimo messaggio, dalla più recente. Una sola query
+// legge l'altro utente e l'ultimo messaggio di tutte le conversazioni.
 func (db *appdbimpl) GetUserConversations(userId int) ([]*structures.ConversationPreview, error) {
-	// Prendi tutte le conversazioni dove l'utente è coinvolto
 	rows, err := db.c.Query(`
-        SELECT c.id
-        FROM conversations c
-        JOIN conversation_members cm ON c.id = cm.conversation_id
-        WHERE cm.user_id = ? AND c.is_group = 0
-    `, userId)
+        SELECT c.id, o.user_id, u.username, u.profile_picture, COALESCE(lm.content, ''), lm.timestamp
+        FROM conversation_members cm
+        JOIN conversations c ON c.id = cm.conversation_id AND c.is_group = FALSE
+        JOIN conversation_members o ON o.conversation_id = c.id AND o.user_id != cm.user_id
+        JOIN users u ON u.id = o.user_id
+        LEFT JOIN messages lm ON lm.id = (`+lastMessageIdQuery+`)
+        WHERE cm.user_id = ?
+        ORDER BY lm.timestamp IS NULL, lm.timestamp DESC, c.id`, userId)
 	if err != nil {
 		return nil, err
 	}
@@ -71,55 +115,18 @@ func (db *appdbimpl) GetUserConversations(userId int) ([]*structures.Conversatio
 
 	var previews []*structures.ConversationPreview
 	for rows.Next() {
-		var id int
-		if err := rows.Scan(&id); err != nil {
+		var preview structures.ConversationPreview
+		var lastTime sql.NullString
+		if err := rows.Scan(
+			&preview.ID, &preview.OtherUserID, &preview.Username, &preview.ProfilePicture, &preview.LastMessage, &lastTime,
+		); err != nil {
 			return nil, err
 		}
-
-		// Trova l'altro utente
-		var otherId int
-		err = db.c.QueryRow(`
-            SELECT user_id FROM conversation_members
-            WHERE conversation_id = ? AND user_id != ?
-        `, id, userId).Scan(&otherId)
-		if err != nil {
-			return nil, err
-		}
-
-		// Prendi info dell'altro utente
-		var username, profilePicture string
-		err = db.c.QueryRow(`SELECT username, profile_picture FROM users WHERE id = ?`, otherId).Scan(&username, &profilePicture)
-		if err != nil {
-			return nil, err
-		}
-
-		// Prendi ultimo messaggio (se esiste)
-		var lastMsg, lastTime string
-		_ = db.c.QueryRow(`
-            SELECT content, timestamp FROM messages
-            WHERE conversation_id = ?
-            ORDER BY timestamp DESC LIMIT 1
-        `, id).Scan(&lastMsg, &lastTime)
-
-		previews = append(previews, &structures.ConversationPreview{
-			ID:              id,
-			OtherUserID:     otherId,
-			Username:        username,
-			ProfilePicture:  profilePicture,
-			LastMessage:     lastMsg,
-			LastMessageTime: lastTime,
-		})
+		preview.LastMessageTime = lastTime.String
+		previews = append(previews, &preview)
 	}
-
-	// Controlla errori del rows
 	if err = rows.Err(); err != nil {
 		return nil, err
 	}
-
-	// Ordina in ordine cronologico inverso (ultimo messaggio più recente)
-	sort.Slice(previews, func(i, j int) bool {
-		return previews[i].LastMessageTime > previews[j].LastMessageTime
-	})
-
 	return previews, nil
 }
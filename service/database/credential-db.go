@@ -0,0 +1,113 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/rerikdev/WASAText/service/globaltime"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// maxLoginFailures è il numero di password errate consecutive dopo il quale lo username viene bloccato
+const maxLoginFailures = 5
+
+// loginLockout è la durata del blocco dopo troppi tentativi falliti
+const loginLockout = 15 * time.Minute
+
+var (
+	// ErrInvalidCredentials viene restituito quando la password è mancante o errata
+	ErrInvalidCredentials = errors.New("credenziali non valide")
+
+	// ErrTooManyAttempts viene restituito quando lo username è temporaneamente bloccato per troppi tentativi falliti
+	ErrTooManyAttempts = errors.New("troppi tentativi di accesso, riprova più tardi")
+)
+
+// hashPassword calcola l'hash bcrypt della password
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// checkPassword verifica la password rispetto all'hash salvato
+func checkPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// setPassword salva l'hash della nuova password dell'utente
+func (db *appdbimpl) setPassword(userId int, password string) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	_, err = db.c.Exec(`UPDATE users SET password_hash = ? WHERE id = ?`, hash, userId)
+	return err
+}
+
+// ChangePassword imposta una nuova password per l'utente. Se l'account ha già una password,
+// currentPassword deve essere corretta.
+func (db *appdbimpl) ChangePassword(userId int, currentPassword, newPassword string) error {
+	var username string
+	var passwordHash sql.NullString
+	err := db.c.QueryRow(`SELECT username, password_hash FROM users WHERE id = ?`, userId).Scan(&username, &passwordHash)
+	if err != nil {
+		return err
+	}
+	if passwordHash.Valid {
+		if err := db.checkLoginThrottle(username); err != nil {
+			return err
+		}
+		if !checkPassword(passwordHash.String, currentPassword) {
+			if err := db.recordLoginFailure(username); err != nil {
+				return err
+			}
+			return ErrInvalidCredentials
+		}
+	}
+	if err := db.setPassword(userId, newPassword); err != nil {
+		return err
+	}
+	return db.resetLoginFailures(username)
+}
+
+// checkLoginThrottle restituisce ErrTooManyAttempts se lo username è bloccato
+func (db *appdbimpl) checkLoginThrottle(username string) error {
+	// Il confronto avviene nel database, dove le date hanno lo stesso formato del parametro
+	var locked int
+	err := db.c.QueryRow(
+		`SELECT COUNT(*) FROM login_attempts WHERE username = ? AND locked_until > ?`,
+		username, globaltime.Now().Format(timestampLayout),
+	).Scan(&locked)
+	if err != nil {
+		return err
+	}
+	if locked > 0 {
+		return ErrTooManyAttempts
+	}
+	return nil
+}
+
+// recordLoginFailure registra un tentativo fallito e blocca lo username al raggiungimento di maxLoginFailures
+func (db *appdbimpl) recordLoginFailure(username string) error {
+	_, err := db.c.Exec(`
+        INSERT INTO login_attempts (username, failures) VALUES (?, 1)
+        ON CONFLICT(username) DO UPDATE SET failures = login_attempts.failures + 1
+    `, username)
+	if err != nil {
+		return err
+	}
+	_, err = db.c.Exec(`
+        UPDATE login_attempts SET failures = 0, locked_until = ?
+        WHERE username = ? AND failures >= ?
+    `, globaltime.Now().Add(loginLockout).Format(timestampLayout), username, maxLoginFailures)
+	return err
+}
+
+// resetLoginFailures azzera i tentativi falliti dopo un accesso riuscito
+func (db *appdbimpl) resetLoginFailures(username string) error {
+	_, err := db.c.Exec(`DELETE FROM login_attempts WHERE username = ?`, username)
+	return err
+}
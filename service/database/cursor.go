@@ -0,0 +1,54 @@
+package database
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrInvalidCursor viene restituito quando un cursore di paginazione non è valido
+var ErrInvalidCursor = errors.New("cursore non valido")
+
+// MessageCursor identifica la posizione di un messaggio nella cronologia, ordinata per (timestamp, id). L'ID rende
+// l'ordine stabile anche tra messaggi inviati nello stesso secondo.
+type MessageCursor struct {
+	Timestamp time.Time
+	ID        int
+}
+
+// String codifica il cursore nel formato opaco usato dalle API
+func (c MessageCursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%d", c.Timestamp.Unix(), c.ID)))
+}
+
+// ParseMessageCursor decodifica un cursore restituito da MessageCursor.String
+func ParseMessageCursor(s string) (MessageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return MessageCursor{}, ErrInvalidCursor
+	}
+	var unix int64
+	var id int
+	if n, err := fmt.Sscanf(string(raw), "%d:%d", &unix, &id); err != nil || n != 2 || id <= 0 {
+		return MessageCursor{}, ErrInvalidCursor
+	}
+	return MessageCursor{Timestamp: time.Unix(unix, 0).UTC(), ID: id}, nil
+}
+
+// messageCursorOf restituisce il cursore che punta al messaggio
+func messageCursorOf(timestamp string, id int) MessageCursor {
+	// I timestamp letti dal database sono in formato RFC 3339
+	t, _ := time.Parse(time.RFC3339Nano, timestamp)
+	return MessageCursor{Timestamp: t, ID: id}
+}
+
+// MessagePageQuery seleziona una pagina della cronologia: i Limit messaggi immediatamente precedenti a Before, quelli
+// immediatamente successivi ad After oppure, se nessuno dei due è impostato, i più recenti.
+type MessagePageQuery struct {
+	Before *MessageCursor
+	After  *MessageCursor
+	Limit  int
+	// CollapseThreads esclude dalla cronologia le risposte dei thread, che si leggono con GetThread
+	CollapseThreads bool
+}
@@ -3,7 +3,10 @@ Package database is the middleware between the app database and the code. All da
 persistent database are handled here. Database specific logic should never escape this package.
 
 To use this package you need to apply migrations to the database if needed/wanted, connect to it (using the database
-data source name from config), and then initialize an instance of AppDatabase from the DB connection.
+data source name from config), and then initialize an instance of AppDatabase from the DB connection. Both SQLite
+(DriverSQLite) and PostgreSQL (DriverPostgres) are supported: the driver name passed to New must be the one used to
+open the connection. NewMemory returns an AppDatabase that keeps everything in memory (DriverMemory), for tests and
+demos.
 
 For example, this code adds a parameter in `webapi` executable for the database data source name (add it to the
 main.WebAPIConfiguration structure):
@@ -27,134 +30,163 @@ This is an example on how to migrate the DB and connect to it:
 	}()
 
 Then you can initialize the AppDatabase and pass it to the api package.
+
+The schema is versioned: migrations are SQL files in `migrations/<driver>/`, embedded in the executable, and New
+applies the pending ones (see migrate.go). CheckSchema and Migrate can be used to inspect and migrate the database without
+starting the application.
 */
 package database
 
 import (
 	"database/sql"
 	"errors"
-	"fmt"
+	"time"
 
 	"github.com/rerikdev/WASAText/service/structures"
 )
 
+// timestampLayout è il formato con cui le date vengono salvate nelle colonne DATETIME (TIMESTAMP su PostgreSQL)
+const timestampLayout = "2006-01-02 15:04:05"
+
 // AppDatabase is the high level interface for the DB
 type AppDatabase interface {
 	Ping() error
 	CheckUserExistence(username string) (bool, error)
-	DoLogin(username, displayName, profilePicture string) (*structures.User, string, error)
+	DoLogin(username, displayName, profilePicture, password string) (*structures.User, string, error)
+	ChangePassword(userId int, currentPassword, newPassword string) error
 	GetUserById(userId string) (*structures.User, error)
 	SetMyPhotoById(userId, photoUrl string) error
 	SetMyUserNameById(userId, newUsername string) error
 	SearchUsers(query string) ([]*structures.User, error)
 	// Conversazioni 1:1
 	CreateConversation(user1, user2 int) (int64, error)
+	GetMembership(conversationId, userId int) (isGroup bool, isMember bool, err error)
+	GetConversationMemberIds(conversationId int) ([]int, error)
 	// Messaggi
-	SendMessage(conversationId, senderId int, content, mediaType string, isForwarded bool, replyToMessageId *int) ([]*structures.Message, error)
+	SendMessage(conversationId, senderId int, content, mediaType string, mediaId *string, isForwarded bool, replyToMessageId *int) (*structures.Message, error)
 	GetMessages(conversationId int) ([]*structures.Message, error)
+	GetMessagesPage(conversationId, userId int, query MessagePageQuery) (*structures.MessagePage, error)
+	// Thread: il messaggio iniziale con una pagina delle risposte (vedi thread-db.go)
+	GetThread(conversationId, messageId, userId int, query MessagePageQuery) (*structures.ThreadPage, error)
+	// SetThreadRead restituisce anche l'ID del messaggio iniziale del thread
+	SetThreadRead(conversationId, messageId, userId int) (rootId int, updated int64, err error)
+	// Messaggi fissati in cima alla conversazione (vedi pin-db.go)
+	PinMessage(conversationId, messageId, userId int) ([]*structures.Message, error)
+	UnpinMessage(conversationId, messageId, userId int) ([]*structures.Message, error)
+	GetPinnedMessages(conversationId, userId int) ([]*structures.PinnedMessage, error)
 	// Restituisce tutte le conversazioni di un utente con anteprima ultimo messaggio
 	GetUserConversations(userId int) ([]*structures.ConversationPreview, error)
-	SetMessagesReceived(conversationId int, userId int) error
-	SetMessagesRead(conversationId int, userId int) error
+	// Inbox: chat 1:1 e gruppi insieme, con non letti e preferenze dell'utente
+	GetInbox(userId int) ([]*structures.InboxEntry, error)
+	SetConversationMuted(conversationId, userId int, muted bool) error
+	SetConversationPinned(conversationId, userId int, pinned bool) error
+	// Restituiscono il numero di messaggi aggiornati
+	SetMessagesReceived(conversationId int, userId int) (int64, error)
+	SetMessagesRead(conversationId int, userId int, collapseThreads bool) (int64, error)
+	GetMessageReceipts(conversationId, messageId int) ([]*structures.MessageReceipt, error)
+	SearchMessages(userId int, query MessageSearchQuery) (*structures.MessageSearchPage, error)
 	DeleteMessage(conversationId int, messageId int, userId int) error
+	HideMessage(conversationId, messageId, userId int) error
+	PurgeDeletedMessages(t time.Time) (int64, error)
 	GetMessageById(conversationId, messageId int) (*structures.Message, error)
+	MessageExists(conversationId, messageId int) (bool, error)
+	EditMessage(conversationId, messageId, userId int, content string, window time.Duration) (*structures.Message, error)
+	GetMessageEdits(conversationId, messageId int) ([]*structures.MessageEdit, error)
+	// Media
+	CreateMedia(media *structures.Media) error
+	GetMedia(id string) (*structures.Media, error)
+	CanAccessMedia(id string, userId int) (bool, error)
+	UnusedBlobKeys(keys []string) ([]string, error)
+	// Migrazione delle immagini salvate inline come data URL (vedi cmd/migrate-media)
+	ListInlineImages(source string, afterId, limit int) ([]InlineImage, error)
+	ReplaceInlineImages(images []InlineImage) ([]InlineImage, error)
+	Vacuum() error
 	// Reazioni
 	AddReaction(messageId int, userId int, emoji string) error
 	RemoveReaction(messageId int, userId int) error
 	GetReactions(messageId int) ([]*structures.Reaction, error)
-	// Gruppi (usano la logica unificata delle conversazioni)
-	AddToGroup(name string, photo string, usernames []string) (*structures.Conversation, error) // operationId: addToGroup
-	ListGroups(userID int) ([]*structures.GroupPreview, error)                                  // operationId: listGroups
-	LeaveGroup(groupID int, userID int) error
-	SetGroupName(groupID int, newName string) error
-	SetGroupPhoto(groupID int, photoUrl string) error
-	AddMembersToGroup(groupID int, usernames []string) error
+	// Gruppi (usano la logica unificata delle conversazioni). Le modifiche registrate nella cronologia, come quelle ai
+	// messaggi fissati, restituiscono i messaggi di sistema che hanno inserito (vedi system-message-db.go).
+	AddToGroup(creatorID int, name string, photo string, usernames []string, options GroupOptions) (*structures.Conversation, error) // operationId: addToGroup
+	ListGroups(userID int) ([]*structures.GroupPreview, error)                                                                       // operationId: listGroups
+	LeaveGroup(groupID int, userID int) ([]*structures.Message, error)
+	DeleteGroup(groupID, actorID int) error
+	SetGroupName(groupID int, userID int, newName string) ([]*structures.Message, error)
+	SetGroupPhoto(groupID int, userID int, photoUrl string) ([]*structures.Message, error)
+	AddMembersToGroup(groupID int, userID int, usernames []string) ([]*structures.Message, error)
+	// Descrizione, limite di membri e informazioni complete dei gruppi (vedi group-info-db.go)
+	SetGroupDescription(groupID int, userID int, description string) ([]*structures.Message, error)
+	GetGroupInfo(groupID int) (*structures.GroupInfo, error)
+	// Ruoli e impostazioni dei gruppi (vedi group-role-db.go)
+	SetGroupMemberRole(groupID, actorID, userID int, role string) error
+	RemoveGroupMember(groupID, actorID, userID int) ([]*structures.Message, error)
+	SetGroupSettings(groupID, actorID int, update GroupSettingsUpdate) (*structures.GroupSettings, error)
+	// Link di invito e richieste di ingresso (vedi group-invite-db.go)
+	CreateGroupInvite(groupID, actorID int, options GroupInviteOptions) (*structures.GroupInvite, error)
+	ListGroupInvites(groupID, actorID int) ([]*structures.GroupInvite, error)
+	RevokeGroupInvite(groupID, actorID int, token string) error
+	PreviewGroupInvite(token string, userID int) (*structures.GroupInvitePreview, error)
+	JoinGroupByInvite(token string, userID int) (groupID int, pending bool, messages []*structures.Message, err error)
+	ListGroupJoinRequests(groupID, actorID int) ([]*structures.GroupJoinRequest, error)
+	ApproveGroupJoinRequest(groupID, actorID, userID int) ([]*structures.Message, error)
+	RejectGroupJoinRequest(groupID, actorID, userID int) error
+	// Utenti banditi dai gruppi (vedi group-ban-db.go)
+	BanFromGroup(groupID, actorID, userID int) ([]*structures.Message, error)
+	UnbanFromGroup(groupID, actorID, userID int) error
+	ListGroupBans(groupID, actorID int) ([]*structures.GroupBan, error)
+	// Sessioni
+	CreateSession(userId int, token string, expiresAt time.Time) error
+	GetSessionUserId(token string) (int, error)
+	RevokeSession(token string) error
+	RevokeUserSessions(userId int) error
+	RevokeOtherSessions(userId int, token string) error
+	// Log degli eventi in tempo reale
+	AddEvent(ev *structures.Event, recipients []int) error
+	GetEventsSince(userId int, lastEventId int64, limit int) ([]*structures.Event, bool, error)
+	DeleteEventsBefore(t time.Time) error
 }
 
 type appdbimpl struct {
-	c *sql.DB
+	c *dbConn
+
+	// fts indica se l'indice di ricerca FTS5 è disponibile (vedi search-db.go)
+	fts bool
 }
 
-// New returns a new instance of AppDatabase based on the SQLite connection `db`.
-// It also creates all tables if the database is empty.
-func New(db *sql.DB) (AppDatabase, error) {
+// New returns a new instance of AppDatabase based on the connection `db`, opened with `driver` (DriverSQLite or
+// DriverPostgres). It also applies the pending schema migrations, and fails with ErrSchemaTooNew if the database was created by a newer
+// version of the application.
+func New(db *sql.DB, driver string) (AppDatabase, error) {
 	if db == nil {
 		return nil, errors.New("database is required when building a AppDatabase")
 	}
+	conn, err := newConn(db, driver)
+	if err != nil {
+		return nil, err
+	}
 
 	// SOLO PER SVILUPPO: elimina tutte le tabelle esistenti
 	// if err := dropAllTables(db); err != nil {
 	// return nil, fmt.Errorf("error dropping tables: %w", err)
 	// }
 
-	// Check if the main table exists. If not, the database is empty, and we need to create the structure
-	var tableName string
-	err := db.QueryRow(`SELECT name FROM sqlite_master WHERE type='table' AND name='users';`).Scan(&tableName)
-	if errors.Is(err, sql.ErrNoRows) {
-		tables := []string{
-			`CREATE TABLE IF NOT EXISTS users (
-                id INTEGER PRIMARY KEY AUTOINCREMENT,
-                username TEXT NOT NULL UNIQUE,
-                display_name TEXT NOT NULL,
-                profile_picture TEXT
-            );`,
-			`CREATE TABLE IF NOT EXISTS conversations (
-                id INTEGER PRIMARY KEY AUTOINCREMENT,
-                name TEXT,                -- NULL per chat 1:1, valorizzato per gruppi
-                photo TEXT,               -- NULL per chat 1:1, valorizzato per gruppi
-                is_group BOOLEAN NOT NULL DEFAULT 0
-            );`,
-			`CREATE TABLE IF NOT EXISTS conversation_members (
-                conversation_id INTEGER NOT NULL,
-                user_id INTEGER NOT NULL,
-                PRIMARY KEY (conversation_id, user_id),
-                FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE,
-                FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
-            );`,
-			`CREATE TABLE IF NOT EXISTS messages (
-                id INTEGER PRIMARY KEY AUTOINCREMENT,
-                conversation_id INTEGER NOT NULL,
-                sender_id INTEGER NOT NULL,
-                content TEXT NOT NULL,
-                is_forwarded BOOLEAN NOT NULL DEFAULT 0,
-                media_type TEXT NOT NULL,
-                status TEXT NOT NULL CHECK (status IN ('sent', 'received', 'read')),
-                timestamp DATETIME NOT NULL,
-                reply_to_message_id INTEGER DEFAULT NULL,
-                FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE,
-                FOREIGN KEY (sender_id) REFERENCES users(id) ON DELETE CASCADE,
-                FOREIGN KEY (reply_to_message_id) REFERENCES messages(id) ON DELETE SET NULL
-            );`,
-			`CREATE TABLE IF NOT EXISTS reactions (
-                message_id INTEGER NOT NULL,
-                user_id INTEGER NOT NULL,
-                emoji TEXT NOT NULL,
-                PRIMARY KEY (message_id, user_id),
-                FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE,
-                FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
-            );`,
-		}
-		for _, stmt := range tables {
-			if _, err := db.Exec(stmt); err != nil {
-				return nil, fmt.Errorf("error creating database structure: %w", err)
-			}
-		}
-	} else if err != nil && !errors.Is(err, sql.ErrNoRows) {
-		return nil, fmt.Errorf("error checking database structure: %w", err)
+	// Porta lo schema all'ultima versione (vedi migrate.go)
+	if _, err := Migrate(db, driver); err != nil {
+		return nil, err
 	}
 
-	// Migration: Add reply_to_message_id column if it doesn't exist (for existing databases)
-	var columnExists int
-	err = db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('messages') WHERE name='reply_to_message_id';`).Scan(&columnExists)
-	if err == nil && columnExists == 0 {
-		_, err = db.Exec(`ALTER TABLE messages ADD COLUMN reply_to_message_id INTEGER DEFAULT NULL;`)
+	// Su PostgreSQL l'indice di ricerca viene creato dalle migrazioni (vedi search-db.go)
+	var fts bool
+	if driver == DriverSQLite {
+		fts, err = createSearchIndex(db)
 		if err != nil {
-			return nil, fmt.Errorf("error adding reply_to_message_id column: %w", err)
+			return nil, err
 		}
 	}
 
 	appdb := &appdbimpl{
-		c: db,
+		c:   conn,
+		fts: fts,
 	}
 
 	return appdb, nil
@@ -0,0 +1,97 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/rerikdev/WASAText/service/structures"
+)
+
+// AddEvent salva un evento nel log per i destinatari indicati e ne imposta l'ID
+func (db *appdbimpl) AddEvent(ev *structures.Event, recipients []int) error {
+	payload, err := json.Marshal(ev.Data)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.c.Begin()
+	if err != nil {
+		return err
+	}
+	// Dopo il commit il rollback non fa nulla
+	defer func() { _ = tx.Rollback() }()
+
+	var id int64
+	err = tx.QueryRow(
+		`INSERT INTO events (type, conversation_id, payload, created_at) VALUES (?, ?, ?, ?) RETURNING id`,
+		ev.Type, ev.ConversationID, string(payload), ev.Timestamp.Format(timestampLayout),
+	).Scan(&id)
+	if err != nil {
+		return err
+	}
+	for _, userId := range recipients {
+		if _, err := tx.Exec(`INSERT INTO event_recipients (event_id, user_id) VALUES (?, ?) ON CONFLICT DO NOTHING`, id, userId); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	ev.ID = id
+	return nil
+}
+
+// GetEventsSince restituisce al massimo limit eventi destinati all'utente con ID maggiore di lastEventId, dal più
+// vecchio. Il secondo valore è false se alcuni di questi eventi sono già stati eliminati dal log.
+func (db *appdbimpl) GetEventsSince(userId int, lastEventId int64, limit int) ([]*structures.Event, bool, error) {
+	// Gli eventi vengono eliminati dal più vecchio: se il primo evento successivo a lastEventId non è più nel log,
+	// qualcosa è andato perso. Eliminare gli eventi di un gruppo (vedi deleteGroup) può solo far segnalare una perdita
+	// che non c'è, e il client ricarica lo stato.
+	var oldest sql.NullInt64
+	if err := db.c.QueryRow(`SELECT MIN(id) FROM events`).Scan(&oldest); err != nil {
+		return nil, false, err
+	}
+	var lastId int64
+	if err := db.c.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM events`).Scan(&lastId); err != nil {
+		return nil, false, err
+	}
+	complete := lastEventId >= lastId || (oldest.Valid && lastEventId+1 >= oldest.Int64)
+
+	rows, err := db.c.Query(`
+        SELECT e.id, e.type, COALESCE(e.conversation_id, 0), e.payload, e.created_at
+        FROM events e
+        JOIN event_recipients er ON er.event_id = e.id
+        WHERE er.user_id = ? AND e.id > ?
+        ORDER BY e.id ASC
+        LIMIT ?`, userId, lastEventId, limit)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	var events []*structures.Event
+	for rows.Next() {
+		var ev structures.Event
+		var payload string
+		if err := rows.Scan(&ev.ID, &ev.Type, &ev.ConversationID, &payload, &ev.Timestamp); err != nil {
+			return nil, false, err
+		}
+		ev.Data = json.RawMessage(payload)
+		events = append(events, &ev)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+	return events, complete, nil
+}
+
+// DeleteEventsBefore elimina dal log gli eventi più vecchi di t
+func (db *appdbimpl) DeleteEventsBefore(t time.Time) error {
+	cutoff := t.Format(timestampLayout)
+	if _, err := db.c.Exec(`DELETE FROM event_recipients WHERE event_id IN (SELECT id FROM events WHERE created_at < ?)`, cutoff); err != nil {
+		return err
+	}
+	_, err := db.c.Exec(`DELETE FROM events WHERE created_at < ?`, cutoff)
+	return err
+}
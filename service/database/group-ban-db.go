@@ -0,0 +1,131 @@
+package database
+
+import (
+	"errors"
+
+	"github.com/rerikdev/WASAText/service/globaltime"
+	"github.com/rerikdev/WASAText/service/structures"
+)
+
+// Il proprietario e gli amministratori possono bandire un utente da un gruppo, con le stesse regole della rimozione:
+// l'utente viene rimosso se è membro e non può essere aggiunto di nuovo né entrare con un link di invito finché il ban
+// non viene revocato. Si possono bandire anche utenti che non sono membri.
+
+// ErrUserBanned viene restituito quando un utente bandito dal gruppo prova a entrarci
+var ErrUserBanned = errors.New("sei stato bandito dal gruppo")
+
+// ErrUserNotFound viene restituito quando l'utente da bandire non esiste
+var ErrUserNotFound = errors.New("utente non trovato")
+
+// ErrBanNotFound viene restituito quando l'utente non è bandito dal gruppo
+var ErrBanNotFound = errors.New("l'utente non è bandito dal gruppo")
+
+// BanFromGroup bandisce userID dal gruppo, rimuovendolo se è membro e annullando la sua richiesta di ingresso, se
+// actorID ha il permesso di farlo
+func (db *appdbimpl) BanFromGroup(groupID, actorID, userID int) ([]*structures.Message, error) {
+	if actorID == userID {
+		return nil, invalidGroupRequest("non puoi bandire te stesso dal gruppo")
+	}
+	tx, err := db.c.Begin()
+	if err != nil {
+		return nil, err
+	}
+	// Dopo il commit il rollback non fa nulla
+	defer func() { _ = tx.Rollback() }()
+
+	access, err := getGroupAccess(tx, groupID, actorID)
+	if err != nil {
+		return nil, err
+	}
+	target, err := getMemberRole(tx, groupID, userID)
+	isMember := err == nil
+	if errors.Is(err, ErrMemberNotFound) {
+		var exists bool
+		if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE id = ?)`, userID).Scan(&exists); err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, ErrUserNotFound
+		}
+		target = RoleMember
+	} else if err != nil {
+		return nil, err
+	}
+	if !access.canRemove(target) {
+		return nil, ErrPermissionDenied
+	}
+
+	stmts := []string{
+		`DELETE FROM conversation_members WHERE conversation_id = ? AND user_id = ?`,
+		`DELETE FROM group_join_requests WHERE conversation_id = ? AND user_id = ?`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt, groupID, userID); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := tx.Exec(
+		`INSERT INTO group_bans (conversation_id, user_id, banned_by, banned_at) VALUES (?, ?, ?, ?) ON CONFLICT DO NOTHING`,
+		groupID, userID, actorID, globaltime.Now().Format(timestampLayout),
+	); err != nil {
+		return nil, err
+	}
+	var ids []int
+	if isMember {
+		id, err := insertSystemMessage(tx, groupID, actorID, MessageKindMemberRemoved, &userID, "")
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return db.getNewMessages(groupID, ids...)
+}
+
+// UnbanFromGroup revoca il ban di userID, se actorID è il proprietario o un amministratore. L'utente non torna nel
+// gruppo: può essere aggiunto di nuovo o entrare con un link di invito.
+func (db *appdbimpl) UnbanFromGroup(groupID, actorID, userID int) error {
+	if err := requireGroupAdmin(db.c, groupID, actorID); err != nil {
+		return err
+	}
+	res, err := db.c.Exec(`DELETE FROM group_bans WHERE conversation_id = ? AND user_id = ?`, groupID, userID)
+	if err != nil {
+		return err
+	}
+	if deleted, err := res.RowsAffected(); err != nil {
+		return err
+	} else if deleted == 0 {
+		return ErrBanNotFound
+	}
+	return nil
+}
+
+// ListGroupBans restituisce gli utenti banditi dal gruppo, dal ban più recente, se actorID è il proprietario o un
+// amministratore
+func (db *appdbimpl) ListGroupBans(groupID, actorID int) ([]*structures.GroupBan, error) {
+	if err := requireGroupAdmin(db.c, groupID, actorID); err != nil {
+		return nil, err
+	}
+	rows, err := db.c.Query(`
+        SELECT u.id, u.username, u.display_name, COALESCE(u.profile_picture, ''), b.banned_by, b.banned_at
+        FROM group_bans b
+        JOIN users u ON u.id = b.user_id
+        WHERE b.conversation_id = ?
+        ORDER BY b.banned_at DESC, u.id`, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bans := []*structures.GroupBan{}
+	for rows.Next() {
+		var b structures.GroupBan
+		if err := rows.Scan(&b.User.ID, &b.User.Username, &b.User.DisplayName, &b.User.ProfilePicture, &b.BannedBy, &b.BannedAt); err != nil {
+			return nil, err
+		}
+		bans = append(bans, &b)
+	}
+	return bans, rows.Err()
+}
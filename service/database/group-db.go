@@ -3,185 +3,385 @@ package database
 import (
 	"database/sql"
 	"errors"
-	"fmt"
 
+	"github.com/rerikdev/WASAText/service/globaltime"
 	"github.com/rerikdev/WASAText/service/structures"
 )
 
-// AddToGroup: crea un nuovo gruppo come conversazione con is_group = 1
-func (db *appdbimpl) AddToGroup(name string, photo string, usernames []string) (*structures.Conversation, error) {
+// AddToGroup: crea un nuovo gruppo come conversazione con is_group = TRUE. Il creatore ne diventa il proprietario, anche
+// se non è tra gli utenti indicati. Se options indica un numero massimo di membri e gli utenti sono di più restituisce
+// ErrGroupFull.
+func (db *appdbimpl) AddToGroup(creatorID int, name string, photo string, usernames []string, options GroupOptions) (*structures.Conversation, error) {
+	if options.MaxMembers < 0 {
+		return nil, invalidGroupRequest("numero massimo di membri non valido")
+	}
 	tx, err := db.c.Begin()
 	if err != nil {
 		return nil, err
 	}
-	defer func() {
-		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
-			// Log dell'errore se necessario
-		}
-	}()
+	// Dopo il commit il rollback non fa nulla
+	defer func() { _ = tx.Rollback() }()
 
 	// Crea la conversazione di gruppo
-	res, err := tx.Exec(`INSERT INTO conversations (name, photo, is_group) VALUES (?, ?, 1)`, name, photo)
+	var convID int
+	err = tx.QueryRow(`
+        INSERT INTO conversations (name, photo, is_group, description, max_members, created_at, created_by)
+        VALUES (?, ?, TRUE, ?, ?, ?, ?)
+        RETURNING id`,
+		name, photo, options.Description, options.MaxMembers, globaltime.Now().Format(timestampLayout), creatorID,
+	).Scan(&convID)
 	if err != nil {
 		return nil, err
 	}
-	convID64, err := res.LastInsertId()
+
+	var creator structures.User
+	err = tx.QueryRow(`SELECT id, username, display_name, profile_picture FROM users WHERE id = ?`, creatorID).
+		Scan(&creator.ID, &creator.Username, &creator.DisplayName, &creator.ProfilePicture)
+	if err != nil {
+		return nil, invalidGroupRequest("utente %d non trovato", creatorID)
+	}
+	_, err = tx.Exec(`INSERT INTO conversation_members (conversation_id, user_id, role) VALUES (?, ?, ?)`, convID, creatorID, RoleOwner)
 	if err != nil {
 		return nil, err
 	}
-	convID := int(convID64)
 
-	members := make([]structures.User, 0) // oppure se conosci la dimensione: make([]structures.User, 0, expectedSize)
+	members := []structures.User{creator}
+	seen := map[int]bool{creatorID: true}
 	for _, username := range usernames {
 		var user structures.User
-		err := db.c.QueryRow(`SELECT id, username, display_name, profile_picture FROM users WHERE username = ?`, username).
+		err := tx.QueryRow(`SELECT id, username, display_name, profile_picture FROM users WHERE username = ?`, username).
 			Scan(&user.ID, &user.Username, &user.DisplayName, &user.ProfilePicture)
 		if err != nil {
-			return nil, fmt.Errorf("utente %s non trovato", username)
+			return nil, invalidGroupRequest("utente %s non trovato", username)
+		}
+		if seen[user.ID] {
+			// Il creatore può essere tra gli utenti indicati, gli altri non possono ripetersi
+			if user.ID == creatorID {
+				continue
+			}
+			return nil, invalidGroupRequest("utente %s ripetuto", username)
 		}
+		seen[user.ID] = true
 		_, err = tx.Exec(`INSERT INTO conversation_members (conversation_id, user_id) VALUES (?, ?)`, convID, user.ID)
 		if err != nil {
 			return nil, err
 		}
 		members = append(members, user)
 	}
+	if err := checkGroupSize(tx, convID); err != nil {
+		return nil, err
+	}
 
 	if err := tx.Commit(); err != nil {
 		return nil, err
 	}
 
 	return &structures.Conversation{
-		ID:      convID,
-		Name:    name,
-		Photo:   photo,
-		IsGroup: true,
-		Members: members,
+		ID:          convID,
+		Name:        name,
+		Photo:       photo,
+		Description: options.Description,
+		IsGroup:     true,
+		Members:     members,
 	}, nil
 }
 
-// ListGroups: restituisce tutte le conversazioni di gruppo dell'utente
+// ListGroups: restituisce tutte le conversazioni di gruppo dell'utente. Gruppi, ultimi messaggi e membri vengono letti
+// con due query, indipendentemente dal numero di gruppi.
 func (db *appdbimpl) ListGroups(userID int) ([]*structures.GroupPreview, error) {
 	rows, err := db.c.Query(`
-        SELECT c.id, COALESCE(c.name,''), COALESCE(c.photo,'')
+        SELECT c.id, COALESCE(c.name,''), COALESCE(c.photo,''), c.description, c.only_admins_edit_info,
+               c.only_admins_add_members, c.only_admins_send, c.only_admins_pin, c.max_members, COALESCE(lm.content, ''),
+               lm.timestamp
         FROM conversations c
         JOIN conversation_members cm ON c.id = cm.conversation_id
-        WHERE cm.user_id = ? AND c.is_group = 1
-    `, userID)
+        LEFT JOIN messages lm ON lm.id = (`+lastMessageIdQuery+`)
+        WHERE cm.user_id = ? AND c.is_group = TRUE
+        ORDER BY c.id`, userID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
 	var groups []*structures.GroupPreview
+	byId := make(map[int]*structures.GroupPreview)
 	for rows.Next() {
-		var id int
-		var name, photo string
-		if err := rows.Scan(&id, &name, &photo); err != nil {
+		var group structures.GroupPreview
+		var lastTime sql.NullString
+		if err := rows.Scan(
+			&group.ID, &group.Name, &group.Photo, &group.Description, &group.Settings.OnlyAdminsEditInfo,
+			&group.Settings.OnlyAdminsAddMembers, &group.Settings.OnlyAdminsSend, &group.Settings.OnlyAdminsPin,
+			&group.Settings.MaxMembers, &group.LastMessage, &lastTime,
+		); err != nil {
 			return nil, err
 		}
+		group.LastMessageTime = lastTime.String
+		groups = append(groups, &group)
+		byId[group.ID] = &group
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
-		// Prendi ultimo messaggio
-		var lastMsg, lastTime string
-		_ = db.c.QueryRow(`SELECT content, timestamp FROM messages WHERE conversation_id = ? ORDER BY timestamp DESC LIMIT 1`, id).Scan(&lastMsg, &lastTime)
-
-		// Prendi membri del gruppo
-		members, err := db.getConversationMembers(id)
-		if err != nil {
+	// Membri di tutti i gruppi dell'utente
+	memberRows, err := db.c.Query(`
+        SELECT cm.conversation_id, u.id, u.username, u.display_name, COALESCE(u.profile_picture, ''), cm.role
+        FROM conversation_members mine
+        JOIN conversations c ON c.id = mine.conversation_id AND c.is_group = TRUE
+        JOIN conversation_members cm ON cm.conversation_id = c.id
+        JOIN users u ON u.id = cm.user_id
+        WHERE mine.user_id = ?
+        ORDER BY cm.conversation_id, u.id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer memberRows.Close()
+	for memberRows.Next() {
+		var groupID int
+		var member structures.GroupMember
+		if err := memberRows.Scan(
+			&groupID, &member.ID, &member.Username, &member.DisplayName, &member.ProfilePicture, &member.Role,
+		); err != nil {
 			return nil, err
 		}
-
-		groups = append(groups, &structures.GroupPreview{
-			ID:              id,
-			Name:            name,
-			Photo:           photo,
-			Members:         members,
-			LastMessage:     lastMsg,
-			LastMessageTime: lastTime,
-		})
+		if group, ok := byId[groupID]; ok {
+			group.Members = append(group.Members, member)
+		}
 	}
-	if err := rows.Err(); err != nil {
+	if err := memberRows.Err(); err != nil {
 		return nil, err
 	}
 	return groups, nil
 }
 
-// getConversationMembers: restituisce i membri di una conversazione
-func (db *appdbimpl) getConversationMembers(convID int) ([]structures.User, error) {
-	rows, err := db.c.Query(`
-        SELECT u.id, u.username, u.display_name, COALESCE(u.profile_picture, '')
-        FROM users u
-        JOIN conversation_members cm ON u.id = cm.user_id
-        WHERE cm.conversation_id = ?`, convID)
+// LeaveGroup: rimuove l'utente dalla conversazione di gruppo. Se esce il proprietario, la proprietà passa
+// all'amministratore con l'ID più basso o, se non ce ne sono, al membro con l'ID più basso; se esce l'ultimo membro il
+// gruppo viene eliminato come con DeleteGroup.
+func (db *appdbimpl) LeaveGroup(groupID int, userID int) ([]*structures.Message, error) {
+	tx, err := db.c.Begin()
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
+	// Dopo il commit il rollback non fa nulla
+	defer func() { _ = tx.Rollback() }()
 
-	var members []structures.User
-	for rows.Next() {
-		var user structures.User
-		if err := rows.Scan(&user.ID, &user.Username, &user.DisplayName, &user.ProfilePicture); err != nil {
+	var isGroup bool
+	err = tx.QueryRow(`SELECT is_group FROM conversations WHERE id = ?`, groupID).Scan(&isGroup)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, invalidGroupRequest("gruppo non trovato")
+	} else if err != nil {
+		return nil, err
+	}
+	if !isGroup {
+		return nil, invalidGroupRequest("non è un gruppo")
+	}
+	role, err := getMemberRole(tx, groupID, userID)
+	if errors.Is(err, ErrMemberNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`DELETE FROM conversation_members WHERE conversation_id = ? AND user_id = ?`, groupID, userID); err != nil {
+		return nil, err
+	}
+
+	var next int
+	err = tx.QueryRow(`
+        SELECT user_id FROM conversation_members
+        WHERE conversation_id = ?
+        ORDER BY CASE role WHEN 'owner' THEN 0 WHEN 'admin' THEN 1 ELSE 2 END, user_id
+        LIMIT 1`, groupID).Scan(&next)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Era l'ultimo membro
+		if err := deleteGroup(tx, groupID); err != nil {
 			return nil, err
 		}
-		members = append(members, user)
+		return nil, tx.Commit()
 	}
-	if err := rows.Err(); err != nil {
+	if err != nil {
 		return nil, err
 	}
-	return members, nil
+	if role == RoleOwner {
+		if _, err := tx.Exec(`UPDATE conversation_members SET role = ? WHERE conversation_id = ? AND user_id = ?`, RoleOwner, groupID, next); err != nil {
+			return nil, err
+		}
+	}
+	id, err := insertSystemMessage(tx, groupID, userID, MessageKindMemberLeft, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return db.getNewMessages(groupID, id)
 }
 
-// LeaveGroup: rimuove l'utente dalla conversazione di gruppo
-func (db *appdbimpl) LeaveGroup(groupID int, userID int) error {
-	var isGroup bool
-	err := db.c.QueryRow(`SELECT is_group FROM conversations WHERE id = ?`, groupID).Scan(&isGroup)
+// DeleteGroup elimina il gruppo con tutti i suoi messaggi e i suoi eventi, se actorID ne è il proprietario. Vengono
+// eliminati anche i media allegati e la foto del gruppo, se non sono usati altrove (ad esempio da un messaggio
+// inoltrato in un'altra conversazione).
+func (db *appdbimpl) DeleteGroup(groupID, actorID int) error {
+	tx, err := db.c.Begin()
 	if err != nil {
-		return fmt.Errorf("gruppo non trovato")
+		return err
 	}
-	if !isGroup {
-		return fmt.Errorf("non è un gruppo")
+	// Dopo il commit il rollback non fa nulla
+	defer func() { _ = tx.Rollback() }()
+
+	access, err := getGroupAccess(tx, groupID, actorID)
+	if err != nil {
+		return err
+	}
+	if access.role != RoleOwner {
+		return ErrPermissionDenied
 	}
-	_, err = db.c.Exec(`DELETE FROM conversation_members WHERE conversation_id = ? AND user_id = ?`, groupID, userID)
-	return err
+	if err := deleteGroup(tx, groupID); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-// SetGroupName: aggiorna il nome del gruppo
-func (db *appdbimpl) SetGroupName(groupID int, newName string) error {
-	var isGroup bool
-	err := db.c.QueryRow(`SELECT is_group FROM conversations WHERE id = ?`, groupID).Scan(&isGroup)
-	if err != nil {
-		return fmt.Errorf("gruppo non trovato")
+// groupOnlyMedia seleziona i media usati dai messaggi o come foto del gruppo (il parametro, ripetuto quattro volte) e
+// da nient'altro. Le foto sono salvate con il percorso del media, come in CanAccessMedia.
+const groupOnlyMedia = `
+    SELECT md.id FROM media md
+    WHERE (md.id IN (SELECT media_id FROM messages WHERE conversation_id = ?)
+           OR '/media/' || md.id = (SELECT photo FROM conversations WHERE id = ?))
+      AND NOT EXISTS (SELECT 1 FROM messages m WHERE m.media_id = md.id AND m.conversation_id != ?)
+      AND NOT EXISTS (SELECT 1 FROM conversations c WHERE c.photo = '/media/' || md.id AND c.id != ?)
+      AND NOT EXISTS (SELECT 1 FROM users u WHERE u.profile_picture = '/media/' || md.id)`
+
+// deleteGroup elimina la conversazione del gruppo e tutte le righe collegate, compresi i media usati solo dal gruppo e
+// gli eventi del log. I file dei media restano nel blob store finché la pulizia periodica dell'API non trova che
+// nessun media li usa più (vedi UnusedBlobKeys).
+func deleteGroup(tx *dbTx, groupID int) error {
+	// I media vanno cercati prima di eliminare i messaggi che li usano
+	if _, err := tx.Exec(`DELETE FROM media_thumbnails WHERE media_id IN (`+groupOnlyMedia+`)`, groupID, groupID, groupID, groupID); err != nil {
+		return err
 	}
-	if !isGroup {
-		return fmt.Errorf("non è un gruppo")
+	if _, err := tx.Exec(`DELETE FROM media WHERE id IN (`+groupOnlyMedia+`)`, groupID, groupID, groupID, groupID); err != nil {
+		return err
+	}
+
+	// Le chiavi esterne non sono attive su SQLite: le righe collegate vengono eliminate a mano
+	messages := `SELECT id FROM messages WHERE conversation_id = ?`
+	stmts := []string{
+		`DELETE FROM hidden_messages WHERE message_id IN (` + messages + `)`,
+		`DELETE FROM reactions WHERE message_id IN (` + messages + `)`,
+		`DELETE FROM message_edits WHERE message_id IN (` + messages + `)`,
+		`DELETE FROM message_receipts WHERE message_id IN (` + messages + `)`,
+		`DELETE FROM pinned_messages WHERE conversation_id = ?`,
+		`DELETE FROM messages WHERE conversation_id = ?`,
+		`DELETE FROM conversation_members WHERE conversation_id = ?`,
+		`DELETE FROM group_invites WHERE conversation_id = ?`,
+		`DELETE FROM group_join_requests WHERE conversation_id = ?`,
+		`DELETE FROM group_bans WHERE conversation_id = ?`,
+		`DELETE FROM event_recipients WHERE event_id IN (SELECT id FROM events WHERE conversation_id = ?)`,
+		`DELETE FROM events WHERE conversation_id = ?`,
+		`DELETE FROM conversations WHERE id = ?`,
 	}
-	_, err = db.c.Exec(`UPDATE conversations SET name = ? WHERE id = ?`, newName, groupID)
-	return err
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt, groupID); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// SetGroupPhoto: aggiorna la foto del gruppo
-func (db *appdbimpl) SetGroupPhoto(groupID int, photoUrl string) error {
-	var isGroup bool
-	err := db.c.QueryRow(`SELECT is_group FROM conversations WHERE id = ?`, groupID).Scan(&isGroup)
+// SetGroupName: aggiorna il nome del gruppo, se l'utente può modificarne le informazioni
+func (db *appdbimpl) SetGroupName(groupID int, userID int, newName string) ([]*structures.Message, error) {
+	return db.setGroupInfo(groupID, userID, "name", newName, MessageKindGroupRenamed)
+}
+
+// SetGroupPhoto: aggiorna la foto del gruppo, se l'utente può modificarne le informazioni
+func (db *appdbimpl) SetGroupPhoto(groupID int, userID int, photoUrl string) ([]*structures.Message, error) {
+	return db.setGroupInfo(groupID, userID, "photo", photoUrl, MessageKindGroupPhotoChanged)
+}
+
+// setGroupInfo imposta la colonna di conversations indicata (name, photo o description) e registra la modifica con un messaggio di
+// sistema di tipo kind
+func (db *appdbimpl) setGroupInfo(groupID, userID int, column, value, kind string) ([]*structures.Message, error) {
+	tx, err := db.c.Begin()
 	if err != nil {
-		return fmt.Errorf("gruppo non trovato")
+		return nil, err
 	}
-	if !isGroup {
-		return fmt.Errorf("non è un gruppo")
+	// Dopo il commit il rollback non fa nulla
+	defer func() { _ = tx.Rollback() }()
+
+	access, err := getGroupAccess(tx, groupID, userID)
+	if err != nil {
+		return nil, err
 	}
-	_, err = db.c.Exec(`UPDATE conversations SET photo = ? WHERE id = ?`, photoUrl, groupID)
-	return err
+	if !access.can(access.settings.OnlyAdminsEditInfo) {
+		return nil, ErrPermissionDenied
+	}
+	if _, err := tx.Exec(`UPDATE conversations SET `+column+` = ? WHERE id = ?`, value, groupID); err != nil {
+		return nil, err
+	}
+	id, err := insertSystemMessage(tx, groupID, userID, kind, nil, value)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return db.getNewMessages(groupID, id)
 }
 
-// AddMembersToGroup aggiunge utenti a un gruppo esistente
-func (db *appdbimpl) AddMembersToGroup(groupID int, usernames []string) error {
+// AddMembersToGroup aggiunge utenti a un gruppo esistente, se l'utente userID può aggiungere membri. Gli utenti banditi dal
+// gruppo vengono ignorati. Se il gruppo supererebbe il numero massimo di membri non aggiunge nessuno e restituisce
+// ErrGroupFull.
+func (db *appdbimpl) AddMembersToGroup(groupID int, userID int, usernames []string) ([]*structures.Message, error) {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return nil, err
+	}
+	// Dopo il commit il rollback non fa nulla
+	defer func() { _ = tx.Rollback() }()
+
+	access, err := getGroupAccess(tx, groupID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !access.can(access.settings.OnlyAdminsAddMembers) {
+		return nil, ErrPermissionDenied
+	}
+	var ids []int
 	for _, username := range usernames {
-		var userID int
-		err := db.c.QueryRow(`SELECT id FROM users WHERE username = ?`, username).Scan(&userID)
+		var memberID int
+		err := tx.QueryRow(`
+            SELECT u.id FROM users u
+            WHERE u.username = ?
+              AND NOT EXISTS (SELECT 1 FROM group_bans b WHERE b.conversation_id = ? AND b.user_id = u.id)`,
+			username, groupID,
+		).Scan(&memberID)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue // ignora utenti non trovati o banditi dal gruppo
+		}
 		if err != nil {
-			continue // ignora utenti non trovati
+			return nil, err
 		}
-		_, _ = db.c.Exec(`INSERT OR IGNORE INTO conversation_members (conversation_id, user_id) VALUES (?, ?)`, groupID, userID)
+		res, err := tx.Exec(`INSERT INTO conversation_members (conversation_id, user_id) VALUES (?, ?) ON CONFLICT DO NOTHING`, groupID, memberID)
+		if err != nil {
+			return nil, err
+		}
+		// Chi era già membro non viene aggiunto di nuovo
+		if added, err := res.RowsAffected(); err != nil {
+			return nil, err
+		} else if added == 0 {
+			continue
+		}
+		id, err := insertSystemMessage(tx, groupID, userID, MessageKindMemberAdded, &memberID, "")
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
 	}
-	return nil
+	if err := checkGroupSize(tx, groupID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return db.getNewMessages(groupID, ids...)
 }
@@ -0,0 +1,102 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/rerikdev/WASAText/service/structures"
+)
+
+// Oltre a nome e foto, un gruppo ha una descrizione e può avere un numero massimo di membri (MaxMembers nelle
+// impostazioni, 0 se non c'è un limite). Il limite vale per tutti i modi di entrare nel gruppo: la creazione,
+// l'aggiunta da parte di un membro, i link di invito e l'approvazione delle richieste di ingresso.
+
+// ErrGroupFull viene restituito quando un ingresso porterebbe il gruppo oltre il numero massimo di membri
+var ErrGroupFull = errors.New("il gruppo ha raggiunto il numero massimo di membri")
+
+// GroupOptions contiene le informazioni facoltative di un nuovo gruppo
+type GroupOptions struct {
+	Description string
+	// MaxMembers è il numero massimo di membri, 0 per nessun limite
+	MaxMembers int
+}
+
+// checkGroupSize restituisce ErrGroupFull se il gruppo ha più membri di quelli permessi. Va chiamata nella transazione
+// che aggiunge i membri, dopo averli inseriti, così in caso di errore non viene aggiunto nessuno.
+func checkGroupSize(q rowQuerier, groupID int) error {
+	var members, maxMembers int
+	err := q.QueryRow(`
+        SELECT (SELECT COUNT(*) FROM conversation_members WHERE conversation_id = c.id), c.max_members
+        FROM conversations c
+        WHERE c.id = ?`, groupID).Scan(&members, &maxMembers)
+	if err != nil {
+		return err
+	}
+	if maxMembers > 0 && members > maxMembers {
+		return ErrGroupFull
+	}
+	return nil
+}
+
+// SetGroupDescription aggiorna la descrizione del gruppo, se l'utente può modificarne le informazioni. La descrizione
+// vuota la rimuove.
+func (db *appdbimpl) SetGroupDescription(groupID int, userID int, description string) ([]*structures.Message, error) {
+	return db.setGroupInfo(groupID, userID, "description", description, MessageKindGroupDescriptionChanged)
+}
+
+// GetGroupInfo restituisce le informazioni complete del gruppo: impostazioni, membri con i loro ruoli e data e autore
+// della creazione. Non controlla che l'utente sia membro del gruppo.
+func (db *appdbimpl) GetGroupInfo(groupID int) (*structures.GroupInfo, error) {
+	var info structures.GroupInfo
+	var isGroup bool
+	var creatorID sql.NullInt64
+	var creator structures.User
+	err := db.c.QueryRow(`
+        SELECT c.id, c.is_group, COALESCE(c.name, ''), COALESCE(c.photo, ''), c.description, c.only_admins_edit_info,
+               c.only_admins_add_members, c.only_admins_send, c.only_admins_pin, c.max_members, c.created_at,
+               u.id, COALESCE(u.username, ''), COALESCE(u.display_name, ''), COALESCE(u.profile_picture, '')
+        FROM conversations c
+        LEFT JOIN users u ON u.id = c.created_by
+        WHERE c.id = ?`, groupID).Scan(
+		&info.ID, &isGroup, &info.Name, &info.Photo, &info.Description, &info.Settings.OnlyAdminsEditInfo,
+		&info.Settings.OnlyAdminsAddMembers, &info.Settings.OnlyAdminsSend, &info.Settings.OnlyAdminsPin,
+		&info.Settings.MaxMembers, &info.CreatedAt,
+		&creatorID, &creator.Username, &creator.DisplayName, &creator.ProfilePicture,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, invalidGroupRequest("gruppo non trovato")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !isGroup {
+		return nil, invalidGroupRequest("non è un gruppo")
+	}
+	if creatorID.Valid {
+		creator.ID = int(creatorID.Int64)
+		info.CreatedBy = &creator
+	}
+
+	rows, err := db.c.Query(`
+        SELECT u.id, u.username, u.display_name, COALESCE(u.profile_picture, ''), cm.role
+        FROM conversation_members cm
+        JOIN users u ON u.id = cm.user_id
+        WHERE cm.conversation_id = ?
+        ORDER BY u.id`, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	info.Members = []structures.GroupMember{}
+	for rows.Next() {
+		var member structures.GroupMember
+		if err := rows.Scan(&member.ID, &member.Username, &member.DisplayName, &member.ProfilePicture, &member.Role); err != nil {
+			return nil, err
+		}
+		info.Members = append(info.Members, member)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
@@ -0,0 +1,348 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/rerikdev/WASAText/service/globaltime"
+	"github.com/rerikdev/WASAText/service/structures"
+)
+
+// Link di invito ai gruppi. Il proprietario e gli amministratori creano i link, con una scadenza e un numero massimo di
+// utilizzi facoltativi; chiunque abbia il token può vedere l'anteprima del gruppo e usarlo per entrare. Se il link
+// richiede l'approvazione, chi lo usa crea invece una richiesta di ingresso che un amministratore approva o rifiuta.
+// Ogni utilizzo, per entrare o per chiedere di entrare, conta per il limite del link.
+
+// ErrInviteNotFound viene restituito quando il token non corrisponde a nessun link, anche perché è stato revocato
+var ErrInviteNotFound = errors.New("link di invito non trovato")
+
+// ErrInviteExpired viene restituito quando il link è scaduto o è già stato usato il numero massimo di volte
+var ErrInviteExpired = errors.New("link di invito scaduto o esaurito")
+
+// ErrAlreadyMember viene restituito quando chi usa un link di invito è già membro del gruppo
+var ErrAlreadyMember = errors.New("sei già membro del gruppo")
+
+// ErrJoinRequestNotFound viene restituito quando l'utente non ha richieste di ingresso in attesa nel gruppo
+var ErrJoinRequestNotFound = errors.New("richiesta di ingresso non trovata")
+
+// GroupInviteOptions sono le opzioni di un nuovo link di invito
+type GroupInviteOptions struct {
+	// Token è la parte casuale del link, generata dal chiamante
+	Token string
+	// ExpiresAt è la scadenza del link, nil se non scade
+	ExpiresAt *time.Time
+	// MaxUses è il numero massimo di utilizzi, 0 se illimitato
+	MaxUses          int
+	RequiresApproval bool
+}
+
+// inviteExpiredCondition è vera per i link di group_invites scaduti o esauriti, con la data corrente come parametro
+const inviteExpiredCondition = `((expires_at IS NOT NULL AND expires_at <= ?) OR (max_uses IS NOT NULL AND uses >= max_uses))`
+
+// requireGroupAdmin restituisce ErrPermissionDenied se l'utente non è il proprietario o un amministratore del gruppo
+func requireGroupAdmin(q rowQuerier, groupID, userID int) error {
+	access, err := getGroupAccess(q, groupID, userID)
+	if err != nil {
+		return err
+	}
+	if !access.isAdmin() {
+		return ErrPermissionDenied
+	}
+	return nil
+}
+
+// CreateGroupInvite crea un link di invito al gruppo, se actorID è il proprietario o un amministratore. I link scaduti
+// o esauriti del gruppo vengono eliminati.
+func (db *appdbimpl) CreateGroupInvite(groupID, actorID int, options GroupInviteOptions) (*structures.GroupInvite, error) {
+	if err := requireGroupAdmin(db.c, groupID, actorID); err != nil {
+		return nil, err
+	}
+	now := globaltime.Now()
+	if _, err := db.c.Exec(`DELETE FROM group_invites WHERE conversation_id = ? AND `+inviteExpiredCondition,
+		groupID, now.Format(timestampLayout),
+	); err != nil {
+		return nil, err
+	}
+
+	var expiresAt, maxUses interface{}
+	if options.ExpiresAt != nil {
+		expiresAt = options.ExpiresAt.Format(timestampLayout)
+	}
+	if options.MaxUses > 0 {
+		maxUses = options.MaxUses
+	}
+	if _, err := db.c.Exec(`
+        INSERT INTO group_invites (token, conversation_id, created_by, created_at, expires_at, max_uses, requires_approval)
+        VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		options.Token, groupID, actorID, now.Format(timestampLayout), expiresAt, maxUses, options.RequiresApproval,
+	); err != nil {
+		return nil, err
+	}
+	return getGroupInvite(db.c, options.Token)
+}
+
+// groupInviteColumns sono le colonne lette da scanGroupInvite
+const groupInviteColumns = `token, conversation_id, created_by, created_at, expires_at, max_uses, uses, requires_approval`
+
+func scanGroupInvite(row interface{ Scan(...interface{}) error }) (*structures.GroupInvite, error) {
+	var invite structures.GroupInvite
+	err := row.Scan(
+		&invite.Token, &invite.GroupID, &invite.CreatedBy, &invite.CreatedAt, &invite.ExpiresAt, &invite.MaxUses,
+		&invite.Uses, &invite.RequiresApproval,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &invite, nil
+}
+
+func getGroupInvite(q rowQuerier, token string) (*structures.GroupInvite, error) {
+	invite, err := scanGroupInvite(q.QueryRow(`SELECT `+groupInviteColumns+` FROM group_invites WHERE token = ?`, token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInviteNotFound
+	}
+	return invite, err
+}
+
+// ListGroupInvites restituisce i link di invito ancora validi del gruppo, dal più recente, se actorID è il
+// proprietario o un amministratore
+func (db *appdbimpl) ListGroupInvites(groupID, actorID int) ([]*structures.GroupInvite, error) {
+	if err := requireGroupAdmin(db.c, groupID, actorID); err != nil {
+		return nil, err
+	}
+	rows, err := db.c.Query(`
+        SELECT `+groupInviteColumns+`
+        FROM group_invites
+        WHERE conversation_id = ? AND NOT `+inviteExpiredCondition+`
+        ORDER BY created_at DESC, token`,
+		groupID, globaltime.Now().Format(timestampLayout),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invites := []*structures.GroupInvite{}
+	for rows.Next() {
+		invite, err := scanGroupInvite(rows)
+		if err != nil {
+			return nil, err
+		}
+		invites = append(invites, invite)
+	}
+	return invites, rows.Err()
+}
+
+// RevokeGroupInvite elimina un link di invito del gruppo, se actorID è il proprietario o un amministratore. Le
+// richieste di ingresso già fatte con il link restano in attesa.
+func (db *appdbimpl) RevokeGroupInvite(groupID, actorID int, token string) error {
+	if err := requireGroupAdmin(db.c, groupID, actorID); err != nil {
+		return err
+	}
+	res, err := db.c.Exec(`DELETE FROM group_invites WHERE token = ? AND conversation_id = ?`, token, groupID)
+	if err != nil {
+		return err
+	}
+	revoked, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if revoked == 0 {
+		return ErrInviteNotFound
+	}
+	return nil
+}
+
+// PreviewGroupInvite restituisce l'anteprima del gruppo a cui invita il link, come la vede userID
+func (db *appdbimpl) PreviewGroupInvite(token string, userID int) (*structures.GroupInvitePreview, error) {
+	var preview structures.GroupInvitePreview
+	var expired bool
+	err := db.c.QueryRow(`
+        SELECT c.id, COALESCE(c.name, ''), COALESCE(c.photo, ''), i.requires_approval, i.expires_at, `+inviteExpiredCondition+`,
+               (SELECT COUNT(*) FROM conversation_members cm WHERE cm.conversation_id = c.id),
+               EXISTS (SELECT 1 FROM conversation_members cm WHERE cm.conversation_id = c.id AND cm.user_id = ?),
+               EXISTS (SELECT 1 FROM group_join_requests r WHERE r.conversation_id = c.id AND r.user_id = ?)
+        FROM group_invites i
+        JOIN conversations c ON c.id = i.conversation_id
+        WHERE i.token = ?`,
+		globaltime.Now().Format(timestampLayout), userID, userID, token,
+	).Scan(
+		&preview.GroupID, &preview.Name, &preview.Photo, &preview.RequiresApproval, &preview.ExpiresAt, &expired,
+		&preview.MemberCount, &preview.IsMember, &preview.Pending,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInviteNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if expired {
+		return nil, ErrInviteExpired
+	}
+	return &preview, nil
+}
+
+// JoinGroupByInvite usa il link di invito per userID e restituisce l'ID del gruppo. Se il link richiede l'approvazione
+// l'utente non entra nel gruppo ma crea una richiesta di ingresso, e pending è true; una richiesta già in attesa non
+// conta di nuovo per il limite del link. Se l'utente è già membro restituisce l'ID del gruppo con ErrAlreadyMember.
+// messages contiene il messaggio di sistema dell'ingresso, se l'utente è entrato.
+func (db *appdbimpl) JoinGroupByInvite(token string, userID int) (groupID int, pending bool, messages []*structures.Message, err error) {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return 0, false, nil, err
+	}
+	// Dopo il commit il rollback non fa nulla
+	defer func() { _ = tx.Rollback() }()
+
+	now := globaltime.Now().Format(timestampLayout)
+	var requiresApproval, isMember, isPending, isBanned bool
+	err = tx.QueryRow(`
+        SELECT i.conversation_id, i.requires_approval,
+               EXISTS (SELECT 1 FROM conversation_members cm WHERE cm.conversation_id = i.conversation_id AND cm.user_id = ?),
+               EXISTS (SELECT 1 FROM group_join_requests r WHERE r.conversation_id = i.conversation_id AND r.user_id = ?),
+               EXISTS (SELECT 1 FROM group_bans b WHERE b.conversation_id = i.conversation_id AND b.user_id = ?)
+        FROM group_invites i
+        WHERE i.token = ?`, userID, userID, userID, token,
+	).Scan(&groupID, &requiresApproval, &isMember, &isPending, &isBanned)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil, ErrInviteNotFound
+	}
+	if err != nil {
+		return 0, false, nil, err
+	}
+	if isMember {
+		return groupID, false, nil, ErrAlreadyMember
+	}
+	if isBanned {
+		return 0, false, nil, ErrUserBanned
+	}
+	if requiresApproval && isPending {
+		return groupID, true, nil, nil
+	}
+
+	// L'utilizzo viene contato solo se il link è ancora valido, anche con più utenti che lo usano insieme
+	res, err := tx.Exec(`UPDATE group_invites SET uses = uses + 1 WHERE token = ? AND NOT `+inviteExpiredCondition, token, now)
+	if err != nil {
+		return 0, false, nil, err
+	}
+	if used, err := res.RowsAffected(); err != nil {
+		return 0, false, nil, err
+	} else if used == 0 {
+		return 0, false, nil, ErrInviteExpired
+	}
+
+	var ids []int
+	if requiresApproval {
+		_, err = tx.Exec(`INSERT INTO group_join_requests (conversation_id, user_id, requested_at) VALUES (?, ?, ?)`, groupID, userID, now)
+	} else {
+		_, err = tx.Exec(`INSERT INTO conversation_members (conversation_id, user_id) VALUES (?, ?)`, groupID, userID)
+		if err == nil {
+			// Una richiesta fatta con un altro link non serve più
+			_, err = tx.Exec(`DELETE FROM group_join_requests WHERE conversation_id = ? AND user_id = ?`, groupID, userID)
+		}
+		if err == nil {
+			var id int
+			id, err = insertSystemMessage(tx, groupID, userID, MessageKindMemberJoined, nil, "")
+			ids = append(ids, id)
+		}
+		if err == nil {
+			err = checkGroupSize(tx, groupID)
+		}
+	}
+	if err != nil {
+		return 0, false, nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, false, nil, err
+	}
+	messages, err = db.getNewMessages(groupID, ids...)
+	return groupID, requiresApproval, messages, err
+}
+
+// ListGroupJoinRequests restituisce le richieste di ingresso in attesa nel gruppo, dalla più vecchia, se actorID è il
+// proprietario o un amministratore
+func (db *appdbimpl) ListGroupJoinRequests(groupID, actorID int) ([]*structures.GroupJoinRequest, error) {
+	if err := requireGroupAdmin(db.c, groupID, actorID); err != nil {
+		return nil, err
+	}
+	rows, err := db.c.Query(`
+        SELECT u.id, u.username, u.display_name, COALESCE(u.profile_picture, ''), r.requested_at
+        FROM group_join_requests r
+        JOIN users u ON u.id = r.user_id
+        WHERE r.conversation_id = ?
+        ORDER BY r.requested_at, u.id`, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	requests := []*structures.GroupJoinRequest{}
+	for rows.Next() {
+		var r structures.GroupJoinRequest
+		if err := rows.Scan(&r.User.ID, &r.User.Username, &r.User.DisplayName, &r.User.ProfilePicture, &r.RequestedAt); err != nil {
+			return nil, err
+		}
+		requests = append(requests, &r)
+	}
+	return requests, rows.Err()
+}
+
+// ApproveGroupJoinRequest aggiunge al gruppo l'utente che ha chiesto di entrare, se actorID è il proprietario o un
+// amministratore
+func (db *appdbimpl) ApproveGroupJoinRequest(groupID, actorID, userID int) ([]*structures.Message, error) {
+	return db.resolveJoinRequest(groupID, actorID, userID, true)
+}
+
+// RejectGroupJoinRequest rifiuta la richiesta di ingresso dell'utente, se actorID è il proprietario o un amministratore
+func (db *appdbimpl) RejectGroupJoinRequest(groupID, actorID, userID int) error {
+	_, err := db.resolveJoinRequest(groupID, actorID, userID, false)
+	return err
+}
+
+func (db *appdbimpl) resolveJoinRequest(groupID, actorID, userID int, approve bool) ([]*structures.Message, error) {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return nil, err
+	}
+	// Dopo il commit il rollback non fa nulla
+	defer func() { _ = tx.Rollback() }()
+
+	if err := requireGroupAdmin(tx, groupID, actorID); err != nil {
+		return nil, err
+	}
+	res, err := tx.Exec(`DELETE FROM group_join_requests WHERE conversation_id = ? AND user_id = ?`, groupID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if deleted, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if deleted == 0 {
+		return nil, ErrJoinRequestNotFound
+	}
+	var ids []int
+	if approve {
+		res, err := tx.Exec(`INSERT INTO conversation_members (conversation_id, user_id) VALUES (?, ?) ON CONFLICT DO NOTHING`, groupID, userID)
+		if err != nil {
+			return nil, err
+		}
+		// L'utente può essere già stato aggiunto da un membro mentre la richiesta era in attesa
+		if added, err := res.RowsAffected(); err != nil {
+			return nil, err
+		} else if added > 0 {
+			id, err := insertSystemMessage(tx, groupID, actorID, MessageKindMemberAdded, &userID, "")
+			if err != nil {
+				return nil, err
+			}
+			ids = append(ids, id)
+		}
+		// Se il gruppo è pieno la richiesta resta in attesa
+		if err := checkGroupSize(tx, groupID); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return db.getNewMessages(groupID, ids...)
+}
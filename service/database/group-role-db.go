@@ -0,0 +1,269 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/rerikdev/WASAText/service/structures"
+)
+
+// Ogni membro di un gruppo ha un ruolo. Il proprietario (RoleOwner) è chi ha creato il gruppo e può fare tutto. Gli
+// amministratori (RoleAdmin) possono nominare altri amministratori, rimuovere i membri semplici e cambiare le impostazioni
+// del gruppo; solo il proprietario può revocare un amministratore o rimuoverlo dal gruppo, e nessuno può rimuovere il
+// proprietario. Le impostazioni (structures.GroupSettings) decidono se modificare nome e foto, aggiungere membri, inviare
+// messaggi e fissarli è permesso a tutti i membri o solo al proprietario e agli amministratori.
+
+// Ruoli dei membri di un gruppo, salvati in conversation_members.role
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
+
+// ErrPermissionDenied viene restituito quando il ruolo dell'utente nel gruppo non gli permette l'operazione
+var ErrPermissionDenied = errors.New("operazione non consentita dal tuo ruolo nel gruppo")
+
+// ErrMemberNotFound viene restituito quando l'utente su cui si opera non è membro del gruppo
+var ErrMemberNotFound = errors.New("l'utente non è membro del gruppo")
+
+// ErrInvalidGroupRequest identifica, con errors.Is, gli errori dovuti ai dati della richiesta (un ruolo o un limite di
+// membri non validi, un utente inesistente, un gruppo che non esiste, ...), ognuno con un messaggio più preciso
+var ErrInvalidGroupRequest = errors.New("richiesta non valida")
+
+// groupRequestError è un errore di ErrInvalidGroupRequest con il proprio messaggio
+type groupRequestError string
+
+func (e groupRequestError) Error() string { return string(e) }
+
+func (e groupRequestError) Is(target error) bool { return target == ErrInvalidGroupRequest }
+
+// invalidGroupRequest restituisce un errore di ErrInvalidGroupRequest con il messaggio dato
+func invalidGroupRequest(format string, args ...interface{}) error {
+	return groupRequestError(fmt.Sprintf(format, args...))
+}
+
+// GroupSettingsUpdate contiene le impostazioni di un gruppo da cambiare: i campi nil restano invariati
+type GroupSettingsUpdate struct {
+	OnlyAdminsEditInfo   *bool
+	OnlyAdminsAddMembers *bool
+	OnlyAdminsSend       *bool
+	OnlyAdminsPin        *bool
+	MaxMembers           *int
+}
+
+// apply cambia le impostazioni indicate in settings
+func (u GroupSettingsUpdate) apply(settings *structures.GroupSettings) {
+	if u.OnlyAdminsEditInfo != nil {
+		settings.OnlyAdminsEditInfo = *u.OnlyAdminsEditInfo
+	}
+	if u.OnlyAdminsAddMembers != nil {
+		settings.OnlyAdminsAddMembers = *u.OnlyAdminsAddMembers
+	}
+	if u.OnlyAdminsSend != nil {
+		settings.OnlyAdminsSend = *u.OnlyAdminsSend
+	}
+	if u.OnlyAdminsPin != nil {
+		settings.OnlyAdminsPin = *u.OnlyAdminsPin
+	}
+	if u.MaxMembers != nil {
+		settings.MaxMembers = *u.MaxMembers
+	}
+}
+
+// rowQuerier è implementato sia da dbConn che da dbTx
+type rowQuerier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// groupAccess è il ruolo di un utente in un gruppo, insieme alle impostazioni del gruppo
+type groupAccess struct {
+	role     string
+	settings structures.GroupSettings
+}
+
+func (a *groupAccess) isAdmin() bool {
+	return a.role == RoleOwner || a.role == RoleAdmin
+}
+
+// can indica se l'utente può eseguire un'azione che, se adminsOnly è attiva, è riservata agli amministratori
+func (a *groupAccess) can(adminsOnly bool) bool {
+	return !adminsOnly || a.isAdmin()
+}
+
+// canChangeRole indica se l'utente può dare il ruolo role a un membro che ha il ruolo current. Chiunque può rinunciare
+// al proprio ruolo di amministratore (self).
+func (a *groupAccess) canChangeRole(current, role string, self bool) bool {
+	switch {
+	case current == RoleOwner:
+		return false
+	case role == RoleAdmin:
+		return a.isAdmin()
+	case current == RoleAdmin:
+		return a.role == RoleOwner || self
+	default:
+		return true
+	}
+}
+
+// canRemove indica se l'utente può rimuovere dal gruppo un membro con il ruolo target
+func (a *groupAccess) canRemove(target string) bool {
+	switch target {
+	case RoleOwner:
+		return false
+	case RoleAdmin:
+		return a.role == RoleOwner
+	default:
+		return a.isAdmin()
+	}
+}
+
+// getGroupAccess restituisce il ruolo dell'utente nel gruppo e le impostazioni del gruppo, con gli stessi errori delle
+// altre funzioni dei gruppi. Se l'utente non è membro restituisce ErrPermissionDenied.
+func getGroupAccess(q rowQuerier, groupID, userID int) (*groupAccess, error) {
+	var isGroup bool
+	var role sql.NullString
+	var access groupAccess
+	err := q.QueryRow(`
+        SELECT c.is_group, c.only_admins_edit_info, c.only_admins_add_members, c.only_admins_send, c.only_admins_pin,
+               c.max_members, cm.role
+        FROM conversations c
+        LEFT JOIN conversation_members cm ON cm.conversation_id = c.id AND cm.user_id = ?
+        WHERE c.id = ?`, userID, groupID).Scan(
+		&isGroup, &access.settings.OnlyAdminsEditInfo, &access.settings.OnlyAdminsAddMembers,
+		&access.settings.OnlyAdminsSend, &access.settings.OnlyAdminsPin, &access.settings.MaxMembers, &role,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, invalidGroupRequest("gruppo non trovato")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !isGroup {
+		return nil, invalidGroupRequest("non è un gruppo")
+	}
+	if !role.Valid {
+		return nil, ErrPermissionDenied
+	}
+	access.role = role.String
+	return &access, nil
+}
+
+// getMemberRole restituisce il ruolo di un membro del gruppo, o ErrMemberNotFound
+func getMemberRole(q rowQuerier, groupID, userID int) (string, error) {
+	var role string
+	err := q.QueryRow(`SELECT role FROM conversation_members WHERE conversation_id = ? AND user_id = ?`, groupID, userID).Scan(&role)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrMemberNotFound
+	}
+	return role, err
+}
+
+// SetGroupMemberRole nomina amministratore (RoleAdmin) un membro del gruppo o lo riporta a membro semplice (RoleMember),
+// se actorID ha il permesso di farlo
+func (db *appdbimpl) SetGroupMemberRole(groupID, actorID, userID int, role string) error {
+	if role != RoleAdmin && role != RoleMember {
+		return invalidGroupRequest("ruolo non valido: %s", role)
+	}
+	tx, err := db.c.Begin()
+	if err != nil {
+		return err
+	}
+	// Dopo il commit il rollback non fa nulla
+	defer func() { _ = tx.Rollback() }()
+
+	access, err := getGroupAccess(tx, groupID, actorID)
+	if err != nil {
+		return err
+	}
+	current, err := getMemberRole(tx, groupID, userID)
+	if err != nil {
+		return err
+	}
+	if !access.canChangeRole(current, role, actorID == userID) {
+		return ErrPermissionDenied
+	}
+	if _, err := tx.Exec(`UPDATE conversation_members SET role = ? WHERE conversation_id = ? AND user_id = ?`, role, groupID, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RemoveGroupMember rimuove userID dal gruppo, se actorID ha il permesso di farlo. Per uscire da un gruppo si usa
+// LeaveGroup.
+func (db *appdbimpl) RemoveGroupMember(groupID, actorID, userID int) ([]*structures.Message, error) {
+	if actorID == userID {
+		return nil, invalidGroupRequest("non puoi rimuovere te stesso dal gruppo")
+	}
+	tx, err := db.c.Begin()
+	if err != nil {
+		return nil, err
+	}
+	// Dopo il commit il rollback non fa nulla
+	defer func() { _ = tx.Rollback() }()
+
+	access, err := getGroupAccess(tx, groupID, actorID)
+	if err != nil {
+		return nil, err
+	}
+	target, err := getMemberRole(tx, groupID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !access.canRemove(target) {
+		return nil, ErrPermissionDenied
+	}
+	if _, err := tx.Exec(`DELETE FROM conversation_members WHERE conversation_id = ? AND user_id = ?`, groupID, userID); err != nil {
+		return nil, err
+	}
+	id, err := insertSystemMessage(tx, groupID, actorID, MessageKindMemberRemoved, &userID, "")
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return db.getNewMessages(groupID, id)
+}
+
+// SetGroupSettings cambia le impostazioni del gruppo, se actorID è il proprietario o un amministratore, e restituisce
+// quelle risultanti. Il numero massimo di membri non può essere inferiore a quello attuale.
+func (db *appdbimpl) SetGroupSettings(groupID, actorID int, update GroupSettingsUpdate) (*structures.GroupSettings, error) {
+	if update.MaxMembers != nil && *update.MaxMembers < 0 {
+		return nil, invalidGroupRequest("numero massimo di membri non valido")
+	}
+	tx, err := db.c.Begin()
+	if err != nil {
+		return nil, err
+	}
+	// Dopo il commit il rollback non fa nulla
+	defer func() { _ = tx.Rollback() }()
+
+	access, err := getGroupAccess(tx, groupID, actorID)
+	if err != nil {
+		return nil, err
+	}
+	if !access.isAdmin() {
+		return nil, ErrPermissionDenied
+	}
+	settings := access.settings
+	update.apply(&settings)
+	if _, err := tx.Exec(`
+        UPDATE conversations
+        SET only_admins_edit_info = ?, only_admins_add_members = ?, only_admins_send = ?, only_admins_pin = ?, max_members = ?
+        WHERE id = ?`,
+		settings.OnlyAdminsEditInfo, settings.OnlyAdminsAddMembers, settings.OnlyAdminsSend, settings.OnlyAdminsPin,
+		settings.MaxMembers, groupID,
+	); err != nil {
+		return nil, err
+	}
+	if err := checkGroupSize(tx, groupID); errors.Is(err, ErrGroupFull) {
+		return nil, invalidGroupRequest("il gruppo ha più di %d membri", settings.MaxMembers)
+	} else if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
@@ -0,0 +1,160 @@
+package database
+
+import (
+	"database/sql"
+
+	"github.com/rerikdev/WASAText/service/globaltime"
+	"github.com/rerikdev/WASAText/service/structures"
+)
+
+// inboxPreviewLength è il numero massimo di caratteri del testo nelle anteprime dell'ultimo messaggio
+const inboxPreviewLength = 100
+
+// inboxLastMessageQuery è come lastMessageIdQuery, ma salta i messaggi che il membro cm ha nascosto
+const inboxLastMessageQuery = `
+    SELECT m.id FROM messages m
+    WHERE m.conversation_id = c.id
+      AND NOT EXISTS (SELECT 1 FROM hidden_messages h WHERE h.user_id = cm.user_id AND h.message_id = m.id)
+    ORDER BY m.timestamp DESC, m.id DESC LIMIT 1`
+
+// truncatePreview tronca il testo a inboxPreviewLength caratteri e indica se è stato troncato
+func truncatePreview(text string) (string, bool) {
+	n := 0
+	for i := range text {
+		if n == inboxPreviewLength {
+			return text[:i], true
+		}
+		n++
+	}
+	return text, false
+}
+
+// GetInbox restituisce tutte le conversazioni dell'utente, chat 1:1 e gruppi, con l'anteprima dell'ultimo messaggio, il
+// numero di messaggi non letti e le preferenze dell'utente. Le conversazioni fissate vengono per prime, dalla più
+// recentemente fissata; le altre seguono dall'ultima attività, e quelle senza messaggi sono in fondo.
+//
+// Basta una query: i non letti vengono contati in un'unica aggregazione sulle ricevute ancora da leggere dell'utente
+// (indice idx_message_receipts_unread), e del testo dell'ultimo messaggio viene letto solo l'inizio. Se l'ultimo
+// messaggio registra che un messaggio è stato fissato o tolto, il testo è quello del messaggio fissato, se non è stato
+// eliminato o nascosto dall'utente.
+func (db *appdbimpl) GetInbox(userId int) ([]*structures.InboxEntry, error) {
+	rows, err := db.c.Query(`
+        SELECT c.id, c.is_group, COALESCE(c.name, ''), COALESCE(c.photo, ''),
+               COALESCE(o.id, 0), COALESCE(o.username, ''), COALESCE(o.display_name, ''), COALESCE(o.profile_picture, ''),
+               cm.muted, cm.pinned_at, COALESCE(un.unread, 0),
+               lm.id, COALESCE(lm.sender_id, 0), COALESCE(s.username, ''), COALESCE(s.display_name, ''),
+               COALESCE(s.profile_picture, ''), COALESCE(lm.media_type, ''),
+               CASE WHEN COALESCE(pm.content, lm.content) LIKE 'data:image/%' THEN ''
+                    ELSE COALESCE(SUBSTR(COALESCE(pm.content, lm.content), 1, ?), '') END,
+               lm.timestamp, lm.deleted_at, COALESCE(lm.kind, ''),
+               t.id, COALESCE(t.username, ''), COALESCE(t.display_name, ''), COALESCE(t.profile_picture, '')
+        FROM conversation_members cm
+        JOIN conversations c ON c.id = cm.conversation_id
+        LEFT JOIN conversation_members om ON om.conversation_id = c.id AND om.user_id != cm.user_id AND c.is_group = FALSE
+        LEFT JOIN users o ON o.id = om.user_id
+        LEFT JOIN messages lm ON lm.id = (`+inboxLastMessageQuery+`)
+        LEFT JOIN users s ON s.id = lm.sender_id
+        LEFT JOIN users t ON t.id = lm.target_user_id
+        LEFT JOIN messages pm ON pm.id = lm.reply_to_message_id AND lm.kind != ? AND pm.deleted_at IS NULL
+            AND NOT EXISTS (SELECT 1 FROM hidden_messages h WHERE h.user_id = cm.user_id AND h.message_id = pm.id)
+        LEFT JOIN (
+            SELECT m.conversation_id, COUNT(*) AS unread
+            FROM message_receipts r
+            JOIN messages m ON m.id = r.message_id
+            WHERE r.user_id = ? AND r.read_at IS NULL AND m.deleted_at IS NULL
+              AND NOT EXISTS (SELECT 1 FROM hidden_messages h WHERE h.user_id = r.user_id AND h.message_id = m.id)
+            GROUP BY m.conversation_id
+        ) un ON un.conversation_id = c.id
+        WHERE cm.user_id = ? AND (c.is_group = TRUE OR o.id IS NOT NULL)
+        ORDER BY cm.pinned_at IS NULL, cm.pinned_at DESC, lm.timestamp IS NULL, lm.timestamp DESC, lm.id DESC, c.id DESC`,
+		inboxPreviewLength+1, MessageKindUser, userId, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*structures.InboxEntry{}
+	for rows.Next() {
+		var entry structures.InboxEntry
+		var isGroup bool
+		var name, photo string
+		var other structures.User
+		var lastId *int
+		var last structures.MessagePreview
+		var lastTime sql.NullString
+		var targetId *int
+		var target structures.User
+		if err := rows.Scan(
+			&entry.ID, &isGroup, &name, &photo,
+			&other.ID, &other.Username, &other.DisplayName, &other.ProfilePicture,
+			&entry.Muted, &entry.PinnedAt, &entry.UnreadCount,
+			&lastId, &last.Sender.ID, &last.Sender.Username, &last.Sender.DisplayName,
+			&last.Sender.ProfilePicture, &last.MediaType,
+			&last.Text,
+			&lastTime, &last.DeletedAt, &last.Kind,
+			&targetId, &target.Username, &target.DisplayName, &target.ProfilePicture,
+		); err != nil {
+			return nil, err
+		}
+
+		if isGroup {
+			entry.Type, entry.Title, entry.Avatar = "group", name, photo
+		} else {
+			entry.Type, entry.Title, entry.Avatar, entry.OtherUserID = "direct", other.DisplayName, other.ProfilePicture, other.ID
+			if entry.Title == "" {
+				entry.Title = other.Username
+			}
+		}
+		entry.Pinned = entry.PinnedAt != nil
+		if lastId != nil {
+			last.ID, last.Timestamp = *lastId, lastTime.String
+			last.Text, last.Truncated = truncatePreview(last.Text)
+			if targetId != nil {
+				target.ID = *targetId
+				last.Target = &target
+			}
+			entry.LastMessage = &last
+		}
+		entries = append(entries, &entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// membershipUpdated controlla il risultato di un UPDATE su conversation_members: se non ha trovato la riga, l'utente non
+// è membro della conversazione
+func membershipUpdated(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return ErrConversationNotFound
+	}
+	return nil
+}
+
+// SetConversationMuted silenzia o riattiva le notifiche della conversazione per l'utente
+func (db *appdbimpl) SetConversationMuted(conversationId, userId int, muted bool) error {
+	return membershipUpdated(db.c.Exec(
+		`UPDATE conversation_members SET muted = ? WHERE conversation_id = ? AND user_id = ?`,
+		muted, conversationId, userId))
+}
+
+// SetConversationPinned fissa la conversazione in cima all'inbox dell'utente, o la rimuove da quelle fissate. Fissare di
+// nuovo una conversazione già fissata non ne cambia la posizione.
+func (db *appdbimpl) SetConversationPinned(conversationId, userId int, pinned bool) error {
+	if !pinned {
+		return membershipUpdated(db.c.Exec(
+			`UPDATE conversation_members SET pinned_at = NULL WHERE conversation_id = ? AND user_id = ?`,
+			conversationId, userId))
+	}
+	return membershipUpdated(db.c.Exec(
+		`UPDATE conversation_members SET pinned_at = COALESCE(pinned_at, ?) WHERE conversation_id = ? AND user_id = ?`,
+		globaltime.Now().Format(timestampLayout), conversationId, userId))
+}
@@ -0,0 +1,126 @@
+package database
+
+import (
+	"fmt"
+)
+
+// Tabelle in cui, prima dell'introduzione dei media, le immagini venivano salvate inline come data URL
+const (
+	InlineImageMessages      = "messages"      // messages.content
+	InlineImageUsers         = "users"         // users.profile_picture
+	InlineImageConversations = "conversations" // conversations.photo
+)
+
+// InlineImageSources sono le tabelle da migrare, nell'ordine in cui vengono elaborate
+var InlineImageSources = []string{InlineImageMessages, InlineImageUsers, InlineImageConversations}
+
+// InlineImage è un'immagine salvata come data URL in una riga del database
+type InlineImage struct {
+	Source string
+	RowID  int
+	// OwnerID è l'utente a cui attribuire il media: il mittente del messaggio, l'utente stesso o, per i gruppi, il
+	// membro con l'ID più basso (0 se il gruppo non ha membri)
+	OwnerID int
+	DataURL string
+	// Replacement è il valore che sostituisce il data URL, impostato da chi esegue la migrazione: l'ID del media per
+	// i messaggi, il percorso del media per le foto
+	Replacement string
+}
+
+// inlineImageQueries contiene, per ogni tabella, la query che legge le immagini inline e quella che le sostituisce.
+// L'aggiornamento controlla che il contenuto non sia cambiato nel frattempo, così la migrazione può girare anche con
+// il server avviato.
+var inlineImageQueries = map[string]struct{ list, replace string }{
+	InlineImageMessages: {
+		list: `SELECT id, sender_id, content FROM messages
+               WHERE content LIKE 'data:image/%' AND id > ? ORDER BY id LIMIT ?`,
+		replace: `UPDATE messages SET content = '', media_id = ?, media_type = 'image' WHERE id = ? AND content = ?`,
+	},
+	InlineImageUsers: {
+		list: `SELECT id, id, profile_picture FROM users
+               WHERE profile_picture LIKE 'data:image/%' AND id > ? ORDER BY id LIMIT ?`,
+		replace: `UPDATE users SET profile_picture = ? WHERE id = ? AND profile_picture = ?`,
+	},
+	InlineImageConversations: {
+		list: `SELECT c.id, COALESCE((SELECT MIN(cm.user_id) FROM conversation_members cm WHERE cm.conversation_id = c.id), 0), c.photo
+               FROM conversations c
+               WHERE c.photo LIKE 'data:image/%' AND c.id > ? ORDER BY c.id LIMIT ?`,
+		replace: `UPDATE conversations SET photo = ? WHERE id = ? AND photo = ?`,
+	},
+}
+
+// ListInlineImages restituisce al massimo limit immagini inline della tabella source, con ID della riga maggiore di
+// afterId e in ordine di ID
+func (db *appdbimpl) ListInlineImages(source string, afterId, limit int) ([]InlineImage, error) {
+	queries, ok := inlineImageQueries[source]
+	if !ok {
+		return nil, fmt.Errorf("tabella sconosciuta: %s", source)
+	}
+	rows, err := db.c.Query(queries.list, afterId, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var images []InlineImage
+	for rows.Next() {
+		image := InlineImage{Source: source}
+		if err := rows.Scan(&image.RowID, &image.OwnerID, &image.DataURL); err != nil {
+			return nil, err
+		}
+		images = append(images, image)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+// ReplaceInlineImages sostituisce i data URL con il loro Replacement in un'unica transazione. Le righe modificate nel
+// frattempo restano invariate; restituisce le immagini effettivamente sostituite.
+func (db *appdbimpl) ReplaceInlineImages(images []InlineImage) ([]InlineImage, error) {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return nil, err
+	}
+	// Dopo il commit il rollback non fa nulla
+	defer func() { _ = tx.Rollback() }()
+
+	var replaced []InlineImage
+	for _, image := range images {
+		queries, ok := inlineImageQueries[image.Source]
+		if !ok {
+			return nil, fmt.Errorf("tabella sconosciuta: %s", image.Source)
+		}
+		res, err := tx.Exec(queries.replace, image.Replacement, image.RowID, image.DataURL)
+		if err != nil {
+			return nil, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			replaced = append(replaced, image)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return replaced, nil
+}
+
+// Vacuum ricostruisce il file del database, restituendo al file system lo spazio liberato. Con il journal WAL le
+// pagine ricostruite passano dal file WAL, che viene quindi riportato nel database e svuotato. Su PostgreSQL vengono
+// riscritte le tabelle.
+func (db *appdbimpl) Vacuum() error {
+	if db.c.driver == DriverPostgres {
+		_, err := db.c.Exec(`VACUUM FULL`)
+		return err
+	}
+	if _, err := db.c.Exec(`VACUUM`); err != nil {
+		return err
+	}
+	_, err := db.c.Exec(`PRAGMA wal_checkpoint(TRUNCATE)`)
+	return err
+}
@@ -0,0 +1,74 @@
+package database
+
+import (
+	"fmt"
+)
+
+// legacyColumns sono le colonne aggiunte alle tabelle esistenti prima delle migrazioni versionate
+var legacyColumns = []struct{ table, column, definition string }{
+	{"messages", "reply_to_message_id", "INTEGER DEFAULT NULL"},
+	{"users", "password_hash", "TEXT DEFAULT NULL"},
+	{"messages", "edited_at", "DATETIME DEFAULT NULL"},
+	{"messages", "deleted_at", "DATETIME DEFAULT NULL"},
+	{"messages", "media_id", "TEXT DEFAULT NULL"},
+	{"media", "width", "INTEGER DEFAULT NULL"},
+	{"media", "height", "INTEGER DEFAULT NULL"},
+	{"media", "placeholder", "TEXT DEFAULT NULL"},
+}
+
+// adoptLegacySchema porta un database creato prima delle migrazioni versionate allo stato atteso dalla prima
+// migrazione: aggiunge le colonne mancanti alle tabelle esistenti e crea le ricevute dei messaggi già presenti. Le
+// tabelle mancanti vengono poi create dalla migrazione stessa.
+func adoptLegacySchema(tx *dbTx) error {
+	for _, c := range legacyColumns {
+		var tableExists, columnExists int
+		err := tx.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name=?`, c.table).Scan(&tableExists)
+		if err != nil {
+			return err
+		}
+		if tableExists == 0 {
+			continue
+		}
+		err = tx.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name=?`, c.table, c.column).Scan(&columnExists)
+		if err != nil {
+			return err
+		}
+		if columnExists == 0 {
+			if _, err := tx.Exec(`ALTER TABLE ` + c.table + ` ADD COLUMN ` + c.column + ` ` + c.definition); err != nil {
+				return fmt.Errorf("error adding %s.%s column: %w", c.table, c.column, err)
+			}
+		}
+	}
+
+	// Le ricevute per destinatario dei messaggi già presenti vengono ricavate dallo stato del messaggio
+	var receiptsExist int
+	err := tx.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='message_receipts'`).Scan(&receiptsExist)
+	if err != nil {
+		return err
+	}
+	if receiptsExist == 0 {
+		stmts := []string{
+			`CREATE TABLE message_receipts (
+                message_id INTEGER NOT NULL,
+                user_id INTEGER NOT NULL,
+                delivered_at DATETIME DEFAULT NULL,
+                read_at DATETIME DEFAULT NULL,
+                PRIMARY KEY (message_id, user_id),
+                FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE,
+                FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+            );`,
+			`INSERT INTO message_receipts (message_id, user_id, delivered_at, read_at)
+             SELECT m.id, cm.user_id,
+                    CASE WHEN m.status IN ('received', 'read') THEN m.timestamp END,
+                    CASE WHEN m.status = 'read' THEN m.timestamp END
+             FROM messages m
+             JOIN conversation_members cm ON cm.conversation_id = m.conversation_id AND cm.user_id != m.sender_id;`,
+		}
+		for _, stmt := range stmts {
+			if _, err := tx.Exec(stmt); err != nil {
+				return fmt.Errorf("error creating message_receipts table: %w", err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/rerikdev/WASAText/service/globaltime"
+	"github.com/rerikdev/WASAText/service/structures"
+)
+
+// ErrMediaNotFound viene restituito quando non esiste un media con l'ID richiesto
+var ErrMediaNotFound = errors.New("media non trovato")
+
+// CreateMedia salva i dati di un media caricato, con le sue miniature, e ne imposta la data di creazione; il
+// contenuto deve essere già nel blob store
+func (db *appdbimpl) CreateMedia(media *structures.Media) error {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return err
+	}
+	// Dopo il commit il rollback non fa nulla
+	defer func() { _ = tx.Rollback() }()
+
+	_, err = tx.Exec(
+		`INSERT INTO media (id, blob_key, uploader_id, content_type, size, created_at, width, height, placeholder)
+         VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		media.ID, media.BlobKey, media.UploaderID, media.ContentType, media.Size, globaltime.Now().Format(timestampLayout),
+		nullIfZero(media.Width), nullIfZero(media.Height), nullIfEmpty(media.Placeholder),
+	)
+	if err != nil {
+		return err
+	}
+	for _, thumb := range media.Thumbnails {
+		_, err = tx.Exec(
+			`INSERT INTO media_thumbnails (media_id, size, blob_key, content_type, width, height) VALUES (?, ?, ?, ?, ?, ?)`,
+			media.ID, thumb.Size, thumb.BlobKey, thumb.ContentType, thumb.Width, thumb.Height,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	// Riletto per avere la data nello stesso formato delle altre letture
+	stored, err := db.GetMedia(media.ID)
+	if err != nil {
+		return err
+	}
+	*media = *stored
+	return nil
+}
+
+// GetMedia restituisce i dati di un media e delle sue miniature, dalla più piccola
+func (db *appdbimpl) GetMedia(id string) (*structures.Media, error) {
+	var media structures.Media
+	err := db.c.QueryRow(
+		`SELECT id, blob_key, uploader_id, content_type, size, created_at,
+                COALESCE(width, 0), COALESCE(height, 0), COALESCE(placeholder, '')
+         FROM media WHERE id = ?`, id,
+	).Scan(&media.ID, &media.BlobKey, &media.UploaderID, &media.ContentType, &media.Size, &media.CreatedAt,
+		&media.Width, &media.Height, &media.Placeholder)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrMediaNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.c.Query(
+		`SELECT size, blob_key, content_type, width, height FROM media_thumbnails WHERE media_id = ? ORDER BY size`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var thumb structures.MediaThumbnail
+		if err := rows.Scan(&thumb.Size, &thumb.BlobKey, &thumb.ContentType, &thumb.Width, &thumb.Height); err != nil {
+			return nil, err
+		}
+		media.Thumbnails = append(media.Thumbnails, &thumb)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return &media, nil
+}
+
+// CanAccessMedia indica se l'utente può scaricare il media: se l'ha caricato, se è la foto di un utente o di un gruppo
+// di cui è membro, o se è allegato a un messaggio di una sua conversazione. I messaggi eliminati per tutti non
+// hanno più il media, quindi non contano.
+func (db *appdbimpl) CanAccessMedia(id string, userId int) (bool, error) {
+	// Le foto sono salvate con il percorso del media, come lo restituisce mediastore.Path
+	path := "/media/" + id
+	var ok bool
+	err := db.c.QueryRow(`
+        SELECT EXISTS (SELECT 1 FROM media WHERE id = ? AND uploader_id = ?)
+            OR EXISTS (SELECT 1 FROM users WHERE profile_picture = ?)
+            OR EXISTS (
+                SELECT 1 FROM conversations c
+                JOIN conversation_members cm ON cm.conversation_id = c.id
+                WHERE c.photo = ? AND cm.user_id = ?)
+            OR EXISTS (
+                SELECT 1 FROM messages m
+                JOIN conversation_members cm ON cm.conversation_id = m.conversation_id
+                WHERE m.media_id = ? AND cm.user_id = ?)`,
+		id, userId, path, path, userId, id, userId).Scan(&ok)
+	return ok, err
+}
+
+// UnusedBlobKeys restituisce, tra le chiavi indicate, quelle dei blob che non sono usati da nessun media né da
+// nessuna miniatura: lo stesso contenuto può essere condiviso da più media, quindi un blob si può eliminare solo quando
+// non ne resta nessuno
+func (db *appdbimpl) UnusedBlobKeys(keys []string) ([]string, error) {
+	args := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		args = append(args, key)
+	}
+
+	used := make(map[string]bool)
+	for _, chunk := range chunkArgs(args) {
+		for _, table := range []string{"media", "media_thumbnails"} {
+			rows, err := db.c.Query(`SELECT DISTINCT blob_key FROM `+table+` WHERE blob_key IN (`+placeholders(len(chunk))+`)`, chunk...)
+			if err != nil {
+				return nil, err
+			}
+			for rows.Next() {
+				var key string
+				if err := rows.Scan(&key); err != nil {
+					_ = rows.Close()
+					return nil, err
+				}
+				used[key] = true
+			}
+			// Next ha già chiuso rows
+			if err := rows.Err(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var unused []string
+	for _, key := range keys {
+		if !used[key] {
+			unused = append(unused, key)
+		}
+	}
+	return unused, nil
+}
+
+func nullIfZero(v int) interface{} {
+	if v == 0 {
+		return nil
+	}
+	return v
+}
+
+func nullIfEmpty(v string) interface{} {
+	if v == "" {
+		return nil
+	}
+	return v
+}
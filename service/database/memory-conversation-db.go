@@ -0,0 +1,437 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/rerikdev/WASAText/service/globaltime"
+	"github.com/rerikdev/WASAText/service/structures"
+)
+
+type memConversation struct {
+	id          int
+	name        string // vuoto per le chat 1:1
+	photo       string // vuoto per le chat 1:1
+	description string
+	isGroup     bool
+	// Impostazioni del gruppo (vedi group-role-db.go)
+	settings structures.GroupSettings
+	// Data e autore della creazione dei gruppi
+	createdAt time.Time
+	createdBy int
+}
+
+// sortedIds restituisce le chiavi di un insieme di ID in ordine crescente
+func sortedIds(set map[int]bool) []int {
+	ids := make([]int, 0, len(set))
+	for id := range set {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// sortedConversationIds restituisce gli ID delle conversazioni in ordine crescente
+func (db *memdb) sortedConversationIds() []int {
+	ids := make([]int, 0, len(db.conversations))
+	for id := range db.conversations {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// lastMessages restituisce l'ultimo messaggio di ogni conversazione, scorrendo i messaggi una volta sola
+func (db *memdb) lastMessages() map[int]*memMessage {
+	last := make(map[int]*memMessage)
+	for _, m := range db.messages {
+		if l, ok := last[m.conversationId]; !ok || l.before(m) {
+			last[m.conversationId] = m
+		}
+	}
+	return last
+}
+
+// preview restituisce contenuto e data del messaggio per le anteprime, vuoti se m è nil
+func (m *memMessage) preview() (string, string) {
+	if m == nil {
+		return "", ""
+	}
+	return m.content, memTimeString(m.timestamp)
+}
+
+// conversationMembers restituisce i membri della conversazione in ordine di ID
+func (db *memdb) conversationMembers(conversationId int) []structures.User {
+	var members []structures.User
+	for _, id := range sortedIds(db.members[conversationId]) {
+		if u, ok := db.users[id]; ok {
+			members = append(members, u.User)
+		}
+	}
+	return members
+}
+
+// addMember aggiunge l'utente alla conversazione e indica se non ne era già membro
+func (db *memdb) addMember(conversationId, userId int) bool {
+	if db.members[conversationId] == nil {
+		db.members[conversationId] = make(map[int]bool)
+	}
+	if db.members[conversationId][userId] {
+		return false
+	}
+	db.members[conversationId][userId] = true
+	return true
+}
+
+func (db *memdb) GetMembership(conversationId, userId int) (bool, bool, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	c, ok := db.conversations[conversationId]
+	if !ok {
+		return false, false, ErrConversationNotFound
+	}
+	return c.isGroup, db.members[conversationId][userId], nil
+}
+
+func (db *memdb) GetConversationMemberIds(conversationId int) ([]int, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if len(db.members[conversationId]) == 0 {
+		return nil, nil
+	}
+	return sortedIds(db.members[conversationId]), nil
+}
+
+func (db *memdb) CreateConversation(user1, user2 int) (int64, error) {
+	if user1 == user2 {
+		return 0, fmt.Errorf("non puoi creare una conversazione con te stesso")
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for _, id := range db.sortedConversationIds() {
+		if !db.conversations[id].isGroup && db.members[id][user1] && db.members[id][user2] {
+			return int64(id), nil
+		}
+	}
+
+	db.lastConversationId++
+	id := db.lastConversationId
+	db.conversations[id] = &memConversation{id: id}
+	db.addMember(id, user1)
+	db.addMember(id, user2)
+	return int64(id), nil
+}
+
+func (db *memdb) GetUserConversations(userId int) ([]*structures.ConversationPreview, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	last := db.lastMessages()
+	var previews []*structures.ConversationPreview
+	for _, id := range db.sortedConversationIds() {
+		if db.conversations[id].isGroup || !db.members[id][userId] {
+			continue
+		}
+		otherId := 0
+		for _, memberId := range sortedIds(db.members[id]) {
+			if memberId != userId {
+				otherId = memberId
+				break
+			}
+		}
+		other, ok := db.users[otherId]
+		if !ok {
+			continue
+		}
+		lastMsg, lastTime := last[id].preview()
+		previews = append(previews, &structures.ConversationPreview{
+			ID:              id,
+			OtherUserID:     otherId,
+			Username:        other.Username,
+			ProfilePicture:  other.ProfilePicture,
+			LastMessage:     lastMsg,
+			LastMessageTime: lastTime,
+		})
+	}
+
+	sort.SliceStable(previews, func(i, j int) bool {
+		return previews[i].LastMessageTime > previews[j].LastMessageTime
+	})
+	return previews, nil
+}
+
+func (db *memdb) AddToGroup(creatorID int, name string, photo string, usernames []string, options GroupOptions) (*structures.Conversation, error) {
+	if options.MaxMembers < 0 {
+		return nil, invalidGroupRequest("numero massimo di membri non valido")
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// Gli utenti vengono controllati prima di creare il gruppo, così in caso di errore non cambia nulla
+	creator, ok := db.users[creatorID]
+	if !ok {
+		return nil, invalidGroupRequest("utente %d non trovato", creatorID)
+	}
+	members := []structures.User{creator.User}
+	seen := map[int]bool{creatorID: true}
+	for _, username := range usernames {
+		u := db.userByName(username)
+		if u == nil {
+			return nil, invalidGroupRequest("utente %s non trovato", username)
+		}
+		if seen[u.ID] {
+			// Il creatore può essere tra gli utenti indicati, gli altri non possono ripetersi
+			if u.ID == creatorID {
+				continue
+			}
+			return nil, invalidGroupRequest("utente %s ripetuto", username)
+		}
+		seen[u.ID] = true
+		members = append(members, u.User)
+	}
+	if options.MaxMembers > 0 && len(members) > options.MaxMembers {
+		return nil, ErrGroupFull
+	}
+
+	db.lastConversationId++
+	id := db.lastConversationId
+	db.conversations[id] = &memConversation{
+		id:          id,
+		name:        name,
+		photo:       photo,
+		description: options.Description,
+		isGroup:     true,
+		settings:    structures.GroupSettings{MaxMembers: options.MaxMembers},
+		createdAt:   memTime(globaltime.Now()),
+		createdBy:   creatorID,
+	}
+	for _, u := range members {
+		db.addMember(id, u.ID)
+	}
+	db.setRole(id, creatorID, RoleOwner)
+	return &structures.Conversation{
+		ID:          id,
+		Name:        name,
+		Photo:       photo,
+		Description: options.Description,
+		IsGroup:     true,
+		Members:     members,
+	}, nil
+}
+
+func (db *memdb) ListGroups(userID int) ([]*structures.GroupPreview, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	last := db.lastMessages()
+	var groups []*structures.GroupPreview
+	for _, id := range db.sortedConversationIds() {
+		c := db.conversations[id]
+		if !c.isGroup || !db.members[id][userID] {
+			continue
+		}
+		lastMsg, lastTime := last[id].preview()
+		groups = append(groups, &structures.GroupPreview{
+			ID:              id,
+			Name:            c.name,
+			Photo:           c.photo,
+			Description:     c.description,
+			Members:         db.groupMembers(id),
+			Settings:        c.settings,
+			LastMessage:     lastMsg,
+			LastMessageTime: lastTime,
+		})
+	}
+	return groups, nil
+}
+
+// group restituisce il gruppo con l'ID dato, con gli stessi errori delle funzioni dei gruppi in group-db.go
+func (db *memdb) group(groupID int) (*memConversation, error) {
+	c, ok := db.conversations[groupID]
+	if !ok {
+		return nil, invalidGroupRequest("gruppo non trovato")
+	}
+	if !c.isGroup {
+		return nil, invalidGroupRequest("non è un gruppo")
+	}
+	return c, nil
+}
+
+func (db *memdb) LeaveGroup(groupID int, userID int) ([]*structures.Message, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, err := db.group(groupID); err != nil {
+		return nil, err
+	}
+	if !db.members[groupID][userID] {
+		return nil, nil
+	}
+	role := db.roleOf(groupID, userID)
+	db.removeMember(groupID, userID)
+
+	// Come in LeaveGroup su SQL: la proprietà passa al primo amministratore, o al primo membro
+	remaining := sortedIds(db.members[groupID])
+	if len(remaining) == 0 {
+		db.deleteGroup(groupID)
+		return nil, nil
+	}
+	if role == RoleOwner {
+		next := remaining[0]
+		for _, id := range remaining {
+			if db.roleOf(groupID, id) == RoleAdmin {
+				next = id
+				break
+			}
+		}
+		db.setRole(groupID, next, RoleOwner)
+	}
+	return db.newMessages(db.insertSystemMessage(groupID, userID, MessageKindMemberLeft, nil, "")), nil
+}
+
+func (db *memdb) DeleteGroup(groupID, actorID int) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, err := db.groupAccess(groupID, actorID); err != nil {
+		return err
+	}
+	if db.roleOf(groupID, actorID) != RoleOwner {
+		return ErrPermissionDenied
+	}
+	db.deleteGroup(groupID)
+	return nil
+}
+
+// deleteGroup elimina il gruppo e tutto ciò che gli appartiene, come deleteGroup in group-db.go
+func (db *memdb) deleteGroup(groupID int) {
+	for _, id := range db.groupOnlyMedia(groupID) {
+		delete(db.media, id)
+	}
+	for id, m := range db.messages {
+		if m.conversationId != groupID {
+			continue
+		}
+		for _, hidden := range db.hidden {
+			delete(hidden, id)
+		}
+		delete(db.reactions, id)
+		db.deleteEdits(id)
+		delete(db.receipts, id)
+		delete(db.pins, id)
+		delete(db.messages, id)
+	}
+	for token, i := range db.invites {
+		if i.conversationId == groupID {
+			delete(db.invites, token)
+		}
+	}
+	delete(db.members, groupID)
+	delete(db.preferences, groupID)
+	delete(db.roles, groupID)
+	delete(db.joinRequests, groupID)
+	delete(db.bans, groupID)
+	kept := db.events[:0]
+	for _, e := range db.events {
+		if e.conversationId != groupID {
+			kept = append(kept, e)
+		}
+	}
+	db.events = kept
+	delete(db.conversations, groupID)
+}
+
+// groupOnlyMedia restituisce i media usati dai messaggi o come foto del gruppo e da nient'altro, come l'omonima query
+// di group-db.go. I media vengono confrontati con le foto tramite il loro percorso.
+func (db *memdb) groupOnlyMedia(groupID int) []string {
+	used := make(map[string]bool)
+	for _, m := range db.messages {
+		if m.conversationId == groupID && m.mediaId != nil {
+			used["/media/"+*m.mediaId] = true
+		}
+	}
+	for _, c := range db.conversations {
+		if c.id == groupID {
+			used[c.photo] = true
+		}
+	}
+	for _, m := range db.messages {
+		if m.conversationId != groupID && m.mediaId != nil {
+			delete(used, "/media/"+*m.mediaId)
+		}
+	}
+	for _, c := range db.conversations {
+		if c.id != groupID {
+			delete(used, c.photo)
+		}
+	}
+	for _, u := range db.users {
+		delete(used, u.ProfilePicture)
+	}
+
+	var ids []string
+	for id := range db.media {
+		if used["/media/"+id] {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func (db *memdb) SetGroupName(groupID int, userID int, newName string) ([]*structures.Message, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	c, err := db.groupAccess(groupID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !db.access(c, userID).can(c.settings.OnlyAdminsEditInfo) {
+		return nil, ErrPermissionDenied
+	}
+	c.name = newName
+	return db.newMessages(db.insertSystemMessage(groupID, userID, MessageKindGroupRenamed, nil, newName)), nil
+}
+
+func (db *memdb) SetGroupPhoto(groupID int, userID int, photoUrl string) ([]*structures.Message, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	c, err := db.groupAccess(groupID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !db.access(c, userID).can(c.settings.OnlyAdminsEditInfo) {
+		return nil, ErrPermissionDenied
+	}
+	c.photo = photoUrl
+	return db.newMessages(db.insertSystemMessage(groupID, userID, MessageKindGroupPhotoChanged, nil, photoUrl)), nil
+}
+
+func (db *memdb) AddMembersToGroup(groupID int, userID int, usernames []string) ([]*structures.Message, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	c, err := db.groupAccess(groupID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !db.access(c, userID).can(c.settings.OnlyAdminsAddMembers) {
+		return nil, ErrPermissionDenied
+	}
+	// Gli utenti non trovati o banditi dal gruppo vengono ignorati, come quelli che sono già membri
+	var added []int
+	seen := make(map[int]bool)
+	for _, username := range usernames {
+		if u := db.userByName(username); u != nil && db.bans[groupID][u.ID] == nil && !db.members[groupID][u.ID] && !seen[u.ID] {
+			seen[u.ID] = true
+			added = append(added, u.ID)
+		}
+	}
+	if !db.hasRoom(c, len(added)) {
+		return nil, ErrGroupFull
+	}
+	var inserted []*memMessage
+	for i := range added {
+		db.addMember(groupID, added[i])
+		inserted = append(inserted, db.insertSystemMessage(groupID, userID, MessageKindMemberAdded, &added[i], ""))
+	}
+	return db.newMessages(inserted...), nil
+}
@@ -0,0 +1,375 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rerikdev/WASAText/service/globaltime"
+	"github.com/rerikdev/WASAText/service/structures"
+)
+
+// memdb è un'implementazione di AppDatabase che tiene tutti i dati in memoria, usata dai test e dalla demo
+// (DriverMemory). Ogni tabella dello schema SQL è una mappa indicizzata dalla chiave primaria e ogni metodo si comporta
+// come la query corrispondente su SQLite, con gli stessi errori. Un unico RWMutex protegge tutti i dati: ogni metodo
+// pubblico lo tiene per tutta la sua durata, come una transazione, mentre le funzioni non esportate assumono che sia già
+// tenuto. I dati restituiti sono sempre copie.
+type memdb struct {
+	mu sync.RWMutex
+
+	users         map[int]*memUser
+	conversations map[int]*memConversation
+	members       map[int]map[int]bool        // conversazione -> utenti membri
+	preferences   map[int]map[int]*memPrefs   // conversazione -> membro -> preferenze, se impostate
+	roles         map[int]map[int]string      // gruppo -> membro -> ruolo, se diverso da RoleMember
+	invites       map[string]*memInvite       // token -> link di invito
+	joinRequests  map[int]map[int]time.Time   // gruppo -> utente -> data della richiesta di ingresso
+	bans          map[int]map[int]*memBan     // gruppo -> utente bandito
+	messages      map[int]*memMessage         // ID -> messaggio
+	reactions     map[int]map[int]string      // messaggio -> utente -> emoji
+	receipts      map[int]map[int]*memReceipt // messaggio -> destinatario -> ricevuta
+	edits         []*memEdit                  // in ordine di ID
+	hidden        map[int]map[int]bool        // utente -> messaggi nascosti
+	pins          map[int]*memPin             // messaggio -> fissato
+	sessions      map[string]*memSession      // hash del token -> sessione
+	loginAttempts map[string]*memLoginAttempt // username -> tentativi falliti
+	events        []*memEvent                 // in ordine di ID
+	media         map[string]*structures.Media
+
+	// Ultimi ID assegnati, che come con AUTOINCREMENT non vengono mai riutilizzati
+	lastUserId         int
+	lastConversationId int
+	lastMessageId      int
+	lastEditId         int
+	lastEventId        int64
+}
+
+type memUser struct {
+	structures.User
+	passwordHash string // vuoto se l'account non ha una password
+}
+
+type memSession struct {
+	userId    int
+	expiresAt time.Time
+}
+
+type memLoginAttempt struct {
+	failures    int
+	lockedUntil *time.Time
+}
+
+// NewMemory returns an empty AppDatabase that keeps all data in memory. It is safe for concurrent use and behaves like
+// the SQLite one, except that the data is lost when the process exits and message search always matches substrings
+// (like SQLite without FTS5).
+func NewMemory() AppDatabase {
+	return &memdb{
+		users:         make(map[int]*memUser),
+		conversations: make(map[int]*memConversation),
+		members:       make(map[int]map[int]bool),
+		preferences:   make(map[int]map[int]*memPrefs),
+		roles:         make(map[int]map[int]string),
+		invites:       make(map[string]*memInvite),
+		joinRequests:  make(map[int]map[int]time.Time),
+		bans:          make(map[int]map[int]*memBan),
+		messages:      make(map[int]*memMessage),
+		reactions:     make(map[int]map[int]string),
+		receipts:      make(map[int]map[int]*memReceipt),
+		hidden:        make(map[int]map[int]bool),
+		pins:          make(map[int]*memPin),
+		sessions:      make(map[string]*memSession),
+		loginAttempts: make(map[string]*memLoginAttempt),
+		media:         make(map[string]*structures.Media),
+	}
+}
+
+func (db *memdb) Ping() error {
+	return nil
+}
+
+// memTime riduce t alla precisione con cui le date vengono salvate nelle colonne DATETIME: secondi, senza fuso orario
+func memTime(t time.Time) time.Time {
+	stored, _ := time.Parse(timestampLayout, t.Format(timestampLayout))
+	return stored
+}
+
+// memTimeString formatta una data come la restituisce database/sql quando la colonna viene letta in una stringa
+func memTimeString(t time.Time) string {
+	return t.Format(time.RFC3339Nano)
+}
+
+// memTimeStringPtr è come memTimeString, per le colonne che possono essere NULL
+func memTimeStringPtr(t *time.Time) *string {
+	if t == nil {
+		return nil
+	}
+	s := memTimeString(*t)
+	return &s
+}
+
+// memLike indica se s corrisponde al pattern di LIKE, senza distinguere maiuscole e minuscole. Con escaped il
+// carattere \ protegge quello successivo, come con ESCAPE '\'.
+func memLike(s, pattern string, escaped bool) bool {
+	var b strings.Builder
+	b.WriteString(`(?is)^`)
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		switch r := runes[i]; {
+		case escaped && r == '\\' && i+1 < len(runes):
+			i++
+			b.WriteString(regexp.QuoteMeta(string(runes[i])))
+		case r == '%':
+			b.WriteString(`.*`)
+		case r == '_':
+			b.WriteString(`.`)
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString(`$`)
+	return regexp.MustCompile(b.String()).MatchString(s)
+}
+
+// userByName restituisce l'utente con lo username dato, o nil
+func (db *memdb) userByName(username string) *memUser {
+	for _, u := range db.users {
+		if u.Username == username {
+			return u
+		}
+	}
+	return nil
+}
+
+// userByIdString restituisce l'utente con l'ID dato in forma di stringa, come nei parametri delle query, o nil
+func (db *memdb) userByIdString(userId string) *memUser {
+	id, err := strconv.Atoi(userId)
+	if err != nil {
+		return nil
+	}
+	return db.users[id]
+}
+
+func (db *memdb) CheckUserExistence(username string) (bool, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.userByName(username) != nil, nil
+}
+
+func (db *memdb) DoLogin(username, displayName, profilePicture, password string) (*structures.User, string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if u := db.userByName(username); u != nil {
+		if displayName != "" || profilePicture != "" {
+			return nil, "", fmt.Errorf("registrazione già effettuata")
+		}
+		if err := db.checkLoginThrottle(username); err != nil {
+			return nil, "", err
+		}
+		if u.passwordHash != "" {
+			if password == "" || !checkPassword(u.passwordHash, password) {
+				db.recordLoginFailure(username)
+				return nil, "", ErrInvalidCredentials
+			}
+		} else if password != "" {
+			hash, err := hashPassword(password)
+			if err != nil {
+				return nil, "", err
+			}
+			u.passwordHash = hash
+		}
+		delete(db.loginAttempts, username)
+		user := u.User
+		return &user, "login", nil
+	}
+
+	if displayName == "" || profilePicture == "" {
+		return nil, "", fmt.Errorf("per la registrazione servono displayName e profilePicture")
+	}
+	var passwordHash string
+	if password != "" {
+		hash, err := hashPassword(password)
+		if err != nil {
+			return nil, "", err
+		}
+		passwordHash = hash
+	}
+
+	db.lastUserId++
+	u := &memUser{
+		User: structures.User{
+			ID:             db.lastUserId,
+			Username:       username,
+			DisplayName:    displayName,
+			ProfilePicture: profilePicture,
+		},
+		passwordHash: passwordHash,
+	}
+	db.users[u.ID] = u
+	user := u.User
+	return &user, "register", nil
+}
+
+func (db *memdb) ChangePassword(userId int, currentPassword, newPassword string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	u, ok := db.users[userId]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if u.passwordHash != "" {
+		if err := db.checkLoginThrottle(u.Username); err != nil {
+			return err
+		}
+		if !checkPassword(u.passwordHash, currentPassword) {
+			db.recordLoginFailure(u.Username)
+			return ErrInvalidCredentials
+		}
+	}
+	hash, err := hashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+	u.passwordHash = hash
+	delete(db.loginAttempts, u.Username)
+	return nil
+}
+
+// checkLoginThrottle restituisce ErrTooManyAttempts se lo username è bloccato
+func (db *memdb) checkLoginThrottle(username string) error {
+	a, ok := db.loginAttempts[username]
+	if ok && a.lockedUntil != nil && a.lockedUntil.After(memTime(globaltime.Now())) {
+		return ErrTooManyAttempts
+	}
+	return nil
+}
+
+// recordLoginFailure registra un tentativo fallito e blocca lo username al raggiungimento di maxLoginFailures
+func (db *memdb) recordLoginFailure(username string) {
+	a, ok := db.loginAttempts[username]
+	if !ok {
+		a = &memLoginAttempt{}
+		db.loginAttempts[username] = a
+	}
+	a.failures++
+	if a.failures >= maxLoginFailures {
+		lockedUntil := memTime(globaltime.Now().Add(loginLockout))
+		a.failures, a.lockedUntil = 0, &lockedUntil
+	}
+}
+
+func (db *memdb) GetUserById(userId string) (*structures.User, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	u := db.userByIdString(userId)
+	if u == nil {
+		return nil, sql.ErrNoRows
+	}
+	user := u.User
+	return &user, nil
+}
+
+func (db *memdb) SetMyUserNameById(userId, newUsername string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.userByName(newUsername) != nil {
+		return fmt.Errorf("username già in uso")
+	}
+	if u := db.userByIdString(userId); u != nil {
+		u.Username = newUsername
+	}
+	return nil
+}
+
+func (db *memdb) SetMyPhotoById(userId, photoUrl string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if u := db.userByIdString(userId); u != nil {
+		u.ProfilePicture = photoUrl
+	}
+	return nil
+}
+
+func (db *memdb) SearchUsers(query string) ([]*structures.User, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	// Come su SQLite, i caratteri speciali di LIKE nella query non sono protetti
+	pattern := "%" + query + "%"
+	var users []*structures.User
+	for _, u := range db.users {
+		if memLike(u.Username, pattern, false) || memLike(u.DisplayName, pattern, false) {
+			user := u.User
+			users = append(users, &user)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+	if len(users) > 10 {
+		users = users[:10]
+	}
+	return users, nil
+}
+
+func (db *memdb) CreateSession(userId int, token string, expiresAt time.Time) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	now := memTime(globaltime.Now())
+	for hash, s := range db.sessions {
+		if !s.expiresAt.After(now) {
+			delete(db.sessions, hash)
+		}
+	}
+	hash := hashToken(token)
+	if _, ok := db.sessions[hash]; ok {
+		return fmt.Errorf("sessione già esistente")
+	}
+	db.sessions[hash] = &memSession{userId: userId, expiresAt: memTime(expiresAt)}
+	return nil
+}
+
+func (db *memdb) GetSessionUserId(token string) (int, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	s, ok := db.sessions[hashToken(token)]
+	if !ok || !s.expiresAt.After(memTime(globaltime.Now())) {
+		return 0, ErrSessionNotFound
+	}
+	return s.userId, nil
+}
+
+func (db *memdb) RevokeSession(token string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	delete(db.sessions, hashToken(token))
+	return nil
+}
+
+func (db *memdb) RevokeUserSessions(userId int) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for hash, s := range db.sessions {
+		if s.userId == userId {
+			delete(db.sessions, hash)
+		}
+	}
+	return nil
+}
+
+func (db *memdb) RevokeOtherSessions(userId int, token string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	keep := hashToken(token)
+	for hash, s := range db.sessions {
+		if s.userId == userId && hash != keep {
+			delete(db.sessions, hash)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package database
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/rerikdev/WASAText/service/structures"
+)
+
+type memEvent struct {
+	id             int64
+	eventType      string
+	conversationId int
+	payload        string
+	createdAt      time.Time
+	recipients     map[int]bool
+}
+
+func (db *memdb) AddEvent(ev *structures.Event, recipients []int) error {
+	payload, err := json.Marshal(ev.Data)
+	if err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.lastEventId++
+	stored := &memEvent{
+		id:             db.lastEventId,
+		eventType:      ev.Type,
+		conversationId: ev.ConversationID,
+		payload:        string(payload),
+		createdAt:      memTime(ev.Timestamp),
+		recipients:     make(map[int]bool, len(recipients)),
+	}
+	for _, userId := range recipients {
+		stored.recipients[userId] = true
+	}
+	db.events = append(db.events, stored)
+	ev.ID = stored.id
+	return nil
+}
+
+func (db *memdb) GetEventsSince(userId int, lastEventId int64, limit int) ([]*structures.Event, bool, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	// Stesso controllo di event-db.go: gli eventi eliminati sono sempre i più vecchi
+	complete := len(db.events) == 0 || lastEventId >= db.events[len(db.events)-1].id || lastEventId+1 >= db.events[0].id
+
+	var events []*structures.Event
+	for _, e := range db.events {
+		if len(events) >= limit {
+			break
+		}
+		if e.id <= lastEventId || !e.recipients[userId] {
+			continue
+		}
+		events = append(events, &structures.Event{
+			ID:             e.id,
+			Type:           e.eventType,
+			ConversationID: e.conversationId,
+			Data:           json.RawMessage(e.payload),
+			Timestamp:      e.createdAt,
+		})
+	}
+	return events, complete, nil
+}
+
+func (db *memdb) DeleteEventsBefore(t time.Time) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	cutoff := memTime(t)
+	kept := db.events[:0]
+	for _, e := range db.events {
+		if !e.createdAt.Before(cutoff) {
+			kept = append(kept, e)
+		}
+	}
+	db.events = kept
+	return nil
+}
@@ -0,0 +1,80 @@
+package database
+
+import (
+	"sort"
+	"time"
+
+	"github.com/rerikdev/WASAText/service/globaltime"
+	"github.com/rerikdev/WASAText/service/structures"
+)
+
+type memBan struct {
+	bannedBy int
+	bannedAt time.Time
+}
+
+func (db *memdb) BanFromGroup(groupID, actorID, userID int) ([]*structures.Message, error) {
+	if actorID == userID {
+		return nil, invalidGroupRequest("non puoi bandire te stesso dal gruppo")
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	c, err := db.groupAccess(groupID, actorID)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := db.users[userID]; !ok {
+		return nil, ErrUserNotFound
+	}
+	// roleOf restituisce RoleMember anche per chi non è membro, come BanFromGroup su SQL
+	if !db.access(c, actorID).canRemove(db.roleOf(groupID, userID)) {
+		return nil, ErrPermissionDenied
+	}
+	var inserted []*memMessage
+	if db.members[groupID][userID] {
+		db.removeMember(groupID, userID)
+		inserted = append(inserted, db.insertSystemMessage(groupID, actorID, MessageKindMemberRemoved, &userID, ""))
+	}
+	delete(db.joinRequests[groupID], userID)
+	if db.bans[groupID] == nil {
+		db.bans[groupID] = make(map[int]*memBan)
+	}
+	if db.bans[groupID][userID] == nil {
+		db.bans[groupID][userID] = &memBan{bannedBy: actorID, bannedAt: memTime(globaltime.Now())}
+	}
+	return db.newMessages(inserted...), nil
+}
+
+func (db *memdb) UnbanFromGroup(groupID, actorID, userID int) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.requireGroupAdmin(groupID, actorID); err != nil {
+		return err
+	}
+	if db.bans[groupID][userID] == nil {
+		return ErrBanNotFound
+	}
+	delete(db.bans[groupID], userID)
+	return nil
+}
+
+func (db *memdb) ListGroupBans(groupID, actorID int) ([]*structures.GroupBan, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if err := db.requireGroupAdmin(groupID, actorID); err != nil {
+		return nil, err
+	}
+	bans := []*structures.GroupBan{}
+	for userID, b := range db.bans[groupID] {
+		if u, ok := db.users[userID]; ok {
+			bans = append(bans, &structures.GroupBan{User: u.User, BannedBy: b.bannedBy, BannedAt: memTimeString(b.bannedAt)})
+		}
+	}
+	sort.Slice(bans, func(a, b int) bool {
+		if bans[a].BannedAt != bans[b].BannedAt {
+			return bans[a].BannedAt > bans[b].BannedAt
+		}
+		return bans[a].User.ID < bans[b].User.ID
+	})
+	return bans, nil
+}
@@ -0,0 +1,52 @@
+package database
+
+import (
+	"github.com/rerikdev/WASAText/service/structures"
+)
+
+// hasRoom indica se nel gruppo c'è posto per n nuovi membri, come checkGroupSize in group-info-db.go
+func (db *memdb) hasRoom(c *memConversation, n int) bool {
+	return c.settings.MaxMembers == 0 || len(db.members[c.id])+n <= c.settings.MaxMembers
+}
+
+func (db *memdb) SetGroupDescription(groupID int, userID int, description string) ([]*structures.Message, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	c, err := db.groupAccess(groupID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !db.access(c, userID).can(c.settings.OnlyAdminsEditInfo) {
+		return nil, ErrPermissionDenied
+	}
+	c.description = description
+	return db.newMessages(db.insertSystemMessage(groupID, userID, MessageKindGroupDescriptionChanged, nil, description)), nil
+}
+
+func (db *memdb) GetGroupInfo(groupID int) (*structures.GroupInfo, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	c, err := db.group(groupID)
+	if err != nil {
+		return nil, err
+	}
+	info := &structures.GroupInfo{
+		ID:          c.id,
+		Name:        c.name,
+		Photo:       c.photo,
+		Description: c.description,
+		Settings:    c.settings,
+		Members:     db.groupMembers(groupID),
+	}
+	if info.Members == nil {
+		info.Members = []structures.GroupMember{}
+	}
+	if !c.createdAt.IsZero() {
+		info.CreatedAt = memTimeStringPtr(&c.createdAt)
+	}
+	if u, ok := db.users[c.createdBy]; ok {
+		creator := u.User
+		info.CreatedBy = &creator
+	}
+	return info, nil
+}
@@ -0,0 +1,243 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/rerikdev/WASAText/service/globaltime"
+	"github.com/rerikdev/WASAText/service/structures"
+)
+
+type memInvite struct {
+	token            string
+	conversationId   int
+	createdBy        int
+	createdAt        time.Time
+	expiresAt        *time.Time
+	maxUses          int // 0 se illimitato
+	uses             int
+	requiresApproval bool
+}
+
+// expired indica se il link è scaduto o esaurito, come inviteExpiredCondition
+func (i *memInvite) expired(now time.Time) bool {
+	return (i.expiresAt != nil && !i.expiresAt.After(now)) || (i.maxUses > 0 && i.uses >= i.maxUses)
+}
+
+func (i *memInvite) toInvite() *structures.GroupInvite {
+	invite := &structures.GroupInvite{
+		Token:            i.token,
+		GroupID:          i.conversationId,
+		CreatedBy:        i.createdBy,
+		CreatedAt:        memTimeString(i.createdAt),
+		ExpiresAt:        memTimeStringPtr(i.expiresAt),
+		Uses:             i.uses,
+		RequiresApproval: i.requiresApproval,
+	}
+	if i.maxUses > 0 {
+		maxUses := i.maxUses
+		invite.MaxUses = &maxUses
+	}
+	return invite
+}
+
+// requireGroupAdmin è come requireGroupAdmin in group-invite-db.go
+func (db *memdb) requireGroupAdmin(groupID, userID int) error {
+	c, err := db.groupAccess(groupID, userID)
+	if err != nil {
+		return err
+	}
+	if !db.access(c, userID).isAdmin() {
+		return ErrPermissionDenied
+	}
+	return nil
+}
+
+func (db *memdb) CreateGroupInvite(groupID, actorID int, options GroupInviteOptions) (*structures.GroupInvite, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.requireGroupAdmin(groupID, actorID); err != nil {
+		return nil, err
+	}
+	now := memTime(globaltime.Now())
+	for token, i := range db.invites {
+		if i.conversationId == groupID && i.expired(now) {
+			delete(db.invites, token)
+		}
+	}
+	if _, ok := db.invites[options.Token]; ok {
+		return nil, fmt.Errorf("token %s già in uso", options.Token)
+	}
+
+	invite := &memInvite{
+		token:            options.Token,
+		conversationId:   groupID,
+		createdBy:        actorID,
+		createdAt:        now,
+		requiresApproval: options.RequiresApproval,
+	}
+	if options.ExpiresAt != nil {
+		expiresAt := memTime(*options.ExpiresAt)
+		invite.expiresAt = &expiresAt
+	}
+	if options.MaxUses > 0 {
+		invite.maxUses = options.MaxUses
+	}
+	db.invites[invite.token] = invite
+	return invite.toInvite(), nil
+}
+
+func (db *memdb) ListGroupInvites(groupID, actorID int) ([]*structures.GroupInvite, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if err := db.requireGroupAdmin(groupID, actorID); err != nil {
+		return nil, err
+	}
+	now := memTime(globaltime.Now())
+	var active []*memInvite
+	for _, i := range db.invites {
+		if i.conversationId == groupID && !i.expired(now) {
+			active = append(active, i)
+		}
+	}
+	sort.Slice(active, func(a, b int) bool {
+		if !active[a].createdAt.Equal(active[b].createdAt) {
+			return active[a].createdAt.After(active[b].createdAt)
+		}
+		return active[a].token < active[b].token
+	})
+	invites := make([]*structures.GroupInvite, 0, len(active))
+	for _, i := range active {
+		invites = append(invites, i.toInvite())
+	}
+	return invites, nil
+}
+
+func (db *memdb) RevokeGroupInvite(groupID, actorID int, token string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.requireGroupAdmin(groupID, actorID); err != nil {
+		return err
+	}
+	if i, ok := db.invites[token]; !ok || i.conversationId != groupID {
+		return ErrInviteNotFound
+	}
+	delete(db.invites, token)
+	return nil
+}
+
+func (db *memdb) PreviewGroupInvite(token string, userID int) (*structures.GroupInvitePreview, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	i, ok := db.invites[token]
+	if !ok {
+		return nil, ErrInviteNotFound
+	}
+	if i.expired(memTime(globaltime.Now())) {
+		return nil, ErrInviteExpired
+	}
+	c := db.conversations[i.conversationId]
+	_, pending := db.joinRequests[c.id][userID]
+	return &structures.GroupInvitePreview{
+		GroupID:          c.id,
+		Name:             c.name,
+		Photo:            c.photo,
+		MemberCount:      len(db.members[c.id]),
+		RequiresApproval: i.requiresApproval,
+		ExpiresAt:        memTimeStringPtr(i.expiresAt),
+		IsMember:         db.members[c.id][userID],
+		Pending:          pending,
+	}, nil
+}
+
+func (db *memdb) JoinGroupByInvite(token string, userID int) (int, bool, []*structures.Message, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	i, ok := db.invites[token]
+	if !ok {
+		return 0, false, nil, ErrInviteNotFound
+	}
+	groupID := i.conversationId
+	if db.members[groupID][userID] {
+		return groupID, false, nil, ErrAlreadyMember
+	}
+	if db.bans[groupID][userID] != nil {
+		return 0, false, nil, ErrUserBanned
+	}
+	if _, pending := db.joinRequests[groupID][userID]; i.requiresApproval && pending {
+		return groupID, true, nil, nil
+	}
+	now := memTime(globaltime.Now())
+	if i.expired(now) {
+		return 0, false, nil, ErrInviteExpired
+	}
+	if !i.requiresApproval && !db.hasRoom(db.conversations[groupID], 1) {
+		return 0, false, nil, ErrGroupFull
+	}
+	i.uses++
+
+	var inserted []*memMessage
+	if i.requiresApproval {
+		if db.joinRequests[groupID] == nil {
+			db.joinRequests[groupID] = make(map[int]time.Time)
+		}
+		db.joinRequests[groupID][userID] = now
+	} else {
+		db.addMember(groupID, userID)
+		delete(db.joinRequests[groupID], userID)
+		inserted = append(inserted, db.insertSystemMessage(groupID, userID, MessageKindMemberJoined, nil, ""))
+	}
+	return groupID, i.requiresApproval, db.newMessages(inserted...), nil
+}
+
+func (db *memdb) ListGroupJoinRequests(groupID, actorID int) ([]*structures.GroupJoinRequest, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if err := db.requireGroupAdmin(groupID, actorID); err != nil {
+		return nil, err
+	}
+	requests := []*structures.GroupJoinRequest{}
+	for userID, requestedAt := range db.joinRequests[groupID] {
+		if u, ok := db.users[userID]; ok {
+			requests = append(requests, &structures.GroupJoinRequest{User: u.User, RequestedAt: memTimeString(requestedAt)})
+		}
+	}
+	sort.Slice(requests, func(a, b int) bool {
+		if requests[a].RequestedAt != requests[b].RequestedAt {
+			return requests[a].RequestedAt < requests[b].RequestedAt
+		}
+		return requests[a].User.ID < requests[b].User.ID
+	})
+	return requests, nil
+}
+
+func (db *memdb) ApproveGroupJoinRequest(groupID, actorID, userID int) ([]*structures.Message, error) {
+	return db.resolveJoinRequest(groupID, actorID, userID, true)
+}
+
+func (db *memdb) RejectGroupJoinRequest(groupID, actorID, userID int) error {
+	_, err := db.resolveJoinRequest(groupID, actorID, userID, false)
+	return err
+}
+
+func (db *memdb) resolveJoinRequest(groupID, actorID, userID int, approve bool) ([]*structures.Message, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.requireGroupAdmin(groupID, actorID); err != nil {
+		return nil, err
+	}
+	if _, ok := db.joinRequests[groupID][userID]; !ok {
+		return nil, ErrJoinRequestNotFound
+	}
+	// Se il gruppo è pieno la richiesta resta in attesa
+	if approve && !db.members[groupID][userID] && !db.hasRoom(db.conversations[groupID], 1) {
+		return nil, ErrGroupFull
+	}
+	delete(db.joinRequests[groupID], userID)
+	var inserted []*memMessage
+	if approve && db.addMember(groupID, userID) {
+		inserted = append(inserted, db.insertSystemMessage(groupID, actorID, MessageKindMemberAdded, &userID, ""))
+	}
+	return db.newMessages(inserted...), nil
+}
@@ -0,0 +1,120 @@
+package database
+
+import "github.com/rerikdev/WASAText/service/structures"
+
+// roleOf restituisce il ruolo del membro nella conversazione: nelle chat 1:1 tutti sono RoleMember, come il valore
+// predefinito della colonna conversation_members.role
+func (db *memdb) roleOf(conversationId, userId int) string {
+	if role, ok := db.roles[conversationId][userId]; ok {
+		return role
+	}
+	return RoleMember
+}
+
+// setRole cambia il ruolo di un membro
+func (db *memdb) setRole(conversationId, userId int, role string) {
+	if role == RoleMember {
+		delete(db.roles[conversationId], userId)
+		return
+	}
+	if db.roles[conversationId] == nil {
+		db.roles[conversationId] = make(map[int]string)
+	}
+	db.roles[conversationId][userId] = role
+}
+
+// removeMember toglie l'utente dalla conversazione insieme al suo ruolo e alle sue preferenze, come la cancellazione
+// della riga di conversation_members
+func (db *memdb) removeMember(conversationId, userId int) {
+	delete(db.members[conversationId], userId)
+	delete(db.preferences[conversationId], userId)
+	delete(db.roles[conversationId], userId)
+}
+
+// groupMembers restituisce i membri del gruppo con il loro ruolo, in ordine di ID
+func (db *memdb) groupMembers(groupID int) []structures.GroupMember {
+	var members []structures.GroupMember
+	for _, u := range db.conversationMembers(groupID) {
+		members = append(members, structures.GroupMember{User: u, Role: db.roleOf(groupID, u.ID)})
+	}
+	return members
+}
+
+// access restituisce il ruolo dell'utente nella conversazione c insieme alle impostazioni, come getGroupAccess
+func (db *memdb) access(c *memConversation, userId int) *groupAccess {
+	return &groupAccess{role: db.roleOf(c.id, userId), settings: c.settings}
+}
+
+// groupAccess restituisce il gruppo se l'utente ne è membro, con gli stessi errori di getGroupAccess
+func (db *memdb) groupAccess(groupID, userID int) (*memConversation, error) {
+	c, err := db.group(groupID)
+	if err != nil {
+		return nil, err
+	}
+	if !db.members[groupID][userID] {
+		return nil, ErrPermissionDenied
+	}
+	return c, nil
+}
+
+func (db *memdb) SetGroupMemberRole(groupID, actorID, userID int, role string) error {
+	if role != RoleAdmin && role != RoleMember {
+		return invalidGroupRequest("ruolo non valido: %s", role)
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	c, err := db.groupAccess(groupID, actorID)
+	if err != nil {
+		return err
+	}
+	if !db.members[groupID][userID] {
+		return ErrMemberNotFound
+	}
+	if !db.access(c, actorID).canChangeRole(db.roleOf(groupID, userID), role, actorID == userID) {
+		return ErrPermissionDenied
+	}
+	db.setRole(groupID, userID, role)
+	return nil
+}
+
+func (db *memdb) RemoveGroupMember(groupID, actorID, userID int) ([]*structures.Message, error) {
+	if actorID == userID {
+		return nil, invalidGroupRequest("non puoi rimuovere te stesso dal gruppo")
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	c, err := db.groupAccess(groupID, actorID)
+	if err != nil {
+		return nil, err
+	}
+	if !db.members[groupID][userID] {
+		return nil, ErrMemberNotFound
+	}
+	if !db.access(c, actorID).canRemove(db.roleOf(groupID, userID)) {
+		return nil, ErrPermissionDenied
+	}
+	db.removeMember(groupID, userID)
+	return db.newMessages(db.insertSystemMessage(groupID, actorID, MessageKindMemberRemoved, &userID, "")), nil
+}
+
+func (db *memdb) SetGroupSettings(groupID, actorID int, update GroupSettingsUpdate) (*structures.GroupSettings, error) {
+	if update.MaxMembers != nil && *update.MaxMembers < 0 {
+		return nil, invalidGroupRequest("numero massimo di membri non valido")
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	c, err := db.groupAccess(groupID, actorID)
+	if err != nil {
+		return nil, err
+	}
+	if !db.access(c, actorID).isAdmin() {
+		return nil, ErrPermissionDenied
+	}
+	settings := c.settings
+	update.apply(&settings)
+	if settings.MaxMembers > 0 && len(db.members[groupID]) > settings.MaxMembers {
+		return nil, invalidGroupRequest("il gruppo ha più di %d membri", settings.MaxMembers)
+	}
+	c.settings = settings
+	return &settings, nil
+}
@@ -0,0 +1,160 @@
+package database
+
+import (
+	"sort"
+	"time"
+
+	"github.com/rerikdev/WASAText/service/globaltime"
+	"github.com/rerikdev/WASAText/service/structures"
+)
+
+type memPrefs struct {
+	muted    bool
+	pinnedAt *time.Time
+}
+
+// prefs restituisce le preferenze del membro, creandole se non esistono ancora
+func (db *memdb) prefs(conversationId, userId int) *memPrefs {
+	if db.preferences[conversationId] == nil {
+		db.preferences[conversationId] = make(map[int]*memPrefs)
+	}
+	p, ok := db.preferences[conversationId][userId]
+	if !ok {
+		p = &memPrefs{}
+		db.preferences[conversationId][userId] = p
+	}
+	return p
+}
+
+func (db *memdb) GetInbox(userId int) ([]*structures.InboxEntry, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	// Una sola passata sui messaggi trova l'ultimo messaggio visibile all'utente e conta i non letti di ogni conversazione
+	last := make(map[int]*memMessage)
+	unread := make(map[int]int)
+	for _, m := range db.messages {
+		if !db.members[m.conversationId][userId] || db.hidden[userId][m.id] {
+			continue
+		}
+		if l, ok := last[m.conversationId]; !ok || l.before(m) {
+			last[m.conversationId] = m
+		}
+		if r, ok := db.receipts[m.id][userId]; ok && r.readAt == nil && m.deletedAt == nil {
+			unread[m.conversationId]++
+		}
+	}
+
+	entries := []*structures.InboxEntry{}
+	lastOf := make(map[*structures.InboxEntry]*memMessage)
+	for _, id := range db.sortedConversationIds() {
+		c := db.conversations[id]
+		if !db.members[id][userId] {
+			continue
+		}
+		entry := &structures.InboxEntry{ID: id, UnreadCount: unread[id]}
+		if c.isGroup {
+			entry.Type, entry.Title, entry.Avatar = "group", c.name, c.photo
+		} else {
+			var other *memUser
+			for _, memberId := range sortedIds(db.members[id]) {
+				if memberId != userId {
+					other = db.users[memberId]
+					break
+				}
+			}
+			if other == nil {
+				continue
+			}
+			entry.Type, entry.Title, entry.Avatar, entry.OtherUserID = "direct", other.DisplayName, other.ProfilePicture, other.ID
+			if entry.Title == "" {
+				entry.Title = other.Username
+			}
+		}
+		if p, ok := db.preferences[id][userId]; ok {
+			entry.Muted = p.muted
+			entry.PinnedAt = memTimeStringPtr(p.pinnedAt)
+			entry.Pinned = p.pinnedAt != nil
+		}
+		if m, ok := last[id]; ok {
+			preview := &structures.MessagePreview{
+				ID:        m.id,
+				MediaType: m.mediaType,
+				Timestamp: memTimeString(m.timestamp),
+				DeletedAt: memTimeStringPtr(m.deletedAt),
+				Kind:      m.kind,
+				Target:    db.targetOf(m),
+			}
+			if sender, ok := db.users[m.senderId]; ok {
+				preview.Sender = sender.User
+			}
+			text := m.content
+			if pinned, ok := db.pinnedMessageOf(m); ok {
+				// Come nella lista dei messaggi, il messaggio fissato eliminato o nascosto non si vede
+				text = ""
+				if pinned.deletedAt == nil && !db.hidden[userId][pinned.id] {
+					text = pinned.content
+				}
+			}
+			if !isInlineImage(text) {
+				preview.Text, preview.Truncated = truncatePreview(text)
+			}
+			entry.LastMessage = preview
+			lastOf[entry] = m
+		}
+		entries = append(entries, entry)
+	}
+
+	// Stesso ordine di GetInbox in inbox-db.go
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := db.preferences[entries[i].ID][userId], db.preferences[entries[j].ID][userId]
+		var pa, pb *time.Time
+		if a != nil {
+			pa = a.pinnedAt
+		}
+		if b != nil {
+			pb = b.pinnedAt
+		}
+		if (pa == nil) != (pb == nil) {
+			return pa != nil
+		}
+		if pa != nil && !pa.Equal(*pb) {
+			return pa.After(*pb)
+		}
+		la, lb := lastOf[entries[i]], lastOf[entries[j]]
+		if (la == nil) != (lb == nil) {
+			return la != nil
+		}
+		if la != nil && la != lb {
+			return lb.before(la)
+		}
+		return entries[i].ID > entries[j].ID
+	})
+	return entries, nil
+}
+
+func (db *memdb) SetConversationMuted(conversationId, userId int, muted bool) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if !db.members[conversationId][userId] {
+		return ErrConversationNotFound
+	}
+	db.prefs(conversationId, userId).muted = muted
+	return nil
+}
+
+func (db *memdb) SetConversationPinned(conversationId, userId int, pinned bool) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if !db.members[conversationId][userId] {
+		return ErrConversationNotFound
+	}
+	p := db.prefs(conversationId, userId)
+	if !pinned {
+		p.pinnedAt = nil
+	} else if p.pinnedAt == nil {
+		now := memTime(globaltime.Now())
+		p.pinnedAt = &now
+	}
+	return nil
+}
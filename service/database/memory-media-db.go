@@ -0,0 +1,188 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/rerikdev/WASAText/service/globaltime"
+	"github.com/rerikdev/WASAText/service/structures"
+)
+
+// copyMedia restituisce una copia del media e delle sue miniature
+func copyMedia(media *structures.Media) *structures.Media {
+	c := *media
+	c.Thumbnails = nil
+	for _, thumb := range media.Thumbnails {
+		t := *thumb
+		c.Thumbnails = append(c.Thumbnails, &t)
+	}
+	return &c
+}
+
+func (db *memdb) CreateMedia(media *structures.Media) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.media[media.ID]; ok {
+		return fmt.Errorf("media %s già esistente", media.ID)
+	}
+	sizes := make(map[int]bool)
+	for _, thumb := range media.Thumbnails {
+		if sizes[thumb.Size] {
+			return fmt.Errorf("miniatura di %d pixel ripetuta", thumb.Size)
+		}
+		sizes[thumb.Size] = true
+	}
+
+	stored := copyMedia(media)
+	stored.CreatedAt = memTimeString(memTime(globaltime.Now()))
+	sort.Slice(stored.Thumbnails, func(i, j int) bool { return stored.Thumbnails[i].Size < stored.Thumbnails[j].Size })
+	db.media[media.ID] = stored
+	*media = *copyMedia(stored)
+	return nil
+}
+
+func (db *memdb) GetMedia(id string) (*structures.Media, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	media, ok := db.media[id]
+	if !ok {
+		return nil, ErrMediaNotFound
+	}
+	return copyMedia(media), nil
+}
+
+func (db *memdb) UnusedBlobKeys(keys []string) ([]string, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	used := make(map[string]bool)
+	for _, media := range db.media {
+		used[media.BlobKey] = true
+		for _, thumb := range media.Thumbnails {
+			used[thumb.BlobKey] = true
+		}
+	}
+	var unused []string
+	for _, key := range keys {
+		if !used[key] {
+			unused = append(unused, key)
+		}
+	}
+	return unused, nil
+}
+
+func (db *memdb) CanAccessMedia(id string, userId int) (bool, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if media, ok := db.media[id]; ok && media.UploaderID == userId {
+		return true, nil
+	}
+	path := "/media/" + id
+	for _, u := range db.users {
+		if u.ProfilePicture == path {
+			return true, nil
+		}
+	}
+	for _, c := range db.conversations {
+		if c.photo == path && db.members[c.id][userId] {
+			return true, nil
+		}
+	}
+	for _, m := range db.messages {
+		if m.mediaId != nil && *m.mediaId == id && db.members[m.conversationId][userId] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// isInlineImage indica se il valore è un data URL di un'immagine, come il LIKE 'data:image/%' delle query SQL
+func isInlineImage(s string) bool {
+	return strings.HasPrefix(strings.ToLower(s), "data:image/")
+}
+
+func (db *memdb) ListInlineImages(source string, afterId, limit int) ([]InlineImage, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var images []InlineImage
+	switch source {
+	case InlineImageMessages:
+		for _, m := range db.messages {
+			if m.id > afterId && isInlineImage(m.content) {
+				images = append(images, InlineImage{Source: source, RowID: m.id, OwnerID: m.senderId, DataURL: m.content})
+			}
+		}
+	case InlineImageUsers:
+		for _, u := range db.users {
+			if u.ID > afterId && isInlineImage(u.ProfilePicture) {
+				images = append(images, InlineImage{Source: source, RowID: u.ID, OwnerID: u.ID, DataURL: u.ProfilePicture})
+			}
+		}
+	case InlineImageConversations:
+		for _, c := range db.conversations {
+			if c.id > afterId && isInlineImage(c.photo) {
+				ownerId := 0
+				if ids := sortedIds(db.members[c.id]); len(ids) > 0 {
+					ownerId = ids[0]
+				}
+				images = append(images, InlineImage{Source: source, RowID: c.id, OwnerID: ownerId, DataURL: c.photo})
+			}
+		}
+	default:
+		return nil, fmt.Errorf("tabella sconosciuta: %s", source)
+	}
+
+	sort.Slice(images, func(i, j int) bool { return images[i].RowID < images[j].RowID })
+	if len(images) > limit {
+		images = images[:limit]
+	}
+	return images, nil
+}
+
+func (db *memdb) ReplaceInlineImages(images []InlineImage) ([]InlineImage, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// Le tabelle vengono controllate prima di modificare qualcosa, così un errore non lascia modifiche parziali
+	for _, image := range images {
+		if _, ok := inlineImageQueries[image.Source]; !ok {
+			return nil, fmt.Errorf("tabella sconosciuta: %s", image.Source)
+		}
+	}
+
+	var replaced []InlineImage
+	for _, image := range images {
+		done := false
+		switch image.Source {
+		case InlineImageMessages:
+			if m, ok := db.messages[image.RowID]; ok && m.content == image.DataURL {
+				mediaId := image.Replacement
+				m.content, m.mediaId, m.mediaType = "", &mediaId, "image"
+				done = true
+			}
+		case InlineImageUsers:
+			if u, ok := db.users[image.RowID]; ok && u.ProfilePicture == image.DataURL {
+				u.ProfilePicture = image.Replacement
+				done = true
+			}
+		case InlineImageConversations:
+			if c, ok := db.conversations[image.RowID]; ok && c.photo == image.DataURL {
+				c.photo = image.Replacement
+				done = true
+			}
+		}
+		if done {
+			replaced = append(replaced, image)
+		}
+	}
+	return replaced, nil
+}
+
+// Vacuum non fa nulla: la memoria liberata viene recuperata dal garbage collector
+func (db *memdb) Vacuum() error {
+	return nil
+}
@@ -0,0 +1,771 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rerikdev/WASAText/service/globaltime"
+	"github.com/rerikdev/WASAText/service/structures"
+)
+
+type memMessage struct {
+	id             int
+	conversationId int
+	senderId       int
+	content        string
+	isForwarded    bool
+	mediaType      string
+	status         string
+	timestamp      time.Time
+	replyTo        *int
+	editedAt       *time.Time
+	deletedAt      *time.Time
+	mediaId        *string
+	kind           string
+	targetId       *int // utente su cui è stata compiuta l'azione, per i messaggi di sistema
+	// Thread di cui fa parte la risposta e, per il messaggio iniziale, risposte non eliminate e data dell'ultima
+	threadRoot  *int
+	replyCount  int
+	lastReplyAt *time.Time
+}
+
+// before indica se il messaggio precede o nella cronologia, ordinata per (timestamp, id)
+func (m *memMessage) before(o *memMessage) bool {
+	if !m.timestamp.Equal(o.timestamp) {
+		return m.timestamp.Before(o.timestamp)
+	}
+	return m.id < o.id
+}
+
+// beforeCursor e afterCursor confrontano il messaggio con un cursore di paginazione
+func (m *memMessage) beforeCursor(c MessageCursor) bool {
+	ts := memTime(c.Timestamp)
+	return m.timestamp.Before(ts) || (m.timestamp.Equal(ts) && m.id < c.ID)
+}
+
+func (m *memMessage) afterCursor(c MessageCursor) bool {
+	ts := memTime(c.Timestamp)
+	return m.timestamp.After(ts) || (m.timestamp.Equal(ts) && m.id > c.ID)
+}
+
+type memReceipt struct {
+	deliveredAt *time.Time
+	readAt      *time.Time
+}
+
+type memEdit struct {
+	id         int
+	messageId  int
+	content    string
+	replacedAt time.Time
+}
+
+func copyIntPtr(p *int) *int {
+	if p == nil {
+		return nil
+	}
+	v := *p
+	return &v
+}
+
+func copyStringPtr(p *string) *string {
+	if p == nil {
+		return nil
+	}
+	v := *p
+	return &v
+}
+
+// conversationMessages restituisce i messaggi della conversazione dal più vecchio al più recente
+func (db *memdb) conversationMessages(conversationId int) []*memMessage {
+	var messages []*memMessage
+	for _, m := range db.messages {
+		if m.conversationId == conversationId {
+			messages = append(messages, m)
+		}
+	}
+	sort.Slice(messages, func(i, j int) bool { return messages[i].before(messages[j]) })
+	return messages
+}
+
+// toMessage converte il messaggio nella struttura restituita dalle API, senza reazioni né messaggio a cui risponde.
+// Come il JOIN con users, restituisce false se il mittente non esiste.
+func (db *memdb) toMessage(m *memMessage) (*structures.Message, bool) {
+	sender, ok := db.users[m.senderId]
+	if !ok {
+		return nil, false
+	}
+	msg := &structures.Message{
+		ID:               m.id,
+		ConversationID:   m.conversationId,
+		Content:          m.content,
+		IsForwarded:      m.isForwarded,
+		MediaType:        m.mediaType,
+		Sender:           sender.User,
+		Status:           m.status,
+		Timestamp:        memTimeString(m.timestamp),
+		ReplyToMessageID: copyIntPtr(m.replyTo),
+		EditedAt:         memTimeStringPtr(m.editedAt),
+		DeletedAt:        memTimeStringPtr(m.deletedAt),
+		MediaID:          copyStringPtr(m.mediaId),
+		Kind:             m.kind,
+		Target:           db.targetOf(m),
+		ThreadRootID:     copyIntPtr(m.threadRoot),
+	}
+	if m.replyCount > 0 && m.lastReplyAt != nil {
+		msg.Thread = &structures.ThreadSummary{ReplyCount: m.replyCount, LastReplyAt: memTimeString(*m.lastReplyAt)}
+	}
+	if m.mediaId != nil {
+		if md, ok := db.media[*m.mediaId]; ok {
+			msg.Width, msg.Height, msg.Placeholder = md.Width, md.Height, md.Placeholder
+		}
+	}
+	return msg, true
+}
+
+// targetOf restituisce l'utente su cui è stata compiuta l'azione di un messaggio di sistema, o nil
+func (db *memdb) targetOf(m *memMessage) *structures.User {
+	if m.targetId == nil {
+		return nil
+	}
+	u, ok := db.users[*m.targetId]
+	if !ok {
+		return nil
+	}
+	target := u.User
+	return &target
+}
+
+// reactionsOf restituisce le reazioni al messaggio in ordine di utente, nil se non ce ne sono
+func (db *memdb) reactionsOf(messageId int) []*structures.Reaction {
+	byUser := db.reactions[messageId]
+	userIds := make([]int, 0, len(byUser))
+	for userId := range byUser {
+		userIds = append(userIds, userId)
+	}
+	sort.Ints(userIds)
+
+	var reactions []*structures.Reaction
+	for _, userId := range userIds {
+		u, ok := db.users[userId]
+		if !ok {
+			continue
+		}
+		reactions = append(reactions, &structures.Reaction{
+			MessageId: messageId,
+			UserId:    userId,
+			Emoji:     byUser[userId],
+			User:      u.User,
+		})
+	}
+	return reactions
+}
+
+func (db *memdb) SendMessage(conversationId, senderId int, content, mediaType string, mediaId *string, isForwarded bool, replyToMessageId *int) (*structures.Message, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.conversations[conversationId]; !ok {
+		return nil, fmt.Errorf("conversazione non trovata")
+	}
+	if !db.members[conversationId][senderId] {
+		return nil, fmt.Errorf("utente non autorizzato a inviare messaggi in questa conversazione")
+	}
+	if c := db.conversations[conversationId]; !db.access(c, senderId).can(c.settings.OnlyAdminsSend) {
+		return nil, ErrPermissionDenied
+	}
+	var threadRoot *int
+	if replyToMessageId != nil {
+		reply, ok := db.messages[*replyToMessageId]
+		if !ok || reply.conversationId != conversationId || reply.kind != MessageKindUser || reply.deletedAt != nil {
+			return nil, fmt.Errorf("messaggio di risposta non trovato nella conversazione")
+		}
+		root := reply.id
+		if reply.threadRoot != nil {
+			root = *reply.threadRoot
+		}
+		threadRoot = &root
+	}
+
+	db.lastMessageId++
+	m := &memMessage{
+		id:             db.lastMessageId,
+		conversationId: conversationId,
+		senderId:       senderId,
+		content:        content,
+		isForwarded:    isForwarded,
+		mediaType:      mediaType,
+		status:         "sent",
+		timestamp:      memTime(globaltime.Now()),
+		replyTo:        copyIntPtr(replyToMessageId),
+		mediaId:        copyStringPtr(mediaId),
+		kind:           MessageKindUser,
+		threadRoot:     threadRoot,
+	}
+	db.messages[m.id] = m
+	if threadRoot != nil {
+		db.updateThread(*threadRoot)
+	}
+
+	// Una ricevuta per ogni destinatario, come in createReceipts
+	for userId := range db.members[conversationId] {
+		if userId == senderId {
+			continue
+		}
+		if db.receipts[m.id] == nil {
+			db.receipts[m.id] = make(map[int]*memReceipt)
+		}
+		db.receipts[m.id][userId] = &memReceipt{}
+	}
+
+	msg, _ := db.toMessage(m)
+	db.loadRepliesAndReactions([]*structures.Message{msg}, map[int]*structures.Message{msg.ID: msg})
+	return msg, nil
+}
+
+func (db *memdb) GetMessages(conversationId int) ([]*structures.Message, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.getMessages(conversationId)
+}
+
+func (db *memdb) getMessages(conversationId int) ([]*structures.Message, error) {
+	if _, ok := db.conversations[conversationId]; !ok {
+		return nil, fmt.Errorf("conversazione non trovata")
+	}
+
+	var messages []*structures.Message
+	messageMap := make(map[int]*structures.Message)
+	for _, m := range db.conversationMessages(conversationId) {
+		msg, ok := db.toMessage(m)
+		if !ok {
+			continue
+		}
+		messages = append(messages, msg)
+		messageMap[msg.ID] = msg
+	}
+	db.loadRepliesAndReactions(messages, messageMap)
+	return messages, nil
+}
+
+func (db *memdb) GetMessagesPage(conversationId, userId int, query MessagePageQuery) (*structures.MessagePage, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if _, ok := db.conversations[conversationId]; !ok {
+		return nil, ErrConversationNotFound
+	}
+	return db.messagesPage(conversationId, userId, func(m *memMessage) bool {
+		return !query.CollapseThreads || m.threadRoot == nil
+	}, query), nil
+}
+
+// messagesPage restituisce una pagina dei messaggi visibili all'utente per cui filter è vera, come l'omonima funzione
+// di message-db.go
+func (db *memdb) messagesPage(conversationId, userId int, filter func(m *memMessage) bool, query MessagePageQuery) *structures.MessagePage {
+	var selected []*memMessage
+	for _, m := range db.conversationMessages(conversationId) {
+		if db.hidden[userId][m.id] || !filter(m) {
+			continue
+		}
+		if query.After != nil && !m.afterCursor(*query.After) {
+			continue
+		}
+		if query.After == nil && query.Before != nil && !m.beforeCursor(*query.Before) {
+			continue
+		}
+		selected = append(selected, m)
+	}
+
+	// Come nella query SQL: i primi Limit+1 dopo After, altrimenti gli ultimi Limit+1 presi dal più recente
+	descending := query.After == nil
+	if descending {
+		for i, j := 0, len(selected)-1; i < j; i, j = i+1, j-1 {
+			selected[i], selected[j] = selected[j], selected[i]
+		}
+	}
+
+	messages := []*structures.Message{}
+	messageMap := make(map[int]*structures.Message)
+	for _, m := range selected {
+		if len(messages) > query.Limit {
+			break
+		}
+		msg, ok := db.toMessage(m)
+		if !ok {
+			continue
+		}
+		messages = append(messages, msg)
+		messageMap[msg.ID] = msg
+	}
+
+	page := &structures.MessagePage{}
+	if len(messages) > query.Limit {
+		page.HasMore = true
+		delete(messageMap, messages[query.Limit].ID)
+		messages = messages[:query.Limit]
+	}
+	if descending {
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+		}
+	}
+	if len(messages) > 0 {
+		first, last := messages[0], messages[len(messages)-1]
+		page.PrevCursor = messageCursorOf(first.Timestamp, first.ID).String()
+		page.NextCursor = messageCursorOf(last.Timestamp, last.ID).String()
+	}
+
+	db.loadRepliesAndReactions(messages, messageMap)
+	db.loadThreadUnread(userId, messages)
+	page.Messages = messages
+	return page
+}
+
+// loadRepliesAndReactions popola i messaggi a cui si risponde e le reazioni, come l'omonima funzione di message-db.go
+func (db *memdb) loadRepliesAndReactions(messages []*structures.Message, messageMap map[int]*structures.Message) {
+	for _, msg := range messages {
+		if msg.ReplyToMessageID != nil {
+			replyMsg, exists := messageMap[*msg.ReplyToMessageID]
+			if !exists {
+				if m, err := db.getMessageById(msg.ConversationID, *msg.ReplyToMessageID); err == nil {
+					replyMsg, exists = m, true
+				}
+			}
+			if exists {
+				msg.ReplyToMessage = &structures.Message{
+					ID:             replyMsg.ID,
+					ConversationID: replyMsg.ConversationID,
+					Content:        replyMsg.Content,
+					MediaType:      replyMsg.MediaType,
+					Sender:         replyMsg.Sender,
+					Timestamp:      replyMsg.Timestamp,
+					Status:         replyMsg.Status,
+					IsForwarded:    replyMsg.IsForwarded,
+					Kind:           replyMsg.Kind,
+					DeletedAt:      replyMsg.DeletedAt,
+					MediaID:        replyMsg.MediaID,
+					Width:          replyMsg.Width,
+					Height:         replyMsg.Height,
+					Placeholder:    replyMsg.Placeholder,
+				}
+			}
+		}
+		msg.Reactions = db.reactionsOf(msg.ID)
+	}
+}
+
+func (db *memdb) MessageExists(conversationId, messageId int) (bool, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	m, ok := db.messages[messageId]
+	return ok && m.conversationId == conversationId, nil
+}
+
+func (db *memdb) GetMessageById(conversationId, messageId int) (*structures.Message, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.getMessageById(conversationId, messageId)
+}
+
+func (db *memdb) getMessageById(conversationId, messageId int) (*structures.Message, error) {
+	m, ok := db.messages[messageId]
+	if !ok || m.conversationId != conversationId {
+		return nil, fmt.Errorf("messaggio non trovato")
+	}
+	msg, ok := db.toMessage(m)
+	if !ok {
+		return nil, fmt.Errorf("messaggio non trovato")
+	}
+	msg.Reactions = db.reactionsOf(msg.ID)
+
+	if msg.ReplyToMessageID != nil {
+		if replyMsg, err := db.getMessageById(conversationId, *msg.ReplyToMessageID); err == nil {
+			msg.ReplyToMessage = &structures.Message{
+				ID:             replyMsg.ID,
+				ConversationID: replyMsg.ConversationID,
+				Content:        replyMsg.Content,
+				MediaType:      replyMsg.MediaType,
+				Sender:         replyMsg.Sender,
+				Timestamp:      replyMsg.Timestamp,
+				Status:         replyMsg.Status,
+				IsForwarded:    replyMsg.IsForwarded,
+				Kind:           replyMsg.Kind,
+			}
+		}
+	}
+	return msg, nil
+}
+
+func (db *memdb) DeleteMessage(conversationId, messageId, userId int) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	m, ok := db.messages[messageId]
+	if !ok || m.conversationId != conversationId || m.senderId != userId || m.deletedAt != nil || m.kind != MessageKindUser {
+		return fmt.Errorf("not authorized or message not found")
+	}
+	now := memTime(globaltime.Now())
+	m.content, m.mediaId, m.deletedAt = "", nil, &now
+	delete(db.reactions, messageId)
+	db.deleteEdits(messageId)
+	delete(db.pins, messageId)
+	if m.threadRoot != nil {
+		db.updateThread(*m.threadRoot)
+	}
+	return nil
+}
+
+// deleteEdits elimina la cronologia delle modifiche del messaggio
+func (db *memdb) deleteEdits(messageId int) {
+	kept := db.edits[:0]
+	for _, e := range db.edits {
+		if e.messageId != messageId {
+			kept = append(kept, e)
+		}
+	}
+	db.edits = kept
+}
+
+func (db *memdb) HideMessage(conversationId, messageId, userId int) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	m, ok := db.messages[messageId]
+	if !ok || m.conversationId != conversationId {
+		return nil
+	}
+	if db.hidden[userId] == nil {
+		db.hidden[userId] = make(map[int]bool)
+	}
+	db.hidden[userId][messageId] = true
+	return nil
+}
+
+func (db *memdb) PurgeDeletedMessages(t time.Time) (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// Come in PurgeDeletedMessages di message-db.go, resta il messaggio iniziale dei thread con risposte non eliminate
+	liveThreads := make(map[int]bool)
+	for _, m := range db.messages {
+		if m.threadRoot != nil && m.deletedAt == nil {
+			liveThreads[*m.threadRoot] = true
+		}
+	}
+	cutoff := memTime(t)
+	purged := make(map[int]bool)
+	for id, m := range db.messages {
+		if m.deletedAt != nil && m.deletedAt.Before(cutoff) && !liveThreads[id] {
+			purged[id] = true
+		}
+	}
+	for _, m := range db.messages {
+		if m.replyTo != nil && purged[*m.replyTo] {
+			m.replyTo = nil
+		}
+		if m.threadRoot != nil && purged[*m.threadRoot] {
+			m.threadRoot = nil
+		}
+	}
+	for id := range purged {
+		for _, hidden := range db.hidden {
+			delete(hidden, id)
+		}
+		delete(db.reactions, id)
+		db.deleteEdits(id)
+		delete(db.receipts, id)
+		delete(db.messages, id)
+	}
+	return int64(len(purged)), nil
+}
+
+func (db *memdb) EditMessage(conversationId, messageId, userId int, content string, window time.Duration) (*structures.Message, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	now := globaltime.Now()
+	m, ok := db.messages[messageId]
+	if !ok || m.conversationId != conversationId || m.deletedAt != nil {
+		return nil, ErrMessageNotFound
+	}
+	if m.senderId != userId {
+		return nil, ErrNotMessageSender
+	}
+	if m.mediaType != "text" || m.kind != MessageKindUser {
+		return nil, ErrMessageNotEditable
+	}
+	if m.timestamp.Before(memTime(now.Add(-window))) {
+		return nil, ErrEditWindowExpired
+	}
+
+	if content != m.content {
+		replacedAt := memTime(now)
+		db.lastEditId++
+		db.edits = append(db.edits, &memEdit{id: db.lastEditId, messageId: messageId, content: m.content, replacedAt: replacedAt})
+		m.content, m.editedAt = content, &replacedAt
+	}
+	return db.getMessageById(conversationId, messageId)
+}
+
+func (db *memdb) GetMessageEdits(conversationId, messageId int) ([]*structures.MessageEdit, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	edits := []*structures.MessageEdit{}
+	m, ok := db.messages[messageId]
+	if !ok || m.conversationId != conversationId {
+		return edits, nil
+	}
+	for _, e := range db.edits {
+		if e.messageId == messageId {
+			edits = append(edits, &structures.MessageEdit{Content: e.content, ReplacedAt: memTimeString(e.replacedAt)})
+		}
+	}
+	return edits, nil
+}
+
+func (db *memdb) SetMessagesReceived(conversationId int, userId int) (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	now := memTime(globaltime.Now())
+	var updated int64
+	for _, m := range db.conversationMessages(conversationId) {
+		if r, ok := db.receipts[m.id][userId]; ok && r.deliveredAt == nil {
+			r.deliveredAt = &now
+			updated++
+		}
+	}
+	if updated > 0 {
+		db.updateAggregateStatus(conversationId)
+	}
+	return updated, nil
+}
+
+func (db *memdb) SetMessagesRead(conversationId int, userId int, collapseThreads bool) (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.setRead(conversationId, userId, func(m *memMessage) bool { return !collapseThreads || m.threadRoot == nil }), nil
+}
+
+// setRead segna come letti dall'utente i messaggi della conversazione per cui filter è vera e restituisce quanti ne ha
+// aggiornati
+func (db *memdb) setRead(conversationId, userId int, filter func(m *memMessage) bool) int64 {
+	now := memTime(globaltime.Now())
+	var updated int64
+	for _, m := range db.conversationMessages(conversationId) {
+		if !filter(m) {
+			continue
+		}
+		if r, ok := db.receipts[m.id][userId]; ok && r.readAt == nil {
+			r.readAt = &now
+			if r.deliveredAt == nil {
+				r.deliveredAt = &now
+			}
+			updated++
+		}
+	}
+	if updated > 0 {
+		db.updateAggregateStatus(conversationId)
+	}
+	return updated
+}
+
+// updateAggregateStatus ricalcola lo stato complessivo dei messaggi non ancora letti da tutti, come
+// aggregateStatusStmt: contano solo le ricevute dei destinatari ancora membri della conversazione
+func (db *memdb) updateAggregateStatus(conversationId int) {
+	for _, m := range db.conversationMessages(conversationId) {
+		if m.status == "read" {
+			continue
+		}
+		unread, undelivered := false, false
+		for userId, r := range db.receipts[m.id] {
+			if !db.members[conversationId][userId] {
+				continue
+			}
+			unread = unread || r.readAt == nil
+			undelivered = undelivered || r.deliveredAt == nil
+		}
+		switch {
+		case !unread:
+			m.status = "read"
+		case !undelivered:
+			m.status = "received"
+		default:
+			m.status = "sent"
+		}
+	}
+}
+
+// receiptBefore ordina le ricevute come GetMessageReceipts su SQLite: prima quelle lette, poi quelle consegnate, per
+// data e quindi per username
+func receiptBefore(a, b *memReceipt, usernameA, usernameB string) bool {
+	for _, pair := range [][2]*time.Time{{a.readAt, b.readAt}, {a.deliveredAt, b.deliveredAt}} {
+		x, y := pair[0], pair[1]
+		if (x == nil) != (y == nil) {
+			return x != nil
+		}
+		if x != nil && !x.Equal(*y) {
+			return x.Before(*y)
+		}
+	}
+	return usernameA < usernameB
+}
+
+func (db *memdb) GetMessageReceipts(conversationId, messageId int) ([]*structures.MessageReceipt, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	receipts := []*structures.MessageReceipt{}
+	m, ok := db.messages[messageId]
+	if !ok || m.conversationId != conversationId {
+		return receipts, nil
+	}
+	type row struct {
+		r *memReceipt
+		u *memUser
+	}
+	var rows []row
+	for userId, r := range db.receipts[messageId] {
+		if u, ok := db.users[userId]; ok {
+			rows = append(rows, row{r, u})
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		return receiptBefore(rows[i].r, rows[j].r, rows[i].u.Username, rows[j].u.Username)
+	})
+	for _, row := range rows {
+		receipts = append(receipts, &structures.MessageReceipt{
+			User:        row.u.User,
+			DeliveredAt: memTimeStringPtr(row.r.deliveredAt),
+			ReadAt:      memTimeStringPtr(row.r.readAt),
+		})
+	}
+	return receipts, nil
+}
+
+func (db *memdb) AddReaction(messageId int, userId int, emoji string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.reactions[messageId] == nil {
+		db.reactions[messageId] = make(map[int]string)
+	}
+	db.reactions[messageId][userId] = emoji
+	return nil
+}
+
+func (db *memdb) RemoveReaction(messageId int, userId int) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	delete(db.reactions[messageId], userId)
+	return nil
+}
+
+func (db *memdb) GetReactions(messageId int) ([]*structures.Reaction, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.reactionsOf(messageId), nil
+}
+
+func (db *memdb) SearchMessages(userId int, query MessageSearchQuery) (*structures.MessageSearchPage, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	// Come su SQLite senza FTS5: ogni parola deve comparire nel contenuto dei messaggi di testo
+	terms := strings.Fields(query.Text)
+	matches := func(m *memMessage) bool {
+		if m.deletedAt != nil || m.kind != MessageKindUser || db.hidden[userId][m.id] || !db.members[m.conversationId][userId] {
+			return false
+		}
+		if len(terms) > 0 && m.mediaType != "text" {
+			return false
+		}
+		for _, term := range terms {
+			if !memLike(m.content, "%"+escapeLike(term)+"%", true) {
+				return false
+			}
+		}
+		switch {
+		case query.ConversationID != 0 && m.conversationId != query.ConversationID,
+			query.SenderID != 0 && m.senderId != query.SenderID,
+			!query.From.IsZero() && m.timestamp.Before(memTime(query.From)),
+			!query.To.IsZero() && !m.timestamp.Before(memTime(query.To)),
+			query.MediaType != "" && m.mediaType != query.MediaType,
+			query.Before != nil && !m.beforeCursor(*query.Before):
+			return false
+		}
+		return true
+	}
+
+	var found []*memMessage
+	for _, m := range db.messages {
+		if matches(m) {
+			found = append(found, m)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[j].before(found[i]) })
+
+	page := &structures.MessageSearchPage{Results: []*structures.MessageSearchResult{}}
+	for _, m := range found {
+		if len(page.Results) > query.Limit {
+			break
+		}
+		msg, ok := db.toMessage(m)
+		if !ok {
+			continue
+		}
+		result := &structures.MessageSearchResult{Message: msg}
+		if len(terms) > 0 {
+			if raw := markTerms(msg.Content, terms); raw != "" {
+				result.Snippet = renderSnippet(raw)
+			}
+		}
+		page.Results = append(page.Results, result)
+	}
+
+	if len(page.Results) > query.Limit {
+		page.HasMore = true
+		page.Results = page.Results[:query.Limit]
+	}
+	if page.HasMore {
+		last := page.Results[len(page.Results)-1].Message
+		page.NextCursor = messageCursorOf(last.Timestamp, last.ID).String()
+	}
+	return page, nil
+}
+
+// insertSystemMessage aggiunge al gruppo un messaggio di sistema e lo restituisce, come insertSystemMessage in
+// system-message-db.go
+func (db *memdb) insertSystemMessage(groupID, actorID int, kind string, target *int, content string) *memMessage {
+	db.lastMessageId++
+	m := &memMessage{
+		id:             db.lastMessageId,
+		conversationId: groupID,
+		senderId:       actorID,
+		content:        content,
+		mediaType:      "text",
+		status:         "sent",
+		timestamp:      memTime(globaltime.Now()),
+		kind:           kind,
+		targetId:       copyIntPtr(target),
+	}
+	db.messages[m.id] = m
+	return m
+}
+
+// newMessages converte i messaggi di sistema appena inseriti, come getNewMessages in system-message-db.go
+func (db *memdb) newMessages(inserted ...*memMessage) []*structures.Message {
+	messages := make([]*structures.Message, 0, len(inserted))
+	messageMap := make(map[int]*structures.Message, len(inserted))
+	for _, m := range inserted {
+		if msg, ok := db.toMessage(m); ok {
+			messages = append(messages, msg)
+			messageMap[msg.ID] = msg
+		}
+	}
+	db.loadRepliesAndReactions(messages, messageMap)
+	return messages
+}
@@ -0,0 +1,135 @@
+package database
+
+import (
+	"sort"
+	"time"
+
+	"github.com/rerikdev/WASAText/service/globaltime"
+	"github.com/rerikdev/WASAText/service/structures"
+)
+
+type memPin struct {
+	conversationId int
+	pinnedBy       int
+	pinnedAt       time.Time
+}
+
+// checkPinAccess è come l'omonima funzione di pin-db.go
+func (db *memdb) checkPinAccess(conversationId, userId int) error {
+	c, ok := db.conversations[conversationId]
+	if !ok || !db.members[conversationId][userId] {
+		return ErrPermissionDenied
+	}
+	if !db.access(c, userId).can(c.settings.OnlyAdminsPin) {
+		return ErrPermissionDenied
+	}
+	return nil
+}
+
+// insertPinMessage è come l'omonima funzione di pin-db.go, ma restituisce il messaggio
+func (db *memdb) insertPinMessage(conversationId, actorId int, kind string, messageId int) *memMessage {
+	db.lastMessageId++
+	m := &memMessage{
+		id:             db.lastMessageId,
+		conversationId: conversationId,
+		senderId:       actorId,
+		mediaType:      "text",
+		status:         "sent",
+		timestamp:      memTime(globaltime.Now()),
+		replyTo:        &messageId,
+		kind:           kind,
+	}
+	db.messages[m.id] = m
+	return m
+}
+
+// pinnedMessageOf restituisce il messaggio fissato o tolto registrato dal messaggio di sistema m, se lo è
+func (db *memdb) pinnedMessageOf(m *memMessage) (*memMessage, bool) {
+	if m.kind == MessageKindUser || m.replyTo == nil {
+		return nil, false
+	}
+	pinned, ok := db.messages[*m.replyTo]
+	return pinned, ok
+}
+
+func (db *memdb) PinMessage(conversationId, messageId, userId int) ([]*structures.Message, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.checkPinAccess(conversationId, userId); err != nil {
+		return nil, err
+	}
+	m, ok := db.messages[messageId]
+	if !ok || m.conversationId != conversationId || m.deletedAt != nil || m.kind != MessageKindUser {
+		return nil, ErrMessageNotFound
+	}
+	if _, pinned := db.pins[messageId]; pinned {
+		return nil, ErrMessageAlreadyPinned
+	}
+	count := 0
+	for _, p := range db.pins {
+		if p.conversationId == conversationId {
+			count++
+		}
+	}
+	if count >= maxPinnedMessages {
+		return nil, ErrTooManyPinnedMessages
+	}
+	db.pins[messageId] = &memPin{conversationId: conversationId, pinnedBy: userId, pinnedAt: memTime(globaltime.Now())}
+	return db.newMessages(db.insertPinMessage(conversationId, userId, MessageKindMessagePinned, messageId)), nil
+}
+
+func (db *memdb) UnpinMessage(conversationId, messageId, userId int) ([]*structures.Message, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.checkPinAccess(conversationId, userId); err != nil {
+		return nil, err
+	}
+	if p, ok := db.pins[messageId]; !ok || p.conversationId != conversationId {
+		return nil, ErrMessageNotPinned
+	}
+	delete(db.pins, messageId)
+	return db.newMessages(db.insertPinMessage(conversationId, userId, MessageKindMessageUnpinned, messageId)), nil
+}
+
+func (db *memdb) GetPinnedMessages(conversationId, userId int) ([]*structures.PinnedMessage, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var ids []int
+	for id, p := range db.pins {
+		if p.conversationId == conversationId && !db.hidden[userId][id] {
+			ids = append(ids, id)
+		}
+	}
+	// Dal più recentemente fissato, come ORDER BY p.pinned_at DESC, p.message_id DESC
+	sort.Slice(ids, func(i, j int) bool {
+		a, b := db.pins[ids[i]], db.pins[ids[j]]
+		if !a.pinnedAt.Equal(b.pinnedAt) {
+			return a.pinnedAt.After(b.pinnedAt)
+		}
+		return ids[i] > ids[j]
+	})
+
+	pins := []*structures.PinnedMessage{}
+	var messages []*structures.Message
+	messageMap := make(map[int]*structures.Message, len(ids))
+	for _, id := range ids {
+		msg, ok := db.toMessage(db.messages[id])
+		if !ok {
+			continue
+		}
+		messages = append(messages, msg)
+		messageMap[id] = msg
+		p := db.pins[id]
+		pin := &structures.PinnedMessage{Message: msg, PinnedAt: memTimeString(p.pinnedAt)}
+		if u, ok := db.users[p.pinnedBy]; ok {
+			pinnedBy := u.User
+			pin.PinnedBy = &pinnedBy
+		}
+		pins = append(pins, pin)
+	}
+	db.loadRepliesAndReactions(messages, messageMap)
+	return pins, nil
+}
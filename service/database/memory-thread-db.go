@@ -0,0 +1,93 @@
+package database
+
+import (
+	"github.com/rerikdev/WASAText/service/structures"
+)
+
+// updateThread ricalcola il numero delle risposte e la data dell'ultima per il messaggio iniziale di un thread, come
+// updateThreadStmt
+func (db *memdb) updateThread(rootId int) {
+	root, ok := db.messages[rootId]
+	if !ok {
+		return
+	}
+	root.replyCount, root.lastReplyAt = 0, nil
+	for _, m := range db.messages {
+		if m.threadRoot == nil || *m.threadRoot != rootId || m.deletedAt != nil {
+			continue
+		}
+		root.replyCount++
+		if root.lastReplyAt == nil || m.timestamp.After(*root.lastReplyAt) {
+			ts := m.timestamp
+			root.lastReplyAt = &ts
+		}
+	}
+}
+
+// threadRootOf restituisce il messaggio iniziale del thread di cui fa parte messageId, o ErrMessageNotFound
+func (db *memdb) threadRootOf(conversationId, messageId int) (int, error) {
+	m, ok := db.messages[messageId]
+	if !ok || m.conversationId != conversationId {
+		return 0, ErrMessageNotFound
+	}
+	if m.threadRoot != nil {
+		return *m.threadRoot, nil
+	}
+	return m.id, nil
+}
+
+// inThread indica se il messaggio è il messaggio iniziale o una risposta del thread rootId
+func (m *memMessage) inThread(rootId int) bool {
+	return m.id == rootId || (m.threadRoot != nil && *m.threadRoot == rootId)
+}
+
+func (db *memdb) GetThread(conversationId, messageId, userId int, query MessagePageQuery) (*structures.ThreadPage, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	rootId, err := db.threadRootOf(conversationId, messageId)
+	if err != nil {
+		return nil, err
+	}
+	root, ok := db.toMessage(db.messages[rootId])
+	if !ok {
+		return nil, ErrMessageNotFound
+	}
+	roots := []*structures.Message{root}
+	db.loadRepliesAndReactions(roots, map[int]*structures.Message{root.ID: root})
+	db.loadThreadUnread(userId, roots)
+
+	page := db.messagesPage(conversationId, userId, func(m *memMessage) bool {
+		return m.threadRoot != nil && *m.threadRoot == rootId
+	}, query)
+	return &structures.ThreadPage{Root: root, MessagePage: *page}, nil
+}
+
+func (db *memdb) SetThreadRead(conversationId, messageId, userId int) (int, int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	rootId, err := db.threadRootOf(conversationId, messageId)
+	if err != nil {
+		return 0, 0, err
+	}
+	return rootId, db.setRead(conversationId, userId, func(m *memMessage) bool { return m.inThread(rootId) }), nil
+}
+
+// loadThreadUnread conta le risposte non lette da userId dei messaggi che ne hanno, come l'omonima funzione di
+// thread-db.go
+func (db *memdb) loadThreadUnread(userId int, messages []*structures.Message) {
+	for _, msg := range messages {
+		if msg.Thread == nil {
+			continue
+		}
+		for _, m := range db.messages {
+			if m.threadRoot == nil || *m.threadRoot != msg.ID || m.deletedAt != nil {
+				continue
+			}
+			if r, ok := db.receipts[m.id][userId]; ok && r.readAt == nil {
+				msg.Thread.UnreadCount++
+			}
+		}
+	}
+}
@@ -2,47 +2,90 @@ package database
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/rerikdev/WASAText/service/globaltime"
 	"github.com/rerikdev/WASAText/service/structures"
 )
 
-// SendMessage inserisce un nuovo messaggio e restituisce la lista aggiornata dei messaggi della conversazione
-func (db *appdbimpl) SendMessage(conversationId, senderId int, content, mediaType string, isForwarded bool, replyToMessageId *int) ([]*structures.Message, error) {
+// SendMessage inserisce un nuovo messaggio e lo restituisce, riletto tramite l'ID assegnato dall'inserimento
+func (db *appdbimpl) SendMessage(conversationId, senderId int, content, mediaType string, mediaId *string, isForwarded bool, replyToMessageId *int) (*structures.Message, error) {
 	// Controlla che la conversazione esista
 	var exists int
 	err := db.c.QueryRow(`SELECT COUNT(*) FROM conversations WHERE id = ?`, conversationId).Scan(&exists)
 	if err != nil || exists == 0 {
 		return nil, fmt.Errorf("conversazione non trovata")
 	}
-	// Controlla che il mittente sia membro della conversazione
-	var count int
-	err = db.c.QueryRow(`SELECT COUNT(*) FROM conversation_members WHERE conversation_id = ? AND user_id = ?`, conversationId, senderId).Scan(&count)
-	if err != nil || count == 0 {
+	// Controlla che il mittente sia membro della conversazione e, nei gruppi in cui solo gli amministratori possono
+	// scrivere, che abbia il ruolo per farlo
+	var role string
+	var adminsOnly bool
+	err = db.c.QueryRow(`
+        SELECT cm.role, c.only_admins_send
+        FROM conversation_members cm
+        JOIN conversations c ON c.id = cm.conversation_id
+        WHERE cm.conversation_id = ? AND cm.user_id = ?`, conversationId, senderId).Scan(&role, &adminsOnly)
+	if err != nil {
 		return nil, fmt.Errorf("utente non autorizzato a inviare messaggi in questa conversazione")
 	}
+	if !(&groupAccess{role: role}).can(adminsOnly) {
+		return nil, ErrPermissionDenied
+	}
 
-	// Se c'è un replyToMessageId, verifica che il messaggio esista nella stessa conversazione
+	// Il messaggio, il contatore del thread e le ricevute vengono salvati insieme
+	tx, err := db.c.Begin()
+	if err != nil {
+		return nil, err
+	}
+	// Dopo il commit il rollback non fa nulla
+	defer func() { _ = tx.Rollback() }()
+
+	// Se c'è un replyToMessageId, verifica che il messaggio esista nella stessa conversazione, non sia stato eliminato e
+	// non sia un messaggio di sistema. La risposta entra nel thread del messaggio, o ne inizia uno se il messaggio non
+	// è a sua volta una risposta.
+	var threadRootId *int
 	if replyToMessageId != nil {
-		var replyExists int
-		err = db.c.QueryRow(`SELECT COUNT(*) FROM messages WHERE id = ? AND conversation_id = ?`, *replyToMessageId, conversationId).Scan(&replyExists)
-		if err != nil || replyExists == 0 {
+		var root int
+		err = tx.QueryRow(`SELECT COALESCE(thread_root_id, id) FROM messages WHERE id = ? AND conversation_id = ? AND kind = ? AND deleted_at IS NULL`, *replyToMessageId, conversationId, MessageKindUser).Scan(&root)
+		if err != nil {
 			return nil, fmt.Errorf("messaggio di risposta non trovato nella conversazione")
 		}
+		threadRootId = &root
 	}
 
-	status := "received"
-
-	_, err = db.c.Exec(
-		`INSERT INTO messages (conversation_id, sender_id, content, is_forwarded, media_type, status, timestamp, reply_to_message_id)
-         VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
-		conversationId, senderId, content, isForwarded, mediaType, status, globaltime.Now().Format("2006-01-02 15:04:05"), replyToMessageId,
-	)
+	// Lo stato complessivo parte da "sent" e avanza quando tutti i destinatari l'hanno ricevuto o letto (vedi
+	// receipt-db.go)
+	var messageId int64
+	err = tx.QueryRow(
+		`INSERT INTO messages (conversation_id, sender_id, content, is_forwarded, media_type, media_id, status, timestamp, reply_to_message_id, thread_root_id)
+         VALUES (?, ?, ?, ?, ?, ?, 'sent', ?, ?, ?)
+         RETURNING id`,
+		conversationId, senderId, content, isForwarded, mediaType, mediaId, globaltime.Now().Format(timestampLayout), replyToMessageId, threadRootId,
+	).Scan(&messageId)
+	if err != nil {
+		return nil, err
+	}
+	if threadRootId != nil {
+		if _, err := tx.Exec(updateThreadStmt, *threadRootId); err != nil {
+			return nil, err
+		}
+	}
+	if err := createReceipts(tx, messageId, conversationId, senderId); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
 
+	msg, err := scanMessage(db.c.QueryRow(messageSelect+`
+         WHERE m.id = ?`, messageId))
 	if err != nil {
 		return nil, err
 	}
-	return db.GetMessages(conversationId)
+	if err := db.loadRepliesAndReactions(conversationId, []*structures.Message{msg}, map[int]*structures.Message{msg.ID: msg}); err != nil {
+		return nil, err
+	}
+	return msg, nil
 }
 
 // GetMessages restituisce tutti i messaggi di una conversazione, ordinati dal più vecchio al più recente
@@ -54,48 +97,219 @@ func (db *appdbimpl) GetMessages(conversationId int) ([]*structures.Message, err
 		return nil, fmt.Errorf("conversazione non trovata")
 	}
 
-	rows, err := db.c.Query(
-		`SELECT m.id, m.conversation_id, m.sender_id, m.content, m.is_forwarded, m.media_type, m.status, m.timestamp, m.reply_to_message_id,
-                u.username, u.display_name, u.profile_picture
-         FROM messages m
-         JOIN users u ON m.sender_id = u.id
+	rows, err := db.c.Query(messageSelect+`
          WHERE m.conversation_id = ?
-         ORDER BY m.timestamp ASC`, conversationId)
+         ORDER BY m.timestamp ASC, m.id ASC`, conversationId)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	if err = rows.Err(); err != nil {
-		return nil, err
-	}
 
 	var messages []*structures.Message
 	messageMap := make(map[int]*structures.Message) // Per riferimenti veloci durante la costruzione delle reply
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+		messageMap[msg.ID] = msg
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := db.loadRepliesAndReactions(conversationId, messages, messageMap); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// GetMessagesPage restituisce una pagina della cronologia della conversazione vista dall'utente userId secondo query,
+// dal messaggio più vecchio al più recente. I messaggi nascosti dall'utente ("elimina per me") sono esclusi, come le
+// risposte dei thread se query.CollapseThreads è impostato.
+func (db *appdbimpl) GetMessagesPage(conversationId, userId int, query MessagePageQuery) (*structures.MessagePage, error) {
+	var exists int
+	err := db.c.QueryRow(`SELECT COUNT(*) FROM conversations WHERE id = ?`, conversationId).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if exists == 0 {
+		return nil, ErrConversationNotFound
+	}
+	filter := ""
+	if query.CollapseThreads {
+		filter = "m.thread_root_id IS NULL"
+	}
+	return db.messagesPage(conversationId, userId, filter, nil, query)
+}
+
+// messagesPage restituisce una pagina dei messaggi della conversazione visibili all'utente che soddisfano la condizione
+// filter (se non è vuota), con i parametri filterArgs. Se ci sono dei thread, ne conta le risposte non lette da userId.
+func (db *appdbimpl) messagesPage(conversationId, userId int, filter string, filterArgs []interface{}, query MessagePageQuery) (*structures.MessagePage, error) {
+	// Si legge un messaggio in più del limite per sapere se ce ne sono altri
+	where := "m.conversation_id = ? AND NOT EXISTS (SELECT 1 FROM hidden_messages h WHERE h.message_id = m.id AND h.user_id = ?)"
+	args := []interface{}{conversationId, userId}
+	if filter != "" {
+		where += " AND " + filter
+		args = append(args, filterArgs...)
+	}
+	order := "DESC"
+	if query.After != nil {
+		where += " AND (m.timestamp > ? OR (m.timestamp = ? AND m.id > ?))"
+		ts := query.After.Timestamp.Format(timestampLayout)
+		args = append(args, ts, ts, query.After.ID)
+		order = "ASC"
+	} else if query.Before != nil {
+		where += " AND (m.timestamp < ? OR (m.timestamp = ? AND m.id < ?))"
+		ts := query.Before.Timestamp.Format(timestampLayout)
+		args = append(args, ts, ts, query.Before.ID)
+	}
+	args = append(args, query.Limit+1)
 
-	// Prima passata: leggi tutti i messaggi
+	rows, err := db.c.Query(messageSelect+`
+         WHERE `+where+`
+         ORDER BY m.timestamp `+order+`, m.id `+order+`
+         LIMIT ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []*structures.Message{}
+	messageMap := make(map[int]*structures.Message)
 	for rows.Next() {
-		var msg structures.Message
-		var sender structures.User
-		var replyToID *int
-
-		if err := rows.Scan(
-			&msg.ID, &msg.ConversationID, &sender.ID, &msg.Content, &msg.IsForwarded, &msg.MediaType, &msg.Status, &msg.Timestamp, &replyToID,
-			&sender.Username, &sender.DisplayName, &sender.ProfilePicture,
-		); err != nil {
+		msg, err := scanMessage(rows)
+		if err != nil {
 			return nil, err
 		}
-		msg.Sender = sender
-		msg.ReplyToMessageID = replyToID
-		msg.Reactions = []*structures.Reaction{} // Inizializza slice vuoto
+		messages = append(messages, msg)
+		messageMap[msg.ID] = msg
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	page := &structures.MessagePage{}
+	if len(messages) > query.Limit {
+		page.HasMore = true
+		delete(messageMap, messages[query.Limit].ID)
+		messages = messages[:query.Limit]
+	}
+	if order == "DESC" {
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+		}
+	}
+	if len(messages) > 0 {
+		first, last := messages[0], messages[len(messages)-1]
+		page.PrevCursor = messageCursorOf(first.Timestamp, first.ID).String()
+		page.NextCursor = messageCursorOf(last.Timestamp, last.ID).String()
+	}
+
+	if err := db.loadRepliesAndReactions(conversationId, messages, messageMap); err != nil {
+		return nil, err
+	}
+	if err := db.loadThreadUnread(userId, messages); err != nil {
+		return nil, err
+	}
+	page.Messages = messages
+	return page, nil
+}
+
+// messageSelect legge i messaggi con il mittente e le dimensioni del media allegato, nelle colonne attese da
+// scanMessage. Le query aggiungono WHERE e ORDER BY.
+const messageSelect = `
+        SELECT m.id, m.conversation_id, m.sender_id, m.content, m.is_forwarded, m.media_type, m.status, m.timestamp, m.reply_to_message_id, m.edited_at, m.deleted_at, m.media_id,
+                COALESCE(md.width, 0), COALESCE(md.height, 0), COALESCE(md.placeholder, ''),
+                u.username, u.display_name, u.profile_picture,
+                m.kind, t.id, COALESCE(t.username, ''), COALESCE(t.display_name, ''), COALESCE(t.profile_picture, ''),
+                m.thread_root_id, m.reply_count, m.last_reply_at
+         FROM messages m
+         JOIN users u ON m.sender_id = u.id
+         LEFT JOIN users t ON t.id = m.target_user_id
+         LEFT JOIN media md ON md.id = m.media_id`
+
+// rowScanner è implementata sia da *sql.Row che da *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanMessage legge un messaggio selezionato con messageSelect
+func scanMessage(row rowScanner) (*structures.Message, error) {
+	var msg structures.Message
+	var targetID *int
+	var target structures.User
+	var replyCount int
+	var lastReplyAt *string
+	if err := row.Scan(
+		&msg.ID, &msg.ConversationID, &msg.Sender.ID, &msg.Content, &msg.IsForwarded, &msg.MediaType, &msg.Status, &msg.Timestamp, &msg.ReplyToMessageID, &msg.EditedAt, &msg.DeletedAt, &msg.MediaID,
+		&msg.Width, &msg.Height, &msg.Placeholder,
+		&msg.Sender.Username, &msg.Sender.DisplayName, &msg.Sender.ProfilePicture,
+		&msg.Kind, &targetID, &target.Username, &target.DisplayName, &target.ProfilePicture,
+		&msg.ThreadRootID, &replyCount, &lastReplyAt,
+	); err != nil {
+		return nil, err
+	}
+	if targetID != nil {
+		target.ID = *targetID
+		msg.Target = &target
+	}
+	if replyCount > 0 && lastReplyAt != nil {
+		msg.Thread = &structures.ThreadSummary{ReplyCount: replyCount, LastReplyAt: *lastReplyAt}
+	}
+	return &msg, nil
+}
+
+// loadRepliesAndReactions popola i messaggi a cui si risponde e le reazioni. messageMap contiene i messaggi già letti
+// per ID: quelli a cui si risponde che non sono tra questi vengono letti dal database con un'unica query, come le
+// reazioni di tutti i messaggi.
+func (db *appdbimpl) loadRepliesAndReactions(conversationId int, messages []*structures.Message, messageMap map[int]*structures.Message) error {
+	var missing []interface{}
+	ids := make([]interface{}, 0, len(messages))
+	for _, msg := range messages {
+		ids = append(ids, msg.ID)
+		if msg.ReplyToMessageID != nil {
+			if _, ok := messageMap[*msg.ReplyToMessageID]; !ok {
+				missing = append(missing, *msg.ReplyToMessageID)
+			}
+		}
+	}
 
-		messages = append(messages, &msg)
-		messageMap[msg.ID] = &msg
+	replies := make(map[int]*structures.Message, len(missing))
+	for _, chunk := range chunkArgs(missing) {
+		rows, err := db.c.Query(messageSelect+`
+         WHERE m.conversation_id = ? AND m.id IN (`+placeholders(len(chunk))+`)`,
+			append([]interface{}{conversationId}, chunk...)...)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			msg, err := scanMessage(rows)
+			if err != nil {
+				_ = rows.Close()
+				return err
+			}
+			replies[msg.ID] = msg
+		}
+		// Next ha già chiuso rows
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+
+	reactions, err := db.getReactionsByMessage(ids)
+	if err != nil {
+		return err
 	}
 
-	// Seconda passata: popola i riferimenti alle reply e le reazioni
-	for i := range messages {
-		if messages[i].ReplyToMessageID != nil {
-			if replyMsg, exists := messageMap[*messages[i].ReplyToMessageID]; exists {
+	for _, msg := range messages {
+		if msg.ReplyToMessageID != nil {
+			replyMsg, exists := messageMap[*msg.ReplyToMessageID]
+			if !exists {
+				replyMsg, exists = replies[*msg.ReplyToMessageID]
+			}
+			if exists {
 				// Crea una versione semplificata senza reply annidate per evitare ricorsione infinita
 				simplifiedReply := &structures.Message{
 					ID:             replyMsg.ID,
@@ -106,83 +320,142 @@ func (db *appdbimpl) GetMessages(conversationId int) ([]*structures.Message, err
 					Timestamp:      replyMsg.Timestamp,
 					Status:         replyMsg.Status,
 					IsForwarded:    replyMsg.IsForwarded,
+					Kind:           replyMsg.Kind,
+					DeletedAt:      replyMsg.DeletedAt,
+					MediaID:        replyMsg.MediaID,
+					Width:          replyMsg.Width,
+					Height:         replyMsg.Height,
+					Placeholder:    replyMsg.Placeholder,
 				}
-				messages[i].ReplyToMessage = simplifiedReply
+				msg.ReplyToMessage = simplifiedReply
 			}
 		}
+		msg.Reactions = reactions[msg.ID]
+	}
+	return nil
+}
 
-		// Carica le reazioni per questo messaggio
-		reactions, _ := db.GetReactions(messages[i].ID)
-		messages[i].Reactions = reactions
+// DeleteMessage elimina un messaggio per tutti i membri se l'utente è il mittente e non è un messaggio di sistema. Il
+// messaggio non viene rimosso subito: ne resta un segnaposto senza contenuto (con deleted_at impostato), così le
+// risposte continuano a puntare a qualcosa. Il segnaposto non ha più il media allegato, che non si può più scaricare
+// dai membri (vedi CanAccessMedia). Le reazioni e la cronologia delle modifiche vengono eliminate e il messaggio non è
+// più fissato; i segnaposto vengono rimossi definitivamente da PurgeDeletedMessages.
+func (db *appdbimpl) DeleteMessage(conversationId, messageId, userId int) error {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return err
 	}
+	// Dopo il commit il rollback non fa nulla
+	defer func() { _ = tx.Rollback() }()
 
-	return messages, nil
+	res, err := tx.Exec(
+		`UPDATE messages SET content = '', media_id = NULL, deleted_at = ?
+         WHERE id = ? AND conversation_id = ? AND sender_id = ? AND deleted_at IS NULL AND kind = ?`,
+		globaltime.Now().Format(timestampLayout), messageId, conversationId, userId, MessageKindUser,
+	)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil || affected == 0 {
+		return fmt.Errorf("not authorized or message not found")
+	}
+	if _, err := tx.Exec(`DELETE FROM reactions WHERE message_id = ?`, messageId); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM message_edits WHERE message_id = ?`, messageId); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM pinned_messages WHERE message_id = ?`, messageId); err != nil {
+		return err
+	}
+	// Una risposta eliminata non conta più nel suo thread
+	var threadRootId *int
+	if err := tx.QueryRow(`SELECT thread_root_id FROM messages WHERE id = ?`, messageId).Scan(&threadRootId); err != nil {
+		return err
+	}
+	if threadRootId != nil {
+		if _, err := tx.Exec(updateThreadStmt, *threadRootId); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
 }
 
-// Setta a "received" tutti i messaggi ricevuti dall'utente in una conversazione che sono ancora "sent"
-func (db *appdbimpl) SetMessagesReceived(conversationId int, userId int) error {
+// HideMessage nasconde un messaggio della conversazione solo per l'utente ("elimina per me")
+func (db *appdbimpl) HideMessage(conversationId, messageId, userId int) error {
 	_, err := db.c.Exec(`
-        UPDATE messages
-        SET status = CASE WHEN status = 'sent' THEN 'received' ELSE status END
-        WHERE conversation_id = ? AND sender_id != ?`, conversationId, userId)
+        INSERT INTO hidden_messages (user_id, message_id)
+        SELECT CAST(? AS INTEGER), id FROM messages WHERE id = ? AND conversation_id = ?
+        ON CONFLICT DO NOTHING`,
+		userId, messageId, conversationId,
+	)
 	return err
 }
 
-// Setta a "read" tutti i messaggi ricevuti dall'utente in una conversazione che sono "received"
-func (db *appdbimpl) SetMessagesRead(conversationId int, userId int) error {
-	_, err := db.c.Exec(`
-        UPDATE messages
-        SET status = 'read'
-        WHERE conversation_id = ? AND sender_id != ? AND status != 'read'`, conversationId, userId)
-	return err
-}
+// PurgeDeletedMessages rimuove definitivamente i messaggi eliminati prima di t e restituisce quanti ne ha rimossi. Il
+// segnaposto del messaggio iniziale di un thread resta finché il thread ha risposte non eliminate, che altrimenti
+// uscirebbero dal thread; le altre risposte ai messaggi rimossi perdono il riferimento.
+func (db *appdbimpl) PurgeDeletedMessages(t time.Time) (int64, error) {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return 0, err
+	}
+	// Dopo il commit il rollback non fa nulla
+	defer func() { _ = tx.Rollback() }()
 
-// DeleteMessage rimuove un messaggio da una conversazione se l'utente è il mittente
-func (db *appdbimpl) DeleteMessage(conversationId, messageId, userId int) error {
-	res, err := db.c.Exec(
-		`DELETE FROM messages WHERE id = ? AND conversation_id = ? AND sender_id = ?`,
-		messageId, conversationId, userId,
-	)
+	// Le chiavi esterne non sono attive su SQLite: le righe collegate vengono aggiornate a mano
+	cutoff := t.Format(timestampLayout)
+	purged := `
+        SELECT id FROM messages p
+        WHERE p.deleted_at IS NOT NULL AND p.deleted_at < ?
+          AND NOT EXISTS (SELECT 1 FROM messages r WHERE r.thread_root_id = p.id AND r.deleted_at IS NULL)`
+	stmts := []string{
+		`UPDATE messages SET reply_to_message_id = NULL WHERE reply_to_message_id IN (` + purged + `)`,
+		`UPDATE messages SET thread_root_id = NULL WHERE thread_root_id IN (` + purged + `)`,
+		`DELETE FROM hidden_messages WHERE message_id IN (` + purged + `)`,
+		`DELETE FROM reactions WHERE message_id IN (` + purged + `)`,
+		`DELETE FROM message_edits WHERE message_id IN (` + purged + `)`,
+		`DELETE FROM message_receipts WHERE message_id IN (` + purged + `)`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt, cutoff); err != nil {
+			return 0, err
+		}
+	}
+	res, err := tx.Exec(`DELETE FROM messages WHERE id IN (`+purged+`)`, cutoff)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	affected, err := res.RowsAffected()
-	if err != nil || affected == 0 {
-		return fmt.Errorf("not authorized or message not found")
+	count, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
 	}
-	return nil
+	return count, tx.Commit()
+}
+
+// MessageExists indica se messageId è un messaggio della conversazione, anche eliminato, senza caricarlo
+func (db *appdbimpl) MessageExists(conversationId, messageId int) (bool, error) {
+	var exists bool
+	err := db.c.QueryRow(`SELECT EXISTS (SELECT 1 FROM messages WHERE id = ? AND conversation_id = ?)`, messageId, conversationId).Scan(&exists)
+	return exists, err
 }
 
 // GetMessageById restituisce un messaggio specifico di una conversazione
 func (db *appdbimpl) GetMessageById(conversationId, messageId int) (*structures.Message, error) {
-	var msg structures.Message
-	var sender structures.User
-	var replyToID *int
-
-	err := db.c.QueryRow(
-		`SELECT m.id, m.conversation_id, m.sender_id, m.content, m.is_forwarded, m.media_type, m.status, m.timestamp, m.reply_to_message_id,
-                u.username, u.display_name, u.profile_picture
-         FROM messages m
-         JOIN users u ON m.sender_id = u.id
-         WHERE m.conversation_id = ? AND m.id = ?`, conversationId, messageId).Scan(
-		&msg.ID, &msg.ConversationID, &sender.ID, &msg.Content, &msg.IsForwarded, &msg.MediaType, &msg.Status, &msg.Timestamp, &replyToID,
-		&sender.Username, &sender.DisplayName, &sender.ProfilePicture,
-	)
-
+	msg, err := scanMessage(db.c.QueryRow(messageSelect+`
+         WHERE m.conversation_id = ? AND m.id = ?`, conversationId, messageId))
 	if err != nil {
 		return nil, fmt.Errorf("messaggio non trovato")
 	}
 
-	msg.Sender = sender
-	msg.ReplyToMessageID = replyToID
-
 	// Carica le reazioni
 	reactions, _ := db.GetReactions(msg.ID)
 	msg.Reactions = reactions
 
 	// Se c'è un reply, caricalo (versione semplificata)
-	if replyToID != nil {
-		replyMsg, err := db.GetMessageById(conversationId, *replyToID)
+	if msg.ReplyToMessageID != nil {
+		replyMsg, err := db.GetMessageById(conversationId, *msg.ReplyToMessageID)
 		if err == nil {
 			simplifiedReply := &structures.Message{
 				ID:             replyMsg.ID,
@@ -193,10 +466,11 @@ func (db *appdbimpl) GetMessageById(conversationId, messageId int) (*structures.
 				Timestamp:      replyMsg.Timestamp,
 				Status:         replyMsg.Status,
 				IsForwarded:    replyMsg.IsForwarded,
+				Kind:           replyMsg.Kind,
 			}
 			msg.ReplyToMessage = simplifiedReply
 		}
 	}
 
-	return &msg, nil
+	return msg, nil
 }
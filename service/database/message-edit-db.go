@@ -0,0 +1,106 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/rerikdev/WASAText/service/globaltime"
+	"github.com/rerikdev/WASAText/service/structures"
+)
+
+var (
+	// ErrMessageNotFound viene restituito quando il messaggio non esiste nella conversazione
+	ErrMessageNotFound = errors.New("messaggio non trovato")
+
+	// ErrNotMessageSender viene restituito quando l'utente prova a modificare un messaggio inviato da altri
+	ErrNotMessageSender = errors.New("solo il mittente può modificare il messaggio")
+
+	// ErrEditWindowExpired viene restituito quando il tempo concesso per modificare il messaggio è scaduto
+	ErrEditWindowExpired = errors.New("il messaggio non può più essere modificato")
+
+	// ErrMessageNotEditable viene restituito per i messaggi che non sono di testo
+	ErrMessageNotEditable = errors.New("solo i messaggi di testo possono essere modificati")
+)
+
+// EditMessage sostituisce il contenuto di un messaggio, salvando la versione precedente in message_edits. Solo il
+// mittente può modificare il messaggio, entro window dall'invio.
+func (db *appdbimpl) EditMessage(conversationId, messageId, userId int, content string, window time.Duration) (*structures.Message, error) {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return nil, err
+	}
+	// Dopo il commit il rollback non fa nulla
+	defer func() { _ = tx.Rollback() }()
+
+	// Le date sono passate nel formato timestampLayout: su SQLite il confronto avviene tra stringhe, come per le sessioni
+	now := globaltime.Now()
+	var senderId int
+	var oldContent, mediaType, kind string
+	var inWindow bool
+	err = tx.QueryRow(
+		`SELECT sender_id, content, media_type, kind, timestamp >= ? FROM messages WHERE id = ? AND conversation_id = ? AND deleted_at IS NULL`,
+		now.Add(-window).Format(timestampLayout), messageId, conversationId,
+	).Scan(&senderId, &oldContent, &mediaType, &kind, &inWindow)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if senderId != userId {
+		return nil, ErrNotMessageSender
+	}
+	if mediaType != "text" || kind != MessageKindUser {
+		return nil, ErrMessageNotEditable
+	}
+	if !inWindow {
+		return nil, ErrEditWindowExpired
+	}
+
+	if content != oldContent {
+		if _, err := tx.Exec(
+			`INSERT INTO message_edits (message_id, content, replaced_at) VALUES (?, ?, ?)`,
+			messageId, oldContent, now.Format(timestampLayout),
+		); err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(
+			`UPDATE messages SET content = ?, edited_at = ? WHERE id = ?`,
+			content, now.Format(timestampLayout), messageId,
+		); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return db.GetMessageById(conversationId, messageId)
+}
+
+// GetMessageEdits restituisce le versioni precedenti di un messaggio, dalla più vecchia alla più recente
+func (db *appdbimpl) GetMessageEdits(conversationId, messageId int) ([]*structures.MessageEdit, error) {
+	rows, err := db.c.Query(`
+        SELECT e.content, e.replaced_at
+        FROM message_edits e
+        JOIN messages m ON m.id = e.message_id
+        WHERE m.conversation_id = ? AND e.message_id = ?
+        ORDER BY e.id ASC`, conversationId, messageId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	edits := []*structures.MessageEdit{}
+	for rows.Next() {
+		var edit structures.MessageEdit
+		if err := rows.Scan(&edit.Content, &edit.ReplacedAt); err != nil {
+			return nil, err
+		}
+		edits = append(edits, &edit)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return edits, nil
+}
@@ -0,0 +1,234 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rerikdev/WASAText/service/globaltime"
+)
+
+// Le migrazioni dello schema sono file SQL incorporati nell'eseguibile, chiamati
+// migrations/<driver>/<versione>_<nome>.sql. Vengono applicate in ordine di versione, ognuna nella propria transazione,
+// e la tabella schema_version registra quelle già applicate. Una migrazione già rilasciata non va mai modificata: ogni cambiamento dello schema è una nuova
+// migrazione, da scrivere per entrambi i driver con lo stesso numero di versione.
+//
+// L'indice di ricerca FTS5 di SQLite non fa parte delle migrazioni perché dipende dal supporto del driver, verificato a
+// ogni avvio (vedi search-db.go); quello di PostgreSQL sì.
+
+//go:embed migrations
+var migrationFiles embed.FS
+
+// ErrSchemaTooNew viene restituito quando il database è stato aggiornato da una versione più recente
+// dell'applicazione, di cui questa non conosce lo schema
+var ErrSchemaTooNew = errors.New("the database schema is newer than this version of the application")
+
+// Migration è una migrazione dello schema
+type Migration struct {
+	Version int
+	Name    string
+	sql     string
+}
+
+// SchemaStatus descrive lo stato dello schema di un database
+type SchemaStatus struct {
+	// Version è la versione attuale dello schema, 0 per un database vuoto o creato prima delle migrazioni versionate
+	Version int
+	// Latest è la versione più recente conosciuta da questa versione dell'applicazione
+	Latest int
+	// Legacy indica un database creato prima delle migrazioni versionate, che viene adattato dalla prima migrazione
+	Legacy bool
+	// Pending sono le migrazioni ancora da applicare, in ordine
+	Pending []Migration
+}
+
+// loadMigrations legge le migrazioni incorporate per il driver, ordinate per versione
+func loadMigrations(driver string) ([]Migration, error) {
+	dir := path.Join("migrations", driver)
+	entries, err := migrationFiles.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var migrations []Migration
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".sql")
+		sep := strings.IndexByte(name, '_')
+		if sep < 0 {
+			return nil, fmt.Errorf("invalid migration file name: %s", entry.Name())
+		}
+		version, err := strconv.Atoi(name[:sep])
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration file name: %s", entry.Name())
+		}
+		content, err := migrationFiles.ReadFile(path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, Migration{Version: version, Name: name[sep+1:], sql: string(content)})
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration %d is missing", i+1)
+		}
+	}
+	return migrations, nil
+}
+
+// CheckSchema restituisce lo stato dello schema del database, aperto con driver, senza modificarlo. Se il database è
+// più recente dell'applicazione restituisce anche ErrSchemaTooNew.
+func CheckSchema(db *sql.DB, driver string) (*SchemaStatus, error) {
+	c, err := newConn(db, driver)
+	if err != nil {
+		return nil, err
+	}
+	return checkSchema(c)
+}
+
+func checkSchema(c *dbConn) (*SchemaStatus, error) {
+	migrations, err := loadMigrations(c.driver)
+	if err != nil {
+		return nil, err
+	}
+	status := &SchemaStatus{Latest: len(migrations)}
+
+	versionTable, err := tableExists(c, "schema_version")
+	if err != nil {
+		return nil, err
+	}
+	if versionTable {
+		err = c.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&status.Version)
+		if err != nil {
+			return nil, err
+		}
+	} else if c.driver == DriverSQLite {
+		// Su PostgreSQL lo schema è sempre stato creato dalle migrazioni
+		status.Legacy, err = tableExists(c, "users")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if status.Version > status.Latest {
+		return status, fmt.Errorf("%w: version %d, latest known %d", ErrSchemaTooNew, status.Version, status.Latest)
+	}
+	status.Pending = migrations[status.Version:]
+	return status, nil
+}
+
+// Migrate applica le migrazioni mancanti al database, aperto con driver, e restituisce quelle applicate. Si ferma alla
+// prima che fallisce, lasciando il database all'ultima versione completata.
+func Migrate(db *sql.DB, driver string) ([]Migration, error) {
+	c, err := newConn(db, driver)
+	if err != nil {
+		return nil, err
+	}
+	if driver == DriverPostgres {
+		unlock, err := lockMigrations(db)
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
+	}
+	status, err := checkSchema(c)
+	if err != nil {
+		return nil, err
+	}
+	if len(status.Pending) == 0 {
+		return nil, nil
+	}
+
+	_, err = c.Exec(`CREATE TABLE IF NOT EXISTS schema_version (
+        version INTEGER PRIMARY KEY,
+        name TEXT NOT NULL,
+        applied_at TIMESTAMP NOT NULL
+    )`)
+	if err != nil {
+		return nil, fmt.Errorf("error creating schema_version table: %w", err)
+	}
+
+	var applied []Migration
+	for _, m := range status.Pending {
+		if err := applyMigration(c, m, status.Legacy && m.Version == 1); err != nil {
+			return applied, fmt.Errorf("error applying migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		applied = append(applied, m)
+	}
+	return applied, nil
+}
+
+// migrationLockKey identifica l'advisory lock di PostgreSQL preso durante le migrazioni
+const migrationLockKey = 20240501
+
+// lockMigrations prende l'advisory lock delle migrazioni su PostgreSQL, dove più istanze dell'applicazione possono
+// avviarsi insieme sullo stesso database: le altre aspettano e poi trovano le migrazioni già applicate. Il lock è
+// legato a una connessione dedicata, chiusa dalla funzione restituita.
+func lockMigrations(db *sql.DB) (func(), error) {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockKey); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("error locking migrations: %w", err)
+	}
+	return func() {
+		_, _ = conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, migrationLockKey)
+		_ = conn.Close()
+	}, nil
+}
+
+// applyMigration applica una migrazione in una transazione. Con legacy il database viene prima adattato allo schema
+// precedente alle migrazioni versionate.
+func applyMigration(c *dbConn, m Migration, legacy bool) error {
+	tx, err := c.Begin()
+	if err != nil {
+		return err
+	}
+	// Dopo il commit il rollback non fa nulla
+	defer func() { _ = tx.Rollback() }()
+
+	// Un altro processo potrebbe aver applicato la migrazione nel frattempo
+	var done int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM schema_version WHERE version = ?`, m.Version).Scan(&done); err != nil {
+		return err
+	}
+	if done > 0 {
+		return nil
+	}
+
+	if legacy {
+		if err := adoptLegacySchema(tx); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(m.sql); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)`,
+		m.Version, m.Name, globaltime.Now().Format(timestampLayout))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// tableExists indica se nel database esiste la tabella name
+func tableExists(c *dbConn, name string) (bool, error) {
+	query := `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`
+	if c.driver == DriverPostgres {
+		query = `SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = ?`
+	}
+	var count int
+	if err := c.QueryRow(query, name).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
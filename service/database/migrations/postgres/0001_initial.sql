@@ -0,0 +1,140 @@
+-- Schema iniziale, equivalente a migrations/sqlite3/0001_initial.sql. Le date sono TIMESTAMP senza fuso orario, in
+-- UTC come quelle salvate su SQLite.
+
+CREATE TABLE users (
+    id SERIAL PRIMARY KEY,
+    username TEXT NOT NULL UNIQUE,
+    display_name TEXT NOT NULL,
+    profile_picture TEXT,
+    password_hash TEXT DEFAULT NULL
+);
+
+CREATE TABLE conversations (
+    id SERIAL PRIMARY KEY,
+    name TEXT,                -- NULL per chat 1:1, valorizzato per gruppi
+    photo TEXT,               -- NULL per chat 1:1, valorizzato per gruppi
+    is_group BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE TABLE conversation_members (
+    conversation_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    PRIMARY KEY (conversation_id, user_id),
+    FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE messages (
+    id SERIAL PRIMARY KEY,
+    conversation_id INTEGER NOT NULL,
+    sender_id INTEGER NOT NULL,
+    content TEXT NOT NULL,
+    is_forwarded BOOLEAN NOT NULL DEFAULT FALSE,
+    media_type TEXT NOT NULL,
+    status TEXT NOT NULL CHECK (status IN ('sent', 'received', 'read')),
+    timestamp TIMESTAMP NOT NULL,
+    reply_to_message_id INTEGER DEFAULT NULL,
+    edited_at TIMESTAMP DEFAULT NULL,
+    deleted_at TIMESTAMP DEFAULT NULL,
+    media_id TEXT DEFAULT NULL,
+    FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE,
+    FOREIGN KEY (sender_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (reply_to_message_id) REFERENCES messages(id) ON DELETE SET NULL
+);
+
+-- Usato dalla paginazione della cronologia, ordinata per (timestamp, id)
+CREATE INDEX idx_messages_conversation_timestamp ON messages(conversation_id, timestamp, id);
+
+CREATE TABLE reactions (
+    message_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    emoji TEXT NOT NULL,
+    PRIMARY KEY (message_id, user_id),
+    FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE message_receipts (
+    message_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    delivered_at TIMESTAMP DEFAULT NULL,
+    read_at TIMESTAMP DEFAULT NULL,
+    PRIMARY KEY (message_id, user_id),
+    FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_message_receipts_user_id ON message_receipts(user_id);
+
+CREATE TABLE message_edits (
+    id SERIAL PRIMARY KEY,
+    message_id INTEGER NOT NULL,
+    content TEXT NOT NULL,
+    replaced_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_message_edits_message_id ON message_edits(message_id);
+
+CREATE TABLE hidden_messages (
+    user_id INTEGER NOT NULL,
+    message_id INTEGER NOT NULL,
+    PRIMARY KEY (user_id, message_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE
+);
+
+CREATE TABLE sessions (
+    token_hash TEXT PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_sessions_user_id ON sessions(user_id);
+
+CREATE TABLE login_attempts (
+    username TEXT PRIMARY KEY,
+    failures INTEGER NOT NULL DEFAULT 0,
+    locked_until TIMESTAMP DEFAULT NULL
+);
+
+CREATE TABLE events (
+    id BIGSERIAL PRIMARY KEY,
+    type TEXT NOT NULL,
+    conversation_id INTEGER,
+    payload TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE event_recipients (
+    event_id BIGINT NOT NULL,
+    user_id INTEGER NOT NULL,
+    PRIMARY KEY (user_id, event_id),
+    FOREIGN KEY (event_id) REFERENCES events(id) ON DELETE CASCADE
+);
+
+CREATE TABLE media (
+    id TEXT PRIMARY KEY,
+    blob_key TEXT NOT NULL,
+    uploader_id INTEGER NOT NULL,
+    content_type TEXT NOT NULL,
+    size BIGINT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    width INTEGER DEFAULT NULL,
+    height INTEGER DEFAULT NULL,
+    placeholder TEXT DEFAULT NULL,
+    FOREIGN KEY (uploader_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE media_thumbnails (
+    media_id TEXT NOT NULL,
+    size INTEGER NOT NULL,
+    blob_key TEXT NOT NULL,
+    content_type TEXT NOT NULL,
+    width INTEGER NOT NULL,
+    height INTEGER NOT NULL,
+    PRIMARY KEY (media_id, size),
+    FOREIGN KEY (media_id) REFERENCES media(id) ON DELETE CASCADE
+);
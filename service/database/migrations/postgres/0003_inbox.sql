@@ -0,0 +1,8 @@
+-- Preferenze di ogni membro per la conversazione, mostrate da GET /inbox: le conversazioni silenziate e quelle fissate
+-- in cima all'elenco (pinned_at è il momento in cui sono state fissate, NULL se non lo sono).
+ALTER TABLE conversation_members ADD COLUMN muted BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE conversation_members ADD COLUMN pinned_at TIMESTAMP DEFAULT NULL;
+
+-- Indice parziale per contare i messaggi non letti di un utente: contiene solo le ricevute ancora da leggere, che sono
+-- poche rispetto a tutte quelle dell'utente.
+CREATE INDEX idx_message_receipts_unread ON message_receipts(user_id, message_id) WHERE read_at IS NULL;
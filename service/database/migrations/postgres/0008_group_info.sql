@@ -0,0 +1,6 @@
+-- Informazioni aggiuntive dei gruppi: una descrizione, il numero massimo di membri (0 per nessun limite) e la data e
+-- l'autore della creazione. Per i gruppi esistenti data e autore non sono noti e restano NULL.
+ALTER TABLE conversations ADD COLUMN description TEXT NOT NULL DEFAULT '';
+ALTER TABLE conversations ADD COLUMN max_members INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE conversations ADD COLUMN created_at TIMESTAMP DEFAULT NULL;
+ALTER TABLE conversations ADD COLUMN created_by INTEGER DEFAULT NULL REFERENCES users(id) ON DELETE SET NULL;
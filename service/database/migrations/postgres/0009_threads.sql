@@ -0,0 +1,24 @@
+-- Thread: ogni risposta appartiene al thread del primo messaggio della sua catena di risposte (thread_root_id). Il
+-- messaggio iniziale tiene il numero delle risposte non eliminate e la data dell'ultima, aggiornati a ogni risposta e
+-- a ogni eliminazione.
+ALTER TABLE messages ADD COLUMN thread_root_id INTEGER DEFAULT NULL REFERENCES messages(id) ON DELETE SET NULL;
+ALTER TABLE messages ADD COLUMN reply_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE messages ADD COLUMN last_reply_at TIMESTAMP DEFAULT NULL;
+
+-- Le risposte esistenti vengono assegnate al thread seguendo la catena fino al primo messaggio. Le risposte a messaggi
+-- eliminati definitivamente non hanno un thread.
+WITH RECURSIVE roots (id, root_id) AS (
+    SELECT id, id FROM messages WHERE reply_to_message_id IS NULL
+    UNION ALL
+    SELECT m.id, r.root_id FROM messages m JOIN roots r ON m.reply_to_message_id = r.id
+)
+UPDATE messages SET thread_root_id = (SELECT root_id FROM roots WHERE roots.id = messages.id)
+WHERE reply_to_message_id IS NOT NULL;
+
+UPDATE messages SET
+    reply_count = (SELECT COUNT(*) FROM messages r WHERE r.thread_root_id = messages.id AND r.deleted_at IS NULL),
+    last_reply_at = (SELECT MAX(r.timestamp) FROM messages r WHERE r.thread_root_id = messages.id AND r.deleted_at IS NULL)
+WHERE id IN (SELECT thread_root_id FROM messages WHERE thread_root_id IS NOT NULL);
+
+-- Usato dalla paginazione delle risposte di un thread, ordinate per (timestamp, id)
+CREATE INDEX idx_messages_thread ON messages(thread_root_id, timestamp, id) WHERE thread_root_id IS NOT NULL;
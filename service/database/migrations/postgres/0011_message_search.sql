@@ -0,0 +1,4 @@
+-- Indice di ricerca full-text dei messaggi di testo non eliminati, usato da SearchMessages (vedi search-db.go). La
+-- configurazione 'simple' non applica lo stemming né le stop word, come il tokenizer unicode61 di FTS5 su SQLite.
+CREATE INDEX idx_messages_search ON messages USING GIN (to_tsvector('simple', content))
+    WHERE media_type = 'text' AND deleted_at IS NULL;
@@ -0,0 +1,3 @@
+-- Indici per contare i media e le miniature che usano un blob: la pulizia dei blob non più usati li cerca per chiave.
+CREATE INDEX idx_media_blob_key ON media(blob_key);
+CREATE INDEX idx_media_thumbnails_blob_key ON media_thumbnails(blob_key);
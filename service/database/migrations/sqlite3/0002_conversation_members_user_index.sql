@@ -0,0 +1,3 @@
+-- Indice per trovare le conversazioni di un utente: la chiave primaria (conversation_id, user_id) non lo copre, e senza
+-- gli elenchi delle conversazioni e dei gruppi scorrono tutta la tabella.
+CREATE INDEX idx_conversation_members_user_id ON conversation_members(user_id);
@@ -0,0 +1,14 @@
+-- Ruoli nei gruppi: il creatore è il proprietario ('owner'), che può nominare degli amministratori ('admin'); gli altri
+-- sono membri semplici. Nelle chat 1:1 il ruolo resta 'member'.
+ALTER TABLE conversation_members ADD COLUMN role TEXT NOT NULL DEFAULT 'member' CHECK (role IN ('owner', 'admin', 'member'));
+
+-- Il creatore dei gruppi esistenti non è noto: diventa proprietario il membro con l'ID più basso
+UPDATE conversation_members SET role = 'owner'
+WHERE conversation_id IN (SELECT id FROM conversations WHERE is_group = TRUE)
+  AND user_id = (SELECT MIN(o.user_id) FROM conversation_members o WHERE o.conversation_id = conversation_members.conversation_id);
+
+-- Impostazioni dei gruppi: ognuna, se attiva, riserva l'azione al proprietario e agli amministratori. Sono disattivate
+-- per i gruppi esistenti, che continuano a funzionare come prima.
+ALTER TABLE conversations ADD COLUMN only_admins_edit_info BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE conversations ADD COLUMN only_admins_add_members BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE conversations ADD COLUMN only_admins_send BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,28 @@
+-- Link di invito ai gruppi, creati dal proprietario e dagli amministratori. Il token è la parte casuale del link.
+-- expires_at e max_uses sono NULL se il link non scade o non ha limiti di utilizzo; uses conta gli utenti che lo hanno
+-- usato, per entrare o per chiedere di entrare. Con requires_approval chi usa il link non entra subito ma crea una
+-- richiesta in group_join_requests.
+CREATE TABLE group_invites (
+    token TEXT PRIMARY KEY,
+    conversation_id INTEGER NOT NULL,
+    created_by INTEGER NOT NULL,
+    created_at DATETIME NOT NULL,
+    expires_at DATETIME DEFAULT NULL,
+    max_uses INTEGER DEFAULT NULL,
+    uses INTEGER NOT NULL DEFAULT 0,
+    requires_approval BOOLEAN NOT NULL DEFAULT FALSE,
+    FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE,
+    FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_group_invites_conversation_id ON group_invites(conversation_id);
+
+-- Richieste di ingresso in attesa: vengono eliminate quando un amministratore le approva o le rifiuta
+CREATE TABLE group_join_requests (
+    conversation_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    requested_at DATETIME NOT NULL,
+    PRIMARY KEY (conversation_id, user_id),
+    FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
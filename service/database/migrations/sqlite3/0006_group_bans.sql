@@ -0,0 +1,12 @@
+-- Utenti banditi dai gruppi: non possono essere aggiunti di nuovo né entrare con un link di invito finché un
+-- amministratore non revoca il ban
+CREATE TABLE group_bans (
+    conversation_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    banned_by INTEGER NOT NULL,
+    banned_at DATETIME NOT NULL,
+    PRIMARY KEY (conversation_id, user_id),
+    FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (banned_by) REFERENCES users(id) ON DELETE CASCADE
+);
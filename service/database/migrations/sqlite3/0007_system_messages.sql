@@ -0,0 +1,6 @@
+-- Messaggi di sistema, che registrano nella cronologia dei gruppi gli ingressi, le uscite e le modifiche al nome e alla
+-- foto. kind è 'user' per i messaggi scritti dagli utenti, altrimenti il tipo dell'evento; sender_id è l'utente che ha
+-- compiuto l'azione e target_user_id quello su cui è stata compiuta, per gli eventi che ne hanno uno. Per i cambi di
+-- nome e di foto content contiene il nuovo valore.
+ALTER TABLE messages ADD COLUMN kind TEXT NOT NULL DEFAULT 'user';
+ALTER TABLE messages ADD COLUMN target_user_id INTEGER DEFAULT NULL REFERENCES users(id) ON DELETE SET NULL;
@@ -0,0 +1,15 @@
+-- Messaggi fissati in cima a una conversazione, al massimo maxPinnedMessages per conversazione. pinned_by resta NULL
+-- se l'utente che ha fissato il messaggio viene eliminato.
+CREATE TABLE pinned_messages (
+    message_id INTEGER NOT NULL PRIMARY KEY,
+    conversation_id INTEGER NOT NULL,
+    pinned_by INTEGER DEFAULT NULL,
+    pinned_at DATETIME NOT NULL,
+    FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE,
+    FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE,
+    FOREIGN KEY (pinned_by) REFERENCES users(id) ON DELETE SET NULL
+);
+CREATE INDEX idx_pinned_messages_conversation ON pinned_messages(conversation_id, pinned_at);
+
+-- Impostazione dei gruppi che riserva al proprietario e agli amministratori fissare e togliere i messaggi fissati
+ALTER TABLE conversations ADD COLUMN only_admins_pin BOOLEAN NOT NULL DEFAULT FALSE;
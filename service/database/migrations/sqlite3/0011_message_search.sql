@@ -0,0 +1,3 @@
+-- Su SQLite l'indice di ricerca è la tabella FTS5 messages_fts, creata all'avvio solo se il modulo è disponibile
+-- (vedi search-db.go). La migrazione esiste per mantenere la stessa versione dello schema su entrambi i driver.
+SELECT 1;
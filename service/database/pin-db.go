@@ -0,0 +1,229 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/rerikdev/WASAText/service/globaltime"
+	"github.com/rerikdev/WASAText/service/structures"
+)
+
+// I membri di una conversazione possono fissare in cima alcuni messaggi, al massimo maxPinnedMessages. Nei gruppi con
+// OnlyAdminsPin solo il proprietario e gli amministratori possono fissarli e toglierli. Ogni volta viene aggiunto un
+// messaggio di sistema (MessageKindMessagePinned o MessageKindMessageUnpinned) che risponde al messaggio fissato, così
+// i client possono mostrarne il contenuto. Un messaggio eliminato per tutti non è più fissato.
+
+// maxPinnedMessages è il numero massimo di messaggi fissati in una conversazione
+const maxPinnedMessages = 10
+
+var (
+	// ErrMessageAlreadyPinned viene restituito quando il messaggio da fissare è già fissato
+	ErrMessageAlreadyPinned = errors.New("il messaggio è già fissato")
+
+	// ErrMessageNotPinned viene restituito quando il messaggio da togliere non è fissato
+	ErrMessageNotPinned = errors.New("il messaggio non è fissato")
+
+	// ErrTooManyPinnedMessages viene restituito quando la conversazione ha già maxPinnedMessages messaggi fissati
+	ErrTooManyPinnedMessages = fmt.Errorf("non si possono fissare più di %d messaggi", maxPinnedMessages)
+)
+
+// checkPinAccess restituisce ErrPermissionDenied se l'utente non è membro della conversazione o, nei gruppi in cui
+// solo gli amministratori possono fissare i messaggi, non ha il ruolo per farlo
+func checkPinAccess(q rowQuerier, conversationId, userId int) error {
+	var role string
+	var adminsOnly bool
+	err := q.QueryRow(`
+        SELECT cm.role, c.only_admins_pin
+        FROM conversation_members cm
+        JOIN conversations c ON c.id = cm.conversation_id
+        WHERE cm.conversation_id = ? AND cm.user_id = ?`, conversationId, userId).Scan(&role, &adminsOnly)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrPermissionDenied
+	}
+	if err != nil {
+		return err
+	}
+	if !(&groupAccess{role: role}).can(adminsOnly) {
+		return ErrPermissionDenied
+	}
+	return nil
+}
+
+// insertPinMessage aggiunge il messaggio di sistema di tipo kind che registra chi ha fissato o tolto messageId e ne
+// restituisce l'ID
+func insertPinMessage(q rowQuerier, conversationId, actorId int, kind string, messageId int) (int, error) {
+	var id int
+	err := q.QueryRow(`
+        INSERT INTO messages (conversation_id, sender_id, content, is_forwarded, media_type, status, timestamp, kind, reply_to_message_id)
+        VALUES (?, ?, '', FALSE, 'text', 'sent', ?, ?, ?)
+        RETURNING id`,
+		conversationId, actorId, globaltime.Now().Format(timestampLayout), kind, messageId,
+	).Scan(&id)
+	return id, err
+}
+
+// PinMessage fissa un messaggio della conversazione, se l'utente ha il permesso di farlo. I messaggi eliminati e quelli
+// di sistema non si possono fissare.
+func (db *appdbimpl) PinMessage(conversationId, messageId, userId int) ([]*structures.Message, error) {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return nil, err
+	}
+	// Dopo il commit il rollback non fa nulla
+	defer func() { _ = tx.Rollback() }()
+
+	// Il limite di maxPinnedMessages viene controllato prima di inserire: su PostgreSQL la riga della conversazione
+	// resta bloccata fino alla fine della transazione, così due richieste concorrenti non possono superarlo insieme.
+	// SQLite non ha bisogno del blocco: una transazione che ha letto dati poi modificati da un'altra non può scrivere.
+	if db.c.driver == DriverPostgres {
+		if _, err := tx.Exec(`SELECT 1 FROM conversations WHERE id = ? FOR UPDATE`, conversationId); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkPinAccess(tx, conversationId, userId); err != nil {
+		return nil, err
+	}
+	var exists, pinned bool
+	var count int
+	err = tx.QueryRow(`
+        SELECT EXISTS (SELECT 1 FROM messages WHERE id = ? AND conversation_id = ? AND deleted_at IS NULL AND kind = ?),
+               EXISTS (SELECT 1 FROM pinned_messages WHERE message_id = ?),
+               (SELECT COUNT(*) FROM pinned_messages WHERE conversation_id = ?)`,
+		messageId, conversationId, MessageKindUser, messageId, conversationId).Scan(&exists, &pinned, &count)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case !exists:
+		return nil, ErrMessageNotFound
+	case pinned:
+		return nil, ErrMessageAlreadyPinned
+	case count >= maxPinnedMessages:
+		return nil, ErrTooManyPinnedMessages
+	}
+
+	if _, err := tx.Exec(`
+        INSERT INTO pinned_messages (message_id, conversation_id, pinned_by, pinned_at) VALUES (?, ?, ?, ?)`,
+		messageId, conversationId, userId, globaltime.Now().Format(timestampLayout),
+	); err != nil {
+		return nil, err
+	}
+	id, err := insertPinMessage(tx, conversationId, userId, MessageKindMessagePinned, messageId)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return db.getNewMessages(conversationId, id)
+}
+
+// UnpinMessage toglie un messaggio da quelli fissati della conversazione, se l'utente ha il permesso di farlo
+func (db *appdbimpl) UnpinMessage(conversationId, messageId, userId int) ([]*structures.Message, error) {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return nil, err
+	}
+	// Dopo il commit il rollback non fa nulla
+	defer func() { _ = tx.Rollback() }()
+
+	if err := checkPinAccess(tx, conversationId, userId); err != nil {
+		return nil, err
+	}
+	res, err := tx.Exec(`DELETE FROM pinned_messages WHERE message_id = ? AND conversation_id = ?`, messageId, conversationId)
+	if err != nil {
+		return nil, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if affected == 0 {
+		return nil, ErrMessageNotPinned
+	}
+	id, err := insertPinMessage(tx, conversationId, userId, MessageKindMessageUnpinned, messageId)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return db.getNewMessages(conversationId, id)
+}
+
+// GetPinnedMessages restituisce i messaggi fissati della conversazione, dal più recentemente fissato, esclusi quelli che
+// l'utente ha nascosto. Usa due query: una per i messaggi fissati e una per i messaggi stessi.
+func (db *appdbimpl) GetPinnedMessages(conversationId, userId int) ([]*structures.PinnedMessage, error) {
+	rows, err := db.c.Query(`
+        SELECT p.message_id, p.pinned_at, u.id, COALESCE(u.username, ''), COALESCE(u.display_name, ''),
+               COALESCE(u.profile_picture, '')
+        FROM pinned_messages p
+        LEFT JOIN users u ON u.id = p.pinned_by
+        WHERE p.conversation_id = ?
+          AND NOT EXISTS (SELECT 1 FROM hidden_messages h WHERE h.message_id = p.message_id AND h.user_id = ?)
+        ORDER BY p.pinned_at DESC, p.message_id DESC`, conversationId, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pins := []*structures.PinnedMessage{}
+	var ids []interface{}
+	for rows.Next() {
+		var pin structures.PinnedMessage
+		var messageId int
+		var pinnedById *int
+		var pinnedBy structures.User
+		if err := rows.Scan(&messageId, &pin.PinnedAt, &pinnedById, &pinnedBy.Username, &pinnedBy.DisplayName, &pinnedBy.ProfilePicture); err != nil {
+			return nil, err
+		}
+		if pinnedById != nil {
+			pinnedBy.ID = *pinnedById
+			pin.PinnedBy = &pinnedBy
+		}
+		pin.Message = &structures.Message{ID: messageId}
+		pins = append(pins, &pin)
+		ids = append(ids, messageId)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return pins, nil
+	}
+
+	msgRows, err := db.c.Query(messageSelect+`
+         WHERE m.conversation_id = ? AND m.id IN (`+placeholders(len(ids))+`)`,
+		append([]interface{}{conversationId}, ids...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer msgRows.Close()
+	var messages []*structures.Message
+	messageMap := make(map[int]*structures.Message, len(ids))
+	for msgRows.Next() {
+		msg, err := scanMessage(msgRows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+		messageMap[msg.ID] = msg
+	}
+	if err := msgRows.Err(); err != nil {
+		return nil, err
+	}
+	if err := db.loadRepliesAndReactions(conversationId, messages, messageMap); err != nil {
+		return nil, err
+	}
+
+	// Come il JOIN con users in messageSelect, salta i messaggi il cui mittente non esiste più
+	found := pins[:0]
+	for _, pin := range pins {
+		if msg, ok := messageMap[pin.Message.ID]; ok {
+			pin.Message = msg
+			found = append(found, pin)
+		}
+	}
+	return found, nil
+}
@@ -25,36 +25,41 @@ func (db *appdbimpl) RemoveReaction(messageId int, userId int) error {
 
 // GetReactions restituisce tutte le reazioni a un messaggio
 func (db *appdbimpl) GetReactions(messageId int) ([]*structures.Reaction, error) {
-	rows, err := db.c.Query(`
-        SELECT r.message_id, r.user_id, r.emoji, u.id, u.username, u.display_name, u.profile_picture
-        FROM reactions r
-        JOIN users u ON r.user_id = u.id
-		WHERE r.message_id = ?
-    `, messageId)
+	reactions, err := db.getReactionsByMessage([]interface{}{messageId})
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
+	return reactions[messageId], nil
+}
 
-	var reactions []*structures.Reaction
-	for rows.Next() {
-		reaction := &structures.Reaction{} // Crea PUNTATORE
-		user := &structures.User{}         // Crea PUNTATORE
-		err := rows.Scan(
-			&reaction.MessageId, &reaction.UserId, &reaction.Emoji,
-			&user.ID, &user.Username, &user.DisplayName, &user.ProfilePicture,
-		)
+// getReactionsByMessage restituisce le reazioni ai messaggi indicati, raggruppate per messaggio e in ordine di utente
+func (db *appdbimpl) getReactionsByMessage(messageIds []interface{}) (map[int][]*structures.Reaction, error) {
+	reactions := make(map[int][]*structures.Reaction)
+	for _, chunk := range chunkArgs(messageIds) {
+		rows, err := db.c.Query(`
+        SELECT r.message_id, r.user_id, r.emoji, u.id, u.username, u.display_name, u.profile_picture
+        FROM reactions r
+        JOIN users u ON r.user_id = u.id
+        WHERE r.message_id IN (`+placeholders(len(chunk))+`)
+        ORDER BY r.message_id, r.user_id`, chunk...)
 		if err != nil {
 			return nil, err
 		}
-		reaction.User = *user                   // Dereferenzia il puntatore per assegnare il valore
-		reactions = append(reactions, reaction) // Appendi il PUNTATORE
-	}
-
-	// AGGIUNGI CONTROLLO rows.Err():
-	if err = rows.Err(); err != nil {
-		return nil, err
+		for rows.Next() {
+			reaction := &structures.Reaction{}
+			if err := rows.Scan(
+				&reaction.MessageId, &reaction.UserId, &reaction.Emoji,
+				&reaction.User.ID, &reaction.User.Username, &reaction.User.DisplayName, &reaction.User.ProfilePicture,
+			); err != nil {
+				_ = rows.Close()
+				return nil, err
+			}
+			reactions[reaction.MessageId] = append(reactions[reaction.MessageId], reaction)
+		}
+		// Next ha già chiuso rows
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
 	}
-
 	return reactions, nil
 }
@@ -0,0 +1,122 @@
+package database
+
+import (
+	"database/sql"
+
+	"github.com/rerikdev/WASAText/service/globaltime"
+	"github.com/rerikdev/WASAText/service/structures"
+)
+
+// Ogni messaggio ha una riga in message_receipts per ciascun destinatario (i membri della conversazione al momento
+// dell'invio, escluso il mittente), con le date di consegna e di lettura. La colonna messages.status contiene lo stato
+// complessivo: "received" quando è stato consegnato a tutti i destinatari ancora membri, "read" quando tutti lo hanno
+// letto, altrimenti "sent".
+
+// aggregateStatusStmt ricalcola lo stato complessivo dei messaggi non ancora letti da tutti in una conversazione
+const aggregateStatusStmt = `
+    UPDATE messages
+    SET status = CASE
+        WHEN NOT EXISTS (
+            SELECT 1 FROM message_receipts r
+            JOIN conversation_members cm ON cm.conversation_id = messages.conversation_id AND cm.user_id = r.user_id
+            WHERE r.message_id = messages.id AND r.read_at IS NULL
+        ) THEN 'read'
+        WHEN NOT EXISTS (
+            SELECT 1 FROM message_receipts r
+            JOIN conversation_members cm ON cm.conversation_id = messages.conversation_id AND cm.user_id = r.user_id
+            WHERE r.message_id = messages.id AND r.delivered_at IS NULL
+        ) THEN 'received'
+        ELSE 'sent'
+    END
+    WHERE conversation_id = ? AND status != 'read'`
+
+// createReceipts crea le ricevute di un nuovo messaggio per tutti i membri della conversazione tranne il mittente,
+// nella stessa transazione dell'inserimento
+func createReceipts(e execer, messageId int64, conversationId, senderId int) error {
+	_, err := e.Exec(`
+        INSERT INTO message_receipts (message_id, user_id)
+        SELECT CAST(? AS INTEGER), user_id FROM conversation_members WHERE conversation_id = ? AND user_id != ?`,
+		messageId, conversationId, senderId,
+	)
+	return err
+}
+
+// SetMessagesReceived segna come consegnati all'utente i messaggi della conversazione e restituisce quanti ne ha
+// aggiornati
+func (db *appdbimpl) SetMessagesReceived(conversationId int, userId int) (int64, error) {
+	res, err := db.c.Exec(`
+        UPDATE message_receipts
+        SET delivered_at = ?
+        WHERE user_id = ? AND delivered_at IS NULL
+          AND message_id IN (SELECT id FROM messages WHERE conversation_id = ?)`,
+		globaltime.Now().Format(timestampLayout), userId, conversationId)
+	if err != nil {
+		return 0, err
+	}
+	return db.updateAggregateStatus(conversationId, res)
+}
+
+// SetMessagesRead segna come letti dall'utente i messaggi della conversazione e restituisce quanti ne ha aggiornati.
+// Con collapseThreads le risposte dei thread, che il client non mostra nella cronologia, restano non lette: si
+// leggono con SetThreadRead.
+func (db *appdbimpl) SetMessagesRead(conversationId int, userId int, collapseThreads bool) (int64, error) {
+	filter := ""
+	if collapseThreads {
+		filter = " AND thread_root_id IS NULL"
+	}
+	now := globaltime.Now().Format(timestampLayout)
+	res, err := db.c.Exec(`
+        UPDATE message_receipts
+        SET read_at = ?, delivered_at = COALESCE(delivered_at, ?)
+        WHERE user_id = ? AND read_at IS NULL
+          AND message_id IN (SELECT id FROM messages WHERE conversation_id = ?`+filter+`)`,
+		now, now, userId, conversationId)
+	if err != nil {
+		return 0, err
+	}
+	return db.updateAggregateStatus(conversationId, res)
+}
+
+// updateAggregateStatus ricalcola lo stato complessivo se sono state aggiornate delle ricevute
+func (db *appdbimpl) updateAggregateStatus(conversationId int, res sql.Result) (int64, error) {
+	updated, err := res.RowsAffected()
+	if err != nil || updated == 0 {
+		return updated, err
+	}
+	if _, err := db.c.Exec(aggregateStatusStmt, conversationId); err != nil {
+		return 0, err
+	}
+	return updated, nil
+}
+
+// GetMessageReceipts restituisce le ricevute di un messaggio, una per destinatario
+func (db *appdbimpl) GetMessageReceipts(conversationId, messageId int) ([]*structures.MessageReceipt, error) {
+	rows, err := db.c.Query(`
+        SELECT u.id, u.username, u.display_name, u.profile_picture, r.delivered_at, r.read_at
+        FROM message_receipts r
+        JOIN messages m ON m.id = r.message_id
+        JOIN users u ON u.id = r.user_id
+        WHERE m.conversation_id = ? AND r.message_id = ?
+        ORDER BY r.read_at IS NULL, r.read_at, r.delivered_at IS NULL, r.delivered_at, u.username`,
+		conversationId, messageId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	receipts := []*structures.MessageReceipt{}
+	for rows.Next() {
+		var receipt structures.MessageReceipt
+		if err := rows.Scan(
+			&receipt.User.ID, &receipt.User.Username, &receipt.User.DisplayName, &receipt.User.ProfilePicture,
+			&receipt.DeliveredAt, &receipt.ReadAt,
+		); err != nil {
+			return nil, err
+		}
+		receipts = append(receipts, &receipt)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return receipts, nil
+}
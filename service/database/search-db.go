@@ -0,0 +1,279 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/rerikdev/WASAText/service/structures"
+)
+
+// L'indice FTS5 messages_fts contiene il testo dei messaggi di testo non eliminati ed è mantenuto dai trigger sulla
+// tabella messages. Il modulo FTS5 è disponibile solo se go-sqlite3 è compilato con il tag sqlite_fts5: senza, la
+// ricerca funziona comunque confrontando il contenuto con LIKE. Su PostgreSQL la ricerca usa l'indice GIN
+// idx_messages_search creato dalle migrazioni; in entrambi i casi l'estratto viene costruito da markTerms.
+
+// Delimitatori usati da snippet() attorno ai termini trovati, sostituiti con <mark> dopo l'escape HTML
+const (
+	snippetOpen  = "\x01"
+	snippetClose = "\x02"
+)
+
+// Quando FTS5 non è disponibile l'estratto parte snippetContext byte prima del primo termine trovato ed è lungo al
+// massimo snippetLength byte
+const (
+	snippetContext = 40
+	snippetLength  = 160
+)
+
+var searchIndexStmts = []string{
+	`CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts5(
+        content,
+        content='messages',
+        content_rowid='id',
+        tokenize='unicode61 remove_diacritics 2'
+    );`,
+	`CREATE TRIGGER IF NOT EXISTS messages_fts_insert AFTER INSERT ON messages BEGIN
+        INSERT INTO messages_fts(rowid, content)
+        SELECT new.id, new.content WHERE new.media_type = 'text' AND new.deleted_at IS NULL;
+    END;`,
+	`CREATE TRIGGER IF NOT EXISTS messages_fts_delete AFTER DELETE ON messages BEGIN
+        INSERT INTO messages_fts(messages_fts, rowid, content)
+        SELECT 'delete', old.id, old.content WHERE old.media_type = 'text' AND old.deleted_at IS NULL;
+    END;`,
+	`CREATE TRIGGER IF NOT EXISTS messages_fts_update AFTER UPDATE OF content, media_type, deleted_at ON messages BEGIN
+        INSERT INTO messages_fts(messages_fts, rowid, content)
+        SELECT 'delete', old.id, old.content WHERE old.media_type = 'text' AND old.deleted_at IS NULL;
+        INSERT INTO messages_fts(rowid, content)
+        SELECT new.id, new.content WHERE new.media_type = 'text' AND new.deleted_at IS NULL;
+    END;`,
+}
+
+// createSearchIndex crea l'indice FTS5 e i trigger se non esistono, indicizzando i messaggi già presenti. Restituisce
+// false se il modulo FTS5 non è disponibile.
+func createSearchIndex(db *sql.DB) (bool, error) {
+	var exists int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = 'messages_fts'`).Scan(&exists); err != nil {
+		return false, err
+	}
+	for _, stmt := range searchIndexStmts {
+		if _, err := db.Exec(stmt); err != nil {
+			if strings.Contains(err.Error(), "no such module: fts5") {
+				return false, nil
+			}
+			return false, fmt.Errorf("error creating search index: %w", err)
+		}
+	}
+	if exists == 0 {
+		_, err := db.Exec(`
+            INSERT INTO messages_fts(rowid, content)
+            SELECT id, content FROM messages WHERE media_type = 'text' AND deleted_at IS NULL`)
+		if err != nil {
+			return false, fmt.Errorf("error populating search index: %w", err)
+		}
+	}
+	return true, nil
+}
+
+// MessageSearchQuery descrive una ricerca tra i messaggi delle conversazioni di un utente. Text può essere vuoto se
+// è impostato almeno un filtro; i filtri con valore zero sono ignorati. I risultati sono ordinati dal più recente e
+// Before è il cursore restituito dalla pagina precedente.
+type MessageSearchQuery struct {
+	Text           string
+	ConversationID int
+	SenderID       int
+	From           time.Time
+	To             time.Time
+	MediaType      string
+	Before         *MessageCursor
+	Limit          int
+}
+
+// SearchMessages cerca i messaggi visibili all'utente nelle conversazioni di cui è membro
+func (db *appdbimpl) SearchMessages(userId int, query MessageSearchQuery) (*structures.MessageSearchPage, error) {
+	terms := strings.Fields(query.Text)
+
+	from := "messages m"
+	snippet := "''"
+	// I messaggi di sistema non compaiono tra i risultati
+	where := []string{
+		"m.deleted_at IS NULL",
+		"m.kind = 'user'",
+		"NOT EXISTS (SELECT 1 FROM hidden_messages h WHERE h.message_id = m.id AND h.user_id = ?)",
+	}
+	args := []interface{}{userId}
+	if len(terms) > 0 && db.fts {
+		from = "messages_fts JOIN messages m ON m.id = messages_fts.rowid"
+		snippet = "snippet(messages_fts, 0, char(1), char(2), '…', 12)"
+		where = append(where, "messages_fts MATCH ?")
+		args = append(args, ftsQuery(terms))
+	} else if len(terms) > 0 && db.c.driver == DriverPostgres {
+		// Espressione e condizioni sono quelle dell'indice idx_messages_search, altrimenti non viene usato
+		where = append(where, "m.media_type = 'text'", "to_tsvector('simple', m.content) @@ to_tsquery('simple', ?)")
+		args = append(args, tsQuery(terms))
+	} else if len(terms) > 0 {
+		where = append(where, "m.media_type = 'text'")
+		for _, term := range terms {
+			where = append(where, "m.content "+db.likeOperator()+` ? ESCAPE '\'`)
+			args = append(args, "%"+escapeLike(term)+"%")
+		}
+	}
+	if query.ConversationID != 0 {
+		where = append(where, "m.conversation_id = ?")
+		args = append(args, query.ConversationID)
+	}
+	if query.SenderID != 0 {
+		where = append(where, "m.sender_id = ?")
+		args = append(args, query.SenderID)
+	}
+	if !query.From.IsZero() {
+		where = append(where, "m.timestamp >= ?")
+		args = append(args, query.From.Format(timestampLayout))
+	}
+	if !query.To.IsZero() {
+		where = append(where, "m.timestamp < ?")
+		args = append(args, query.To.Format(timestampLayout))
+	}
+	if query.MediaType != "" {
+		where = append(where, "m.media_type = ?")
+		args = append(args, query.MediaType)
+	}
+	if query.Before != nil {
+		ts := query.Before.Timestamp.Format(timestampLayout)
+		where = append(where, "(m.timestamp < ? OR (m.timestamp = ? AND m.id < ?))")
+		args = append(args, ts, ts, query.Before.ID)
+	}
+	// L'utente deve essere membro della conversazione
+	args = append([]interface{}{userId}, args...)
+	args = append(args, query.Limit+1)
+
+	rows, err := db.c.Query(
+		`SELECT m.id, m.conversation_id, m.sender_id, m.content, m.is_forwarded, m.media_type, m.status, m.timestamp, m.reply_to_message_id, m.edited_at, m.media_id,
+                COALESCE(md.width, 0), COALESCE(md.height, 0), COALESCE(md.placeholder, ''),
+                u.username, u.display_name, u.profile_picture, `+snippet+`
+         FROM `+from+`
+         JOIN conversation_members cm ON cm.conversation_id = m.conversation_id AND cm.user_id = ?
+         JOIN users u ON m.sender_id = u.id
+         LEFT JOIN media md ON md.id = m.media_id
+         WHERE `+strings.Join(where, " AND ")+`
+         ORDER BY m.timestamp DESC, m.id DESC
+         LIMIT ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := &structures.MessageSearchPage{Results: []*structures.MessageSearchResult{}}
+	for rows.Next() {
+		var msg structures.Message
+		var raw string
+		if err := rows.Scan(
+			&msg.ID, &msg.ConversationID, &msg.Sender.ID, &msg.Content, &msg.IsForwarded, &msg.MediaType, &msg.Status, &msg.Timestamp, &msg.ReplyToMessageID, &msg.EditedAt, &msg.MediaID,
+			&msg.Width, &msg.Height, &msg.Placeholder,
+			&msg.Sender.Username, &msg.Sender.DisplayName, &msg.Sender.ProfilePicture, &raw,
+		); err != nil {
+			return nil, err
+		}
+		if len(terms) > 0 && !db.fts {
+			raw = markTerms(msg.Content, terms)
+		}
+		msg.Kind = MessageKindUser
+		result := &structures.MessageSearchResult{Message: &msg}
+		if raw != "" {
+			result.Snippet = renderSnippet(raw)
+		}
+		page.Results = append(page.Results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(page.Results) > query.Limit {
+		page.HasMore = true
+		page.Results = page.Results[:query.Limit]
+	}
+	if page.HasMore {
+		last := page.Results[len(page.Results)-1].Message
+		page.NextCursor = messageCursorOf(last.Timestamp, last.ID).String()
+	}
+	return page, nil
+}
+
+// ftsQuery trasforma il testo cercato in una query FTS5: ogni parola è cercata come prefisso e tutte devono essere
+// presenti. Le parole sono racchiuse tra virgolette, così la sintassi FTS5 non viene interpretata.
+func ftsQuery(terms []string) string {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = `"` + strings.ReplaceAll(term, `"`, `""`) + `"*`
+	}
+	return strings.Join(quoted, " ")
+}
+
+// tsQuery trasforma il testo cercato in una query di PostgreSQL con lo stesso significato di ftsQuery: ogni parola è
+// racchiusa tra apici, così gli operatori di to_tsquery non vengono interpretati.
+func tsQuery(terms []string) string {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = `'` + strings.NewReplacer(`\`, `\\`, `'`, `''`).Replace(term) + `':*`
+	}
+	return strings.Join(quoted, " & ")
+}
+
+// escapeLike protegge i caratteri speciali di LIKE
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// markTerms restituisce un estratto del contenuto attorno al primo termine trovato, con i termini racchiusi tra i
+// delimitatori di snippet(). Usato quando FTS5 non è disponibile.
+func markTerms(content string, terms []string) string {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = regexp.QuoteMeta(term)
+	}
+	matches := regexp.MustCompile(`(?i)`+strings.Join(quoted, "|")).FindAllStringIndex(content, -1)
+	if len(matches) == 0 {
+		return ""
+	}
+
+	start := matches[0][0] - snippetContext
+	prefix := "…"
+	if start <= 0 {
+		start, prefix = 0, ""
+	}
+	for start > 0 && !utf8.RuneStart(content[start]) {
+		start--
+	}
+
+	end, suffix := start+snippetLength, "…"
+	if end >= len(content) {
+		end, suffix = len(content), ""
+	}
+	for end < len(content) && !utf8.RuneStart(content[end]) {
+		end--
+	}
+
+	var b strings.Builder
+	b.WriteString(prefix)
+	pos := start
+	for _, m := range matches {
+		if m[1] > end {
+			break
+		}
+		b.WriteString(content[pos:m[0]])
+		b.WriteString(snippetOpen + content[m[0]:m[1]] + snippetClose)
+		pos = m[1]
+	}
+	b.WriteString(content[pos:end])
+	b.WriteString(suffix)
+	return b.String()
+}
+
+// renderSnippet applica l'escape HTML all'estratto e racchiude i termini trovati in <mark>
+func renderSnippet(raw string) string {
+	return strings.NewReplacer(snippetOpen, "<mark>", snippetClose, "</mark>").Replace(html.EscapeString(raw))
+}
@@ -0,0 +1,14 @@
+//go:build sqlite_fts5
+
+package database
+
+import "testing"
+
+// TestSQLiteSearchIndex checks that a build with the sqlite_fts5 tag searches with the FTS5 index instead of falling
+// back to LIKE, so that the CI entry with the tag runs the search tests on it.
+func TestSQLiteSearchIndex(t *testing.T) {
+	db := newTestDatabase(t, openSQLite(t), DriverSQLite)
+	if !db.(*appdbimpl).fts {
+		t.Error("the FTS5 index is not available")
+	}
+}
@@ -0,0 +1,67 @@
+package database
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/rerikdev/WASAText/service/globaltime"
+)
+
+// ErrSessionNotFound viene restituito quando il token non corrisponde a nessuna sessione valida
+var ErrSessionNotFound = errors.New("sessione non valida o scaduta")
+
+// hashToken calcola l'hash del token: nel database non viene mai salvato il token in chiaro
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateSession salva una nuova sessione per l'utente, eliminando prima quelle già scadute
+func (db *appdbimpl) CreateSession(userId int, token string, expiresAt time.Time) error {
+	now := globaltime.Now()
+	if _, err := db.c.Exec(`DELETE FROM sessions WHERE expires_at <= ?`, now.Format(timestampLayout)); err != nil {
+		return err
+	}
+	_, err := db.c.Exec(
+		`INSERT INTO sessions (token_hash, user_id, created_at, expires_at) VALUES (?, ?, ?, ?)`,
+		hashToken(token), userId, now.Format(timestampLayout), expiresAt.Format(timestampLayout),
+	)
+	return err
+}
+
+// GetSessionUserId restituisce l'ID dell'utente a cui appartiene il token, se la sessione non è scaduta
+func (db *appdbimpl) GetSessionUserId(token string) (int, error) {
+	var userId int
+	err := db.c.QueryRow(
+		`SELECT user_id FROM sessions WHERE token_hash = ? AND expires_at > ?`,
+		hashToken(token), globaltime.Now().Format(timestampLayout),
+	).Scan(&userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrSessionNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	return userId, nil
+}
+
+// RevokeSession invalida la sessione associata al token (logout)
+func (db *appdbimpl) RevokeSession(token string) error {
+	_, err := db.c.Exec(`DELETE FROM sessions WHERE token_hash = ?`, hashToken(token))
+	return err
+}
+
+// RevokeUserSessions invalida tutte le sessioni dell'utente (logout da tutti i dispositivi)
+func (db *appdbimpl) RevokeUserSessions(userId int) error {
+	_, err := db.c.Exec(`DELETE FROM sessions WHERE user_id = ?`, userId)
+	return err
+}
+
+// RevokeOtherSessions invalida tutte le sessioni dell'utente tranne quella associata al token
+func (db *appdbimpl) RevokeOtherSessions(userId int, token string) error {
+	_, err := db.c.Exec(`DELETE FROM sessions WHERE user_id = ? AND token_hash != ?`, userId, hashToken(token))
+	return err
+}
@@ -0,0 +1,2004 @@
+package database
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rerikdev/WASAText/service/globaltime"
+	"github.com/rerikdev/WASAText/service/structures"
+)
+
+// runAppDatabaseTests runs the behavior tests shared by every AppDatabase implementation. open must return a new,
+// empty database for each test. The tests change globaltime.FixedTime, so they must not run in parallel.
+func runAppDatabaseTests(t *testing.T, open func(t *testing.T) AppDatabase) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, db AppDatabase)
+	}{
+		{"Users", testUsers},
+		{"Passwords", testPasswords},
+		{"Conversations", testConversations},
+		{"MessagesPage", testMessagesPage},
+		{"RepliesEditsAndDeletes", testRepliesEditsAndDeletes},
+		{"Receipts", testReceipts},
+		{"Reactions", testReactions},
+		{"Groups", testGroups},
+		{"GroupRoles", testGroupRoles},
+		{"GroupInvites", testGroupInvites},
+		{"GroupBans", testGroupBans},
+		{"GroupDissolution", testGroupDissolution},
+		{"GroupDeletionCleanup", testGroupDeletionCleanup},
+		{"SystemMessages", testSystemMessages},
+		{"GroupInfo", testGroupInfo},
+		{"Threads", testThreads},
+		{"PinnedMessages", testPinnedMessages},
+		{"ConcurrentPins", testConcurrentPins},
+		{"Inbox", testInbox},
+		{"Sessions", testSessions},
+		{"Events", testEvents},
+		{"Media", testMedia},
+		{"MediaAccess", testMediaAccess},
+		{"Search", testSearch},
+		{"InlineImages", testInlineImages},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTime(t, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+			tt.run(t, open(t))
+		})
+	}
+}
+
+// setTime fixes the time returned by globaltime.Now until the end of the test.
+func setTime(t testing.TB, now time.Time) {
+	t.Helper()
+	previous := globaltime.FixedTime
+	globaltime.FixedTime = now
+	t.Cleanup(func() { globaltime.FixedTime = previous })
+}
+
+// advance moves the fixed time forward by d.
+func advance(d time.Duration) {
+	globaltime.FixedTime = globaltime.FixedTime.Add(d)
+}
+
+// register creates a user without password.
+func register(t testing.TB, db AppDatabase, username string) *structures.User {
+	t.Helper()
+	user, action, err := db.DoLogin(username, strings.ToUpper(username), "/media/"+username, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if action != "register" {
+		t.Fatalf("DoLogin(%q) = %q, want register", username, action)
+	}
+	return user
+}
+
+// send sends a text message and returns it.
+func send(t testing.TB, db AppDatabase, conversationId, senderId int, content string, replyTo *int) *structures.Message {
+	t.Helper()
+	msg, err := db.SendMessage(conversationId, senderId, content, "text", nil, false, replyTo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+// newGroup creates a group with the users and returns its ID. The first user creates the group and is its owner.
+func newGroup(t testing.TB, db AppDatabase, name string, users ...*structures.User) int {
+	t.Helper()
+	usernames := make([]string, len(users))
+	for i, u := range users {
+		usernames[i] = u.Username
+	}
+	group, err := db.AddToGroup(users[0].ID, name, "/media/"+name, usernames, GroupOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return group.ID
+}
+
+// messageIds returns the IDs of the messages, in order.
+func messageIds(messages []*structures.Message) []int {
+	ids := make([]int, len(messages))
+	for i, m := range messages {
+		ids[i] = m.ID
+	}
+	return ids
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func testUsers(t *testing.T, db AppDatabase) {
+	alice := register(t, db, "alice")
+	bob := register(t, db, "bob")
+	if alice.ID == bob.ID {
+		t.Fatal("users share the same ID")
+	}
+
+	user, action, err := db.DoLogin("alice", "", "", "")
+	if err != nil || action != "login" || user.ID != alice.ID || user.DisplayName != "ALICE" {
+		t.Fatalf("login = %+v, %q, %v", user, action, err)
+	}
+	if _, _, err := db.DoLogin("alice", "Alice", "/media/a", ""); err == nil {
+		t.Error("registering an existing username succeeded")
+	}
+	if exists, err := db.CheckUserExistence("carol"); err != nil || exists {
+		t.Errorf("CheckUserExistence(carol) = %v, %v", exists, err)
+	}
+
+	// The search ignores case on every database
+	found, err := db.SearchUsers("LIC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0].ID != alice.ID {
+		t.Errorf("SearchUsers(LIC) = %+v, want alice", found)
+	}
+
+	if err := db.SetMyUserNameById(strconv.Itoa(bob.ID), "alice"); err == nil {
+		t.Error("taking the username of another user succeeded")
+	}
+	if err := db.SetMyUserNameById(strconv.Itoa(bob.ID), "robert"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetMyPhotoById(strconv.Itoa(bob.ID), "/media/robert"); err != nil {
+		t.Fatal(err)
+	}
+	user, err = db.GetUserById(strconv.Itoa(bob.ID))
+	if err != nil || user.Username != "robert" || user.ProfilePicture != "/media/robert" {
+		t.Errorf("GetUserById = %+v, %v", user, err)
+	}
+}
+
+func testPasswords(t *testing.T, db AppDatabase) {
+	user, _, err := db.DoLogin("carol", "Carol", "/media/carol", "first-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := db.DoLogin("carol", "", "", "first-secret"); err != nil {
+		t.Fatalf("login with the right password: %v", err)
+	}
+	if err := db.ChangePassword(user.ID, "wrong", "second-secret"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("ChangePassword with a wrong password: got %v", err)
+	}
+	if err := db.ChangePassword(user.ID, "first-secret", "second-secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < maxLoginFailures; i++ {
+		if _, _, err := db.DoLogin("carol", "", "", "first-secret"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("attempt %d with the old password: got %v", i+1, err)
+		}
+	}
+	if _, _, err := db.DoLogin("carol", "", "", "second-secret"); !errors.Is(err, ErrTooManyAttempts) {
+		t.Fatalf("login after too many failures: got %v", err)
+	}
+	advance(loginLockout - time.Second)
+	if _, _, err := db.DoLogin("carol", "", "", "second-secret"); !errors.Is(err, ErrTooManyAttempts) {
+		t.Fatalf("login just before the end of the lockout: got %v", err)
+	}
+	advance(time.Second)
+	if _, _, err := db.DoLogin("carol", "", "", "second-secret"); err != nil {
+		t.Fatalf("login after the lockout: %v", err)
+	}
+}
+
+func testConversations(t *testing.T, db AppDatabase) {
+	alice, bob, carol := register(t, db, "alice"), register(t, db, "bob"), register(t, db, "carol")
+
+	id, err := db.CreateConversation(alice.ID, bob.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, err := db.CreateConversation(bob.ID, alice.ID); err != nil || again != id {
+		t.Errorf("second CreateConversation = %d, %v; want %d", again, err, id)
+	}
+	if _, err := db.CreateConversation(alice.ID, alice.ID); err == nil {
+		t.Error("conversation with oneself created")
+	}
+
+	convId := int(id)
+	if isGroup, isMember, err := db.GetMembership(convId, bob.ID); err != nil || isGroup || !isMember {
+		t.Errorf("GetMembership(bob) = %v, %v, %v", isGroup, isMember, err)
+	}
+	if _, isMember, err := db.GetMembership(convId, carol.ID); err != nil || isMember {
+		t.Errorf("GetMembership(carol) = %v, %v", isMember, err)
+	}
+	if _, _, err := db.GetMembership(convId+100, bob.ID); !errors.Is(err, ErrConversationNotFound) {
+		t.Errorf("GetMembership of a missing conversation: got %v", err)
+	}
+	ids, err := db.GetConversationMemberIds(convId)
+	sort.Ints(ids)
+	if err != nil || !equalInts(ids, []int{alice.ID, bob.ID}) {
+		t.Errorf("GetConversationMemberIds = %v, %v", ids, err)
+	}
+
+	send(t, db, convId, alice.ID, "first", nil)
+	advance(time.Second)
+	send(t, db, convId, bob.ID, "second", nil)
+	if _, err := db.SendMessage(convId, carol.ID, "intruder", "text", nil, false, nil); err == nil {
+		t.Error("a non-member sent a message")
+	}
+
+	previews, err := db.GetUserConversations(alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(previews) != 1 || previews[0].OtherUserID != bob.ID || previews[0].LastMessage != "second" ||
+		previews[0].LastMessageTime != "2024-05-01T12:00:01Z" {
+		t.Errorf("GetUserConversations = %+v", previews)
+	}
+	messages, err := db.GetMessages(convId)
+	if err != nil || len(messages) != 2 || messages[0].Content != "first" || messages[0].Sender.Username != "alice" {
+		t.Errorf("GetMessages = %+v, %v", messages, err)
+	}
+}
+
+func testMessagesPage(t *testing.T, db AppDatabase) {
+	alice, bob := register(t, db, "alice"), register(t, db, "bob")
+	id, err := db.CreateConversation(alice.ID, bob.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	convId := int(id)
+	var ids []int
+	for i := 0; i < 5; i++ {
+		ids = append(ids, send(t, db, convId, alice.ID, "message", nil).ID)
+		// Two messages in the same second are ordered by ID
+		if i%2 == 1 {
+			advance(time.Second)
+		}
+	}
+
+	latest, err := db.GetMessagesPage(convId, bob.ID, MessagePageQuery{Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalInts(messageIds(latest.Messages), ids[3:]) || !latest.HasMore {
+		t.Fatalf("latest page = %v (more %v), want %v", messageIds(latest.Messages), latest.HasMore, ids[3:])
+	}
+	before, err := ParseMessageCursor(latest.PrevCursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	older, err := db.GetMessagesPage(convId, bob.ID, MessagePageQuery{Before: &before, Limit: 10})
+	if err != nil || !equalInts(messageIds(older.Messages), ids[:3]) || older.HasMore {
+		t.Fatalf("older page = %v (more %v), %v; want %v", messageIds(older.Messages), older.HasMore, err, ids[:3])
+	}
+	after, err := ParseMessageCursor(older.NextCursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newer, err := db.GetMessagesPage(convId, bob.ID, MessagePageQuery{After: &after, Limit: 1})
+	if err != nil || !equalInts(messageIds(newer.Messages), ids[3:4]) || !newer.HasMore {
+		t.Fatalf("newer page = %v (more %v), %v; want %v", messageIds(newer.Messages), newer.HasMore, err, ids[3:4])
+	}
+
+	// Messages hidden by a user are hidden only for them
+	if err := db.HideMessage(convId, ids[4], bob.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.HideMessage(convId, ids[4], bob.ID); err != nil {
+		t.Fatalf("hiding a message twice: %v", err)
+	}
+	page, err := db.GetMessagesPage(convId, bob.ID, MessagePageQuery{Limit: 10})
+	if err != nil || !equalInts(messageIds(page.Messages), ids[:4]) {
+		t.Errorf("page after hiding = %v, %v", messageIds(page.Messages), err)
+	}
+	page, err = db.GetMessagesPage(convId, alice.ID, MessagePageQuery{Limit: 10})
+	if err != nil || !equalInts(messageIds(page.Messages), ids) {
+		t.Errorf("page of the other member = %v, %v", messageIds(page.Messages), err)
+	}
+	if _, err := db.GetMessagesPage(convId+100, bob.ID, MessagePageQuery{Limit: 10}); !errors.Is(err, ErrConversationNotFound) {
+		t.Errorf("page of a missing conversation: got %v", err)
+	}
+}
+
+func testRepliesEditsAndDeletes(t *testing.T, db AppDatabase) {
+	alice, bob := register(t, db, "alice"), register(t, db, "bob")
+	id, err := db.CreateConversation(alice.ID, bob.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	convId := int(id)
+	original := send(t, db, convId, alice.ID, "hello", nil)
+	reply := send(t, db, convId, bob.ID, "hi", &original.ID)
+
+	// SendMessage returns the message it inserted, with the preview of the message it replies to
+	if reply.Content != "hi" || reply.Sender.ID != bob.ID || reply.Status != "sent" ||
+		reply.ReplyToMessage == nil || reply.ReplyToMessage.Content != "hello" {
+		t.Errorf("sent reply = %+v", reply)
+	}
+	msg, err := db.GetMessageById(convId, reply.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.ReplyToMessage == nil || msg.ReplyToMessage.ID != original.ID || msg.ReplyToMessage.Content != "hello" {
+		t.Errorf("reply preview = %+v", msg.ReplyToMessage)
+	}
+	other := 9999
+	if _, err := db.SendMessage(convId, bob.ID, "lost", "text", nil, false, &other); err == nil {
+		t.Error("replied to a missing message")
+	}
+	if exists, err := db.MessageExists(convId, reply.ID); err != nil || !exists {
+		t.Errorf("MessageExists = %v, %v", exists, err)
+	}
+	if exists, err := db.MessageExists(convId+100, reply.ID); err != nil || exists {
+		t.Errorf("MessageExists in another conversation = %v, %v", exists, err)
+	}
+
+	window := 15 * time.Minute
+	if _, err := db.EditMessage(convId, original.ID, bob.ID, "hacked", window); !errors.Is(err, ErrNotMessageSender) {
+		t.Errorf("edit by another user: got %v", err)
+	}
+	advance(time.Minute)
+	edited, err := db.EditMessage(convId, original.ID, alice.ID, "hello, world", window)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if edited.Content != "hello, world" || edited.EditedAt == nil || *edited.EditedAt != "2024-05-01T12:01:00Z" {
+		t.Errorf("edited message = %+v", edited)
+	}
+	edits, err := db.GetMessageEdits(convId, original.ID)
+	if err != nil || len(edits) != 1 || edits[0].Content != "hello" {
+		t.Errorf("GetMessageEdits = %+v, %v", edits, err)
+	}
+	advance(window)
+	if _, err := db.EditMessage(convId, original.ID, alice.ID, "too late", window); !errors.Is(err, ErrEditWindowExpired) {
+		t.Errorf("edit after the window: got %v", err)
+	}
+
+	if err := db.DeleteMessage(convId, original.ID, bob.ID); err == nil {
+		t.Error("a message was deleted by another user")
+	}
+	if err := db.DeleteMessage(convId, original.ID, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	msg, err = db.GetMessageById(convId, original.ID)
+	if err != nil || msg.Content != "" || msg.DeletedAt == nil {
+		t.Errorf("deleted message = %+v, %v", msg, err)
+	}
+	if edits, err := db.GetMessageEdits(convId, original.ID); err != nil || len(edits) != 0 {
+		t.Errorf("edits of a deleted message = %+v, %v", edits, err)
+	}
+
+	if _, err := db.SendMessage(convId, bob.ID, "too late", "text", nil, false, &original.ID); err == nil {
+		t.Error("replied to a deleted message")
+	}
+
+	// Only placeholders older than the cutoff are purged. The first message of a thread stays while the thread has
+	// replies, the other replies just lose their reference.
+	late := send(t, db, convId, alice.ID, "still there?", &reply.ID)
+	if err := db.DeleteMessage(convId, reply.ID, bob.ID); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := db.PurgeDeletedMessages(globaltime.Now().Add(-time.Hour)); err != nil || n != 0 {
+		t.Errorf("early purge removed %d messages, %v", n, err)
+	}
+	if n, err := db.PurgeDeletedMessages(globaltime.Now().Add(time.Second)); err != nil || n != 1 {
+		t.Errorf("purge removed %d messages, %v; want 1", n, err)
+	}
+	if _, err := db.GetMessageById(convId, reply.ID); err == nil {
+		t.Error("purged message still found")
+	}
+	if _, err := db.GetMessageById(convId, original.ID); err != nil {
+		t.Errorf("first message of a live thread purged: %v", err)
+	}
+	msg, err = db.GetMessageById(convId, late.ID)
+	if err != nil || msg.ReplyToMessageID != nil || msg.ThreadRootID == nil || *msg.ThreadRootID != original.ID {
+		t.Errorf("reply to a purged message = %+v, %v", msg, err)
+	}
+	if err := db.DeleteMessage(convId, late.ID, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := db.PurgeDeletedMessages(globaltime.Now().Add(time.Second)); err != nil || n != 2 {
+		t.Errorf("purge of the thread removed %d messages, %v; want 2", n, err)
+	}
+	if _, err := db.GetMessageById(convId, original.ID); err == nil {
+		t.Error("first message of an empty thread still found")
+	}
+}
+
+func testReceipts(t *testing.T, db AppDatabase) {
+	alice, bob, carol := register(t, db, "alice"), register(t, db, "bob"), register(t, db, "carol")
+	group := newGroup(t, db, "team", alice, bob, carol)
+	msg := send(t, db, group, alice.ID, "news", nil)
+
+	status := func() string {
+		t.Helper()
+		m, err := db.GetMessageById(group, msg.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m.Status
+	}
+	if s := status(); s != "sent" {
+		t.Fatalf("status of a new message = %q", s)
+	}
+	if n, err := db.SetMessagesReceived(group, bob.ID); err != nil || n != 1 {
+		t.Fatalf("SetMessagesReceived(bob) = %d, %v", n, err)
+	}
+	if n, err := db.SetMessagesReceived(group, bob.ID); err != nil || n != 0 {
+		t.Errorf("second SetMessagesReceived(bob) = %d, %v", n, err)
+	}
+	if s := status(); s != "sent" {
+		t.Errorf("status after one delivery = %q, want sent", s)
+	}
+	if _, err := db.SetMessagesReceived(group, carol.ID); err != nil {
+		t.Fatal(err)
+	}
+	if s := status(); s != "received" {
+		t.Errorf("status after all deliveries = %q, want received", s)
+	}
+	if _, err := db.SetMessagesRead(group, bob.ID, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.SetMessagesRead(group, carol.ID, false); err != nil {
+		t.Fatal(err)
+	}
+	if s := status(); s != "read" {
+		t.Errorf("status after all reads = %q, want read", s)
+	}
+
+	receipts, err := db.GetMessageReceipts(group, msg.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(receipts) != 2 {
+		t.Fatalf("GetMessageReceipts = %d receipts, want 2", len(receipts))
+	}
+	for _, r := range receipts {
+		if r.User.ID == alice.ID || r.DeliveredAt == nil || r.ReadAt == nil || *r.ReadAt != "2024-05-01T12:00:00Z" {
+			t.Errorf("receipt = %+v", r)
+		}
+	}
+}
+
+func testReactions(t *testing.T, db AppDatabase) {
+	alice, bob := register(t, db, "alice"), register(t, db, "bob")
+	id, err := db.CreateConversation(alice.ID, bob.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := send(t, db, int(id), alice.ID, "react to me", nil)
+
+	for _, emoji := range []string{"👍", "🎉"} {
+		if err := db.AddReaction(msg.ID, bob.ID, emoji); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.AddReaction(msg.ID, alice.ID, "👍"); err != nil {
+		t.Fatal(err)
+	}
+	reactions, err := db.GetReactions(msg.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reactions) != 2 || reactions[0].UserId != alice.ID || reactions[1].Emoji != "🎉" || reactions[1].User.Username != "bob" {
+		t.Errorf("GetReactions = %+v", reactions)
+	}
+	if err := db.RemoveReaction(msg.ID, bob.ID); err != nil {
+		t.Fatal(err)
+	}
+	if reactions, err := db.GetReactions(msg.ID); err != nil || len(reactions) != 1 {
+		t.Errorf("GetReactions after removal = %+v, %v", reactions, err)
+	}
+}
+
+func testGroups(t *testing.T, db AppDatabase) {
+	alice, bob, carol := register(t, db, "alice"), register(t, db, "bob"), register(t, db, "carol")
+	if _, err := db.AddToGroup(alice.ID, "bad", "", []string{"alice", "nobody"}, GroupOptions{}); err == nil {
+		t.Error("group created with a missing user")
+	}
+	group := newGroup(t, db, "team", alice, bob)
+	if isGroup, isMember, err := db.GetMembership(group, alice.ID); err != nil || !isGroup || !isMember {
+		t.Errorf("GetMembership = %v, %v, %v", isGroup, isMember, err)
+	}
+
+	if _, err := db.AddMembersToGroup(group, bob.ID, []string{"carol", "bob", "nobody"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.SetGroupName(group, bob.ID, "dream team"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.SetGroupPhoto(group, alice.ID, "/media/dream"); err != nil {
+		t.Fatal(err)
+	}
+	send(t, db, group, carol.ID, "joined", nil)
+
+	groups, err := db.ListGroups(alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 {
+		t.Fatalf("ListGroups = %d groups, want 1", len(groups))
+	}
+	g := groups[0]
+	var members []int
+	for _, m := range g.Members {
+		members = append(members, m.ID)
+	}
+	if g.ID != group || g.Name != "dream team" || g.Photo != "/media/dream" || g.LastMessage != "joined" ||
+		!equalInts(members, []int{alice.ID, bob.ID, carol.ID}) {
+		t.Errorf("ListGroups = %+v", g)
+	}
+
+	if _, err := db.LeaveGroup(group, bob.ID); err != nil {
+		t.Fatal(err)
+	}
+	if groups, err := db.ListGroups(bob.ID); err != nil || len(groups) != 0 {
+		t.Errorf("ListGroups after leaving = %+v, %v", groups, err)
+	}
+
+	chat, err := db.CreateConversation(alice.ID, bob.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.SetGroupName(int(chat), alice.ID, "not a group"); err == nil {
+		t.Error("renamed a 1:1 conversation")
+	}
+	if groups, err := db.ListGroups(alice.ID); err != nil || len(groups) != 1 {
+		t.Errorf("ListGroups includes 1:1 conversations: %+v, %v", groups, err)
+	}
+}
+
+func testGroupRoles(t *testing.T, db AppDatabase) {
+	alice, bob, carol, dave := register(t, db, "alice"), register(t, db, "bob"), register(t, db, "carol"), register(t, db, "dave")
+	group := newGroup(t, db, "team", alice, bob, carol, dave)
+	roles := func() map[int]string {
+		t.Helper()
+		groups, err := db.ListGroups(alice.ID)
+		if err != nil || len(groups) != 1 {
+			t.Fatalf("ListGroups = %+v, %v", groups, err)
+		}
+		roles := make(map[int]string)
+		for _, m := range groups[0].Members {
+			roles[m.ID] = m.Role
+		}
+		return roles
+	}
+	if r := roles(); r[alice.ID] != RoleOwner || r[bob.ID] != RoleMember || r[carol.ID] != RoleMember {
+		t.Errorf("roles after creation = %v", r)
+	}
+
+	// Members can't promote, admins can promote but only the owner can demote another admin
+	if err := db.SetGroupMemberRole(group, bob.ID, carol.ID, RoleAdmin); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("member promoting: got %v", err)
+	}
+	if err := db.SetGroupMemberRole(group, alice.ID, bob.ID, RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetGroupMemberRole(group, bob.ID, carol.ID, RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetGroupMemberRole(group, bob.ID, carol.ID, RoleMember); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("admin demoting an admin: got %v", err)
+	}
+	if err := db.SetGroupMemberRole(group, bob.ID, alice.ID, RoleMember); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("admin demoting the owner: got %v", err)
+	}
+	if err := db.SetGroupMemberRole(group, alice.ID, carol.ID, RoleMember); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetGroupMemberRole(group, alice.ID, carol.ID, RoleOwner); err == nil {
+		t.Error("made a second owner")
+	}
+	if err := db.SetGroupMemberRole(group, alice.ID, alice.ID+100, RoleAdmin); !errors.Is(err, ErrMemberNotFound) {
+		t.Errorf("promoting a non-member: got %v", err)
+	}
+	if r := roles(); r[alice.ID] != RoleOwner || r[bob.ID] != RoleAdmin || r[carol.ID] != RoleMember {
+		t.Errorf("roles after the changes = %v", r)
+	}
+
+	// Admins remove members, only the owner removes admins and nobody removes the owner
+	if _, err := db.RemoveGroupMember(group, carol.ID, dave.ID); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("member removing: got %v", err)
+	}
+	if _, err := db.RemoveGroupMember(group, bob.ID, alice.ID); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("admin removing the owner: got %v", err)
+	}
+	if _, err := db.RemoveGroupMember(group, bob.ID, dave.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.RemoveGroupMember(group, bob.ID, dave.ID); !errors.Is(err, ErrMemberNotFound) {
+		t.Errorf("removing a removed member: got %v", err)
+	}
+	if _, err := db.RemoveGroupMember(group, alice.ID, alice.ID); err == nil {
+		t.Error("the owner removed themselves")
+	}
+	if _, isMember, err := db.GetMembership(group, dave.ID); err != nil || isMember {
+		t.Errorf("GetMembership of a removed member = %v, %v", isMember, err)
+	}
+	if _, err := db.SetGroupName(group, dave.ID, "hijacked"); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("SetGroupName by a removed member: got %v", err)
+	}
+
+	// The settings restrict editing, adding members and sending to the owner and the admins
+	onlyAdmins := true
+	if _, err := db.SetGroupSettings(group, carol.ID, GroupSettingsUpdate{OnlyAdminsSend: &onlyAdmins}); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("member changing the settings: got %v", err)
+	}
+	settings, err := db.SetGroupSettings(group, bob.ID, GroupSettingsUpdate{
+		OnlyAdminsEditInfo: &onlyAdmins, OnlyAdminsAddMembers: &onlyAdmins, OnlyAdminsSend: &onlyAdmins,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !settings.OnlyAdminsEditInfo || !settings.OnlyAdminsAddMembers || !settings.OnlyAdminsSend {
+		t.Errorf("SetGroupSettings = %+v", settings)
+	}
+	if _, err := db.SetGroupName(group, carol.ID, "carol's"); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("member renaming a restricted group: got %v", err)
+	}
+	if _, err := db.SetGroupPhoto(group, carol.ID, "/media/carol"); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("member changing the photo of a restricted group: got %v", err)
+	}
+	if _, err := db.AddMembersToGroup(group, carol.ID, []string{"dave"}); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("member adding to a restricted group: got %v", err)
+	}
+	if _, err := db.SendMessage(group, carol.ID, "hi", "text", nil, false, nil); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("member sending to a restricted group: got %v", err)
+	}
+	send(t, db, group, bob.ID, "announcement", nil)
+	if _, err := db.AddMembersToGroup(group, bob.ID, []string{"dave"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Fields left out of the update keep their value
+	open := false
+	settings, err = db.SetGroupSettings(group, alice.ID, GroupSettingsUpdate{OnlyAdminsSend: &open})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !settings.OnlyAdminsEditInfo || !settings.OnlyAdminsAddMembers || settings.OnlyAdminsSend {
+		t.Errorf("SetGroupSettings with one field = %+v", settings)
+	}
+	groups, err := db.ListGroups(carol.ID)
+	if err != nil || len(groups) != 1 || groups[0].Settings != *settings {
+		t.Errorf("ListGroups settings = %+v, %v", groups, err)
+	}
+	send(t, db, group, carol.ID, "hello again", nil)
+	if r := roles(); r[dave.ID] != RoleMember {
+		t.Errorf("role of a member added again = %q", r[dave.ID])
+	}
+}
+
+func testGroupInvites(t *testing.T, db AppDatabase) {
+	alice, bob, carol := register(t, db, "alice"), register(t, db, "bob"), register(t, db, "carol")
+	dave, erin := register(t, db, "dave"), register(t, db, "erin")
+	group := newGroup(t, db, "team", alice, bob)
+
+	// Only the owner and the admins manage the invites
+	if _, err := db.CreateGroupInvite(group, bob.ID, GroupInviteOptions{Token: "by-bob"}); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("member creating an invite: got %v", err)
+	}
+	expiresAt := globaltime.Now().Add(time.Hour)
+	invite, err := db.CreateGroupInvite(group, alice.ID, GroupInviteOptions{Token: "open", ExpiresAt: &expiresAt, MaxUses: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if invite.Token != "open" || invite.GroupID != group || invite.CreatedBy != alice.ID || invite.Uses != 0 ||
+		invite.MaxUses == nil || *invite.MaxUses != 2 || invite.ExpiresAt == nil || *invite.ExpiresAt != "2024-05-01T13:00:00Z" ||
+		invite.RequiresApproval {
+		t.Errorf("CreateGroupInvite = %+v", invite)
+	}
+
+	preview, err := db.PreviewGroupInvite("open", carol.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if preview.GroupID != group || preview.Name != "team" || preview.MemberCount != 2 || preview.IsMember || preview.Pending {
+		t.Errorf("PreviewGroupInvite = %+v", preview)
+	}
+	if _, err := db.PreviewGroupInvite("missing", carol.ID); !errors.Is(err, ErrInviteNotFound) {
+		t.Errorf("PreviewGroupInvite of a missing token: got %v", err)
+	}
+
+	// Each join counts for the limit, until the link is exhausted
+	if id, pending, _, err := db.JoinGroupByInvite("open", carol.ID); err != nil || id != group || pending {
+		t.Fatalf("JoinGroupByInvite = %d, %v, %v", id, pending, err)
+	}
+	if _, _, _, err := db.JoinGroupByInvite("open", carol.ID); !errors.Is(err, ErrAlreadyMember) {
+		t.Errorf("joining twice: got %v", err)
+	}
+	if _, _, _, err := db.JoinGroupByInvite("open", dave.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := db.JoinGroupByInvite("open", erin.ID); !errors.Is(err, ErrInviteExpired) {
+		t.Errorf("joining with an exhausted link: got %v", err)
+	}
+	if _, err := db.PreviewGroupInvite("open", erin.ID); !errors.Is(err, ErrInviteExpired) {
+		t.Errorf("PreviewGroupInvite of an exhausted link: got %v", err)
+	}
+	if _, isMember, err := db.GetMembership(group, dave.ID); err != nil || !isMember {
+		t.Errorf("GetMembership after joining = %v, %v", isMember, err)
+	}
+
+	// Links that need approval create join requests
+	if err := db.SetGroupMemberRole(group, alice.ID, bob.ID, RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateGroupInvite(group, bob.ID, GroupInviteOptions{Token: "approval", RequiresApproval: true}); err != nil {
+		t.Fatal(err)
+	}
+	expiresAt = globaltime.Now().Add(time.Minute)
+	if _, err := db.CreateGroupInvite(group, bob.ID, GroupInviteOptions{Token: "short", ExpiresAt: &expiresAt}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.RemoveGroupMember(group, bob.ID, dave.ID); err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range []*structures.User{erin, dave, erin} {
+		advance(time.Second)
+		if id, pending, _, err := db.JoinGroupByInvite("approval", u.ID); err != nil || id != group || !pending {
+			t.Fatalf("JoinGroupByInvite with approval = %d, %v, %v", id, pending, err)
+		}
+	}
+	if preview, err := db.PreviewGroupInvite("approval", erin.ID); err != nil || !preview.Pending || !preview.RequiresApproval {
+		t.Errorf("PreviewGroupInvite with a pending request = %+v, %v", preview, err)
+	}
+	if _, err := db.ListGroupJoinRequests(group, carol.ID); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("member listing the join requests: got %v", err)
+	}
+	requests, err := db.ListGroupJoinRequests(group, bob.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 2 || requests[0].User.ID != erin.ID || requests[1].User.ID != dave.ID ||
+		requests[0].RequestedAt != "2024-05-01T12:00:01Z" {
+		t.Errorf("ListGroupJoinRequests = %+v", requests)
+	}
+
+	invites, err := db.ListGroupInvites(group, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tokens []string
+	for _, i := range invites {
+		tokens = append(tokens, i.Token)
+	}
+	if strings.Join(tokens, ",") != "approval,short" || invites[0].Uses != 2 {
+		t.Errorf("ListGroupInvites = %v, %+v", tokens, invites[0])
+	}
+
+	if _, err := db.ApproveGroupJoinRequest(group, carol.ID, erin.ID); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("member approving: got %v", err)
+	}
+	if _, err := db.ApproveGroupJoinRequest(group, bob.ID, erin.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.RejectGroupJoinRequest(group, alice.ID, dave.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.RejectGroupJoinRequest(group, alice.ID, dave.ID); !errors.Is(err, ErrJoinRequestNotFound) {
+		t.Errorf("rejecting twice: got %v", err)
+	}
+	if _, isMember, err := db.GetMembership(group, erin.ID); err != nil || !isMember {
+		t.Errorf("GetMembership after approval = %v, %v", isMember, err)
+	}
+	if _, isMember, err := db.GetMembership(group, dave.ID); err != nil || isMember {
+		t.Errorf("GetMembership after rejection = %v, %v", isMember, err)
+	}
+	if requests, err := db.ListGroupJoinRequests(group, bob.ID); err != nil || len(requests) != 0 {
+		t.Errorf("ListGroupJoinRequests after resolving = %+v, %v", requests, err)
+	}
+
+	// Expired and revoked links can't be used
+	advance(time.Minute)
+	if _, _, _, err := db.JoinGroupByInvite("short", dave.ID); !errors.Is(err, ErrInviteExpired) {
+		t.Errorf("joining with an expired link: got %v", err)
+	}
+	if err := db.RevokeGroupInvite(group, carol.ID, "approval"); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("member revoking: got %v", err)
+	}
+	if err := db.RevokeGroupInvite(group, alice.ID, "approval"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.RevokeGroupInvite(group, alice.ID, "approval"); !errors.Is(err, ErrInviteNotFound) {
+		t.Errorf("revoking twice: got %v", err)
+	}
+	if _, _, _, err := db.JoinGroupByInvite("approval", dave.ID); !errors.Is(err, ErrInviteNotFound) {
+		t.Errorf("joining with a revoked link: got %v", err)
+	}
+	if invites, err := db.ListGroupInvites(group, alice.ID); err != nil || len(invites) != 0 {
+		t.Errorf("ListGroupInvites after expiry and revocation = %+v, %v", invites, err)
+	}
+}
+
+func testGroupBans(t *testing.T, db AppDatabase) {
+	alice, bob, carol, dave := register(t, db, "alice"), register(t, db, "bob"), register(t, db, "carol"), register(t, db, "dave")
+	erin := register(t, db, "erin")
+	group := newGroup(t, db, "team", alice, bob, carol)
+	if err := db.SetGroupMemberRole(group, alice.ID, bob.ID, RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+
+	// Bans follow the same rules as removals
+	if _, err := db.BanFromGroup(group, carol.ID, dave.ID); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("member banning: got %v", err)
+	}
+	if _, err := db.BanFromGroup(group, bob.ID, alice.ID); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("admin banning the owner: got %v", err)
+	}
+	if _, err := db.BanFromGroup(group, bob.ID, bob.ID); err == nil {
+		t.Error("an admin banned themselves")
+	}
+	if _, err := db.BanFromGroup(group, bob.ID, erin.ID+100); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("banning a missing user: got %v", err)
+	}
+	if _, err := db.ListGroupBans(group, carol.ID); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("member listing the bans: got %v", err)
+	}
+
+	// A banned member is removed, a banned user with a pending request loses it
+	if _, err := db.BanFromGroup(group, bob.ID, carol.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, isMember, err := db.GetMembership(group, carol.ID); err != nil || isMember {
+		t.Errorf("GetMembership of a banned member = %v, %v", isMember, err)
+	}
+	if _, err := db.CreateGroupInvite(group, alice.ID, GroupInviteOptions{Token: "approval", RequiresApproval: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateGroupInvite(group, alice.ID, GroupInviteOptions{Token: "open"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := db.JoinGroupByInvite("approval", erin.ID); err != nil {
+		t.Fatal(err)
+	}
+	advance(time.Minute)
+	if _, err := db.BanFromGroup(group, bob.ID, erin.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.BanFromGroup(group, alice.ID, erin.ID); err != nil {
+		t.Errorf("banning twice: got %v", err)
+	}
+	if _, err := db.ApproveGroupJoinRequest(group, alice.ID, erin.ID); !errors.Is(err, ErrJoinRequestNotFound) {
+		t.Errorf("approving the request of a banned user: got %v", err)
+	}
+	bans, err := db.ListGroupBans(group, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bans) != 2 || bans[0].User.ID != erin.ID || bans[0].BannedBy != bob.ID || bans[0].BannedAt != "2024-05-01T12:01:00Z" ||
+		bans[1].User.ID != carol.ID || bans[1].BannedAt != "2024-05-01T12:00:00Z" {
+		t.Errorf("ListGroupBans = %+v", bans)
+	}
+
+	// Banned users can't come back until the ban is lifted
+	if _, _, _, err := db.JoinGroupByInvite("open", carol.ID); !errors.Is(err, ErrUserBanned) {
+		t.Errorf("banned user joining: got %v", err)
+	}
+	if _, err := db.AddMembersToGroup(group, alice.ID, []string{"carol", "dave"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, isMember, err := db.GetMembership(group, carol.ID); err != nil || isMember {
+		t.Errorf("banned user added back: %v, %v", isMember, err)
+	}
+	if _, isMember, err := db.GetMembership(group, dave.ID); err != nil || !isMember {
+		t.Errorf("user added with a banned one = %v, %v", isMember, err)
+	}
+	if err := db.UnbanFromGroup(group, carol.ID, carol.ID); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("non-member lifting a ban: got %v", err)
+	}
+	if err := db.UnbanFromGroup(group, bob.ID, carol.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UnbanFromGroup(group, bob.ID, carol.ID); !errors.Is(err, ErrBanNotFound) {
+		t.Errorf("lifting a lifted ban: got %v", err)
+	}
+	if _, isMember, err := db.GetMembership(group, carol.ID); err != nil || isMember {
+		t.Errorf("GetMembership after lifting the ban = %v, %v", isMember, err)
+	}
+	if _, _, _, err := db.JoinGroupByInvite("open", carol.ID); err != nil {
+		t.Errorf("joining after the ban was lifted: got %v", err)
+	}
+}
+
+func testGroupDissolution(t *testing.T, db AppDatabase) {
+	alice, bob, carol, dave := register(t, db, "alice"), register(t, db, "bob"), register(t, db, "carol"), register(t, db, "dave")
+	roles := func(group int) map[int]string {
+		t.Helper()
+		groups, err := db.ListGroups(dave.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, g := range groups {
+			if g.ID == group {
+				roles := make(map[int]string)
+				for _, m := range g.Members {
+					roles[m.ID] = m.Role
+				}
+				return roles
+			}
+		}
+		t.Fatalf("group %d not listed", group)
+		return nil
+	}
+
+	// The ownership goes to the first admin, or to the first member if there are no admins
+	group := newGroup(t, db, "team", alice, bob, carol, dave)
+	if err := db.SetGroupMemberRole(group, alice.ID, carol.ID, RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.LeaveGroup(group, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	if r := roles(group); len(r) != 3 || r[carol.ID] != RoleOwner || r[bob.ID] != RoleMember {
+		t.Errorf("roles after the owner left = %v", r)
+	}
+	if _, err := db.LeaveGroup(group, carol.ID); err != nil {
+		t.Fatal(err)
+	}
+	if r := roles(group); len(r) != 2 || r[bob.ID] != RoleOwner || r[dave.ID] != RoleMember {
+		t.Errorf("roles after the second owner left = %v", r)
+	}
+
+	// The group is deleted when the last member leaves
+	send(t, db, group, dave.ID, "last words", nil)
+	if _, err := db.LeaveGroup(group, bob.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.LeaveGroup(group, dave.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := db.GetMembership(group, dave.ID); !errors.Is(err, ErrConversationNotFound) {
+		t.Errorf("GetMembership of an abandoned group: got %v", err)
+	}
+
+	// Only the owner deletes the group, with its messages and invites
+	group = newGroup(t, db, "doomed", alice, bob, carol)
+	if err := db.SetGroupMemberRole(group, alice.ID, bob.ID, RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	msg := send(t, db, group, bob.ID, "bye", nil)
+	if err := db.AddReaction(msg.ID, carol.ID, "👋"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateGroupInvite(group, alice.ID, GroupInviteOptions{Token: "doomed"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.BanFromGroup(group, alice.ID, dave.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DeleteGroup(group, bob.ID); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("admin deleting the group: got %v", err)
+	}
+	if err := db.DeleteGroup(group, dave.ID); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("non-member deleting the group: got %v", err)
+	}
+	if err := db.DeleteGroup(group, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := db.GetMembership(group, alice.ID); !errors.Is(err, ErrConversationNotFound) {
+		t.Errorf("GetMembership of a deleted group: got %v", err)
+	}
+	if _, err := db.GetMessageById(group, msg.ID); err == nil {
+		t.Error("message of a deleted group still found")
+	}
+	if _, err := db.PreviewGroupInvite("doomed", dave.ID); !errors.Is(err, ErrInviteNotFound) {
+		t.Errorf("invite of a deleted group: got %v", err)
+	}
+	if inbox, err := db.GetInbox(bob.ID); err != nil || len(inbox) != 0 {
+		t.Errorf("GetInbox after the group was deleted = %+v, %v", inbox, err)
+	}
+	if err := db.DeleteGroup(group, alice.ID); err == nil {
+		t.Error("deleted a group twice")
+	}
+}
+
+func testGroupDeletionCleanup(t *testing.T, db AppDatabase) {
+	alice, bob := register(t, db, "alice"), register(t, db, "bob")
+	// newGroup uses /media/doomed as the group photo
+	for _, id := range []string{"doomed", "attached", "forwarded"} {
+		if err := db.CreateMedia(&structures.Media{ID: id, BlobKey: id, UploaderID: alice.ID, ContentType: "image/png", Size: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	id, err := db.CreateConversation(alice.ID, bob.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chat := int(id)
+	group := newGroup(t, db, "doomed", alice, bob)
+	for _, mediaId := range []string{"attached", "forwarded"} {
+		mediaId := mediaId
+		if _, err := db.SendMessage(group, alice.ID, "", "image", &mediaId, false, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	forwarded := "forwarded"
+	if _, err := db.SendMessage(chat, alice.ID, "", "image", &forwarded, true, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, conversationId := range []int{group, chat} {
+		ev := &structures.Event{Type: "message.created", ConversationID: conversationId, Data: map[string]int{"id": conversationId}, Timestamp: globaltime.Now()}
+		if err := db.AddEvent(ev, []int{alice.ID, bob.ID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := db.DeleteGroup(group, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	// The media used only by the group are gone, the forwarded one is still used by the chat
+	for _, id := range []string{"doomed", "attached"} {
+		if _, err := db.GetMedia(id); !errors.Is(err, ErrMediaNotFound) {
+			t.Errorf("media %s of the deleted group: got %v", id, err)
+		}
+	}
+	if ok, err := db.CanAccessMedia("forwarded", bob.ID); err != nil || !ok {
+		t.Errorf("CanAccessMedia of the media forwarded to the chat = %v, %v", ok, err)
+	}
+	// Their blobs can be deleted from the blob store
+	if unused, err := db.UnusedBlobKeys([]string{"doomed", "attached", "forwarded"}); err != nil || strings.Join(unused, ",") != "doomed,attached" {
+		t.Errorf("UnusedBlobKeys after the group was deleted = %v, %v", unused, err)
+	}
+	events, _, err := db.GetEventsSince(bob.ID, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].ConversationID != chat {
+		t.Errorf("events after the group was deleted = %+v, want only the chat's", events)
+	}
+}
+
+func testSystemMessages(t *testing.T, db AppDatabase) {
+	alice, bob, carol, dave := register(t, db, "alice"), register(t, db, "bob"), register(t, db, "carol"), register(t, db, "dave")
+	group := newGroup(t, db, "team", alice, bob)
+	if _, err := db.CreateGroupInvite(group, alice.ID, GroupInviteOptions{Token: "open"}); err != nil {
+		t.Fatal(err)
+	}
+	// Every change returns the system messages it inserted
+	_, _, returned, err := db.JoinGroupByInvite("open", carol.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	collect := func(inserted []*structures.Message, err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		returned = append(returned, inserted...)
+	}
+	// Users who are already members aren't added again
+	collect(db.AddMembersToGroup(group, bob.ID, []string{"dave", "carol"}))
+	collect(db.SetGroupName(group, carol.ID, "dream team"))
+	collect(db.SetGroupPhoto(group, carol.ID, "/media/team"))
+	collect(db.RemoveGroupMember(group, alice.ID, dave.ID))
+	collect(db.LeaveGroup(group, bob.ID))
+
+	messages, err := db.GetMessages(group)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := messageIds(returned); !equalInts(ids, messageIds(messages)) {
+		t.Errorf("returned system messages = %v, want %v", ids, messageIds(messages))
+	}
+	if m := returned[1]; m.Kind != MessageKindMemberAdded || m.Target == nil || m.Target.Username != "dave" {
+		t.Errorf("returned message of the added member = %+v", m)
+	}
+	want := []struct {
+		kind    string
+		sender  int
+		target  int
+		content string
+	}{
+		{MessageKindMemberJoined, carol.ID, 0, ""},
+		{MessageKindMemberAdded, bob.ID, dave.ID, ""},
+		{MessageKindGroupRenamed, carol.ID, 0, "dream team"},
+		{MessageKindGroupPhotoChanged, carol.ID, 0, "/media/team"},
+		{MessageKindMemberRemoved, alice.ID, dave.ID, ""},
+		{MessageKindMemberLeft, bob.ID, 0, ""},
+	}
+	if len(messages) != len(want) {
+		t.Fatalf("GetMessages = %d messages, want %d", len(messages), len(want))
+	}
+	for i, w := range want {
+		m := messages[i]
+		target := 0
+		if m.Target != nil {
+			target = m.Target.ID
+		}
+		if m.Kind != w.kind || m.Sender.ID != w.sender || target != w.target || m.Content != w.content {
+			t.Errorf("message %d = %s by %d on %d %q, want %s by %d on %d %q",
+				i, m.Kind, m.Sender.ID, target, m.Content, w.kind, w.sender, w.target, w.content)
+		}
+	}
+	if m := messages[1]; m.Target == nil || m.Target.Username != "dave" {
+		t.Errorf("target of the added member = %+v", m.Target)
+	}
+
+	// System messages aren't unread and show up in the inbox preview
+	inbox, err := db.GetInbox(carol.ID)
+	if err != nil || len(inbox) != 1 {
+		t.Fatalf("GetInbox = %+v, %v", inbox, err)
+	}
+	if last := inbox[0].LastMessage; inbox[0].UnreadCount != 0 || last == nil || last.Kind != MessageKindMemberLeft || last.Sender.ID != bob.ID {
+		t.Errorf("GetInbox = %+v, last message %+v", inbox[0], inbox[0].LastMessage)
+	}
+
+	// They can't be edited, deleted, replied to or found by a search
+	renamed := messages[2]
+	if _, err := db.EditMessage(group, renamed.ID, carol.ID, "hacked", time.Hour); !errors.Is(err, ErrMessageNotEditable) {
+		t.Errorf("editing a system message: got %v", err)
+	}
+	if err := db.DeleteMessage(group, renamed.ID, carol.ID); err == nil {
+		t.Error("deleted a system message")
+	}
+	if _, err := db.SendMessage(group, carol.ID, "reply", "text", nil, false, &renamed.ID); err == nil {
+		t.Error("replied to a system message")
+	}
+	msg := send(t, db, group, carol.ID, "the dream team is here", nil)
+	if msg.Kind != MessageKindUser {
+		t.Errorf("kind of a user message = %q", msg.Kind)
+	}
+	page, err := db.SearchMessages(carol.ID, MessageSearchQuery{Text: "dream", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Results) != 1 || page.Results[0].Message.ID != msg.ID || page.Results[0].Message.Kind != MessageKindUser {
+		t.Errorf("SearchMessages = %+v", page.Results)
+	}
+}
+
+func testGroupInfo(t *testing.T, db AppDatabase) {
+	alice, bob, carol, dave := register(t, db, "alice"), register(t, db, "bob"), register(t, db, "carol"), register(t, db, "dave")
+
+	if _, err := db.AddToGroup(alice.ID, "crowded", "", []string{"bob", "carol"}, GroupOptions{MaxMembers: 2}); !errors.Is(err, ErrGroupFull) {
+		t.Errorf("creating a group over its limit: got %v", err)
+	}
+	created, err := db.AddToGroup(alice.ID, "news", "", []string{"bob"}, GroupOptions{Description: "Team news", MaxMembers: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Description != "Team news" {
+		t.Errorf("AddToGroup description = %q", created.Description)
+	}
+	group := created.ID
+
+	info, err := db.GetGroupInfo(group)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Name != "news" || info.Description != "Team news" || info.Settings.MaxMembers != 3 ||
+		info.CreatedAt == nil || *info.CreatedAt != "2024-05-01T12:00:00Z" || info.CreatedBy == nil || info.CreatedBy.ID != alice.ID {
+		t.Errorf("GetGroupInfo = %+v", info)
+	}
+	if len(info.Members) != 2 || info.Members[0].ID != alice.ID || info.Members[0].Role != RoleOwner ||
+		info.Members[1].ID != bob.ID || info.Members[1].Role != RoleMember {
+		t.Errorf("GetGroupInfo members = %+v", info.Members)
+	}
+
+	// The description follows the same permissions as the name, and the change is recorded in the history
+	onlyAdmins := true
+	if _, err := db.SetGroupSettings(group, alice.ID, GroupSettingsUpdate{OnlyAdminsEditInfo: &onlyAdmins}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.SetGroupDescription(group, bob.ID, "pwned"); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("member changing the description: got %v", err)
+	}
+	if _, err := db.SetGroupDescription(group, alice.ID, "Announcements only"); err != nil {
+		t.Fatal(err)
+	}
+	page, err := db.GetMessagesPage(group, alice.ID, MessagePageQuery{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := page.Messages[len(page.Messages)-1]; last.Kind != MessageKindGroupDescriptionChanged || last.Content != "Announcements only" {
+		t.Errorf("last message = %+v", last)
+	}
+
+	// The limit applies to every way of joining the group, and nobody is added when it would be exceeded
+	if _, err := db.AddMembersToGroup(group, alice.ID, []string{"carol", "dave"}); !errors.Is(err, ErrGroupFull) {
+		t.Errorf("adding members over the limit: got %v", err)
+	}
+	if _, isMember, _ := db.GetMembership(group, carol.ID); isMember {
+		t.Error("carol was added to a full group")
+	}
+	if _, err := db.AddMembersToGroup(group, alice.ID, []string{"carol"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateGroupInvite(group, alice.ID, GroupInviteOptions{Token: "open"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := db.JoinGroupByInvite("open", dave.ID); !errors.Is(err, ErrGroupFull) {
+		t.Errorf("joining a full group: got %v", err)
+	}
+	if invites, err := db.ListGroupInvites(group, alice.ID); err != nil || len(invites) != 1 || invites[0].Uses != 0 {
+		t.Errorf("a rejected join used the invite: %+v, %v", invites, err)
+	}
+	if _, err := db.CreateGroupInvite(group, alice.ID, GroupInviteOptions{Token: "approval", RequiresApproval: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, pending, _, err := db.JoinGroupByInvite("approval", dave.ID); err != nil || !pending {
+		t.Fatalf("requesting to join a full group = %v, %v", pending, err)
+	}
+	if _, err := db.ApproveGroupJoinRequest(group, alice.ID, dave.ID); !errors.Is(err, ErrGroupFull) {
+		t.Errorf("approving a request in a full group: got %v", err)
+	}
+	if requests, err := db.ListGroupJoinRequests(group, alice.ID); err != nil || len(requests) != 1 {
+		t.Errorf("the request was dropped: %+v, %v", requests, err)
+	}
+
+	// The limit can't go below the current members, and 0 removes it
+	tooLow, negative, unlimited := 2, -1, 0
+	if _, err := db.SetGroupSettings(group, alice.ID, GroupSettingsUpdate{MaxMembers: &tooLow}); err == nil {
+		t.Error("the limit was set below the number of members")
+	}
+	if _, err := db.SetGroupSettings(group, alice.ID, GroupSettingsUpdate{MaxMembers: &negative}); err == nil {
+		t.Error("a negative limit was accepted")
+	}
+	settings, err := db.SetGroupSettings(group, alice.ID, GroupSettingsUpdate{MaxMembers: &unlimited})
+	if err != nil || settings.MaxMembers != 0 || !settings.OnlyAdminsEditInfo {
+		t.Fatalf("SetGroupSettings = %+v, %v", settings, err)
+	}
+	if _, err := db.ApproveGroupJoinRequest(group, alice.ID, dave.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	groups, err := db.ListGroups(bob.ID)
+	if err != nil || len(groups) != 1 || groups[0].Description != "Announcements only" || len(groups[0].Members) != 4 {
+		t.Errorf("ListGroups = %+v, %v", groups, err)
+	}
+	if _, err := db.GetGroupInfo(group + 100); err == nil {
+		t.Error("GetGroupInfo found a missing group")
+	}
+}
+
+func testThreads(t *testing.T, db AppDatabase) {
+	alice, bob, carol := register(t, db, "alice"), register(t, db, "bob"), register(t, db, "carol")
+	group := newGroup(t, db, "team", alice, bob, carol)
+
+	root := send(t, db, group, alice.ID, "lunch?", nil)
+	first := send(t, db, group, bob.ID, "sure", &root.ID)
+	advance(time.Minute)
+	// A reply to a reply belongs to the thread of the first message
+	second := send(t, db, group, carol.ID, "me too", &first.ID)
+	other := send(t, db, group, alice.ID, "unrelated", nil)
+	if second.ThreadRootID == nil || *second.ThreadRootID != root.ID || other.ThreadRootID != nil {
+		t.Errorf("thread roots = %v, %v", second.ThreadRootID, other.ThreadRootID)
+	}
+
+	page, err := db.GetMessagesPage(group, bob.ID, MessagePageQuery{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if th := page.Messages[0].Thread; th == nil || th.ReplyCount != 2 || th.LastReplyAt != "2024-05-01T12:01:00Z" || th.UnreadCount != 1 {
+		t.Errorf("thread summary = %+v", th)
+	}
+	if len(page.Messages) != 4 || page.Messages[1].Thread != nil {
+		t.Errorf("timeline = %v", messageIds(page.Messages))
+	}
+	collapsed, err := db.GetMessagesPage(group, bob.ID, MessagePageQuery{Limit: 10, CollapseThreads: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := messageIds(collapsed.Messages); !equalInts(ids, []int{root.ID, other.ID}) {
+		t.Errorf("collapsed timeline = %v", ids)
+	}
+
+	// The thread can be opened from any of its messages and is paginated like the timeline
+	thread, err := db.GetThread(group, first.ID, bob.ID, MessagePageQuery{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if thread.Root.ID != root.ID || thread.Root.Thread == nil || !thread.HasMore || !equalInts(messageIds(thread.Messages), []int{second.ID}) {
+		t.Errorf("GetThread = root %d, %v, hasMore %v", thread.Root.ID, messageIds(thread.Messages), thread.HasMore)
+	}
+	if thread.Messages[0].ReplyToMessage == nil || thread.Messages[0].ReplyToMessage.ID != first.ID {
+		t.Errorf("reply in the thread = %+v", thread.Messages[0].ReplyToMessage)
+	}
+	before, _ := ParseMessageCursor(thread.PrevCursor)
+	older, err := db.GetThread(group, root.ID, bob.ID, MessagePageQuery{Before: &before, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if older.HasMore || !equalInts(messageIds(older.Messages), []int{first.ID}) {
+		t.Errorf("older replies = %v, hasMore %v", messageIds(older.Messages), older.HasMore)
+	}
+	if _, err := db.GetThread(group, other.ID+100, bob.ID, MessagePageQuery{Limit: 10}); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("GetThread of a missing message: got %v", err)
+	}
+
+	// Reading the thread leaves the rest of the conversation unread
+	if rootId, updated, err := db.SetThreadRead(group, second.ID, bob.ID); err != nil || rootId != root.ID || updated != 2 {
+		t.Errorf("SetThreadRead = %d, %d, %v", rootId, updated, err)
+	}
+	thread, err = db.GetThread(group, root.ID, bob.ID, MessagePageQuery{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if thread.Root.Thread.UnreadCount != 0 {
+		t.Errorf("unread replies after reading the thread = %d", thread.Root.Thread.UnreadCount)
+	}
+	inbox, err := db.GetInbox(bob.ID)
+	if err != nil || len(inbox) != 1 || inbox[0].UnreadCount != 1 {
+		t.Errorf("GetInbox = %+v, %v", inbox, err)
+	}
+
+	// Reading the collapsed timeline leaves the replies unread
+	if updated, err := db.SetMessagesRead(group, carol.ID, true); err != nil || updated != 2 {
+		t.Errorf("SetMessagesRead with collapsed threads = %d, %v", updated, err)
+	}
+	thread, err = db.GetThread(group, root.ID, carol.ID, MessagePageQuery{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if thread.Root.Thread.UnreadCount != 1 {
+		t.Errorf("unread replies after reading the collapsed timeline = %d", thread.Root.Thread.UnreadCount)
+	}
+
+	// Deleted replies don't count
+	if err := db.DeleteMessage(group, second.ID, carol.ID); err != nil {
+		t.Fatal(err)
+	}
+	root, err = db.GetMessageById(group, root.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if th := root.Thread; th == nil || th.ReplyCount != 1 || th.LastReplyAt != "2024-05-01T12:00:00Z" {
+		t.Errorf("thread after deleting a reply = %+v", th)
+	}
+	if err := db.DeleteMessage(group, first.ID, bob.ID); err != nil {
+		t.Fatal(err)
+	}
+	if root, err = db.GetMessageById(group, root.ID); err != nil || root.Thread != nil {
+		t.Errorf("thread after deleting every reply = %+v, %v", root.Thread, err)
+	}
+}
+
+func testPinnedMessages(t *testing.T, db AppDatabase) {
+	alice, bob, carol := register(t, db, "alice"), register(t, db, "bob"), register(t, db, "carol")
+	group := newGroup(t, db, "team", alice, bob)
+	first := send(t, db, group, alice.ID, "agenda", nil)
+	second := send(t, db, group, bob.ID, "minutes", nil)
+
+	pins := func(userId int) []*structures.PinnedMessage {
+		t.Helper()
+		pinned, err := db.GetPinnedMessages(group, userId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pinned
+	}
+	pinnedIds := func(pinned []*structures.PinnedMessage) []int {
+		ids := make([]int, len(pinned))
+		for i, p := range pinned {
+			ids[i] = p.Message.ID
+		}
+		return ids
+	}
+
+	inserted, err := db.PinMessage(group, first.ID, bob.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The system message recording the pin is returned with the preview of the pinned message
+	if len(inserted) != 1 || inserted[0].Kind != MessageKindMessagePinned || inserted[0].ReplyToMessage == nil || inserted[0].ReplyToMessage.ID != first.ID {
+		t.Errorf("PinMessage returned %+v", inserted)
+	}
+	advance(time.Minute)
+	if _, err := db.PinMessage(group, second.ID, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	// The most recently pinned message comes first
+	pinned := pins(alice.ID)
+	if !equalInts(pinnedIds(pinned), []int{second.ID, first.ID}) {
+		t.Fatalf("pinned messages = %v", pinnedIds(pinned))
+	}
+	if p := pinned[1]; p.PinnedBy == nil || p.PinnedBy.ID != bob.ID || p.PinnedAt != "2024-05-01T12:00:00Z" || p.Message.Content != "agenda" {
+		t.Errorf("pin = %+v", p)
+	}
+	if _, err := db.PinMessage(group, first.ID, alice.ID); !errors.Is(err, ErrMessageAlreadyPinned) {
+		t.Errorf("pinning a pinned message: got %v", err)
+	}
+	if _, err := db.PinMessage(group, second.ID+100, alice.ID); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("pinning a missing message: got %v", err)
+	}
+	if _, err := db.PinMessage(group, first.ID, carol.ID); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("pinning as a non-member: got %v", err)
+	}
+
+	// Every pin and unpin is recorded by a system message that replies to the pinned message
+	messages, err := db.GetMessages(group)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := messages[len(messages)-1]
+	if last.Kind != MessageKindMessagePinned || last.Sender.ID != alice.ID || last.ReplyToMessage == nil || last.ReplyToMessage.ID != second.ID {
+		t.Errorf("last message = %+v", last)
+	}
+	if _, err := db.PinMessage(group, last.ID, alice.ID); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("pinning a system message: got %v", err)
+	}
+	inbox, err := db.GetInbox(bob.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lm := inbox[0].LastMessage; lm == nil || lm.Kind != MessageKindMessagePinned || lm.Text != "minutes" {
+		t.Errorf("inbox preview = %+v", lm)
+	}
+	// A pinned message hidden by the user is not shown in their inbox preview either
+	if err := db.HideMessage(group, second.ID, bob.ID); err != nil {
+		t.Fatal(err)
+	}
+	if inbox, err = db.GetInbox(bob.ID); err != nil {
+		t.Fatal(err)
+	} else if lm := inbox[0].LastMessage; lm == nil || lm.Kind != MessageKindMessagePinned || lm.Text != "" {
+		t.Errorf("inbox preview after hiding the pinned message = %+v", lm)
+	}
+	if inbox, err = db.GetInbox(alice.ID); err != nil {
+		t.Fatal(err)
+	} else if lm := inbox[0].LastMessage; lm == nil || lm.Text != "minutes" {
+		t.Errorf("inbox preview of another member = %+v", lm)
+	}
+
+	// With onlyAdminsPin, members can no longer pin or unpin
+	onlyAdmins := true
+	if _, err := db.SetGroupSettings(group, alice.ID, GroupSettingsUpdate{OnlyAdminsPin: &onlyAdmins}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.UnpinMessage(group, second.ID, bob.ID); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("unpinning as a member: got %v", err)
+	}
+	if _, err := db.UnpinMessage(group, second.ID, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.UnpinMessage(group, second.ID, alice.ID); !errors.Is(err, ErrMessageNotPinned) {
+		t.Errorf("unpinning a message that is not pinned: got %v", err)
+	}
+	messages, err = db.GetMessages(group)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := messages[len(messages)-1]; last.Kind != MessageKindMessageUnpinned || last.ReplyToMessageID == nil || *last.ReplyToMessageID != second.ID {
+		t.Errorf("last message = %+v", last)
+	}
+
+	// The number of pins is bounded
+	for i := 1; i < maxPinnedMessages; i++ {
+		m := send(t, db, group, alice.ID, "note "+strconv.Itoa(i), nil)
+		if _, err := db.PinMessage(group, m.ID, alice.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.PinMessage(group, second.ID, alice.ID); !errors.Is(err, ErrTooManyPinnedMessages) {
+		t.Errorf("pinning beyond the limit: got %v", err)
+	}
+
+	// Deleting a message for everyone unpins it, hiding it only hides it from the user's pins
+	if err := db.DeleteMessage(group, first.ID, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(pins(bob.ID)); n != maxPinnedMessages-1 {
+		t.Errorf("pins after deleting a pinned message = %d", n)
+	}
+	newest := pins(bob.ID)[0].Message.ID
+	if err := db.HideMessage(group, newest, bob.ID); err != nil {
+		t.Fatal(err)
+	}
+	if ids := pinnedIds(pins(bob.ID)); len(ids) != maxPinnedMessages-2 || ids[0] == newest {
+		t.Errorf("pins of bob after hiding one = %v", ids)
+	}
+	if n := len(pins(alice.ID)); n != maxPinnedMessages-1 {
+		t.Errorf("pins of alice = %d", n)
+	}
+
+	// In direct chats both users can pin messages
+	chat, err := db.CreateConversation(alice.ID, carol.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hello := send(t, db, int(chat), carol.ID, "hello", nil)
+	if _, err := db.PinMessage(int(chat), hello.ID, carol.ID); err != nil {
+		t.Errorf("pinning in a direct chat: %v", err)
+	}
+}
+
+func testConcurrentPins(t *testing.T, db AppDatabase) {
+	alice, bob := register(t, db, "alice"), register(t, db, "bob")
+	group := newGroup(t, db, "team", alice, bob)
+	var messages []*structures.Message
+	for i := 0; i < maxPinnedMessages+5; i++ {
+		messages = append(messages, send(t, db, group, alice.ID, "note "+strconv.Itoa(i), nil))
+	}
+
+	// Every request sees room for one more pin; only maxPinnedMessages of them can succeed. SQLite may also reject
+	// some of the concurrent transactions as busy.
+	errs := make(chan error, len(messages))
+	for _, m := range messages {
+		go func(id int) {
+			_, err := db.PinMessage(group, id, bob.ID)
+			errs <- err
+		}(m.ID)
+	}
+	pinned := 0
+	for range messages {
+		if err := <-errs; err == nil {
+			pinned++
+		}
+	}
+
+	pins, err := db.GetPinnedMessages(group, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pins) != pinned || len(pins) > maxPinnedMessages || pinned == 0 {
+		t.Errorf("%d pins succeeded, %d pinned messages, want at most %d", pinned, len(pins), maxPinnedMessages)
+	}
+}
+
+func testInbox(t *testing.T, db AppDatabase) {
+	alice, bob, carol := register(t, db, "alice"), register(t, db, "bob"), register(t, db, "carol")
+	chat, err := db.CreateConversation(alice.ID, bob.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	empty, err := db.CreateConversation(alice.ID, carol.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	direct, quiet := int(chat), int(empty)
+	group := newGroup(t, db, "team", alice, bob, carol)
+
+	send(t, db, direct, bob.ID, "hello", nil)
+	advance(time.Second)
+	long := strings.Repeat("è", 150)
+	send(t, db, group, carol.ID, long, nil)
+	advance(time.Second)
+
+	inbox := func() []*structures.InboxEntry {
+		t.Helper()
+		entries, err := db.GetInbox(alice.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return entries
+	}
+	order := func(entries []*structures.InboxEntry) []int {
+		ids := make([]int, len(entries))
+		for i, e := range entries {
+			ids[i] = e.ID
+		}
+		return ids
+	}
+
+	entries := inbox()
+	if !equalInts(order(entries), []int{group, direct, quiet}) {
+		t.Fatalf("inbox order = %v, want %v", order(entries), []int{group, direct, quiet})
+	}
+	g, d, q := entries[0], entries[1], entries[2]
+	if g.Type != "group" || g.Title != "team" || g.Avatar != "/media/team" || g.UnreadCount != 1 {
+		t.Errorf("group entry = %+v", g)
+	}
+	if p := g.LastMessage; p == nil || p.Sender.ID != carol.ID || p.MediaType != "text" || !p.Truncated ||
+		p.Text != strings.Repeat("è", 100) || p.Timestamp != "2024-05-01T12:00:01Z" {
+		t.Errorf("group preview = %+v", p)
+	}
+	if d.Type != "direct" || d.Title != "BOB" || d.Avatar != "/media/bob" || d.OtherUserID != bob.ID || d.UnreadCount != 1 {
+		t.Errorf("direct entry = %+v", d)
+	}
+	if p := d.LastMessage; p == nil || p.Text != "hello" || p.Truncated || p.Sender.Username != "bob" {
+		t.Errorf("direct preview = %+v", p)
+	}
+	if q.LastMessage != nil || q.UnreadCount != 0 || q.Muted || q.Pinned {
+		t.Errorf("empty conversation entry = %+v", q)
+	}
+
+	// Own messages are never unread, and reading the conversation clears the counter
+	mine := send(t, db, direct, alice.ID, "hi bob", nil)
+	if _, err := db.SetMessagesRead(direct, alice.ID, false); err != nil {
+		t.Fatal(err)
+	}
+	entries = inbox()
+	if entries[0].ID != direct || entries[0].UnreadCount != 0 || entries[0].LastMessage.Sender.ID != alice.ID {
+		t.Errorf("after replying, first entry = %+v", entries[0])
+	}
+
+	// Hidden messages are skipped by the preview, deleted ones are shown without text
+	if err := db.HideMessage(direct, mine.ID, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	if p := inbox()[1].LastMessage; p == nil || p.Text != "hello" {
+		t.Errorf("preview after hiding the last message = %+v", p)
+	}
+	advance(time.Second)
+	last := send(t, db, group, bob.ID, "oops", nil)
+	if err := db.DeleteMessage(group, last.ID, bob.ID); err != nil {
+		t.Fatal(err)
+	}
+	entries = inbox()
+	if p := entries[0].LastMessage; entries[0].ID != group || p == nil || p.Text != "" || p.DeletedAt == nil {
+		t.Errorf("preview of a deleted message = %+v", p)
+	}
+	if entries[0].UnreadCount != 1 {
+		t.Errorf("deleted messages are counted as unread: %d", entries[0].UnreadCount)
+	}
+
+	// Pinned conversations come first, and pinning again does not move them
+	if err := db.SetConversationPinned(direct, alice.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	advance(time.Second)
+	if err := db.SetConversationPinned(quiet, alice.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	advance(time.Second)
+	if err := db.SetConversationPinned(direct, alice.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetConversationMuted(group, alice.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	entries = inbox()
+	if !equalInts(order(entries), []int{quiet, direct, group}) {
+		t.Errorf("inbox order with pins = %v, want %v", order(entries), []int{quiet, direct, group})
+	}
+	if e := entries[1]; !e.Pinned || e.PinnedAt == nil || *e.PinnedAt != "2024-05-01T12:00:03Z" || e.Muted {
+		t.Errorf("pinned entry = %+v", e)
+	}
+	if e := entries[2]; !e.Muted || e.Pinned {
+		t.Errorf("muted entry = %+v", e)
+	}
+
+	// Preferences are per user
+	bobEntries, err := db.GetInbox(bob.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range bobEntries {
+		if e.Pinned || e.Muted {
+			t.Errorf("alice's preferences leaked to bob: %+v", e)
+		}
+	}
+
+	if err := db.SetConversationPinned(quiet, alice.ID, false); err != nil {
+		t.Fatal(err)
+	}
+	if ids := order(inbox()); !equalInts(ids, []int{direct, group, quiet}) {
+		t.Errorf("inbox order after unpinning = %v", ids)
+	}
+	if err := db.SetConversationMuted(direct, carol.ID, true); !errors.Is(err, ErrConversationNotFound) {
+		t.Errorf("SetConversationMuted by a non-member: got %v", err)
+	}
+	if err := db.SetConversationPinned(group+100, alice.ID, true); !errors.Is(err, ErrConversationNotFound) {
+		t.Errorf("SetConversationPinned of a missing conversation: got %v", err)
+	}
+
+	// Leaving a group drops the preferences
+	if _, err := db.LeaveGroup(group, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	if ids := order(inbox()); !equalInts(ids, []int{direct, quiet}) {
+		t.Errorf("inbox after leaving the group = %v", ids)
+	}
+	if _, err := db.AddMembersToGroup(group, bob.ID, []string{"alice"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range inbox() {
+		if e.ID == group && e.Muted {
+			t.Error("the group is still muted after leaving and joining again")
+		}
+	}
+}
+
+func testSessions(t *testing.T, db AppDatabase) {
+	alice, bob := register(t, db, "alice"), register(t, db, "bob")
+	expires := globaltime.Now().Add(time.Hour)
+	for _, s := range []struct {
+		userId int
+		token  string
+	}{{alice.ID, "alice-1"}, {alice.ID, "alice-2"}, {alice.ID, "alice-3"}, {bob.ID, "bob-1"}} {
+		if err := db.CreateSession(s.userId, s.token, expires); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if id, err := db.GetSessionUserId("alice-1"); err != nil || id != alice.ID {
+		t.Errorf("GetSessionUserId = %d, %v", id, err)
+	}
+	if _, err := db.GetSessionUserId("unknown"); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("unknown token: got %v", err)
+	}
+
+	if err := db.RevokeSession("alice-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.RevokeOtherSessions(alice.ID, "alice-2"); err != nil {
+		t.Fatal(err)
+	}
+	for token, valid := range map[string]bool{"alice-1": false, "alice-2": true, "alice-3": false, "bob-1": true} {
+		if _, err := db.GetSessionUserId(token); (err == nil) != valid {
+			t.Errorf("session %s: got %v, want valid=%v", token, err, valid)
+		}
+	}
+	if err := db.RevokeUserSessions(alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.GetSessionUserId("alice-2"); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("session after RevokeUserSessions: got %v", err)
+	}
+
+	advance(time.Hour)
+	if _, err := db.GetSessionUserId("bob-1"); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("expired session: got %v", err)
+	}
+}
+
+func testEvents(t *testing.T, db AppDatabase) {
+	alice, bob := register(t, db, "alice"), register(t, db, "bob")
+	var ids []int64
+	for i := 0; i < 3; i++ {
+		recipients := []int{alice.ID, bob.ID, bob.ID}
+		if i == 1 {
+			recipients = []int{alice.ID}
+		}
+		ev := &structures.Event{Type: "message.created", ConversationID: 7, Data: map[string]int{"n": i}, Timestamp: globaltime.Now()}
+		if err := db.AddEvent(ev, recipients); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, ev.ID)
+		advance(time.Minute)
+	}
+
+	events, complete, err := db.GetEventsSince(bob.ID, 0, 10)
+	if err != nil || !complete || len(events) != 2 || events[0].ID != ids[0] || events[1].ID != ids[2] {
+		t.Fatalf("GetEventsSince(bob) = %+v, %v, %v", events, complete, err)
+	}
+	ev := events[0]
+	if ev.Type != "message.created" || ev.ConversationID != 7 || !ev.Timestamp.Equal(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("event = %+v", ev)
+	}
+	if data, ok := ev.Data.(interface{ MarshalJSON() ([]byte, error) }); !ok {
+		t.Errorf("event data = %T, want raw JSON", ev.Data)
+	} else if raw, _ := data.MarshalJSON(); string(raw) != `{"n":0}` {
+		t.Errorf("event data = %s", raw)
+	}
+	if events, _, err := db.GetEventsSince(alice.ID, ids[0], 1); err != nil || len(events) != 1 || events[0].ID != ids[1] {
+		t.Errorf("GetEventsSince(alice, limit 1) = %+v, %v", events, err)
+	}
+
+	// Once old events are deleted, a client that missed them is told the log is incomplete
+	if err := db.DeleteEventsBefore(time.Date(2024, 5, 1, 12, 1, 30, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	events, complete, err = db.GetEventsSince(bob.ID, 0, 10)
+	if err != nil || complete || len(events) != 1 || events[0].ID != ids[2] {
+		t.Errorf("GetEventsSince after deleting = %+v, %v, %v", events, complete, err)
+	}
+	if _, complete, err := db.GetEventsSince(bob.ID, ids[1], 10); err != nil || !complete {
+		t.Errorf("GetEventsSince from the oldest kept event: complete = %v, %v", complete, err)
+	}
+}
+
+func testMedia(t *testing.T, db AppDatabase) {
+	alice := register(t, db, "alice")
+	media := &structures.Media{
+		ID: "m1", BlobKey: "blob-original", UploaderID: alice.ID, ContentType: "image/jpeg", Size: 12345,
+		Width: 800, Height: 600, Placeholder: "LEHV6nWB2yk8",
+		Thumbnails: []*structures.MediaThumbnail{
+			{Size: 320, BlobKey: "blob-320", ContentType: "image/jpeg", Width: 320, Height: 240},
+			{Size: 64, BlobKey: "blob-64", ContentType: "image/jpeg", Width: 64, Height: 48},
+		},
+	}
+	if err := db.CreateMedia(media); err != nil {
+		t.Fatal(err)
+	}
+	if media.CreatedAt != "2024-05-01T12:00:00Z" {
+		t.Errorf("CreatedAt = %q", media.CreatedAt)
+	}
+	got, err := db.GetMedia("m1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.BlobKey != "blob-original" || got.Size != 12345 || got.Width != 800 || got.Placeholder != "LEHV6nWB2yk8" ||
+		len(got.Thumbnails) != 2 || got.Thumbnails[0].Size != 64 || got.Thumbnails[1].BlobKey != "blob-320" {
+		t.Errorf("GetMedia = %+v", got)
+	}
+
+	// Files that are not images have no dimensions
+	if err := db.CreateMedia(&structures.Media{ID: "m2", BlobKey: "b2", UploaderID: alice.ID, ContentType: "image/gif", Size: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := db.GetMedia("m2"); err != nil || got.Width != 0 || got.Placeholder != "" || len(got.Thumbnails) != 0 {
+		t.Errorf("GetMedia without dimensions = %+v, %v", got, err)
+	}
+	if _, err := db.GetMedia("missing"); !errors.Is(err, ErrMediaNotFound) {
+		t.Errorf("missing media: got %v", err)
+	}
+
+	// A blob is in use while a media or a thumbnail references it
+	unused, err := db.UnusedBlobKeys([]string{"blob-64", "orphan", "b2", "blob-original", "stale"})
+	if err != nil || strings.Join(unused, ",") != "orphan,stale" {
+		t.Errorf("UnusedBlobKeys = %v, %v", unused, err)
+	}
+}
+
+func testMediaAccess(t *testing.T, db AppDatabase) {
+	alice, bob, carol := register(t, db, "alice"), register(t, db, "bob"), register(t, db, "carol")
+	for _, id := range []string{"photo", "shared"} {
+		if err := db.CreateMedia(&structures.Media{ID: id, BlobKey: id, UploaderID: alice.ID, ContentType: "image/png", Size: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	id, err := db.CreateConversation(alice.ID, bob.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	convId, mediaId := int(id), "shared"
+	msg, err := db.SendMessage(convId, alice.ID, "", "image", &mediaId, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	group := newGroup(t, db, "photo", alice, bob)
+
+	canAccess := func(id string, user *structures.User) bool {
+		t.Helper()
+		ok, err := db.CanAccessMedia(id, user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
+	// Group photos are visible to the members, profile pictures to everyone
+	if !canAccess("shared", bob) || canAccess("shared", carol) || !canAccess("photo", bob) || canAccess("photo", carol) {
+		t.Error("media shared in a conversation should be visible only to its members")
+	}
+	if !canAccess("carol", alice) {
+		t.Error("profile pictures should be visible to everyone")
+	}
+
+	// A message deleted for everyone loses its media, which stays visible only to its uploader
+	if err := db.DeleteMessage(convId, msg.ID, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	page, err := db.GetMessagesPage(convId, bob.ID, MessagePageQuery{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range page.Messages {
+		if m.ID == msg.ID && m.MediaID != nil {
+			t.Errorf("deleted message still has media %s", *m.MediaID)
+		}
+	}
+	if canAccess("shared", bob) || !canAccess("shared", alice) {
+		t.Error("the media of a deleted message should be visible only to its uploader")
+	}
+	if _, err := db.LeaveGroup(group, bob.ID); err != nil {
+		t.Fatal(err)
+	}
+	if canAccess("photo", bob) {
+		t.Error("former members should not see the group photo")
+	}
+}
+
+func testSearch(t *testing.T, db AppDatabase) {
+	alice, bob, carol := register(t, db, "alice"), register(t, db, "bob"), register(t, db, "carol")
+	id, err := db.CreateConversation(alice.ID, bob.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chat := int(id)
+	group := newGroup(t, db, "team", bob, carol)
+
+	first := send(t, db, chat, alice.ID, "Hello World", nil)
+	advance(time.Second)
+	second := send(t, db, chat, bob.ID, "well, hello there", nil)
+	advance(time.Second)
+	send(t, db, chat, bob.ID, "bye", nil)
+	send(t, db, group, carol.ID, "hello team", nil)
+
+	page, err := db.SearchMessages(alice.ID, MessageSearchQuery{Text: "HELLO", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []int
+	for _, r := range page.Results {
+		ids = append(ids, r.Message.ID)
+		if !strings.Contains(r.Snippet, "<mark>") {
+			t.Errorf("snippet without highlight: %q", r.Snippet)
+		}
+	}
+	if !equalInts(ids, []int{second.ID, first.ID}) {
+		t.Errorf("SearchMessages(alice, HELLO) = %v, want %v", ids, []int{second.ID, first.ID})
+	}
+
+	page, err = db.SearchMessages(bob.ID, MessageSearchQuery{Text: "hello", SenderID: alice.ID, Limit: 10})
+	if err != nil || len(page.Results) != 1 || page.Results[0].Message.ID != first.ID {
+		t.Errorf("SearchMessages with sender filter = %+v, %v", page, err)
+	}
+	page, err = db.SearchMessages(bob.ID, MessageSearchQuery{Text: "hello", Limit: 1})
+	if err != nil || len(page.Results) != 1 || !page.HasMore || page.NextCursor == "" {
+		t.Fatalf("first page = %+v, %v", page, err)
+	}
+	before, err := ParseMessageCursor(page.NextCursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err = db.SearchMessages(bob.ID, MessageSearchQuery{Text: "hello", Before: &before, Limit: 10})
+	if err != nil || len(page.Results) != 2 || page.HasMore {
+		t.Errorf("second page = %+v, %v", page, err)
+	}
+	if page, err := db.SearchMessages(carol.ID, MessageSearchQuery{Text: "world", Limit: 10}); err != nil || len(page.Results) != 0 {
+		t.Errorf("search outside the user's conversations = %+v, %v", page, err)
+	}
+
+	// Words match as prefixes, and quotes or search operators in the text are not interpreted
+	quoted := send(t, db, group, bob.ID, "don't worry", nil)
+	page, err = db.SearchMessages(carol.ID, MessageSearchQuery{Text: "don't wor", Limit: 10})
+	if err != nil || len(page.Results) != 1 || page.Results[0].Message.ID != quoted.ID {
+		t.Errorf("SearchMessages(don't wor) = %+v, %v", page, err)
+	}
+	for _, text := range []string{`"`, `'`, `\`, "a & !b", "(x | y):*", "NEAR(a b)"} {
+		if _, err := db.SearchMessages(carol.ID, MessageSearchQuery{Text: text, Limit: 10}); err != nil {
+			t.Errorf("SearchMessages(%q): %v", text, err)
+		}
+	}
+
+	if err := db.DeleteMessage(chat, first.ID, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	if page, err := db.SearchMessages(alice.ID, MessageSearchQuery{Text: "world", Limit: 10}); err != nil || len(page.Results) != 0 {
+		t.Errorf("search finds deleted messages: %+v, %v", page, err)
+	}
+}
+
+func testInlineImages(t *testing.T, db AppDatabase) {
+	alice, bob := register(t, db, "alice"), register(t, db, "bob")
+	id, err := db.CreateConversation(alice.ID, bob.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataURL := "data:image/png;base64,iVBORw0KGgo="
+	if _, err := db.SendMessage(int(id), alice.ID, dataURL, "image", nil, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	send(t, db, int(id), alice.ID, "data:text/plain,not an image", nil)
+	if err := db.SetMyPhotoById(strconv.Itoa(bob.ID), dataURL); err != nil {
+		t.Fatal(err)
+	}
+
+	images, err := db.ListInlineImages(InlineImageMessages, 0, 10)
+	if err != nil || len(images) != 1 || images[0].OwnerID != alice.ID || images[0].DataURL != dataURL {
+		t.Fatalf("ListInlineImages(messages) = %+v, %v", images, err)
+	}
+	users, err := db.ListInlineImages(InlineImageUsers, 0, 10)
+	if err != nil || len(users) != 1 || users[0].RowID != bob.ID {
+		t.Fatalf("ListInlineImages(users) = %+v, %v", users, err)
+	}
+
+	images[0].Replacement = "media-1"
+	users[0].Replacement = "/media/media-2"
+	stale := users[0]
+	stale.DataURL = "data:image/png;base64,changed"
+	replaced, err := db.ReplaceInlineImages([]InlineImage{images[0], stale})
+	if err != nil || len(replaced) != 1 {
+		t.Fatalf("ReplaceInlineImages = %+v, %v; want only the unchanged row", replaced, err)
+	}
+	msg, err := db.GetMessageById(int(id), images[0].RowID)
+	if err != nil || msg.Content != "" || msg.MediaID == nil || *msg.MediaID != "media-1" {
+		t.Errorf("migrated message = %+v, %v", msg, err)
+	}
+	if images, err := db.ListInlineImages(InlineImageMessages, 0, 10); err != nil || len(images) != 0 {
+		t.Errorf("ListInlineImages after replacing = %+v, %v", images, err)
+	}
+	if _, err := db.ListInlineImages("unknown", 0, 10); err == nil {
+		t.Error("ListInlineImages accepted an unknown table")
+	}
+}
@@ -0,0 +1,77 @@
+package database
+
+import (
+	"database/sql"
+
+	"github.com/rerikdev/WASAText/service/globaltime"
+	"github.com/rerikdev/WASAText/service/structures"
+)
+
+// I messaggi di sistema registrano nella cronologia di un gruppo chi è entrato, uscito o è stato aggiunto o rimosso, e
+// le modifiche al nome, alla foto e alla descrizione. Vengono salvati in messages insieme a quelli degli utenti, con kind uguale al tipo
+// dell'evento, e creati dalle funzioni dei gruppi nella stessa transazione della modifica. Non hanno ricevute, quindi
+// non contano tra i non letti, e non possono essere modificati, eliminati, inoltrati né usati come risposta. Anche le
+// chat 1:1 hanno i messaggi di sistema dei messaggi fissati (vedi pin-db.go).
+
+// Tipi dei messaggi, salvati in messages.kind
+const (
+	// MessageKindUser è il tipo dei messaggi scritti dagli utenti
+	MessageKindUser = "user"
+	// MessageKindMemberAdded: il mittente ha aggiunto l'utente target, anche approvando la sua richiesta di ingresso
+	MessageKindMemberAdded = "member_added"
+	// MessageKindMemberJoined: il mittente è entrato con un link di invito
+	MessageKindMemberJoined = "member_joined"
+	// MessageKindMemberLeft: il mittente è uscito dal gruppo
+	MessageKindMemberLeft = "member_left"
+	// MessageKindMemberRemoved: il mittente ha rimosso o bandito l'utente target
+	MessageKindMemberRemoved = "member_removed"
+	// MessageKindGroupRenamed: il mittente ha cambiato il nome del gruppo, il nuovo nome è il contenuto
+	MessageKindGroupRenamed = "group_renamed"
+	// MessageKindGroupPhotoChanged: il mittente ha cambiato la foto del gruppo, la nuova foto è il contenuto
+	MessageKindGroupPhotoChanged = "group_photo_changed"
+	// MessageKindGroupDescriptionChanged: il mittente ha cambiato la descrizione del gruppo, la nuova descrizione è il
+	// contenuto
+	MessageKindGroupDescriptionChanged = "group_description_changed"
+	// MessageKindMessagePinned: il mittente ha fissato il messaggio a cui risponde
+	MessageKindMessagePinned = "message_pinned"
+	// MessageKindMessageUnpinned: il mittente ha tolto il messaggio a cui risponde da quelli fissati
+	MessageKindMessageUnpinned = "message_unpinned"
+)
+
+// execer è implementato sia da dbConn che da dbTx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// insertSystemMessage aggiunge al gruppo un messaggio di sistema di tipo kind e ne restituisce l'ID. target è l'utente
+// su cui è stata compiuta l'azione, o nil.
+func insertSystemMessage(q rowQuerier, groupID, actorID int, kind string, target *int, content string) (int, error) {
+	var id int
+	err := q.QueryRow(`
+        INSERT INTO messages (conversation_id, sender_id, content, is_forwarded, media_type, status, timestamp, kind, target_user_id)
+        VALUES (?, ?, ?, FALSE, 'text', 'sent', ?, ?, ?)
+        RETURNING id`,
+		groupID, actorID, content, globaltime.Now().Format(timestampLayout), kind, target,
+	).Scan(&id)
+	return id, err
+}
+
+// getNewMessages rilegge, dopo il commit, i messaggi di sistema inseriti da una modifica, che il chiamante pubblica come
+// nuovi messaggi della conversazione
+func (db *appdbimpl) getNewMessages(conversationId int, ids ...int) ([]*structures.Message, error) {
+	messages := make([]*structures.Message, 0, len(ids))
+	messageMap := make(map[int]*structures.Message, len(ids))
+	for _, id := range ids {
+		msg, err := scanMessage(db.c.QueryRow(messageSelect+`
+         WHERE m.id = ?`, id))
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+		messageMap[msg.ID] = msg
+	}
+	if err := db.loadRepliesAndReactions(conversationId, messages, messageMap); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
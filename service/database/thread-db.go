@@ -0,0 +1,119 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/rerikdev/WASAText/service/globaltime"
+	"github.com/rerikdev/WASAText/service/structures"
+)
+
+// Un thread è formato da un messaggio e da tutte le risposte della catena che parte da esso: una risposta a una
+// risposta appartiene allo stesso thread (messages.thread_root_id). Il messaggio iniziale tiene il numero delle risposte
+// non eliminate e la data dell'ultima (reply_count e last_reply_at), mentre le risposte non lette si contano dalle
+// ricevute di ciascun utente. Le risposte restano anche nella cronologia della conversazione, a meno che il client non
+// chieda di nasconderle (MessagePageQuery.CollapseThreads).
+
+// updateThreadStmt ricalcola il numero delle risposte e la data dell'ultima per il messaggio iniziale di un thread
+const updateThreadStmt = `
+    UPDATE messages SET
+        reply_count = (SELECT COUNT(*) FROM messages r WHERE r.thread_root_id = messages.id AND r.deleted_at IS NULL),
+        last_reply_at = (SELECT MAX(r.timestamp) FROM messages r WHERE r.thread_root_id = messages.id AND r.deleted_at IS NULL)
+    WHERE id = ?`
+
+// GetThread restituisce il messaggio iniziale del thread di cui fa parte messageId e una pagina delle sue risposte vista
+// dall'utente userId, selezionata da query come in GetMessagesPage
+func (db *appdbimpl) GetThread(conversationId, messageId, userId int, query MessagePageQuery) (*structures.ThreadPage, error) {
+	var rootId int
+	err := db.c.QueryRow(`SELECT COALESCE(thread_root_id, id) FROM messages WHERE id = ? AND conversation_id = ?`, messageId, conversationId).Scan(&rootId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	root, err := scanMessage(db.c.QueryRow(messageSelect+`
+         WHERE m.id = ?`, rootId))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	roots := []*structures.Message{root}
+	if err := db.loadRepliesAndReactions(conversationId, roots, map[int]*structures.Message{root.ID: root}); err != nil {
+		return nil, err
+	}
+	if err := db.loadThreadUnread(userId, roots); err != nil {
+		return nil, err
+	}
+
+	page, err := db.messagesPage(conversationId, userId, "m.thread_root_id = ?", []interface{}{rootId}, query)
+	if err != nil {
+		return nil, err
+	}
+	return &structures.ThreadPage{Root: root, MessagePage: *page}, nil
+}
+
+// SetThreadRead segna come letti dall'utente il messaggio iniziale e le risposte del thread di cui fa parte messageId, e
+// restituisce l'ID del messaggio iniziale e quanti messaggi ha aggiornato
+func (db *appdbimpl) SetThreadRead(conversationId, messageId, userId int) (int, int64, error) {
+	var rootId int
+	err := db.c.QueryRow(`SELECT COALESCE(thread_root_id, id) FROM messages WHERE id = ? AND conversation_id = ?`, messageId, conversationId).Scan(&rootId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, 0, ErrMessageNotFound
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	now := globaltime.Now().Format(timestampLayout)
+	res, err := db.c.Exec(`
+        UPDATE message_receipts
+        SET read_at = ?, delivered_at = COALESCE(delivered_at, ?)
+        WHERE user_id = ? AND read_at IS NULL
+          AND message_id IN (SELECT id FROM messages WHERE id = ? OR thread_root_id = ?)`,
+		now, now, userId, rootId, rootId)
+	if err != nil {
+		return 0, 0, err
+	}
+	updated, err := db.updateAggregateStatus(conversationId, res)
+	return rootId, updated, err
+}
+
+// loadThreadUnread conta, per i messaggi che hanno risposte, quelle non eliminate che userId non ha ancora letto
+func (db *appdbimpl) loadThreadUnread(userId int, messages []*structures.Message) error {
+	byId := make(map[int]*structures.Message)
+	var roots []interface{}
+	for _, msg := range messages {
+		if msg.Thread != nil {
+			byId[msg.ID] = msg
+			roots = append(roots, msg.ID)
+		}
+	}
+	for _, chunk := range chunkArgs(roots) {
+		rows, err := db.c.Query(`
+        SELECT m.thread_root_id, COUNT(*)
+        FROM message_receipts r
+        JOIN messages m ON m.id = r.message_id
+        WHERE r.user_id = ? AND r.read_at IS NULL AND m.deleted_at IS NULL
+          AND m.thread_root_id IN (`+placeholders(len(chunk))+`)
+        GROUP BY m.thread_root_id`,
+			append([]interface{}{userId}, chunk...)...)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var rootId, unread int
+			if err := rows.Scan(&rootId, &unread); err != nil {
+				_ = rows.Close()
+				return err
+			}
+			byId[rootId].Thread.UnreadCount = unread
+		}
+		// Next ha già chiuso rows
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
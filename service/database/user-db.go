@@ -23,7 +23,9 @@ func (db *appdbimpl) CheckUserExistence(username string) (bool, error) {
 // DoLogin controlla se l'utente esiste, se sì restituisce i dati e "login",
 // se no lo crea e restituisce i dati e "register".
 // Se si tenta di registrare un utente già esistente, restituisce errore.
-func (db *appdbimpl) DoLogin(username, displayName, profilePicture string) (*structures.User, string, error) {
+// La password è facoltativa: se l'account ne ha una deve essere corretta, se l'account non ne ha ancora una
+// e viene fornita, viene associata all'account (primo accesso dopo l'introduzione delle credenziali).
+func (db *appdbimpl) DoLogin(username, displayName, profilePicture, password string) (*structures.User, string, error) {
 	exists, err := db.CheckUserExistence(username)
 	if err != nil {
 		return nil, "", err
@@ -36,12 +38,33 @@ func (db *appdbimpl) DoLogin(username, displayName, profilePicture string) (*str
 		}
 		// Recupera i dati dell'utente
 		var user structures.User
+		var passwordHash sql.NullString
 		err := db.c.QueryRow(
-			`SELECT id, username, display_name, profile_picture FROM users WHERE username = ?`, username,
-		).Scan(&user.ID, &user.Username, &user.DisplayName, &user.ProfilePicture)
+			`SELECT id, username, display_name, profile_picture, password_hash FROM users WHERE username = ?`, username,
+		).Scan(&user.ID, &user.Username, &user.DisplayName, &user.ProfilePicture, &passwordHash)
 		if err != nil {
 			return nil, "", err
 		}
+
+		if err := db.checkLoginThrottle(username); err != nil {
+			return nil, "", err
+		}
+		if passwordHash.Valid {
+			if password == "" || !checkPassword(passwordHash.String, password) {
+				if err := db.recordLoginFailure(username); err != nil {
+					return nil, "", err
+				}
+				return nil, "", ErrInvalidCredentials
+			}
+		} else if password != "" {
+			// Account senza credenziali: la password fornita viene associata all'account
+			if err := db.setPassword(user.ID, password); err != nil {
+				return nil, "", err
+			}
+		}
+		if err := db.resetLoginFailures(username); err != nil {
+			return nil, "", err
+		}
 		return &user, "login", nil
 	}
 
@@ -50,20 +73,26 @@ func (db *appdbimpl) DoLogin(username, displayName, profilePicture string) (*str
 		return nil, "", fmt.Errorf("per la registrazione servono displayName e profilePicture")
 	}
 
-	// Crea nuovo utente
-	res, err := db.c.Exec(
-		`INSERT INTO users (username, display_name, profile_picture) VALUES (?, ?, ?)`,
-		username, displayName, profilePicture,
-	)
-	if err != nil {
-		return nil, "", err
+	var passwordHash sql.NullString
+	if password != "" {
+		hash, err := hashPassword(password)
+		if err != nil {
+			return nil, "", err
+		}
+		passwordHash = sql.NullString{String: hash, Valid: true}
 	}
-	id, err := res.LastInsertId()
+
+	// Crea nuovo utente
+	var id int
+	err = db.c.QueryRow(
+		`INSERT INTO users (username, display_name, profile_picture, password_hash) VALUES (?, ?, ?, ?) RETURNING id`,
+		username, displayName, profilePicture, passwordHash,
+	).Scan(&id)
 	if err != nil {
 		return nil, "", err
 	}
 	return &structures.User{
-		ID:             int(id),
+		ID:             id,
 		Username:       username,
 		DisplayName:    displayName,
 		ProfilePicture: profilePicture,
@@ -107,7 +136,8 @@ func (db *appdbimpl) SearchUsers(query string) ([]*structures.User, error) {
 	rows, err := db.c.Query(
 		`SELECT id, username, display_name, profile_picture 
          FROM users 
-         WHERE username LIKE ? OR display_name LIKE ? 
+         WHERE username `+db.likeOperator()+` ? OR display_name `+db.likeOperator()+` ?
+         ORDER BY username
          LIMIT 10`,
 		"%"+query+"%", "%"+query+"%",
 	)
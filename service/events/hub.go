@@ -0,0 +1,257 @@
+/*
+Package events contains the in-process publish/subscribe hub used to push real-time updates (new messages, deletions,
+reactions, receipts, group changes) to the connected clients.
+
+Every event is addressed to a list of users (usually the members of a conversation) and is appended to a persistent
+event log (see Store) before being delivered, so that it gets a monotonically increasing ID. A client that reconnects
+can ask for everything that happened after the last event it received.
+
+The hub does not share events with other processes: the events saved by another instance using the same database are
+not delivered live, so the server must run as a single instance.
+*/
+package events
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/rerikdev/WASAText/service/globaltime"
+	"github.com/rerikdev/WASAText/service/structures"
+)
+
+// Event types
+const (
+	MessageCreated  = "message.created"
+	MessageEdited   = "message.edited"
+	MessageDeleted  = "message.deleted"
+	MessagePinned   = "message.pinned"
+	MessageUnpinned = "message.unpinned"
+	ReactionChanged = "reaction.changed"
+	ReceiptUpdated  = "receipt.updated"
+	GroupUpdated    = "group.updated"
+	// PreferencesUpdated is sent only to the user who muted or pinned a conversation, to sync their other devices
+	PreferencesUpdated = "preferences.updated"
+
+	// Resync is sent to a resuming client when some of the events after its last event ID are no longer available:
+	// the client should reload its state from the REST API.
+	Resync = "resync"
+)
+
+const (
+	// subscriptionBuffer is the number of events that can be queued for a slow subscriber before it gets disconnected
+	subscriptionBuffer = 64
+
+	// maxReplay is the maximum number of missed events sent to a resuming client; if more events were missed, the
+	// client receives a Resync instead
+	maxReplay = 500
+)
+
+// Store is the persistent event log
+type Store interface {
+	// AddEvent saves the event for the recipients and sets its ID
+	AddEvent(ev *structures.Event, recipients []int) error
+
+	// GetEventsSince returns at most limit events addressed to the user with ID greater than lastEventId, oldest first.
+	// complete is false if some of those events have already been removed from the log.
+	GetEventsSince(userId int, lastEventId int64, limit int) (events []*structures.Event, complete bool, err error)
+}
+
+// Hub dispatches events to the subscribers of the recipients
+type Hub struct {
+	// mu guards the fields below. It is never held while an event is being saved, so that publishers, subscribers and
+	// unsubscribers don't wait for the database writes.
+	mu     sync.Mutex
+	store  Store
+	subs   map[int]map[*Subscription]struct{}
+	closed bool
+
+	// nextTicket is the ticket of the next save to start, inFlight the tickets of the saves not finished yet
+	nextTicket uint64
+	inFlight   map[uint64]struct{}
+	// pending are the saved events waiting to be delivered, by ID
+	pending []pendingEvent
+	// lastDelivered is the ID of the last delivered event
+	lastDelivered int64
+}
+
+// pendingEvent is a saved event waiting for the saves that can still get a smaller ID
+type pendingEvent struct {
+	ev         *structures.Event
+	recipients []int
+	// lastTicket is the last ticket taken when the event was saved: only the saves up to it can end with a smaller ID
+	lastTicket uint64
+}
+
+// NewHub returns a new Hub saving the events in store
+func NewHub(store Store) *Hub {
+	return &Hub{
+		store:    store,
+		subs:     make(map[int]map[*Subscription]struct{}),
+		inFlight: make(map[uint64]struct{}),
+	}
+}
+
+// Publish saves a new event and sends it to every subscription of the recipients.
+//
+// Events are saved concurrently and get their ID from the event log, so they can finish saving out of ID order. To
+// deliver them in ID order, every save takes a ticket before starting: a saved event is held back until every save
+// started before it was saved has finished, since a save started later always gets a greater ID.
+func (h *Hub) Publish(eventType string, conversationId int, recipients []int, data interface{}) (*structures.Event, error) {
+	ev := &structures.Event{
+		Type:           eventType,
+		ConversationID: conversationId,
+		Data:           data,
+		Timestamp:      globaltime.Now(),
+	}
+
+	h.mu.Lock()
+	ticket := h.nextTicket
+	h.nextTicket++
+	h.inFlight[ticket] = struct{}{}
+	h.mu.Unlock()
+
+	err := h.store.AddEvent(ev, recipients)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.inFlight, ticket)
+	if err == nil {
+		i := sort.Search(len(h.pending), func(i int) bool { return h.pending[i].ev.ID > ev.ID })
+		h.pending = append(h.pending, pendingEvent{})
+		copy(h.pending[i+1:], h.pending[i:])
+		h.pending[i] = pendingEvent{ev: ev, recipients: recipients, lastTicket: h.nextTicket - 1}
+	}
+	// A failed save can also unblock the events waiting for it
+	h.deliverPending()
+	if err != nil {
+		return nil, err
+	}
+	return ev, nil
+}
+
+// deliverPending delivers the pending events, by ID, until one of them is still waiting for a save. The caller must
+// hold h.mu.
+func (h *Hub) deliverPending() {
+	firstInFlight := h.nextTicket
+	for ticket := range h.inFlight {
+		if ticket < firstInFlight {
+			firstInFlight = ticket
+		}
+	}
+
+	delivered := 0
+	for _, p := range h.pending {
+		if p.lastTicket >= firstInFlight {
+			break
+		}
+		h.deliver(p.ev, p.recipients)
+		delivered++
+	}
+	h.pending = append(h.pending[:0], h.pending[delivered:]...)
+}
+
+// deliver sends an event to every subscription of the recipients. The caller must hold h.mu.
+func (h *Hub) deliver(ev *structures.Event, recipients []int) {
+	h.lastDelivered = ev.ID
+	for _, userId := range recipients {
+		for sub := range h.subs[userId] {
+			if _, ok := sub.replayed[ev.ID]; ok {
+				// Already sent to the subscriber with the missed events
+				delete(sub.replayed, ev.ID)
+				continue
+			}
+			select {
+			case sub.c <- ev:
+			default:
+				// The subscriber is not keeping up: drop it, it will resume from its last event ID
+				h.remove(sub)
+			}
+		}
+	}
+}
+
+// Subscribe registers a new subscription for userId. If lastEventId is greater than zero, the events addressed to the
+// user after lastEventId are returned as missed; complete is false if some of them are no longer available.
+func (h *Hub) Subscribe(userId int, lastEventId int64) (sub *Subscription, missed []*structures.Event, complete bool, err error) {
+	// The lock is held while loading the missed events, so that every event is either among them or delivered to the
+	// new subscription. Saves are not blocked, only the deliveries.
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sub = &Subscription{hub: h, userId: userId, c: make(chan *structures.Event, subscriptionBuffer)}
+	complete = true
+	if lastEventId > 0 {
+		missed, complete, err = h.store.GetEventsSince(userId, lastEventId, maxReplay+1)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		if len(missed) > maxReplay {
+			missed, complete = nil, false
+		}
+		// Saved events not delivered yet would be sent again
+		for _, ev := range missed {
+			if ev.ID > h.lastDelivered {
+				if sub.replayed == nil {
+					sub.replayed = make(map[int64]struct{})
+				}
+				sub.replayed[ev.ID] = struct{}{}
+			}
+		}
+	}
+
+	if h.closed {
+		close(sub.c)
+		return sub, missed, complete, nil
+	}
+	if h.subs[userId] == nil {
+		h.subs[userId] = make(map[*Subscription]struct{})
+	}
+	h.subs[userId][sub] = struct{}{}
+	return sub, missed, complete, nil
+}
+
+// Close disconnects every subscriber
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for _, subs := range h.subs {
+		for sub := range subs {
+			h.remove(sub)
+		}
+	}
+}
+
+// remove closes the subscription channel. The caller must hold h.mu.
+func (h *Hub) remove(sub *Subscription) {
+	if _, ok := h.subs[sub.userId][sub]; !ok {
+		return
+	}
+	delete(h.subs[sub.userId], sub)
+	if len(h.subs[sub.userId]) == 0 {
+		delete(h.subs, sub.userId)
+	}
+	close(sub.c)
+}
+
+// Subscription receives the events addressed to a user
+type Subscription struct {
+	hub    *Hub
+	userId int
+	c      chan *structures.Event
+	// replayed are the IDs of the missed events returned by Subscribe that were not delivered yet
+	replayed map[int64]struct{}
+}
+
+// Events returns the channel of the events. The channel is closed when the subscription is dropped (slow subscriber,
+// hub shutdown or Close).
+func (s *Subscription) Events() <-chan *structures.Event {
+	return s.c
+}
+
+// Close removes the subscription from the hub
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.hub.remove(s)
+}
@@ -0,0 +1,422 @@
+package events
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rerikdev/WASAText/service/structures"
+)
+
+// memoryStore is an in-memory event log. Events up to trimmed are considered removed from the log.
+type memoryStore struct {
+	mu         sync.Mutex
+	events     []*structures.Event
+	recipients [][]int
+	trimmed    int64
+	err        error
+	// saved, if set, is called when an event is in the log but AddEvent has not returned yet
+	saved func(ev *structures.Event)
+}
+
+func (s *memoryStore) AddEvent(ev *structures.Event, recipients []int) error {
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return s.err
+	}
+	ev.ID = int64(len(s.events) + 1)
+	s.events = append(s.events, ev)
+	s.recipients = append(s.recipients, recipients)
+	saved := s.saved
+	s.mu.Unlock()
+
+	if saved != nil {
+		saved(ev)
+	}
+	return nil
+}
+
+// blockSave makes the next event saved in store wait, once in the log, until the returned function is called
+func blockSave(t *testing.T, store *memoryStore) (release func()) {
+	t.Helper()
+	inLog, resume := make(chan struct{}), make(chan struct{})
+	store.mu.Lock()
+	store.saved = func(ev *structures.Event) {
+		store.mu.Lock()
+		store.saved = nil
+		store.mu.Unlock()
+		close(inLog)
+		<-resume
+	}
+	store.mu.Unlock()
+	t.Cleanup(func() {
+		select {
+		case <-resume:
+		default:
+			close(resume)
+		}
+	})
+	return func() {
+		<-inLog
+		close(resume)
+	}
+}
+
+// waitSaved waits for the event with the given ID to be in store
+func waitSaved(t *testing.T, store *memoryStore, id int64) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		store.mu.Lock()
+		n := len(store.events)
+		store.mu.Unlock()
+		if int64(n) >= id {
+			return
+		}
+	}
+	t.Fatalf("event %d was never saved", id)
+}
+
+func (s *memoryStore) GetEventsSince(userId int, lastEventId int64, limit int) ([]*structures.Event, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return nil, false, s.err
+	}
+	var events []*structures.Event
+	for i, ev := range s.events {
+		if ev.ID <= lastEventId || ev.ID <= s.trimmed || len(events) == limit {
+			continue
+		}
+		for _, id := range s.recipients[i] {
+			if id == userId {
+				events = append(events, ev)
+				break
+			}
+		}
+	}
+	return events, lastEventId >= s.trimmed, nil
+}
+
+// receive returns the next event of sub, failing if none arrives in time or the subscription is closed
+func receive(t *testing.T, sub *Subscription) *structures.Event {
+	t.Helper()
+	select {
+	case ev, ok := <-sub.Events():
+		if !ok {
+			t.Fatal("the subscription was closed")
+		}
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event received")
+	}
+	return nil
+}
+
+// pending returns the events already queued for sub and whether the subscription is still open
+func pending(sub *Subscription) ([]*structures.Event, bool) {
+	var events []*structures.Event
+	for {
+		select {
+		case ev, ok := <-sub.Events():
+			if !ok {
+				return events, false
+			}
+			events = append(events, ev)
+		default:
+			return events, true
+		}
+	}
+}
+
+func subscribe(t *testing.T, h *Hub, userId int, lastEventId int64) (*Subscription, []*structures.Event, bool) {
+	t.Helper()
+	sub, missed, complete, err := h.Subscribe(userId, lastEventId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(sub.Close)
+	return sub, missed, complete
+}
+
+func publish(t *testing.T, h *Hub, recipients ...int) *structures.Event {
+	t.Helper()
+	ev, err := h.Publish(MessageCreated, 1, recipients, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ev
+}
+
+func ids(events []*structures.Event) []int64 {
+	var ids []int64
+	for _, ev := range events {
+		ids = append(ids, ev.ID)
+	}
+	return ids
+}
+
+func TestPublishDeliversToRecipients(t *testing.T) {
+	h := NewHub(&memoryStore{})
+	alice1, _, _ := subscribe(t, h, 1, 0)
+	alice2, _, _ := subscribe(t, h, 1, 0)
+	bob, _, _ := subscribe(t, h, 2, 0)
+	carol, _, _ := subscribe(t, h, 3, 0)
+
+	first := publish(t, h, 1, 2)
+	second := publish(t, h, 1)
+	if first.ID <= 0 || second.ID <= first.ID {
+		t.Fatalf("event IDs %d, %d are not increasing", first.ID, second.ID)
+	}
+
+	for _, sub := range []*Subscription{alice1, alice2} {
+		if got := []int64{receive(t, sub).ID, receive(t, sub).ID}; got[0] != first.ID || got[1] != second.ID {
+			t.Errorf("alice received %v, want [%d %d]", got, first.ID, second.ID)
+		}
+	}
+	if got := receive(t, bob); got.ID != first.ID {
+		t.Errorf("bob received %d, want %d", got.ID, first.ID)
+	}
+	if events, _ := pending(bob); len(events) > 0 {
+		t.Errorf("bob received events %v not addressed to him", ids(events))
+	}
+	if events, _ := pending(carol); len(events) > 0 {
+		t.Errorf("carol received events %v not addressed to her", ids(events))
+	}
+}
+
+func TestPublishStoreError(t *testing.T) {
+	store := &memoryStore{}
+	h := NewHub(store)
+	sub, _, _ := subscribe(t, h, 1, 0)
+
+	store.err = errors.New("disk full")
+	if _, err := h.Publish(MessageCreated, 1, []int{1}, nil); !errors.Is(err, store.err) {
+		t.Fatalf("error %v, want %v", err, store.err)
+	}
+	if events, open := pending(sub); len(events) > 0 || !open {
+		t.Errorf("after a failed publish: events %v, open %v", ids(events), open)
+	}
+}
+
+func TestSubscribeReplaysMissedEvents(t *testing.T) {
+	// Events 1, 3 and 5 are addressed to alice and bob, events 2, 4 and 6 only to bob
+	tests := []struct {
+		name        string
+		lastEventId int64
+		trimmed     int64
+		want        []int64
+		complete    bool
+	}{
+		{"new connection", 0, 0, nil, true},
+		{"resume from the start", 1, 0, []int64{3, 5}, true},
+		{"resume from another user's event", 2, 0, []int64{3, 5}, true},
+		{"up to date", 6, 0, nil, true},
+		{"log trimmed after the last event", 1, 3, []int64{5}, false},
+		{"log trimmed before the last event", 3, 3, []int64{5}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &memoryStore{}
+			h := NewHub(store)
+			for i := 0; i < 6; i++ {
+				if i%2 == 0 {
+					publish(t, h, 1, 2)
+				} else {
+					publish(t, h, 2)
+				}
+			}
+			store.trimmed = tt.trimmed
+
+			sub, missed, complete := subscribe(t, h, 1, tt.lastEventId)
+			if got := ids(missed); complete != tt.complete || !equalIds(got, tt.want) {
+				t.Errorf("missed %v (complete %v), want %v (complete %v)", got, complete, tt.want, tt.complete)
+			}
+			// The subscription is live right after the replay
+			ev := publish(t, h, 1)
+			if got := receive(t, sub); got.ID != ev.ID {
+				t.Errorf("live event %d, want %d", got.ID, ev.ID)
+			}
+		})
+	}
+}
+
+func TestSubscribeReplayLimit(t *testing.T) {
+	h := NewHub(&memoryStore{})
+	first := publish(t, h, 1)
+	for i := 0; i < maxReplay; i++ {
+		publish(t, h, 1)
+	}
+	if _, missed, complete := subscribe(t, h, 1, first.ID); len(missed) != maxReplay || !complete {
+		t.Errorf("%d missed events: got %d (complete %v)", maxReplay, len(missed), complete)
+	}
+	publish(t, h, 1)
+	if _, missed, complete := subscribe(t, h, 1, first.ID); len(missed) != 0 || complete {
+		t.Errorf("%d missed events: got %d (complete %v), want a resync", maxReplay+1, len(missed), complete)
+	}
+}
+
+func TestSlowSubscriberIsDropped(t *testing.T) {
+	h := NewHub(&memoryStore{})
+	slow, _, _ := subscribe(t, h, 1, 0)
+	fast, _, _ := subscribe(t, h, 1, 0)
+
+	var published []int64
+	var received []int64
+	for i := 0; i < subscriptionBuffer+10; i++ {
+		published = append(published, publish(t, h, 1).ID)
+		received = append(received, receive(t, fast).ID)
+	}
+
+	// The slow subscriber gets the events that fit in its buffer, then its channel is closed
+	events, open := pending(slow)
+	if open {
+		t.Fatal("the slow subscriber is still connected")
+	}
+	if got := ids(events); !equalIds(got, published[:subscriptionBuffer]) {
+		t.Errorf("slow subscriber received %v, want the first %d events", got, subscriptionBuffer)
+	}
+	if !equalIds(received, published) {
+		t.Errorf("fast subscriber received %v, want %v", received, published)
+	}
+
+	// Resuming from the last delivered event replays exactly the dropped ones
+	_, missed, complete := subscribe(t, h, 1, events[len(events)-1].ID)
+	if got := ids(missed); !complete || !equalIds(got, published[subscriptionBuffer:]) {
+		t.Errorf("resumed with %v (complete %v), want %v", got, complete, published[subscriptionBuffer:])
+	}
+
+	// Closing a dropped subscription is harmless
+	slow.Close()
+}
+
+func TestConcurrentPublishKeepsOrder(t *testing.T) {
+	h := NewHub(&memoryStore{})
+	sub, _, _ := subscribe(t, h, 1, 0)
+
+	const publishers, perPublisher = 4, subscriptionBuffer / 4
+	var wg sync.WaitGroup
+	for p := 0; p < publishers; p++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perPublisher; i++ {
+				if _, err := h.Publish(MessageCreated, 1, []int{1}, nil); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	events, open := pending(sub)
+	if !open || len(events) != publishers*perPublisher {
+		t.Fatalf("received %d events (open %v), want %d", len(events), open, publishers*perPublisher)
+	}
+	for i, ev := range events {
+		if ev.ID != int64(i+1) {
+			t.Fatalf("events delivered out of order: %v", ids(events))
+		}
+	}
+}
+
+func TestSlowSaveDoesNotBlockTheHub(t *testing.T) {
+	store := &memoryStore{}
+	h := NewHub(store)
+	sub, _, _ := subscribe(t, h, 1, 0)
+
+	release := blockSave(t, store)
+	slow := make(chan *structures.Event)
+	go func() {
+		ev, err := h.Publish(MessageCreated, 1, []int{1}, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		slow <- ev
+	}()
+	waitSaved(t, store, 1)
+
+	// While the first event is being saved, other events are published and clients come and go
+	var fast *structures.Event
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fast = publish(t, h, 1)
+		other, _, _ := subscribe(t, h, 2, 0)
+		other.Close()
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the hub waited for the first save")
+	}
+	if events, _ := pending(sub); len(events) > 0 {
+		t.Fatalf("event %v delivered before the events with a smaller ID", ids(events))
+	}
+
+	// Once the first save ends, both events are delivered in ID order
+	release()
+	first := <-slow
+	if got := []int64{receive(t, sub).ID, receive(t, sub).ID}; !equalIds(got, []int64{first.ID, fast.ID}) {
+		t.Errorf("received %v, want [%d %d]", got, first.ID, fast.ID)
+	}
+}
+
+func TestResumeDuringSaveDoesNotDuplicate(t *testing.T) {
+	store := &memoryStore{}
+	h := NewHub(store)
+	live, _, _ := subscribe(t, h, 1, 0)
+	first := publish(t, h, 1)
+	receive(t, live)
+
+	// The second event is already in the log when the client resumes, but it is delivered only afterwards
+	release := blockSave(t, store)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if _, err := h.Publish(MessageCreated, 1, []int{1}, nil); err != nil {
+			t.Error(err)
+		}
+	}()
+	waitSaved(t, store, 2)
+	resumed, missed, complete := subscribe(t, h, 1, first.ID)
+	if got := ids(missed); !complete || !equalIds(got, []int64{2}) {
+		t.Fatalf("missed %v (complete %v), want [2]", got, complete)
+	}
+	release()
+	<-done
+	third := publish(t, h, 1)
+
+	if got := receive(t, resumed); got.ID != third.ID {
+		t.Errorf("resumed subscription received %d, want only %d", got.ID, third.ID)
+	}
+	if got := []int64{receive(t, live).ID, receive(t, live).ID}; !equalIds(got, []int64{2, third.ID}) {
+		t.Errorf("live subscription received %v, want [2 %d]", got, third.ID)
+	}
+}
+
+func TestCloseDisconnectsSubscribers(t *testing.T) {
+	h := NewHub(&memoryStore{})
+	before, _, _ := subscribe(t, h, 1, 0)
+	h.Close()
+	if _, open := pending(before); open {
+		t.Error("subscription still open after Close")
+	}
+	after, _, _ := subscribe(t, h, 1, 0)
+	if _, open := pending(after); open {
+		t.Error("subscription opened after Close")
+	}
+}
+
+func equalIds(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,143 @@
+package imaging
+
+import (
+	"image"
+	"math"
+	"strings"
+)
+
+// BlurHash encoding, as described in https://github.com/woltapp/blurhash. The hash stores the average color and a few
+// cosine components of the image in about 30 characters, and clients decode it into a blurred preview.
+
+const (
+	// placeholderComponentsX and placeholderComponentsY are the number of components of the placeholder
+	placeholderComponentsX = 4
+	placeholderComponentsY = 3
+	// placeholderSampleSize is the size of the image the placeholder is computed from: a blurred preview does not
+	// need more detail
+	placeholderSampleSize = 32
+)
+
+const base83Chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// placeholder returns the BlurHash of img
+func placeholder(img image.Image) string {
+	bounds := img.Bounds()
+	var sample *image.RGBA
+	if bounds.Dx() > placeholderSampleSize || bounds.Dy() > placeholderSampleSize {
+		sample = fit(img, placeholderSampleSize)
+	} else {
+		sample = fit(img, maxInt(bounds.Dx(), bounds.Dy()))
+	}
+	w, h := sample.Bounds().Dx(), sample.Bounds().Dy()
+
+	// Image in linear RGB
+	linear := make([][3]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := sample.PixOffset(x, y)
+			linear[y*w+x] = [3]float64{
+				srgbToLinear(sample.Pix[i]),
+				srgbToLinear(sample.Pix[i+1]),
+				srgbToLinear(sample.Pix[i+2]),
+			}
+		}
+	}
+
+	factors := make([][3]float64, 0, placeholderComponentsX*placeholderComponentsY)
+	for j := 0; j < placeholderComponentsY; j++ {
+		for i := 0; i < placeholderComponentsX; i++ {
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1
+			}
+			var factor [3]float64
+			for y := 0; y < h; y++ {
+				for x := 0; x < w; x++ {
+					basis := normalisation *
+						math.Cos(math.Pi*float64(i)*float64(x)/float64(w)) *
+						math.Cos(math.Pi*float64(j)*float64(y)/float64(h))
+					for c := 0; c < 3; c++ {
+						factor[c] += basis * linear[y*w+x][c]
+					}
+				}
+			}
+			scale := 1 / float64(w*h)
+			factors = append(factors, [3]float64{factor[0] * scale, factor[1] * scale, factor[2] * scale})
+		}
+	}
+
+	var hash strings.Builder
+	hash.WriteString(base83((placeholderComponentsX-1)+(placeholderComponentsY-1)*9, 1))
+
+	dc, ac := factors[0], factors[1:]
+	maximumValue := 1.0
+	if len(ac) > 0 {
+		actualMaximum := 0.0
+		for _, f := range ac {
+			for _, v := range f {
+				actualMaximum = math.Max(actualMaximum, math.Abs(v))
+			}
+		}
+		quantisedMaximum := clampInt(int(math.Floor(actualMaximum*166-0.5)), 0, 82)
+		maximumValue = float64(quantisedMaximum+1) / 166
+		hash.WriteString(base83(quantisedMaximum, 1))
+	} else {
+		hash.WriteString(base83(0, 1))
+	}
+
+	hash.WriteString(base83(linearToSRGB(dc[0])<<16+linearToSRGB(dc[1])<<8+linearToSRGB(dc[2]), 4))
+	for _, f := range ac {
+		quant := func(v float64) int {
+			return clampInt(int(math.Floor(signPow(v/maximumValue, 0.5)*9+9.5)), 0, 18)
+		}
+		hash.WriteString(base83(quant(f[0])*19*19+quant(f[1])*19+quant(f[2]), 2))
+	}
+	return hash.String()
+}
+
+func base83(value, length int) string {
+	out := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		out[i] = base83Chars[value%83]
+		value /= 83
+	}
+	return string(out)
+}
+
+func srgbToLinear(value uint8) float64 {
+	v := float64(value) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(value float64) int {
+	v := math.Max(0, math.Min(1, value))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(value, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(value), exp), value)
+}
+
+func clampInt(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,203 @@
+/*
+Package imaging decodes the images uploaded by the users and prepares them for the clients: the metadata (EXIF,
+including the GPS position) is removed from the original, a few thumbnails with fixed sizes are generated and a tiny
+BlurHash placeholder is computed, so that clients can reserve space and show a preview before the image is loaded.
+
+JPEG, PNG, GIF and WebP images are supported. Thumbnails are encoded as JPEG, or as PNG when the image has
+transparency; animated GIFs keep only their first frame in the thumbnails.
+*/
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif" // Registers the GIF decoder; for animated GIFs image.Decode returns the first frame
+	"image/jpeg"
+	"image/png"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp" // Registers the WebP decoder
+)
+
+// ErrUnsupportedFormat is returned when the data is not an image in one of the supported formats
+var ErrUnsupportedFormat = errors.New("unsupported image format")
+
+// ErrInvalidImage is returned when the image can't be decoded
+var ErrInvalidImage = errors.New("invalid image")
+
+// ErrTooManyPixels is returned when the image is larger than MaxPixels, to avoid decompression bombs
+var ErrTooManyPixels = errors.New("image too large")
+
+// MaxPixels is the maximum number of pixels of an image accepted by Process
+const MaxPixels = 50_000_000
+
+// ThumbnailSizes are the sizes, in pixels of the longest side, of the generated thumbnails
+var ThumbnailSizes = []int{64, 320, 960}
+
+// jpegQuality is the quality of the thumbnails and of the re-encoded JPEG images
+const jpegQuality = 85
+
+// contentTypes maps the names of the image formats to their content types
+var contentTypes = map[string]string{
+	"jpeg": "image/jpeg",
+	"png":  "image/png",
+	"gif":  "image/gif",
+	"webp": "image/webp",
+}
+
+// Image is a processed image
+type Image struct {
+	// ContentType is the content type of Data
+	ContentType string
+	// Data is the original image without metadata
+	Data []byte
+	// Width and Height are the displayed dimensions of the image, after applying the EXIF orientation
+	Width, Height int
+	// Placeholder is the BlurHash of the image
+	Placeholder string
+	// Thumbnails are the smaller versions of the image, from the smallest one. Sizes larger than the image are
+	// skipped.
+	Thumbnails []Thumbnail
+}
+
+// Thumbnail is a scaled down version of an image
+type Thumbnail struct {
+	// Size is the entry of ThumbnailSizes used for this thumbnail
+	Size          int
+	Width, Height int
+	ContentType   string
+	Data          []byte
+}
+
+// Process decodes an image, removes its metadata and generates its thumbnails and placeholder
+func Process(data []byte) (*Image, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if errors.Is(err, image.ErrFormat) || contentTypes[format] == "" {
+		return nil, ErrUnsupportedFormat
+	} else if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return nil, ErrInvalidImage
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > MaxPixels {
+		return nil, ErrTooManyPixels
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+
+	result := &Image{ContentType: contentTypes[format]}
+	switch format {
+	case "jpeg":
+		orientation := jpegOrientation(data)
+		if orientation > 1 {
+			// The orientation is lost with the EXIF data, so the pixels are rotated and the image is encoded again
+			img = orient(img, orientation)
+			var buf bytes.Buffer
+			if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality}); err != nil {
+				return nil, err
+			}
+			result.Data = buf.Bytes()
+		} else {
+			result.Data, err = stripJPEG(data)
+		}
+	case "png":
+		result.Data, err = stripPNG(data)
+	case "webp":
+		result.Data, err = stripWebP(data)
+	default:
+		// GIF can't carry EXIF data
+		result.Data = data
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+
+	bounds := img.Bounds()
+	result.Width, result.Height = bounds.Dx(), bounds.Dy()
+	result.Placeholder = placeholder(img)
+	result.Thumbnails, err = thumbnails(img)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// thumbnails generates the thumbnails of img. Each one is scaled down from the next larger one, which is much faster
+// than starting every time from the original.
+func thumbnails(img image.Image) ([]Thumbnail, error) {
+	bounds := img.Bounds()
+	longest := bounds.Dx()
+	if bounds.Dy() > longest {
+		longest = bounds.Dy()
+	}
+
+	opaque := isOpaque(img)
+	var result []Thumbnail
+	src := img
+	for i := len(ThumbnailSizes) - 1; i >= 0; i-- {
+		size := ThumbnailSizes[i]
+		if size >= longest {
+			continue
+		}
+		scaled := fit(src, size)
+		thumb := Thumbnail{Size: size, Width: scaled.Bounds().Dx(), Height: scaled.Bounds().Dy()}
+		var buf bytes.Buffer
+		var err error
+		if opaque {
+			thumb.ContentType = "image/jpeg"
+			err = jpeg.Encode(&buf, scaled, &jpeg.Options{Quality: jpegQuality})
+		} else {
+			thumb.ContentType = "image/png"
+			err = png.Encode(&buf, scaled)
+		}
+		if err != nil {
+			return nil, err
+		}
+		thumb.Data = buf.Bytes()
+		result = append([]Thumbnail{thumb}, result...)
+		src = scaled
+	}
+	return result, nil
+}
+
+// fit scales img down so that its longest side is size pixels, keeping the aspect ratio
+func fit(img image.Image, size int) *image.RGBA {
+	bounds := img.Bounds()
+	w, h := size, size
+	if bounds.Dx() >= bounds.Dy() {
+		h = bounds.Dy() * size / bounds.Dx()
+	} else {
+		w = bounds.Dx() * size / bounds.Dy()
+	}
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Src, nil)
+	return dst
+}
+
+// isOpaque reports whether img has no transparent pixels
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0xffff {
+				return false
+			}
+		}
+	}
+	return true
+}
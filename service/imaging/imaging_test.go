@@ -0,0 +1,429 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"reflect"
+	"testing"
+)
+
+// webpPixel is a 1x1 lossless WebP image; there is no WebP encoder to generate it
+const webpPixel = "UklGRhoAAABXRUJQVlA4TA0AAAAvAAAAEAcQERGIiP4HAA=="
+
+var (
+	red  = color.RGBA{R: 0xff, A: 0xff}
+	blue = color.RGBA{B: 0xff, A: 0xff}
+)
+
+// halves returns a w x h image with the left half red and the right half blue
+func halves(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if x < w/2 {
+				img.Set(x, y, red)
+			} else {
+				img.Set(x, y, blue)
+			}
+		}
+	}
+	return img
+}
+
+func encodeJPEG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func encodeGIF(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := gif.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// exifTIFF returns a big endian TIFF structure with the given orientation and a GPS IFD with a latitude
+func exifTIFF(orientation int) []byte {
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08")
+	u16 := func(v int) { tiff = append(tiff, byte(v>>8), byte(v)) }
+	u32 := func(v int) { tiff = append(tiff, byte(v>>24), byte(v>>16), byte(v>>8), byte(v)) }
+
+	// IFD0: Orientation and the pointer to the GPS IFD, which starts right after it
+	u16(2)
+	u16(0x0112)
+	u16(3)
+	u32(1)
+	u16(orientation)
+	u16(0)
+	u16(0x8825)
+	u16(4)
+	u32(1)
+	u32(8 + 2 + 2*12 + 4)
+	u32(0)
+
+	// GPS IFD: GPSLatitudeRef "N"
+	u16(1)
+	u16(0x0001)
+	u16(2)
+	u32(2)
+	tiff = append(tiff, 'N', 0, 0, 0)
+	u32(0)
+	return tiff
+}
+
+// jpegSegment returns a JPEG segment with the given marker and payload
+func jpegSegment(marker byte, payload []byte) []byte {
+	segment := []byte{0xff, marker, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+// withJPEGSegments inserts segments right after the SOI marker of a JPEG image
+func withJPEGSegments(data []byte, segments ...[]byte) []byte {
+	out := append([]byte(nil), data[:2]...)
+	for _, segment := range segments {
+		out = append(out, segment...)
+	}
+	return append(out, data[2:]...)
+}
+
+// jpegWithMetadata returns img as a JPEG image with EXIF (including GPS), XMP, IPTC and comment segments
+func jpegWithMetadata(t *testing.T, img image.Image, orientation int) []byte {
+	return withJPEGSegments(encodeJPEG(t, img),
+		jpegSegment(0xe1, append([]byte("Exif\x00\x00"), exifTIFF(orientation)...)),
+		jpegSegment(0xe1, []byte("http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta/>")),
+		jpegSegment(0xed, []byte("Photoshop 3.0\x008BIM")),
+		jpegSegment(0xfe, []byte("taken at home")),
+	)
+}
+
+// pngChunk returns a PNG chunk with its CRC
+func pngChunk(kind string, payload []byte) []byte {
+	chunk := make([]byte, 8, 12+len(payload))
+	binary.BigEndian.PutUint32(chunk, uint32(len(payload)))
+	copy(chunk[4:], kind)
+	chunk = append(chunk, payload...)
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.ChecksumIEEE(chunk[4:]))
+	return append(chunk, crc...)
+}
+
+// pngWithMetadata returns img as a PNG image with EXIF, text and time chunks before IEND
+func pngWithMetadata(t *testing.T, img image.Image) []byte {
+	data := encodePNG(t, img)
+	iend := len(data) - 12
+	out := append([]byte(nil), data[:iend]...)
+	out = append(out, pngChunk("eXIf", exifTIFF(1))...)
+	out = append(out, pngChunk("tEXt", []byte("Comment\x00taken at home"))...)
+	out = append(out, pngChunk("iTXt", []byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00<x:xmpmeta/>"))...)
+	out = append(out, pngChunk("tIME", []byte{0x07, 0xea, 1, 2, 3, 4, 5})...)
+	return append(out, data[iend:]...)
+}
+
+// webpChunk returns a RIFF chunk, padded to an even size
+func webpChunk(fourCC string, payload []byte) []byte {
+	chunk := make([]byte, 8, 9+len(payload))
+	copy(chunk, fourCC)
+	binary.LittleEndian.PutUint32(chunk[4:], uint32(len(payload)))
+	chunk = append(chunk, payload...)
+	if len(payload)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+// webpWithMetadata returns the 1x1 WebP image in an extended container with EXIF and XMP chunks
+func webpWithMetadata(t *testing.T) []byte {
+	t.Helper()
+	simple, err := base64.StdEncoding.DecodeString(webpPixel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Flags: 0x08 EXIF, 0x04 XMP; the canvas size is stored minus one
+	vp8x := []byte{0x08 | 0x04, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	out := []byte("RIFF\x00\x00\x00\x00WEBP")
+	out = append(out, webpChunk("VP8X", vp8x)...)
+	out = append(out, simple[12:]...)
+	out = append(out, webpChunk("EXIF", exifTIFF(1))...)
+	out = append(out, webpChunk("XMP ", []byte("<x:xmpmeta/>"))...)
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out
+}
+
+// metadataOf returns the metadata segments or chunks left in an image, and fails if the structure is not walkable
+func metadataOf(t *testing.T, contentType string, data []byte) []string {
+	t.Helper()
+	var found []string
+	switch contentType {
+	case "image/jpeg":
+		for pos := 2; ; {
+			if pos+4 > len(data) || data[pos] != 0xff {
+				t.Fatalf("malformed JPEG segment at %d", pos)
+			}
+			marker := data[pos+1]
+			if marker == 0xda {
+				break
+			}
+			switch marker {
+			case 0xe1:
+				found = append(found, "APP1")
+			case 0xed:
+				found = append(found, "APP13")
+			case 0xfe:
+				found = append(found, "COM")
+			}
+			pos += 2 + int(binary.BigEndian.Uint16(data[pos+2:]))
+		}
+	case "image/png":
+		for pos := 8; pos < len(data); {
+			kind := string(data[pos+4 : pos+8])
+			if pngMetadataChunks[kind] {
+				found = append(found, kind)
+			}
+			pos += 12 + int(binary.BigEndian.Uint32(data[pos:]))
+		}
+	case "image/webp":
+		if got := int(binary.LittleEndian.Uint32(data[4:])); got != len(data)-8 {
+			t.Fatalf("RIFF size %d, want %d", got, len(data)-8)
+		}
+		for pos := 12; pos < len(data); {
+			fourCC := string(data[pos : pos+4])
+			size := int(binary.LittleEndian.Uint32(data[pos+4:]))
+			switch fourCC {
+			case "EXIF", "XMP ":
+				found = append(found, fourCC)
+			case "VP8X":
+				if data[pos+8]&(0x08|0x04) != 0 {
+					found = append(found, "VP8X flags")
+				}
+			}
+			pos += 8 + size + size%2
+		}
+	}
+	if bytes.Contains(data, []byte("Exif\x00\x00")) || bytes.Contains(data, []byte("taken at home")) {
+		found = append(found, "raw metadata")
+	}
+	return found
+}
+
+func TestProcessStripsMetadata(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        []byte
+		contentType string
+		width       int
+		height      int
+	}{
+		{"jpeg", jpegWithMetadata(t, halves(40, 20), 1), "image/jpeg", 40, 20},
+		{"rotated jpeg", jpegWithMetadata(t, halves(40, 20), 6), "image/jpeg", 20, 40},
+		{"png", pngWithMetadata(t, halves(40, 20)), "image/png", 40, 20},
+		{"webp", webpWithMetadata(t), "image/webp", 1, 1},
+		{"gif", encodeGIF(t, halves(40, 20)), "image/gif", 40, 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.contentType != "image/gif" && len(metadataOf(t, tt.contentType, tt.data)) == 0 {
+				t.Fatal("the fixture has no metadata")
+			}
+			img, err := Process(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if img.ContentType != tt.contentType {
+				t.Errorf("content type %q, want %q", img.ContentType, tt.contentType)
+			}
+			if found := metadataOf(t, img.ContentType, img.Data); len(found) > 0 {
+				t.Errorf("metadata left in the image: %v", found)
+			}
+			decoded, _, err := image.Decode(bytes.NewReader(img.Data))
+			if err != nil {
+				t.Fatalf("the stripped image can't be decoded: %v", err)
+			}
+			if b := decoded.Bounds(); b.Dx() != tt.width || b.Dy() != tt.height || img.Width != tt.width || img.Height != tt.height {
+				t.Errorf("size %dx%d (decoded %dx%d), want %dx%d", img.Width, img.Height, b.Dx(), b.Dy(), tt.width, tt.height)
+			}
+		})
+	}
+}
+
+func TestProcessAppliesOrientation(t *testing.T) {
+	// The source is 40x20, red on the left and blue on the right; first and second are the displayed pixels that
+	// must come from the red and the blue half
+	tests := []struct {
+		orientation   int
+		width, height int
+		first, second image.Point
+	}{
+		{1, 40, 20, image.Pt(5, 10), image.Pt(35, 10)},
+		{2, 40, 20, image.Pt(35, 10), image.Pt(5, 10)},
+		{3, 40, 20, image.Pt(35, 10), image.Pt(5, 10)},
+		{4, 40, 20, image.Pt(5, 10), image.Pt(35, 10)},
+		{5, 20, 40, image.Pt(10, 5), image.Pt(10, 35)},
+		{6, 20, 40, image.Pt(10, 5), image.Pt(10, 35)},
+		{7, 20, 40, image.Pt(10, 35), image.Pt(10, 5)},
+		{8, 20, 40, image.Pt(10, 35), image.Pt(10, 5)},
+	}
+	isRed := func(c color.Color) bool {
+		r, g, b, _ := c.RGBA()
+		return r > 0xc000 && g < 0x4000 && b < 0x4000
+	}
+	isBlue := func(c color.Color) bool {
+		r, g, b, _ := c.RGBA()
+		return r < 0x4000 && g < 0x4000 && b > 0xc000
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(string(rune('0'+tt.orientation)), func(t *testing.T) {
+			img, err := Process(jpegWithMetadata(t, halves(40, 20), tt.orientation))
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := jpeg.Decode(bytes.NewReader(img.Data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b := decoded.Bounds(); b.Dx() != tt.width || b.Dy() != tt.height {
+				t.Fatalf("decoded size %dx%d, want %dx%d", b.Dx(), b.Dy(), tt.width, tt.height)
+			}
+			if c := decoded.At(tt.first.X, tt.first.Y); !isRed(c) {
+				t.Errorf("pixel %v is %v, want red", tt.first, c)
+			}
+			if c := decoded.At(tt.second.X, tt.second.Y); !isBlue(c) {
+				t.Errorf("pixel %v is %v, want blue", tt.second, c)
+			}
+		})
+	}
+}
+
+func TestProcessThumbnails(t *testing.T) {
+	transparent := image.NewNRGBA(image.Rect(0, 0, 500, 300))
+	transparent.Set(0, 0, color.NRGBA{R: 0xff, A: 0xff})
+
+	tests := []struct {
+		name        string
+		data        []byte
+		contentType string
+		want        [][3]int // size, width, height
+	}{
+		{"landscape", encodeJPEG(t, halves(1200, 800)), "image/jpeg", [][3]int{{64, 64, 42}, {320, 320, 213}, {960, 960, 640}}},
+		{"portrait", encodePNG(t, halves(300, 500)), "image/jpeg", [][3]int{{64, 38, 64}, {320, 192, 320}}},
+		{"transparent", encodePNG(t, transparent), "image/png", [][3]int{{64, 64, 38}, {320, 320, 192}}},
+		{"longest side equal to a size", encodeJPEG(t, halves(320, 100)), "image/jpeg", [][3]int{{64, 64, 20}}},
+		{"smaller than every size", encodeGIF(t, halves(40, 20)), "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := Process(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got [][3]int
+			for _, thumb := range img.Thumbnails {
+				got = append(got, [3]int{thumb.Size, thumb.Width, thumb.Height})
+				if thumb.ContentType != tt.contentType {
+					t.Errorf("thumbnail %d: content type %q, want %q", thumb.Size, thumb.ContentType, tt.contentType)
+				}
+				decoded, format, err := image.Decode(bytes.NewReader(thumb.Data))
+				if err != nil {
+					t.Fatalf("thumbnail %d can't be decoded: %v", thumb.Size, err)
+				}
+				if contentTypes[format] != thumb.ContentType {
+					t.Errorf("thumbnail %d: encoded as %s, declared as %s", thumb.Size, format, thumb.ContentType)
+				}
+				if b := decoded.Bounds(); b.Dx() != thumb.Width || b.Dy() != thumb.Height {
+					t.Errorf("thumbnail %d: decoded size %dx%d, declared %dx%d", thumb.Size, b.Dx(), b.Dy(), thumb.Width, thumb.Height)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("thumbnails %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessPlaceholder(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"jpeg", encodeJPEG(t, halves(400, 300))},
+		{"rotated jpeg", jpegWithMetadata(t, halves(400, 300), 6)},
+		{"png", encodePNG(t, halves(20, 10))},
+		{"gif", encodeGIF(t, halves(40, 20))},
+		{"webp", webpWithMetadata(t)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first, err := Process(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// 4x3 components: size flag, maximum, DC (4 characters) and 11 AC components (2 characters each)
+			if len(first.Placeholder) != 1+1+4+11*2 {
+				t.Errorf("placeholder %q has length %d", first.Placeholder, len(first.Placeholder))
+			}
+			for _, c := range first.Placeholder {
+				if !bytes.ContainsRune([]byte(base83Chars), c) {
+					t.Errorf("placeholder %q contains %q", first.Placeholder, c)
+				}
+			}
+			second, err := Process(append([]byte(nil), tt.data...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if first.Placeholder != second.Placeholder {
+				t.Errorf("placeholder changed between runs: %q, %q", first.Placeholder, second.Placeholder)
+			}
+		})
+	}
+
+	// Different images have different placeholders, and the rotation is taken into account
+	upright, _ := Process(encodeJPEG(t, halves(400, 300)))
+	rotated, _ := Process(jpegWithMetadata(t, halves(400, 300), 6))
+	if upright.Placeholder == rotated.Placeholder {
+		t.Errorf("the rotated image has the same placeholder %q", upright.Placeholder)
+	}
+}
+
+func TestProcessRejectsInvalidImages(t *testing.T) {
+	valid := encodePNG(t, halves(40, 20))
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"empty", nil, ErrUnsupportedFormat},
+		{"text", []byte("hello, this is not an image"), ErrUnsupportedFormat},
+		{"truncated", valid[:len(valid)/2], ErrInvalidImage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Process(tt.data); !errors.Is(err, tt.want) {
+				t.Errorf("error %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+/*
+Package mediastore saves the images sent by the users as media: it processes them with the imaging package and
+stores the result and its thumbnails in the blob store, and their details in the database.
+
+It is shared by the API, for uploads and images sent inline, and by the command that migrates the images saved in
+the database before media existed.
+*/
+package mediastore
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	"github.com/gofrs/uuid"
+	"github.com/rerikdev/WASAText/service/blobstore"
+	"github.com/rerikdev/WASAText/service/imaging"
+	"github.com/rerikdev/WASAText/service/structures"
+)
+
+// MaxSize is the maximum size of an image
+const MaxSize = 10 << 20
+
+// ErrTooLarge is returned for images larger than MaxSize
+var ErrTooLarge = errors.New("image too large")
+
+// Database is the part of database.AppDatabase used to save the media
+type Database interface {
+	CreateMedia(media *structures.Media) error
+}
+
+// Save processes an image and stores it, with its thumbnails, as a new media of uploaderId. Errors of the imaging
+// package and ErrTooLarge mean that the image was rejected.
+func Save(db Database, blobs blobstore.Store, data []byte, uploaderId int) (*structures.Media, error) {
+	if len(data) > MaxSize {
+		return nil, ErrTooLarge
+	}
+	img, err := imaging.Process(data)
+	if err != nil {
+		return nil, err
+	}
+
+	key, size, err := blobs.Put(bytes.NewReader(img.Data))
+	if err != nil {
+		return nil, err
+	}
+	id, err := uuid.NewV4()
+	if err != nil {
+		return nil, err
+	}
+	media := &structures.Media{
+		ID:          id.String(),
+		BlobKey:     key,
+		UploaderID:  uploaderId,
+		ContentType: img.ContentType,
+		Size:        size,
+		Width:       img.Width,
+		Height:      img.Height,
+		Placeholder: img.Placeholder,
+	}
+	for _, thumb := range img.Thumbnails {
+		key, _, err := blobs.Put(bytes.NewReader(thumb.Data))
+		if err != nil {
+			return nil, err
+		}
+		media.Thumbnails = append(media.Thumbnails, &structures.MediaThumbnail{
+			Size:        thumb.Size,
+			BlobKey:     key,
+			ContentType: thumb.ContentType,
+			Width:       thumb.Width,
+			Height:      thumb.Height,
+		})
+	}
+	if err := db.CreateMedia(media); err != nil {
+		return nil, err
+	}
+	return media, nil
+}
+
+// SaveDataURL stores an image sent inline as a base64 data URL as a new media of uploaderId
+func SaveDataURL(db Database, blobs blobstore.Store, dataURL string, uploaderId int) (*structures.Media, error) {
+	data, err := DecodeDataURL(dataURL)
+	if err != nil {
+		return nil, err
+	}
+	return Save(db, blobs, data, uploaderId)
+}
+
+// DecodeDataURL returns the content of an image sent as a base64 data URL
+func DecodeDataURL(dataURL string) ([]byte, error) {
+	comma := strings.IndexByte(dataURL, ',')
+	if !IsDataURL(dataURL) || comma < 0 || !strings.HasSuffix(dataURL[:comma], ";base64") {
+		return nil, imaging.ErrUnsupportedFormat
+	}
+	encoded := dataURL[comma+1:]
+	if base64.StdEncoding.DecodedLen(len(encoded)) > MaxSize+2 {
+		return nil, ErrTooLarge
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, imaging.ErrInvalidImage
+	}
+	return data, nil
+}
+
+// IsDataURL reports whether s is an image sent inline as a data URL
+func IsDataURL(s string) bool {
+	return strings.HasPrefix(s, "data:image/")
+}
+
+// Path returns the path the API serves a media from. Profile and group photos stored as media are saved as this
+// path.
+func Path(id string) string {
+	return "/media/" + id
+}
@@ -0,0 +1,102 @@
+package mediastore
+
+import (
+	"context"
+
+	"github.com/rerikdev/WASAText/service/blobstore"
+	"github.com/rerikdev/WASAText/service/database"
+	"github.com/sirupsen/logrus"
+)
+
+// MigrationDatabase is the part of database.AppDatabase used by MigrateInlineImages
+type MigrationDatabase interface {
+	Database
+	ListInlineImages(source string, afterId, limit int) ([]database.InlineImage, error)
+	ReplaceInlineImages(images []database.InlineImage) ([]database.InlineImage, error)
+}
+
+// MigrationReport summarizes a run of MigrateInlineImages
+type MigrationReport struct {
+	Sources []SourceReport
+	// InlineBytes is the size of the data URLs removed from the database
+	InlineBytes int64
+	// StoredBytes is the size of the images written to the blob store, without metadata and thumbnails. Identical
+	// images are stored only once, so the space used on disk can be lower.
+	StoredBytes int64
+	// Interrupted is true if ctx was cancelled before all the images were migrated
+	Interrupted bool
+}
+
+// SourceReport counts the images migrated from a table
+type SourceReport struct {
+	Source   string
+	Migrated int
+	// Failed counts the images that can't be decoded: they are left inline
+	Failed int
+}
+
+// MigrateInlineImages moves the images saved as data URLs in the database (see database.InlineImageSources) to the
+// blob store, as media. Rows are rewritten in transactions of batchSize rows, so the migration can be interrupted
+// (by cancelling ctx, after the current batch) and resumed at any time: the rows already migrated no longer contain a
+// data URL. If a run stops between saving the media and rewriting their rows, those media are left unused and the
+// images are saved again by the next run; the blobs are not duplicated.
+func MigrateInlineImages(ctx context.Context, db MigrationDatabase, blobs blobstore.Store, batchSize int, logger logrus.FieldLogger) (*MigrationReport, error) {
+	report := &MigrationReport{}
+	for _, source := range database.InlineImageSources {
+		sourceReport := SourceReport{Source: source}
+		// Rows that failed are skipped by moving past their ID
+		afterId := 0
+		for {
+			if ctx.Err() != nil {
+				report.Interrupted = true
+				report.Sources = append(report.Sources, sourceReport)
+				return report, nil
+			}
+
+			images, err := db.ListInlineImages(source, afterId, batchSize)
+			if err != nil {
+				return report, err
+			}
+			if len(images) == 0 {
+				break
+			}
+
+			var batch []database.InlineImage
+			storedBytes := make(map[int]int64)
+			for _, image := range images {
+				afterId = image.RowID
+				media, err := SaveDataURL(db, blobs, image.DataURL, image.OwnerID)
+				if err != nil {
+					logger.WithError(err).WithFields(logrus.Fields{"table": source, "id": image.RowID}).
+						Warning("image can't be migrated, it is left inline")
+					sourceReport.Failed++
+					continue
+				}
+				if source == database.InlineImageMessages {
+					image.Replacement = media.ID
+				} else {
+					image.Replacement = Path(media.ID)
+				}
+				batch = append(batch, image)
+				storedBytes[image.RowID] = media.Size
+			}
+			if len(batch) == 0 {
+				continue
+			}
+
+			replaced, err := db.ReplaceInlineImages(batch)
+			if err != nil {
+				return report, err
+			}
+			// Rows changed while the batch was processed are not replaced
+			for _, image := range replaced {
+				report.InlineBytes += int64(len(image.DataURL))
+				report.StoredBytes += storedBytes[image.RowID]
+			}
+			sourceReport.Migrated += len(replaced)
+			logger.Infof("%s: %d images migrated, up to row %d", source, sourceReport.Migrated, afterId)
+		}
+		report.Sources = append(report.Sources, sourceReport)
+	}
+	return report, nil
+}
@@ -1,5 +1,7 @@
 package structures
 
+import "time"
+
 type User struct {
 	ID             int    `json:"id"`
 	Username       string `json:"username"`
@@ -26,14 +28,115 @@ type Message struct {
 	Timestamp        string      `json:"timestamp"`
 	ReplyToMessageID *int        `json:"replyToMessageId,omitempty"` // NEW: ID del messaggio a cui si risponde
 	ReplyToMessage   *Message    `json:"replyToMessage,omitempty"`   // NEW: Oggetto messaggio completo a cui si risponde
+	EditedAt         *string     `json:"editedAt,omitempty"`         // Data dell'ultima modifica, se il messaggio è stato modificato
+	DeletedAt        *string     `json:"deletedAt,omitempty"`        // Data di eliminazione: il messaggio è un segnaposto senza contenuto
+	MediaID          *string     `json:"mediaId,omitempty"`          // Media allegato, scaricabile da GET /media/:id
+	Width            int         `json:"width,omitempty"`            // Larghezza dell'immagine allegata, in pixel
+	Height           int         `json:"height,omitempty"`           // Altezza dell'immagine allegata, in pixel
+	Placeholder      string      `json:"placeholder,omitempty"`      // BlurHash dell'immagine allegata
+	// Kind è "user" per i messaggi scritti dagli utenti, altrimenti il tipo del messaggio di sistema: Sender è chi ha
+	// compiuto l'azione e Target, se presente, l'utente su cui è stata compiuta
+	Kind   string `json:"kind"`
+	Target *User  `json:"target,omitempty"`
+	// ThreadRootID è il primo messaggio della catena di risposte di cui fa parte il messaggio; Thread riassume le
+	// risposte dei messaggi che ne hanno
+	ThreadRootID *int           `json:"threadRootId,omitempty"`
+	Thread       *ThreadSummary `json:"thread,omitempty"`
+}
+
+// ThreadSummary riassume le risposte a un messaggio, escluse quelle eliminate: quante sono, quando è arrivata l'ultima
+// e quante l'utente non ha ancora letto
+type ThreadSummary struct {
+	ReplyCount  int    `json:"replyCount"`
+	LastReplyAt string `json:"lastReplyAt"`
+	UnreadCount int    `json:"unreadCount"`
+}
+
+// PinnedMessage è un messaggio fissato in cima a una conversazione, con l'utente che l'ha fissato (nil se non esiste
+// più) e quando
+type PinnedMessage struct {
+	Message  *Message `json:"message"`
+	PinnedBy *User    `json:"pinnedBy,omitempty"`
+	PinnedAt string   `json:"pinnedAt"`
+}
+
+// Media è un file caricato con POST /media. Il contenuto è nel blob store, con chiave BlobKey.
+type Media struct {
+	ID          string `json:"id"`
+	BlobKey     string `json:"-"`
+	UploaderID  int    `json:"uploaderId"`
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size"`
+	CreatedAt   string `json:"createdAt"`
+	// Dimensioni e BlurHash, presenti per le immagini
+	Width       int               `json:"width,omitempty"`
+	Height      int               `json:"height,omitempty"`
+	Placeholder string            `json:"placeholder,omitempty"`
+	Thumbnails  []*MediaThumbnail `json:"thumbnails,omitempty"`
+}
+
+// MediaThumbnail è una versione ridotta di un'immagine, scaricabile da GET /media/:id?size=Size
+type MediaThumbnail struct {
+	Size        int    `json:"size"`
+	BlobKey     string `json:"-"`
+	ContentType string `json:"contentType"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+}
+
+// MessageReceipt indica quando un destinatario ha ricevuto e letto un messaggio
+type MessageReceipt struct {
+	User        User    `json:"user"`
+	DeliveredAt *string `json:"deliveredAt,omitempty"`
+	ReadAt      *string `json:"readAt,omitempty"`
+}
+
+// MessageEdit è una versione precedente del contenuto di un messaggio
+type MessageEdit struct {
+	Content string `json:"content"`
+	// ReplacedAt è il momento in cui questa versione è stata sostituita da una modifica
+	ReplacedAt string `json:"replacedAt"`
+}
+
+// MessagePage è una pagina della cronologia di una conversazione, dal messaggio più vecchio al più recente
+type MessagePage struct {
+	Messages []*Message `json:"messages"`
+	// HasMore indica se esistono altri messaggi oltre la pagina, nella direzione richiesta
+	HasMore bool `json:"hasMore"`
+	// PrevCursor e NextCursor puntano al primo e all'ultimo messaggio della pagina: si usano come before e after
+	// per caricare la pagina precedente o successiva
+	PrevCursor string `json:"prevCursor,omitempty"`
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// ThreadPage è una pagina delle risposte di un thread, dalla più vecchia alla più recente, con il messaggio iniziale
+type ThreadPage struct {
+	Root *Message `json:"root"`
+	MessagePage
+}
+
+// MessageSearchResult è un messaggio trovato da una ricerca. Snippet è un estratto del contenuto, già protetto per
+// l'HTML, con i termini cercati racchiusi in <mark>.
+type MessageSearchResult struct {
+	Message *Message `json:"message"`
+	Snippet string   `json:"snippet,omitempty"`
+}
+
+// MessageSearchPage è una pagina dei risultati di una ricerca, dal messaggio più recente
+type MessageSearchPage struct {
+	Results []*MessageSearchResult `json:"results"`
+	HasMore bool                   `json:"hasMore"`
+	// NextCursor si usa come before per caricare la pagina successiva
+	NextCursor string `json:"nextCursor,omitempty"`
 }
 
 type Conversation struct {
-	ID      int    `json:"id"`
-	Name    string `json:"name,omitempty"`
-	Photo   string `json:"photo,omitempty"`
-	IsGroup bool   `json:"is_group"`
-	Members []User `json:"members"`
+	ID          int    `json:"id"`
+	Name        string `json:"name,omitempty"`
+	Photo       string `json:"photo,omitempty"`
+	Description string `json:"description,omitempty"`
+	IsGroup     bool   `json:"is_group"`
+	Members     []User `json:"members"`
 }
 
 type Group struct {
@@ -47,6 +150,7 @@ type SessionRequest struct {
 	Name           string `json:"name"`
 	DisplayName    string `json:"displayName,omitempty"`
 	ProfilePicture string `json:"profilePicture,omitempty"`
+	Password       string `json:"password,omitempty"`
 }
 
 type ConversationPreview struct {
@@ -59,10 +163,120 @@ type ConversationPreview struct {
 }
 
 type GroupPreview struct {
-	ID              int    `json:"id"`
-	Name            string `json:"name"`
-	Photo           string `json:"photo"`
-	Members         []User `json:"members"`
-	LastMessage     string `json:"lastMessage"`
-	LastMessageTime string `json:"lastMessageTime"`
+	ID              int           `json:"id"`
+	Name            string        `json:"name"`
+	Photo           string        `json:"photo"`
+	Description     string        `json:"description"`
+	Members         []GroupMember `json:"members"`
+	Settings        GroupSettings `json:"settings"`
+	LastMessage     string        `json:"lastMessage"`
+	LastMessageTime string        `json:"lastMessageTime"`
+}
+
+// GroupMember è un membro di un gruppo con il suo ruolo: "owner", "admin" o "member"
+type GroupMember struct {
+	User
+	Role string `json:"role"`
+}
+
+// GroupSettings sono le impostazioni di un gruppo: ognuna delle OnlyAdmins, se attiva, riserva l'azione al proprietario
+// e agli amministratori. Con OnlyAdminsSend il gruppo diventa un canale di annunci.
+type GroupSettings struct {
+	OnlyAdminsEditInfo   bool `json:"onlyAdminsEditInfo"`
+	OnlyAdminsAddMembers bool `json:"onlyAdminsAddMembers"`
+	OnlyAdminsSend       bool `json:"onlyAdminsSend"`
+	OnlyAdminsPin        bool `json:"onlyAdminsPin"`
+	MaxMembers           int  `json:"maxMembers"` // Numero massimo di membri, 0 se non c'è un limite
+}
+
+// GroupInfo contiene tutte le informazioni di un gruppo, restituite da GET /groups/:id
+type GroupInfo struct {
+	ID          int           `json:"id"`
+	Name        string        `json:"name"`
+	Photo       string        `json:"photo"`
+	Description string        `json:"description"`
+	Settings    GroupSettings `json:"settings"`
+	Members     []GroupMember `json:"members"`
+	CreatedAt   *string       `json:"createdAt,omitempty"` // Assente per i gruppi creati prima che venisse registrata
+	CreatedBy   *User         `json:"createdBy,omitempty"` // Assente se non è noto o se l'utente è stato eliminato
+}
+
+// GroupInvite è un link di invito a un gruppo. Il link è formato dal token, che si usa con GET /invites/:token.
+type GroupInvite struct {
+	Token     string  `json:"token"`
+	GroupID   int     `json:"groupId"`
+	CreatedBy int     `json:"createdBy"`
+	CreatedAt string  `json:"createdAt"`
+	ExpiresAt *string `json:"expiresAt,omitempty"` // Assente se il link non scade
+	MaxUses   *int    `json:"maxUses,omitempty"`   // Assente se il link non ha limiti di utilizzo
+	Uses      int     `json:"uses"`
+	// RequiresApproval indica se chi usa il link deve essere approvato da un amministratore per entrare
+	RequiresApproval bool `json:"requiresApproval"`
+}
+
+// GroupInvitePreview è l'anteprima del gruppo mostrata a chi apre un link di invito
+type GroupInvitePreview struct {
+	GroupID          int     `json:"groupId"`
+	Name             string  `json:"name"`
+	Photo            string  `json:"photo"`
+	MemberCount      int     `json:"memberCount"`
+	RequiresApproval bool    `json:"requiresApproval"`
+	ExpiresAt        *string `json:"expiresAt,omitempty"`
+	// IsMember e Pending indicano se l'utente è già nel gruppo o ha già chiesto di entrare
+	IsMember bool `json:"isMember"`
+	Pending  bool `json:"pending"`
+}
+
+// GroupJoinRequest è una richiesta di ingresso in un gruppo, in attesa di un amministratore
+type GroupJoinRequest struct {
+	User        User   `json:"user"`
+	RequestedAt string `json:"requestedAt"`
+}
+
+// GroupBan è un utente bandito da un gruppo
+type GroupBan struct {
+	User     User   `json:"user"`
+	BannedBy int    `json:"bannedBy"`
+	BannedAt string `json:"bannedAt"`
+}
+
+// InboxEntry è una conversazione nell'elenco di GET /inbox, che unisce chat 1:1 e gruppi
+type InboxEntry struct {
+	ID int `json:"id"`
+	// Type è "direct" per le chat 1:1 e "group" per i gruppi
+	Type string `json:"type"`
+	// Title e Avatar sono il nome e la foto del gruppo, o quelli dell'altro utente nelle chat 1:1
+	Title       string          `json:"title"`
+	Avatar      string          `json:"avatar"`
+	OtherUserID int             `json:"otherUserId,omitempty"` // Solo per le chat 1:1
+	LastMessage *MessagePreview `json:"lastMessage,omitempty"` // Assente se la conversazione non ha messaggi
+	UnreadCount int             `json:"unreadCount"`
+	Muted       bool            `json:"muted"`
+	Pinned      bool            `json:"pinned"`
+	PinnedAt    *string         `json:"pinnedAt,omitempty"`
+}
+
+// MessagePreview è l'anteprima dell'ultimo messaggio di una conversazione
+type MessagePreview struct {
+	ID        int    `json:"id"`
+	Sender    User   `json:"sender"`
+	MediaType string `json:"mediaType"`
+	// Text è il contenuto (la didascalia per i media) troncato a 100 caratteri, vuoto per i messaggi eliminati. Per i
+	// messaggi di sistema dei messaggi fissati è il contenuto del messaggio fissato.
+	Text      string  `json:"text"`
+	Truncated bool    `json:"truncated"`
+	Timestamp string  `json:"timestamp"`
+	DeletedAt *string `json:"deletedAt,omitempty"`
+	// Kind e Target sono come in Message, per mostrare i messaggi di sistema
+	Kind   string `json:"kind"`
+	Target *User  `json:"target,omitempty"`
+}
+
+// Event è una notifica in tempo reale inviata ai client (WebSocket e Server-Sent Events)
+type Event struct {
+	ID             int64       `json:"id,omitempty"`
+	Type           string      `json:"type"`
+	ConversationID int         `json:"conversationId,omitempty"`
+	Data           interface{} `json:"data,omitempty"`
+	Timestamp      time.Time   `json:"timestamp"`
 }
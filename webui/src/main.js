@@ -2,6 +2,7 @@ import {createApp, reactive} from 'vue'
 import App from './App.vue'
 import router from './router'
 import axios from './services/axios.js';
+import {mediaUrl, placeholderUrl} from './services/media.js';
 import ErrorMsg from './components/ErrorMsg.vue'
 import LoadingSpinner from './components/LoadingSpinner.vue'
 
@@ -10,6 +11,8 @@ import './assets/main.css'
 
 const app = createApp(App)
 app.config.globalProperties.$axios = axios;
+app.config.globalProperties.$mediaUrl = mediaUrl;
+app.config.globalProperties.$placeholderUrl = placeholderUrl;
 app.component("ErrorMsg", ErrorMsg);
 app.component("LoadingSpinner", LoadingSpinner);
 app.use(router)
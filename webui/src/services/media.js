@@ -0,0 +1,89 @@
+import axios from "./axios.js";
+
+// mediaUrl risolve le immagini servite dal backend ("/media/<id>") rispetto all'URL dell'API e, se indicata, chiede
+// la miniatura della dimensione più adatta. Il token va nella query perché i tag <img> non inviano l'header
+// Authorization. Gli altri URL (esterni o data URL) restano invariati.
+export function mediaUrl(url, size) {
+	if (!url || !url.startsWith("/media/")) return url;
+	const params = new URLSearchParams();
+	if (size) params.set("size", size);
+	params.set("token", localStorage.getItem("token") || "");
+	return `${axios.defaults.baseURL}${url}?${params}`;
+}
+
+const BASE83 = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~";
+const PLACEHOLDER_SIZE = 32;
+const placeholders = new Map();
+
+function decode83(str) {
+	let value = 0;
+	for (const c of str) value = value * 83 + BASE83.indexOf(c);
+	return value;
+}
+
+function srgbToLinear(value) {
+	const v = value / 255;
+	return v <= 0.04045 ? v / 12.92 : Math.pow((v + 0.055) / 1.055, 2.4);
+}
+
+function linearToSrgb(value) {
+	const v = Math.max(0, Math.min(1, value));
+	return v <= 0.0031308 ? Math.round(v * 12.92 * 255) : Math.round((1.055 * Math.pow(v, 1 / 2.4) - 0.055) * 255);
+}
+
+function signPow(value, exp) {
+	return Math.sign(value) * Math.pow(Math.abs(value), exp);
+}
+
+// placeholderUrl decodifica il BlurHash di un'immagine in un data URL, da mostrare sfocato finché l'immagine non è
+// caricata. I risultati sono memorizzati perché la decodifica è relativamente costosa.
+export function placeholderUrl(hash) {
+	if (!hash || hash.length < 6) return "";
+	if (placeholders.has(hash)) return placeholders.get(hash);
+
+	const sizeFlag = decode83(hash[0]);
+	const numY = Math.floor(sizeFlag / 9) + 1;
+	const numX = (sizeFlag % 9) + 1;
+	const maxValue = (decode83(hash[1]) + 1) / 166;
+	if (hash.length !== 4 + 2 * numX * numY) return "";
+
+	const colors = [];
+	const dc = decode83(hash.substring(2, 6));
+	colors.push([srgbToLinear(dc >> 16), srgbToLinear((dc >> 8) & 255), srgbToLinear(dc & 255)]);
+	for (let i = 1; i < numX * numY; i++) {
+		const ac = decode83(hash.substring(4 + i * 2, 6 + i * 2));
+		colors.push([
+			signPow((Math.floor(ac / 361) - 9) / 9, 2) * maxValue,
+			signPow(((Math.floor(ac / 19) % 19) - 9) / 9, 2) * maxValue,
+			signPow(((ac % 19) - 9) / 9, 2) * maxValue,
+		]);
+	}
+
+	const canvas = document.createElement("canvas");
+	canvas.width = canvas.height = PLACEHOLDER_SIZE;
+	const context = canvas.getContext("2d");
+	const image = context.createImageData(PLACEHOLDER_SIZE, PLACEHOLDER_SIZE);
+	for (let y = 0; y < PLACEHOLDER_SIZE; y++) {
+		for (let x = 0; x < PLACEHOLDER_SIZE; x++) {
+			let r = 0, g = 0, b = 0;
+			for (let j = 0; j < numY; j++) {
+				for (let i = 0; i < numX; i++) {
+					const basis = Math.cos((Math.PI * x * i) / PLACEHOLDER_SIZE) * Math.cos((Math.PI * y * j) / PLACEHOLDER_SIZE);
+					const color = colors[i + j * numX];
+					r += color[0] * basis;
+					g += color[1] * basis;
+					b += color[2] * basis;
+				}
+			}
+			const p = 4 * (y * PLACEHOLDER_SIZE + x);
+			image.data[p] = linearToSrgb(r);
+			image.data[p + 1] = linearToSrgb(g);
+			image.data[p + 2] = linearToSrgb(b);
+			image.data[p + 3] = 255;
+		}
+	}
+	context.putImageData(image, 0, 0);
+	const url = canvas.toDataURL();
+	placeholders.set(hash, url);
+	return url;
+}